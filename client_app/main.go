@@ -1,21 +1,118 @@
 package main
 
 import (
-	"log"
-	"os"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
 	"streming_server/components"
+	"streming_server/logging"
+	"streming_server/metrics"
+	"strings"
 )
 
+const defaultRtspPort = "554"
+const defaultRtspsPort = "322"
+
 func main() {
+	caFileName := flag.String("ca", "", "pem file with certificates of authorities trusted for rtsps:// servers, "+
+		"system pool is used without it")
+	metricsAddress := flag.String("metrics", "", "address of http server providing Prometheus /metrics, e.g. :9091")
+	logLevel := flag.String("log-level", logging.LevelInfo.String(),
+		"minimal level of logged messages, one of trace, debug, info, warn and error")
+	logFormat := flag.String("log-format", logging.TextFormat, "format of logged messages, text or json")
+	flag.Parse()
+	args := flag.Args()
+
+	err := components.LogConfig{Level: *logLevel, Format: *logFormat}.Apply()
+	if err != nil {
+		logging.Fatal("invalid arguments", logging.ErrorKey, err)
+	}
 
-	if len(os.Args) < 3 {
-		log.Fatalln("[ERROR] incorrect number of arguments, provide server address and port")
+	if len(args) < 1 {
+		logging.Fatal("incorrect number of arguments, provide server address and port " +
+			"or url in form rtsp[s]://[user:password@]host[:port][/path]")
 	}
 
-	serverAddress := os.Args[1]
-	serverPort := os.Args[2]
 	videoFileName := "livestream"
+	var credentials *url.Userinfo
+	var tlsConfig *tls.Config
+	var serverAddress, serverPort string
+	var recordDirectoryIndex int
+	if strings.HasPrefix(args[0], "rtsp://") || strings.HasPrefix(args[0], "rtsps://") {
+		serverUrl, err := url.Parse(args[0])
+		if err != nil {
+			logging.Fatal("invalid server url", logging.ErrorKey, err)
+		}
+		serverAddress = serverUrl.Hostname()
+		serverPort = serverUrl.Port()
+		if serverUrl.Scheme == "rtsps" {
+			tlsConfig, err = newTlsConfig(serverAddress, *caFileName)
+			if err != nil {
+				logging.Fatal("cannot load certificate authorities", logging.ErrorKey, err)
+			}
+			if serverPort == "" {
+				serverPort = defaultRtspsPort
+			}
+		} else if serverPort == "" {
+			serverPort = defaultRtspPort
+		}
+		if serverUrl.Path != "" && serverUrl.Path != "/" {
+			videoFileName = serverUrl.Path
+		}
+		credentials = serverUrl.User
+		recordDirectoryIndex = 1
+	} else {
+		if len(args) < 2 {
+			logging.Fatal("incorrect number of arguments, provide server address and port")
+		}
+		serverAddress = args[0]
+		serverPort = args[1]
+		recordDirectoryIndex = 2
+	}
+
+	if *metricsAddress != "" {
+		metricsServer := components.NewHttpServer(*metricsAddress)
+		metricsServer.Handle("/metrics", metrics.Default)
+		metricsServer.Start()
+		defer metricsServer.Close()
+	}
+
+	// optional directory where received stream is recorded
+	var recorder *components.Recorder
+	if len(args) > recordDirectoryIndex {
+		recorder = components.NewRecorder(args[recordDirectoryIndex], videoFileName,
+			components.DefaultRecordingDuration, components.DefaultRecordingSize)
+		recorder.Start()
+	}
 
-	client := components.NewClient(serverAddress, serverPort, videoFileName)
+	client, err := components.NewClient(context.Background(), serverAddress, serverPort, videoFileName, credentials,
+		tlsConfig, recorder)
+	if err != nil {
+		logging.Fatal("cannot start client", logging.ErrorKey, err)
+	}
 	client.CloseConnection()
+	if recorder != nil {
+		recorder.Stop()
+	}
+}
+
+// newTlsConfig verifies certificate of the server against authorities of the pem file or system pool
+func newTlsConfig(serverName string, caFileName string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{ServerName: serverName}
+	if caFileName == "" {
+		return tlsConfig, nil
+	}
+	certificates, err := ioutil.ReadFile(caFileName)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.RootCAs = x509.NewCertPool()
+	if !tlsConfig.RootCAs.AppendCertsFromPEM(certificates) {
+		return nil, fmt.Errorf("no certificates found in %v", caFileName)
+	}
+	return tlsConfig, nil
 }
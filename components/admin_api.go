@@ -0,0 +1,240 @@
+package components
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"streming_server/logging"
+	"streming_server/metrics"
+	"streming_server/protocol/rtsp/state"
+	"strings"
+)
+
+// publishers are either sessions of recording clients or sources of the server
+const sessionPublisherType = "session"
+const sourcePublisherType = "source"
+
+// MountPointInfo describes mount point which is configured, used by sessions or draining
+type MountPointInfo struct {
+	Path       string        `json:"path"`
+	Source     *SourceConfig `json:"source,omitempty"`
+	Configured bool          `json:"configured"`
+	Draining   bool          `json:"draining"`
+	Sessions   int           `json:"sessions"`
+	Publishers int           `json:"publishers"`
+}
+
+// PublisherInfo describes session or source which feeds the mount point
+type PublisherInfo struct {
+	Path          string `json:"path"`
+	Type          string `json:"type"`
+	SessionId     string `json:"sessionId,omitempty"`
+	RemoteAddress string `json:"remoteAddress,omitempty"`
+	BytesReceived int64  `json:"bytesReceived"`
+	PacketsLost   int    `json:"packetsLost"`
+}
+
+// handleAdministration registers JSON endpoints of the server:
+// GET /mountpoints, GET /publishers, DELETE /publishers/<path>, GET /sessions, GET and DELETE /sessions/<id>
+// and POST /reload, and GET /metrics in Prometheus text format
+func (s *StreamingServer) handleAdministration(httpServer *HttpServer) {
+	httpServer.Handle("/metrics", metrics.Default)
+	httpServer.Handle("/mountpoints", http.HandlerFunc(s.serveMountPoints))
+	httpServer.Handle("/publishers", http.HandlerFunc(s.servePublishers))
+	httpServer.Handle("/publishers/", http.HandlerFunc(s.servePublisher))
+	httpServer.Handle("/sessions", http.HandlerFunc(s.serveSessions))
+	httpServer.Handle("/sessions/", http.HandlerFunc(s.serveSession))
+	httpServer.Handle("/reload", http.HandlerFunc(s.serveReload))
+}
+
+// MountPoints describes configured mount points together with paths used by sessions and draining ones
+func (s *StreamingServer) MountPoints() []MountPointInfo {
+	sessions := s.rtspListener.Sessions()
+	mountPoints := make(map[string]*MountPointInfo)
+	mountPointInfo := func(stream string) *MountPointInfo {
+		path := mountPointOf(stream)
+		if _, found := mountPoints[path]; !found {
+			mountPoints[path] = &MountPointInfo{
+				Path:       path,
+				Sessions:   sessions.SessionCount(path),
+				Publishers: len(sessions.Publishers(path)),
+			}
+		}
+		return mountPoints[path]
+	}
+
+	for _, mountPoint := range s.mountPoints.All() {
+		info := mountPointInfo(mountPoint.Path)
+		info.Source = mountPoint.Source
+		info.Configured = true
+	}
+	for _, stream := range sessions.Streams() {
+		mountPointInfo(stream)
+	}
+	s.mutex.Lock()
+	for stream := range s.draining {
+		mountPointInfo(stream).Draining = true
+	}
+	for stream := range s.sourcePublishers {
+		mountPointInfo(stream).Publishers++
+	}
+	s.mutex.Unlock()
+
+	result := make([]MountPointInfo, 0, len(mountPoints))
+	for _, info := range mountPoints {
+		result = append(result, *info)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Path < result[j].Path
+	})
+	return result
+}
+
+// Publishers describes publishing sessions and running sources
+func (s *StreamingServer) Publishers() []PublisherInfo {
+	result := make([]PublisherInfo, 0)
+	recording := state.State(state.Recording).String()
+	for _, session := range s.rtspListener.Sessions().Sessions() {
+		if session.State == recording {
+			result = append(result, PublisherInfo{
+				Path:          mountPointOf(session.Path),
+				Type:          sessionPublisherType,
+				SessionId:     session.Id,
+				RemoteAddress: session.RemoteAddress,
+				BytesReceived: session.BytesReceived,
+				PacketsLost:   session.PacketsLost,
+			})
+		}
+	}
+	s.mutex.Lock()
+	for stream := range s.sourcePublishers {
+		result = append(result, PublisherInfo{Path: mountPointOf(stream), Type: sourcePublisherType})
+	}
+	for stream, draining := range s.draining {
+		if draining.sourcePublisher != nil {
+			result = append(result, PublisherInfo{Path: mountPointOf(stream), Type: sourcePublisherType})
+		}
+	}
+	s.mutex.Unlock()
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Path < result[j].Path
+	})
+	return result
+}
+
+// StopPublisher disconnects publishing sessions of the mount point and stops its source until next reload,
+// it reports whether there was anything to stop
+func (s *StreamingServer) StopPublisher(path string) bool {
+	stopped := false
+	for _, srv := range s.rtspListener.Sessions().Publishers(path) {
+		srv.log().Info("publisher disconnected by administrator")
+		srv.Shutdown()
+		stopped = true
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for stream, sourcePublisher := range s.sourcePublishers {
+		if mountPointOf(stream) == mountPointOf(path) {
+			rtspLogger.Info("source stopped by administrator", logging.PathKey, path)
+			sourcePublisher.Stop()
+			delete(s.sourcePublishers, stream)
+			stopped = true
+		}
+	}
+	return stopped
+}
+
+// KickSession disconnects session of given id, it reports whether the session was found
+func (s *StreamingServer) KickSession(sessionId string) bool {
+	srv := s.rtspListener.Sessions().Find(sessionId)
+	if srv == nil {
+		return false
+	}
+	srv.log().Info("session disconnected by administrator")
+	srv.Shutdown()
+	return true
+}
+
+func (s *StreamingServer) serveMountPoints(writer http.ResponseWriter, request *http.Request) {
+	if allowMethods(writer, request, http.MethodGet) {
+		writeJson(writer, http.StatusOK, s.MountPoints())
+	}
+}
+
+func (s *StreamingServer) servePublishers(writer http.ResponseWriter, request *http.Request) {
+	if allowMethods(writer, request, http.MethodGet) {
+		writeJson(writer, http.StatusOK, s.Publishers())
+	}
+}
+
+// servePublisher stops publisher of the mount point following /publishers
+func (s *StreamingServer) servePublisher(writer http.ResponseWriter, request *http.Request) {
+	if !allowMethods(writer, request, http.MethodDelete) {
+		return
+	}
+	path := strings.TrimPrefix(request.URL.Path, "/publishers")
+	if !s.StopPublisher(path) {
+		writeJson(writer, http.StatusNotFound, map[string]string{"error": "no publisher of " + path})
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (s *StreamingServer) serveSessions(writer http.ResponseWriter, request *http.Request) {
+	if allowMethods(writer, request, http.MethodGet) {
+		writeJson(writer, http.StatusOK, s.rtspListener.Sessions().Sessions())
+	}
+}
+
+// serveSession describes or disconnects session of the id following /sessions/
+func (s *StreamingServer) serveSession(writer http.ResponseWriter, request *http.Request) {
+	if !allowMethods(writer, request, http.MethodGet, http.MethodDelete) {
+		return
+	}
+	sessionId := strings.TrimPrefix(request.URL.Path, "/sessions/")
+	srv := s.rtspListener.Sessions().Find(sessionId)
+	if srv == nil {
+		writeJson(writer, http.StatusNotFound, map[string]string{"error": "no session " + sessionId})
+		return
+	}
+	if request.Method == http.MethodGet {
+		writeJson(writer, http.StatusOK, srv.Info())
+		return
+	}
+	s.KickSession(sessionId)
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// serveReload reloads configuration file
+func (s *StreamingServer) serveReload(writer http.ResponseWriter, request *http.Request) {
+	if !allowMethods(writer, request, http.MethodPost) {
+		return
+	}
+	if err := s.ReloadConfigFile(); err != nil {
+		rtspLogger.Error("configuration can't be reloaded", logging.ErrorKey, err)
+		writeJson(writer, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJson(writer, http.StatusOK, map[string]string{"status": "reloaded"})
+}
+
+// allowMethods answers 405 to requests of other methods
+func allowMethods(writer http.ResponseWriter, request *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if request.Method == method {
+			return true
+		}
+	}
+	writer.Header().Set("Allow", strings.Join(methods, ", "))
+	writeJson(writer, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	return false
+}
+
+func writeJson(writer http.ResponseWriter, status int, value interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	err := json.NewEncoder(writer).Encode(value)
+	if err != nil {
+		httpLogger.Error("error while sending response", logging.ErrorKey, err)
+	}
+}
@@ -0,0 +1,159 @@
+package components
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"streming_server/protocol/rtsp/state"
+	"strings"
+	"testing"
+)
+
+// startAdministration serves administration endpoints of the harness server
+func startAdministration(t *testing.T, harness *testHarness) *httptest.Server {
+	httpServer := NewHttpServer("")
+	harness.server.handleAdministration(httpServer)
+	result := httptest.NewServer(httpServer.mux)
+	t.Cleanup(result.Close)
+	return result
+}
+
+func adminRequest(t *testing.T, method string, url string, response interface{}) int {
+	t.Helper()
+	request, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpResponse, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer httpResponse.Body.Close()
+	if response != nil && httpResponse.StatusCode == http.StatusOK {
+		if err = json.NewDecoder(httpResponse.Body).Decode(response); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return httpResponse.StatusCode
+}
+
+func scrapeMetrics(t *testing.T, url string) string {
+	t.Helper()
+	response, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("metrics answered with %v", response.Status)
+	}
+	return string(body)
+}
+
+func TestAdministrationOfSessions(t *testing.T) {
+	config := DefaultServerConfig()
+	config.Listeners = []ListenerConfig{{Address: "127.0.0.1:0"}}
+	config.MountPoints = []MountPointConfig{{Path: "/live"}, {Path: "/recorded"}}
+	harness := startStreamingServer(t, config)
+	admin := startAdministration(t, harness)
+	harness.publish("live")
+
+	viewer, view := harness.newClient("/live")
+	defer viewer.CloseConnection()
+	viewer.onSetup()
+	viewer.onPlay()
+	view.waitForFrames(t, 10)
+
+	var sessions []SessionInfo
+	if adminRequest(t, http.MethodGet, admin.URL+"/sessions", &sessions) != http.StatusOK || len(sessions) != 1 {
+		t.Fatalf("unexpected sessions: %+v", sessions)
+	}
+	session := sessions[0]
+	if session.Path != "live" || session.State != state.State(state.Playing).String() || session.BytesSent == 0 ||
+		session.Transport != "RTP/AVP/UDP" {
+		t.Fatalf("unexpected description of playing session: %+v", session)
+	}
+
+	var mountPoints []MountPointInfo
+	adminRequest(t, http.MethodGet, admin.URL+"/mountpoints", &mountPoints)
+	if len(mountPoints) != 2 || mountPoints[0].Path != "/live" || mountPoints[0].Sessions != 1 ||
+		!mountPoints[1].Configured {
+		t.Fatalf("unexpected mount points: %+v", mountPoints)
+	}
+
+	waitFor(t, "receiver report answered", func() bool {
+		return rtcpReportsMetric.Value(sentDirection, senderReportType) > 0
+	})
+	exposition := scrapeMetrics(t, admin.URL+"/metrics")
+	for _, expected := range []string{
+		`streaming_sessions{state="PLAYING"} 1`,
+		`streaming_sessions_congestion_level{level="0"} 1`,
+		`streaming_frame_sync_depth{stream="live"}`,
+		`streaming_rtcp_reports_total{direction="sent",type="sender_report"}`,
+		`streaming_rtcp_fraction_lost_bucket{direction="received",le="+Inf"}`,
+	} {
+		if !strings.Contains(exposition, expected) {
+			t.Errorf("metrics don't contain %v:\n%v", expected, exposition)
+		}
+	}
+	if rtpSentPacketsMetric.Value("live") == 0 || rtpReceivedPacketsMetric.Value("/live") == 0 {
+		t.Error("sent or received packets of the stream weren't counted")
+	}
+
+	if statusCode := adminRequest(t, http.MethodDelete, admin.URL+"/sessions/unknown", nil); statusCode != 404 {
+		t.Fatalf("kick of unknown session answered with %v", statusCode)
+	}
+	if statusCode := adminRequest(t, http.MethodDelete, admin.URL+"/sessions/"+session.Id, nil); statusCode != 204 {
+		t.Fatalf("kick answered with %v", statusCode)
+	}
+	waitFor(t, "kicked session disconnected", func() bool {
+		return harness.listener.Sessions().SessionCount("live") == 0
+	})
+	if statusCode := adminRequest(t, http.MethodPost, admin.URL+"/sessions", nil); statusCode != 405 {
+		t.Fatalf("POST of sessions answered with %v", statusCode)
+	}
+}
+
+func TestAdministrationOfPublishers(t *testing.T) {
+	config := DefaultServerConfig()
+	config.Listeners = []ListenerConfig{{Address: "127.0.0.1:0"}}
+	harness := startStreamingServer(t, config)
+	admin := startAdministration(t, harness)
+
+	publisher := harness.newHeadlessClient("/recorded")
+	publisher.SetFrameSource(&syntheticSource{})
+	publisher.onSetup()
+	publisher.onRecord()
+	harness.expectSessionState("/recorded", state.Recording)
+	// stream of the stopped publisher keeps running until the client is closed
+	defer func() {
+		publisher.CloseConnection()
+		ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+		defer cancel()
+		if running := publisher.server.routines.Wait(ctx); running != nil {
+			t.Errorf("goroutines of the published stream are still running: %v", running)
+		}
+	}()
+
+	var publishers []PublisherInfo
+	adminRequest(t, http.MethodGet, admin.URL+"/publishers", &publishers)
+	if len(publishers) != 1 || publishers[0].Path != "/recorded" || publishers[0].Type != sessionPublisherType {
+		t.Fatalf("unexpected publishers: %+v", publishers)
+	}
+
+	if statusCode := adminRequest(t, http.MethodDelete, admin.URL+"/publishers/unknown", nil); statusCode != 404 {
+		t.Fatalf("stop of unknown publisher answered with %v", statusCode)
+	}
+	if statusCode := adminRequest(t, http.MethodDelete, admin.URL+"/publishers/recorded", nil); statusCode != 204 {
+		t.Fatalf("stop of publisher answered with %v", statusCode)
+	}
+	waitFor(t, "publisher disconnected", func() bool {
+		return len(harness.server.Publishers()) == 0
+	})
+}
@@ -0,0 +1,248 @@
+package components
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"streming_server/protocol/rtsp/auth"
+	"streming_server/protocol/rtsp/message"
+	"strings"
+	"sync"
+)
+
+const DefaultRealm = "streming_server"
+
+// AnonymousUser is name of the user file entry which grants permissions to clients without credentials
+const AnonymousUser = "*"
+
+// Permission to the mount point, SETUP needs either of them
+type Permission int
+
+const (
+	ReadPermission Permission = 1 << iota
+	PublishPermission
+)
+
+// userEntry holds H(username:realm:password) for every digest algorithm and path patterns of granted permissions
+type userEntry struct {
+	credentialsHashes map[string]string
+	readPaths         []string
+	publishPaths      []string
+}
+
+// Authenticator verifies Basic and Digest (RFC 7616) credentials of RTSP requests against user file
+// with lines in form <username>:<MD5 hash>:<SHA-256 hash>:<read paths>:<publish paths>, where hashes are
+// H(username:realm:password) and paths are comma separated patterns like /live/*, * matches every path
+type Authenticator struct {
+	realm string
+	users map[string]*userEntry
+	mutex sync.RWMutex
+}
+
+func NewAuthenticator(realm string) *Authenticator {
+	return &Authenticator{
+		realm: realm,
+		users: make(map[string]*userEntry),
+	}
+}
+
+// LoadAuthenticator reads user file, hashes have to be calculated for the given realm
+func LoadAuthenticator(userFileName string, realm string) (*Authenticator, error) {
+	userFile, err := os.Open(userFileName)
+	if err != nil {
+		return nil, err
+	}
+	defer userFile.Close()
+
+	authenticator := NewAuthenticator(realm)
+	scanner := bufio.NewScanner(userFile)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) != 5 || fields[0] == "" {
+			return nil, fmt.Errorf("%v:%v: expected <username>:<MD5 hash>:<SHA-256 hash>:<read paths>:<publish paths>",
+				userFileName, lineNumber)
+		}
+		entry := &userEntry{
+			credentialsHashes: make(map[string]string),
+			readPaths:         splitPaths(fields[3]),
+			publishPaths:      splitPaths(fields[4]),
+		}
+		for index, algorithm := range []string{auth.MD5, auth.SHA256} {
+			if fields[index+1] != "" {
+				entry.credentialsHashes[algorithm] = strings.ToLower(fields[index+1])
+			}
+		}
+		authenticator.users[fields[0]] = entry
+	}
+	return authenticator, scanner.Err()
+}
+
+// FormatUserEntry returns line of the user file, password itself isn't stored
+func FormatUserEntry(username string, realm string, password string, readPaths []string, publishPaths []string) string {
+	md5Hash, _ := auth.HashCredentials(auth.MD5, username, realm, password)
+	sha256Hash, _ := auth.HashCredentials(auth.SHA256, username, realm, password)
+	return fmt.Sprintf("%v:%v:%v:%v:%v", username, md5Hash, sha256Hash,
+		strings.Join(readPaths, ","), strings.Join(publishPaths, ","))
+}
+
+func splitPaths(paths string) []string {
+	result := make([]string, 0)
+	for _, pattern := range strings.Split(paths, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			result = append(result, pattern)
+		}
+	}
+	return result
+}
+
+// AddUser adds user with plain password, which is hashed right away
+func (a *Authenticator) AddUser(username string, password string, readPaths []string, publishPaths []string) {
+	entry := &userEntry{
+		credentialsHashes: make(map[string]string),
+		readPaths:         readPaths,
+		publishPaths:      publishPaths,
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for _, algorithm := range auth.Algorithms {
+		entry.credentialsHashes[algorithm], _ = auth.HashCredentials(algorithm, username, a.realm, password)
+	}
+	a.users[username] = entry
+}
+
+// Replace takes realm and users of the other authenticator, sessions using this one verify their following
+// requests against them
+func (a *Authenticator) Replace(other *Authenticator) {
+	other.mutex.RLock()
+	realm, users := other.realm, other.users
+	other.mutex.RUnlock()
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.realm = realm
+	a.users = users
+}
+
+func (a *Authenticator) currentRealm() string {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.realm
+}
+
+// Challenges returns values of WWW-Authenticate headers, the strongest scheme first
+func (a *Authenticator) Challenges(nonce string) []string {
+	realm := a.currentRealm()
+	result := make([]string, 0, len(auth.Algorithms)+1)
+	for _, algorithm := range auth.Algorithms {
+		result = append(result, auth.Challenge{
+			Scheme:    auth.DigestScheme,
+			Realm:     realm,
+			Nonce:     nonce,
+			Algorithm: algorithm,
+			Qop:       auth.QopAuth,
+		}.String())
+	}
+	return append(result, auth.Challenge{Scheme: auth.BasicScheme, Realm: realm}.String())
+}
+
+// Authenticate verifies value of Authorization header and returns name of the user,
+// digest credentials have to answer the nonce of the connection
+func (a *Authenticator) Authenticate(method string, uri string, authorization string, nonce string) (string, error) {
+	credentials, err := auth.ParseCredentials(authorization)
+	if err != nil {
+		return "", err
+	}
+	a.mutex.RLock()
+	entry, found := a.users[credentials.Username]
+	realm := a.realm
+	a.mutex.RUnlock()
+	if !found || credentials.Username == AnonymousUser {
+		return "", errors.New("unknown user")
+	}
+
+	if credentials.Scheme == auth.BasicScheme {
+		for algorithm, credentialsHash := range entry.credentialsHashes {
+			passwordHash, err := auth.HashCredentials(algorithm, credentials.Username, realm, credentials.Password)
+			if err == nil && subtle.ConstantTimeCompare([]byte(passwordHash), []byte(credentialsHash)) == 1 {
+				return credentials.Username, nil
+			}
+		}
+		return "", errors.New("invalid password")
+	}
+
+	if credentials.Realm != realm || credentials.Nonce != nonce {
+		return "", errors.New("stale nonce or foreign realm")
+	}
+	if credentials.Uri != uri {
+		return "", errors.New("credentials for another uri")
+	}
+	credentialsHash, found := entry.credentialsHashes[strings.ToUpper(credentials.Algorithm)]
+	if !found {
+		return "", fmt.Errorf("no %v hash of the user", credentials.Algorithm)
+	}
+	expected, err := auth.DigestResponse(credentials.Algorithm, credentialsHash, credentials.Nonce,
+		credentials.NonceCount, credentials.Cnonce, credentials.Qop, method, credentials.Uri)
+	if err != nil {
+		return "", err
+	}
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(credentials.Response))) != 1 {
+		return "", errors.New("invalid digest response")
+	}
+	return credentials.Username, nil
+}
+
+// Allowed checks whether the user, or anonymous client when username is empty, has any of the permissions
+func (a *Authenticator) Allowed(username string, requestUrl string, permission Permission) bool {
+	if username == "" {
+		username = AnonymousUser
+	}
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	entry, found := a.users[username]
+	if !found {
+		return false
+	}
+	mountPoint := mountPointOf(requestUrl)
+	return permission&ReadPermission != 0 && matchesAny(entry.readPaths, mountPoint) ||
+		permission&PublishPermission != 0 && matchesAny(entry.publishPaths, mountPoint)
+}
+
+// RequiredPermission returns permission needed by the request, 0 when the request is allowed to everyone
+func RequiredPermission(requestType string) Permission {
+	switch requestType {
+	case message.Describe, message.Play, message.GetParameter:
+		return ReadPermission
+	case message.Record:
+		return PublishPermission
+	case message.Setup:
+		return ReadPermission | PublishPermission
+	}
+	return 0
+}
+
+// mountPointOf returns path of absolute or relative request url with leading slash
+func mountPointOf(requestUrl string) string {
+	if parsedUrl, err := url.Parse(requestUrl); err == nil && parsedUrl.Scheme != "" {
+		requestUrl = parsedUrl.Path
+	}
+	return "/" + strings.TrimPrefix(requestUrl, "/")
+}
+
+func matchesAny(patterns []string, mountPoint string) bool {
+	for _, pattern := range patterns {
+		if pattern == "*" {
+			return true
+		}
+		if matched, err := path.Match("/"+strings.TrimPrefix(pattern, "/"), mountPoint); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
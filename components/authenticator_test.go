@@ -0,0 +1,88 @@
+package components
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadAuthenticator(t *testing.T) {
+	directory, err := ioutil.TempDir("", "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	userFileName := filepath.Join(directory, "users")
+	userFile := strings.Join([]string{
+		"# username:MD5 hash:SHA-256 hash:read paths:publish paths",
+		FormatUserEntry("camera", DefaultRealm, "secret", []string{"*"}, []string{"/cameras/*"}),
+		AnonymousUser + ":::/public:",
+	}, "\n")
+	err = ioutil.WriteFile(userFileName, []byte(userFile), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator, err := LoadAuthenticator(userFileName, DefaultRealm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	basic := func(username string, password string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+	}
+	username, err := authenticator.Authenticate("RECORD", "/cameras/door", basic("camera", "secret"), "")
+	if err != nil || username != "camera" {
+		t.Fatalf("valid basic credentials rejected: %v", err)
+	}
+	if _, err = authenticator.Authenticate("RECORD", "/cameras/door", basic("camera", "guess"), ""); err == nil {
+		t.Fatal("invalid password accepted")
+	}
+	if _, err = authenticator.Authenticate("PLAY", "/public", basic(AnonymousUser, ""), ""); err == nil {
+		t.Fatal("anonymous entry accepted as user")
+	}
+
+	permissions := []struct {
+		username   string
+		url        string
+		permission Permission
+		allowed    bool
+	}{
+		{"camera", "/cameras/door", PublishPermission, true},
+		{"camera", "rtsp://localhost:8554/cameras/door", PublishPermission, true},
+		{"camera", "/garden", PublishPermission, false},
+		{"camera", "garden", ReadPermission, true},
+		{"", "/public", ReadPermission, true},
+		{"", "/public", PublishPermission, false},
+		{"", "/cameras/door", ReadPermission | PublishPermission, false},
+		{"unknown", "/public", ReadPermission, false},
+	}
+	for _, expected := range permissions {
+		if authenticator.Allowed(expected.username, expected.url, expected.permission) != expected.allowed {
+			t.Errorf("permission %v of user %q to %v should be %v", expected.permission, expected.username,
+				expected.url, expected.allowed)
+		}
+	}
+}
+
+func TestReplaceAuthenticator(t *testing.T) {
+	authenticator := NewAuthenticator(DefaultRealm)
+	authenticator.AddUser("old", "secret", []string{"*"}, []string{})
+	reloaded := NewAuthenticator("reloaded")
+	reloaded.AddUser("new", "secret", []string{"/live"}, []string{})
+
+	authenticator.Replace(reloaded)
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte("old:secret"))
+	if _, err := authenticator.Authenticate("PLAY", "/live", basic, ""); err == nil {
+		t.Fatal("removed user accepted")
+	}
+	basic = "Basic " + base64.StdEncoding.EncodeToString([]byte("new:secret"))
+	if _, err := authenticator.Authenticate("PLAY", "/live", basic, ""); err != nil {
+		t.Fatalf("added user rejected: %v", err)
+	}
+	if !strings.Contains(authenticator.Challenges("nonce")[0], `realm="reloaded"`) {
+		t.Fatal("realm wasn't replaced")
+	}
+}
@@ -0,0 +1,116 @@
+package components
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	MinBitrate     = 100 * 1000
+	MaxBitrate     = 50 * 1000 * 1000
+	InitialBitrate = 10 * 1000 * 1000
+)
+
+// delay gradient (in microseconds per frame) above which the path is considered overused
+const overuseThreshold = 2000
+
+// multiplicative increase of delay based estimate per second
+const delayBasedIncrease = 1.08
+
+// estimates can't run too far above what the receiver actually gets
+const maxReceivedBitrateRatio = 1.5
+
+// BandwidthEstimator combines loss, round trip time and delay gradient into target bitrate,
+// loss based part follows GCC: decrease when loss exceeds 10%, increase when it's below 2%,
+// delay based part backs off to 85% of received bitrate on overuse and grows slowly otherwise
+type BandwidthEstimator struct {
+	lossBasedBitrate  float64
+	delayBasedBitrate float64
+	receiverEstimate  float64
+	targetBitrate     int
+	minRoundTripTime  time.Duration
+	lastUpdate        time.Time
+	mutex             sync.Mutex
+}
+
+func NewBandwidthEstimator(initialBitrate int) *BandwidthEstimator {
+	return &BandwidthEstimator{
+		lossBasedBitrate:  float64(initialBitrate),
+		delayBasedBitrate: float64(initialBitrate),
+		targetBitrate:     initialBitrate,
+	}
+}
+
+// Update recalculates target bitrate after receiver report
+func (e *BandwidthEstimator) Update(fractionLost float64, receivedBitrate int, delayGradient int,
+	roundTripTime time.Duration) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	now := time.Now()
+	elapsed := 1.0
+	if !e.lastUpdate.IsZero() {
+		elapsed = math.Min(now.Sub(e.lastUpdate).Seconds(), 1.0)
+	}
+	e.lastUpdate = now
+
+	if e.minRoundTripTime == 0 || roundTripTime < e.minRoundTripTime {
+		e.minRoundTripTime = roundTripTime
+	}
+	// growing round trip time means that queues on the path are filling up
+	queueing := roundTripTime > 2*e.minRoundTripTime+50*time.Millisecond
+
+	switch {
+	case fractionLost > 0.1:
+		e.lossBasedBitrate *= 1 - 0.5*fractionLost
+	case fractionLost < 0.02 && !queueing:
+		e.lossBasedBitrate *= 1.05
+	}
+
+	switch {
+	case delayGradient > overuseThreshold:
+		if receivedBitrate > 0 {
+			e.delayBasedBitrate = 0.85 * float64(receivedBitrate)
+		} else {
+			e.delayBasedBitrate *= 0.85
+		}
+	case delayGradient >= -overuseThreshold && !queueing:
+		e.delayBasedBitrate *= math.Pow(delayBasedIncrease, elapsed)
+	}
+
+	if receivedBitrate > 0 {
+		limit := maxReceivedBitrateRatio * float64(receivedBitrate)
+		e.lossBasedBitrate = math.Min(e.lossBasedBitrate, math.Max(limit, MinBitrate))
+		e.delayBasedBitrate = math.Min(e.delayBasedBitrate, math.Max(limit, MinBitrate))
+	}
+	e.lossBasedBitrate = math.Max(math.Min(e.lossBasedBitrate, MaxBitrate), MinBitrate)
+	e.delayBasedBitrate = math.Max(math.Min(e.delayBasedBitrate, MaxBitrate), MinBitrate)
+	e.updateTargetBitrate()
+
+	ccLogger.Debug("bandwidth estimated", "bitrate", e.targetBitrate, "lossBased", int(e.lossBasedBitrate),
+		"delayBased", int(e.delayBasedBitrate), "rtt", roundTripTime)
+}
+
+// SetReceiverEstimate limits target bitrate by maximum bitrate estimated by the receiver (REMB)
+func (e *BandwidthEstimator) SetReceiverEstimate(bitrate int) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.receiverEstimate = float64(bitrate)
+	e.updateTargetBitrate()
+	ccLogger.Debug("receiver estimated bandwidth", "bitrate", bitrate, "target", e.targetBitrate)
+}
+
+func (e *BandwidthEstimator) updateTargetBitrate() {
+	targetBitrate := math.Min(e.lossBasedBitrate, e.delayBasedBitrate)
+	if e.receiverEstimate > 0 {
+		targetBitrate = math.Max(math.Min(targetBitrate, e.receiverEstimate), MinBitrate)
+	}
+	e.targetBitrate = int(targetBitrate)
+}
+
+func (e *BandwidthEstimator) TargetBitrate() int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.targetBitrate
+}
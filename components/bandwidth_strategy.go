@@ -0,0 +1,162 @@
+package components
+
+import (
+	"image"
+	"image/jpeg"
+	"math"
+	"streming_server/video"
+	"time"
+)
+
+const (
+	MinCompressionQuality = 20
+	MinFrameRate          = 2.0
+	// part of target bitrate used before quality, resolution or frame rate is raised
+	bitrateHeadroom    = 0.8
+	frameSizeSmoothing = 0.1
+)
+
+// qualities used at every resolution, lower ones are used only at the smallest resolution,
+// as halving resolution looks better than heavily compressed frames
+var ladderQualities = []int{jpeg.DefaultQuality, 60, 45}
+var lowestResolutionQualities = []int{35, MinCompressionQuality}
+
+// operatingPoint is pair of resolution and quality, points are ordered from the highest bitrate
+type operatingPoint struct {
+	resolution image.Point
+	quality    int
+}
+
+// BandwidthStrategy picks resolution and compression quality jointly to match target bitrate of bandwidth
+// estimator, frame rate is lowered only at the lowest point, recovery goes in reverse order
+type BandwidthStrategy struct {
+	estimator        *BandwidthEstimator
+	points           []operatingPoint
+	currentPoint     int
+	maxFrameRate     float64
+	frameRate        float64
+	averageFrameSize float64
+}
+
+func NewBandwidthStrategy(framePeriod time.Duration) CongestionStrategy {
+	maxFrameRate := float64(time.Second) / float64(framePeriod)
+	return &BandwidthStrategy{
+		estimator:    NewBandwidthEstimator(InitialBitrate),
+		points:       []operatingPoint{{quality: jpeg.DefaultQuality}},
+		maxFrameRate: maxFrameRate,
+		frameRate:    maxFrameRate,
+	}
+}
+
+func (s *BandwidthStrategy) OnReceiverReport(feedback ReceiverFeedback) {
+	s.estimator.Update(feedback.FractionLost, feedback.ReceivedBitrate, feedback.DelayGradient,
+		feedback.RoundTripTime)
+	s.adjust()
+}
+
+func (s *BandwidthStrategy) OnRemb(bitrate int) {
+	s.estimator.SetReceiverEstimate(bitrate)
+	s.adjust()
+}
+
+func (s *BandwidthStrategy) OnFrameSent(size int) {
+	if s.averageFrameSize == 0 {
+		s.averageFrameSize = float64(size)
+	} else {
+		s.averageFrameSize += frameSizeSmoothing * (float64(size) - s.averageFrameSize)
+	}
+}
+
+// OnSourceResolution rebuilds operating points, sending restarts from the point with the same position
+func (s *BandwidthStrategy) OnSourceResolution(width int, height int) {
+	points := make([]operatingPoint, 0)
+	resolutions := video.ResolutionLadder(width, height)
+	for index, resolution := range resolutions {
+		qualities := ladderQualities
+		if index == len(resolutions)-1 {
+			qualities = append(append([]int{}, ladderQualities...), lowestResolutionQualities...)
+		}
+		for _, quality := range qualities {
+			points = append(points, operatingPoint{resolution: resolution, quality: quality})
+		}
+	}
+	if s.currentPoint >= len(points) {
+		s.currentPoint = len(points) - 1
+	}
+	s.points = points
+	s.averageFrameSize = 0
+}
+
+// predictedFrameSize scales measured frame size by number of pixels and rough jpeg size to quality relation
+func (s *BandwidthStrategy) predictedFrameSize(point int) float64 {
+	current := s.points[s.currentPoint]
+	next := s.points[point]
+	areaRatio := 1.0
+	if current.resolution.X > 0 {
+		areaRatio = float64(next.resolution.X*next.resolution.Y) /
+			float64(current.resolution.X*current.resolution.Y)
+	}
+	return s.averageFrameSize * areaRatio * qualityFactor(next.quality) / qualityFactor(current.quality)
+}
+
+func qualityFactor(quality int) float64 {
+	return 0.25 + float64(quality)/100
+}
+
+func (s *BandwidthStrategy) adjust() {
+	if s.averageFrameSize == 0 {
+		return
+	}
+	targetBitrate := float64(s.estimator.TargetBitrate())
+	frameBits := s.averageFrameSize * 8
+	currentBitrate := frameBits * s.frameRate
+	lastPoint := len(s.points) - 1
+
+	if currentBitrate > targetBitrate {
+		if s.currentPoint < lastPoint {
+			// jumps directly to the first point fitting into target, so sudden drop is handled at once
+			next := s.currentPoint + 1
+			for next < lastPoint && s.predictedFrameSize(next)*8*s.frameRate > targetBitrate {
+				next++
+			}
+			s.changePoint(next)
+		} else {
+			s.frameRate = math.Max(targetBitrate/frameBits, MinFrameRate)
+		}
+	} else if currentBitrate < bitrateHeadroom*targetBitrate {
+		if s.frameRate < s.maxFrameRate {
+			s.frameRate = math.Min(bitrateHeadroom*targetBitrate/frameBits, s.maxFrameRate)
+		} else if s.currentPoint > 0 &&
+			s.predictedFrameSize(s.currentPoint-1)*8*s.frameRate < bitrateHeadroom*targetBitrate {
+			// going up is done one point at a time
+			s.changePoint(s.currentPoint - 1)
+		} else {
+			return
+		}
+	} else {
+		return
+	}
+	point := s.points[s.currentPoint]
+	ccLogger.Debug("operating point selected", "bitrate", int(targetBitrate), "frameRate", s.frameRate,
+		"quality", point.quality, "width", point.resolution.X, "height", point.resolution.Y)
+}
+
+func (s *BandwidthStrategy) changePoint(point int) {
+	s.averageFrameSize = s.predictedFrameSize(point)
+	s.currentPoint = point
+}
+
+func (s *BandwidthStrategy) Targets() CongestionTargets {
+	point := s.points[s.currentPoint]
+	targets := CongestionTargets{
+		Bitrate:       s.estimator.TargetBitrate(),
+		FrameInterval: time.Duration(float64(time.Second) / s.frameRate),
+		Quality:       point.quality,
+	}
+	// the source resolution is sent without scaling
+	if s.currentPoint > 0 && point.resolution != s.points[0].resolution {
+		targets.Width = point.resolution.X
+		targets.Height = point.resolution.Y
+	}
+	return targets
+}
@@ -2,83 +2,117 @@ package components
 
 import (
 	"bytes"
-	"gocv.io/x/gocv"
+	"fmt"
+	"image"
 	"image/jpeg"
-	"log"
-	"streming_server/ui"
+	"streming_server/logging"
 	"streming_server/video"
 	"time"
 )
 
 type Broadcast struct {
-	server       *RtspServer
-	frameSync    *video.FrameSync
-	view         *ui.View
-	videoCapture *gocv.VideoCapture
-	videoMat     *gocv.Mat
-	ticker       *time.Ticker
-	interval     time.Duration
-	seqNum       int
-	doneCheck    chan bool
-	started      bool
+	server      *RtspServer
+	frameSync   *video.FrameSync
+	view        ClientView
+	frameSource FrameSource
+	ticker      *time.Ticker
+	interval    time.Duration
+	seqNum      int
+	layers      int
+	doneCheck   chan bool
+	started     bool
 }
 
-func NewBroadcast(srv *RtspServer, sync *video.FrameSync, view *ui.View) *Broadcast {
-	videoMat := gocv.NewMat()
+func NewBroadcast(srv *RtspServer, sync *video.FrameSync, view ClientView, frameSource FrameSource) *Broadcast {
 	return &Broadcast{
-		server:    srv,
-		frameSync: sync,
-		view:      view,
-		videoMat:  &videoMat,
-		interval:  33 * time.Millisecond,
-		seqNum:    1,
-		doneCheck: make(chan bool),
-		started:   false,
+		server:      srv,
+		frameSync:   sync,
+		view:        view,
+		frameSource: frameSource,
+		interval:    33 * time.Millisecond,
+		seqNum:      1,
+		layers:      DefaultSimulcastLayers,
+		doneCheck:   make(chan bool),
+		started:     false,
 	}
 }
 
+// SetSimulcastLayers sets number of encodings sent per frame, every next one has half resolution of the previous,
+// 1 disables simulcast
+func (br *Broadcast) SetSimulcastLayers(layers int) {
+	if layers < 1 {
+		layers = 1
+	} else if layers > MaxSimulcastLayers {
+		layers = MaxSimulcastLayers
+	}
+	br.layers = layers
+}
+
 func (br *Broadcast) nextFrame() {
-	br.videoCapture.Read(br.videoMat)
-	img, err := br.videoMat.ToImage()
+	img, err := br.frameSource.Read()
 	if err != nil {
-		log.Println("[ERROR] unable to intercept frame from camera:", err)
+		videoLogger.Error("unable to intercept frame from camera", logging.ErrorKey, err)
 		return
 	}
 
 	buffer := new(bytes.Buffer)
 	err = jpeg.Encode(buffer, img, nil)
 	if err != nil {
-		log.Println("[ERROR] unable to compress frame to jpeg:", err)
+		videoLogger.Error("unable to compress frame to jpeg", logging.ErrorKey, err)
 		return
 	}
 
-	br.frameSync.AddFrame(buffer.Bytes(), br.seqNum)
-	br.server.frameSync.AddFrame(buffer.Bytes(), br.seqNum)
+	br.server.frameSync.AddLayers(encodeSimulcastLayers(img, buffer.Bytes(), br.layers), br.seqNum)
 
-	br.view.UpdateImage()
+	// preview of the broadcast stream
+	if br.view != nil {
+		br.frameSync.AddFrame(buffer.Bytes(), br.seqNum)
+		br.view.UpdateImage()
+	}
 	br.seqNum++
 }
 
-func (br *Broadcast) Start() {
-	videoCapture, err := gocv.VideoCaptureDevice(0)
+// encodeSimulcastLayers returns primary frame followed by given number of its downscaled encodings in total
+func encodeSimulcastLayers(img image.Image, primary []byte, layersNumber int) [][]byte {
+	layers := [][]byte{primary}
+	bounds := img.Bounds()
+	for layer := 1; layer < layersNumber; layer++ {
+		resolution := SimulcastResolution(bounds.Dx(), bounds.Dy(), layer)
+		if resolution.X == 0 || resolution.Y == 0 {
+			break
+		}
+		buffer := new(bytes.Buffer)
+		err := jpeg.Encode(buffer, video.ScaleImage(img, resolution.X, resolution.Y), nil)
+		if err != nil {
+			videoLogger.Error("unable to compress simulcast layer to jpeg", logging.ErrorKey, err)
+			break
+		}
+		layers = append(layers, buffer.Bytes())
+	}
+	return layers
+}
+
+// Start fails when the frame source can't be opened
+func (br *Broadcast) Start() error {
+	err := br.frameSource.Open()
 	if err != nil {
-		log.Fatalln("[ERROR] unable to connect with webcam:", err)
+		return fmt.Errorf("unable to open frame source: %v", err)
 	}
 
-	br.videoCapture = videoCapture
 	br.started = true
 	br.ticker = time.NewTicker(br.interval)
 
-	go func() {
+	go func(ticker *time.Ticker, doneCheck chan bool) {
 		for {
 			select {
-			case <-br.doneCheck:
+			case <-doneCheck:
 				return
-			case <-br.ticker.C:
+			case <-ticker.C:
 				br.nextFrame()
 			}
 		}
-	}()
+	}(br.ticker, br.doneCheck)
+	return nil
 }
 
 func (br *Broadcast) Stop() {
@@ -87,9 +121,9 @@ func (br *Broadcast) Stop() {
 		br.ticker.Stop()
 		br.started = false
 
-		err := br.videoCapture.Close()
+		err := br.frameSource.Close()
 		if err != nil {
-			log.Fatalln("[ERROR] cannot disconnect with webcam properly:", err)
+			videoLogger.Error("cannot disconnect with webcam properly", logging.ErrorKey, err)
 		}
 	}
 }
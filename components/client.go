@@ -1,12 +1,19 @@
 package components
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
+	"github.com/google/uuid"
 	"github.com/phayes/freeport"
-	"log"
 	"net"
+	"net/url"
+	"streming_server/logging"
+	"streming_server/metrics"
+	"streming_server/protocol/rtsp/auth"
 	"streming_server/protocol/rtsp/message"
 	"streming_server/protocol/rtsp/state"
+	"streming_server/protocol/srtp"
 	"streming_server/ui"
 	"streming_server/util"
 	"streming_server/video"
@@ -14,154 +21,382 @@ import (
 	"time"
 )
 
+// ClientView presents stream received by the client, ui.View is the graphical implementation
+type ClientView interface {
+	UpdateImage()
+	UpdateStatistics(totalBytesReceived int, packageLost int, dataRate float64, estimatedBandwidth int)
+	UpdateResolution(width int, height int, sourceWidth int, sourceHeight int)
+}
+
 type RtspClient struct {
+	// receiving ends once ctx is done
+	ctx               context.Context
 	server            *RtspServer
 	rtcpSender        *RtcpSender
 	rtpReceiver       *RtpReceiver
 	imageRefresh      *ImageRefresh
 	frameSync         *video.FrameSync
 	broadcast         *Broadcast
-	view              *ui.View
+	view              ClientView
+	frameSource       FrameSource
+	publishStrategy   CongestionStrategyFactory
 	serverConnection  net.Conn
 	state             state.State
 	clientsideSrvPort int
 	videoFileName     string
 	sessionId         string
 	sequentialNumber  int
+	timeShift         time.Duration
 	isServerside      bool
+	credentials       *url.Userinfo
+	challenge         *auth.Challenge
+	nonceCount        int
+	secure            bool
+	srtpKey           *srtp.MasterKey
+	recordKey         *srtp.MasterKey
+	removeCollector   func()
+	logger            *logging.Logger
 }
 
-func NewClient(serverAddress string, serverPort string, videoFileName string) *RtspClient {
-	log.Println("[RTSP] client started")
+// RewindStep is how far back playback moves on single rewind
+const RewindStep = 30 * time.Second
 
-	rtspClient := &RtspClient{
-		videoFileName:    videoFileName,
-		state:            state.Init,
-		sequentialNumber: 1,
-	}
+// DefaultPlayoutBuffer is number of frames held back while lost frame may still be retransmitted
+const DefaultPlayoutBuffer = 5
 
-	frameSync := video.NewFrameSync()
-	view := ui.NewView(frameSync,
+// ConnectBackTimeout limits waiting of the publisher for the server which connects back after RECORD
+const ConnectBackTimeout = 10 * time.Second
+
+// NewClient runs GUI client, credentials answer authentication challenges of the server and may be nil,
+// RTSPS is used when tlsConfig isn't nil, ctx limits connecting to the server
+func NewClient(ctx context.Context, serverAddress string, serverPort string, videoFileName string,
+	credentials *url.Userinfo, tlsConfig *tls.Config, recorder *Recorder) (*RtspClient, error) {
+	var rtspClient *RtspClient
+	var err error
+	if tlsConfig != nil {
+		rtspClient, err = NewSecureHeadlessClient(ctx, serverAddress, serverPort, videoFileName, tlsConfig, recorder)
+	} else {
+		rtspClient, err = NewHeadlessClient(ctx, serverAddress, serverPort, videoFileName, recorder)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rtspClient.SetCredentials(credentials)
+	view := ui.NewView(rtspClient.frameSync,
 		rtspClient.onSetup, rtspClient.onRecord, rtspClient.onPlay,
 		rtspClient.onPause, rtspClient.onDescribe, rtspClient.onTeardown,
+		rtspClient.onRewind, rtspClient.onLive,
 	)
+	rtspClient.SetView(view)
+	view.StartGUI()
+
+	return rtspClient, nil
+}
+
+// NewHeadlessClient creates client without GUI, received frames stay in the frame sync until view is set,
+// ctx limits connecting to the server
+func NewHeadlessClient(ctx context.Context, serverAddress string, serverPort string, videoFileName string,
+	recorder *Recorder) (*RtspClient, error) {
+	var dialer net.Dialer
+	serverConnection, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(serverAddress, serverPort))
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to the server: %v", err)
+	}
+	return newHeadlessClient(ctx, serverConnection, videoFileName, recorder)
+}
+
+// NewSecureHeadlessClient connects over RTSPS, the server is verified against RootCAs of tlsConfig
+// or system pool when they are nil, media is protected by SRTP with keys exchanged over TLS
+func NewSecureHeadlessClient(ctx context.Context, serverAddress string, serverPort string, videoFileName string,
+	tlsConfig *tls.Config, recorder *Recorder) (*RtspClient, error) {
+	var dialer net.Dialer
+	connection, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(serverAddress, serverPort))
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to the server: %v", err)
+	}
+	if tlsConfig.ServerName == "" {
+		// certificate is verified against the host name as tls.Dial does
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = serverAddress
+	}
+	serverConnection := tls.Client(connection, tlsConfig)
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = connection.SetDeadline(deadline)
+	}
+	err = serverConnection.Handshake()
+	if err == nil {
+		err = connection.SetDeadline(time.Time{})
+	}
+	if err != nil {
+		_ = connection.Close()
+		return nil, fmt.Errorf("cannot connect to the server: %v", err)
+	}
+	rtspClient, err := newHeadlessClient(ctx, serverConnection, videoFileName, recorder)
+	if err != nil {
+		return nil, err
+	}
+	rtspClient.secure = true
+	return rtspClient, nil
+}
 
-	rtpReceiver := NewRtpReceiver(frameSync, view)
-	serverConnection, err := net.Dial("tcp", fmt.Sprintf("%v:%v", serverAddress, serverPort))
+// newHeadlessClient takes over the connection, it's closed when the client can't be created
+func newHeadlessClient(ctx context.Context, serverConnection net.Conn, videoFileName string,
+	recorder *Recorder) (*RtspClient, error) {
+	frameSync := video.NewFrameSync()
+	rtpReceiver, err := NewRtpReceiver(ctx, frameSync, nil)
 	if err != nil {
-		log.Fatalln("[RTSP] cannot connect to the server:", err)
+		_ = serverConnection.Close()
+		return nil, err
+	}
+	rtspLogger.Info("client started", logging.PathKey, videoFileName)
+
+	rtspClient := &RtspClient{
+		ctx:              context.Background(),
+		videoFileName:    videoFileName,
+		state:            state.Init,
+		sequentialNumber: 1,
+		logger:           rtspLogger.With(logging.PathKey, videoFileName),
 	}
 
+	rtpReceiver.SetRecorder(recorder)
+	rtpReceiver.SetStream(videoFileName)
+	rtpReceiver.SetLogger(rtspClient.logger)
+
 	rtspClient.rtcpSender = NewRtcpSender(rtpReceiver)
-	rtspClient.imageRefresh = NewImageRefresh(view, frameSync)
+	rtspClient.rtcpSender.SetLogger(rtspClient.logger)
+	rtpReceiver.SetNackGenerator(NewNackGenerator(rtspClient.rtcpSender))
+	frameSync.SetPlayoutBuffer(DefaultPlayoutBuffer)
+	rtspClient.removeCollector = metrics.Default.OnCollect(func() {
+		frameSyncDepthMetric.Add(float64(frameSync.Len()), videoFileName)
+	})
 	rtspClient.frameSync = frameSync
 	rtspClient.rtpReceiver = rtpReceiver
-	rtspClient.view = view
 	rtspClient.serverConnection = serverConnection
 	rtspClient.isServerside = false
-	rtspClient.view.StartGUI()
 
-	return rtspClient
+	return rtspClient, nil
 }
 
-// used to receive video from streaming client
-func NewServersideClient(server *RtspServer, serverAddress string, serverPort string, videoFileName string) *RtspClient {
-	log.Println("[RTSP] serverside client started")
+// SetView sets view which takes frames from the frame sync while playing
+func (rc *RtspClient) SetView(view ClientView) {
+	rc.view = view
+	rc.rtpReceiver.view = view
+	rc.imageRefresh = NewImageRefresh(view, rc.frameSync)
+}
+
+// guiLogger reports actions of the user
+func (rc *RtspClient) guiLogger() *logging.Logger {
+	return rc.logger.With(logging.ComponentKey, "GUI")
+}
+
+// SetCredentials sets username and password sent once the server challenges the client
+func (rc *RtspClient) SetCredentials(credentials *url.Userinfo) {
+	rc.credentials = credentials
+}
+
+func (rc *RtspClient) FrameSync() *video.FrameSync {
+	return rc.frameSync
+}
+
+// StreamEnded is closed once the server announces end of the played stream by RTCP BYE
+func (rc *RtspClient) StreamEnded() <-chan struct{} {
+	return rc.rtcpSender.ByeReceived()
+}
+
+// SetFrameSource sets source of frames broadcast after RECORD, webcam is used by default
+func (rc *RtspClient) SetFrameSource(frameSource FrameSource) {
+	rc.frameSource = frameSource
+}
+
+// SetCongestionStrategy selects congestion control of the published stream, NewBandwidthStrategy is used by default
+func (rc *RtspClient) SetCongestionStrategy(congestionStrategy CongestionStrategyFactory) {
+	rc.publishStrategy = congestionStrategy
+}
+
+// used to receive video from streaming client, the client ends with the session of the server
+func NewServersideClient(ctx context.Context, server *RtspServer, serverAddress string, serverPort string,
+	videoFileName string) (*RtspClient, error) {
+	frameSync := video.NewFrameSync()
+	rtpReceiver, err := NewRtpReceiverWithServer(ctx, server, frameSync)
+	if err != nil {
+		return nil, err
+	}
+	var dialer net.Dialer
+	serverConnection, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(serverAddress, serverPort))
+	if err != nil {
+		rtpReceiver.Close()
+		return nil, fmt.Errorf("cannot connect to clientside server: %v", err)
+	}
+	rtspLogger.Info("serverside client started", logging.PathKey, server.videoFileName)
 
 	rtspClient := &RtspClient{
+		ctx:              server.ctx,
 		videoFileName:    videoFileName,
 		state:            state.Init,
 		sequentialNumber: 1,
+		logger:           server.log(),
 	}
 
-	frameSync := video.NewFrameSync()
-	rtpReceiver := NewRtpReceiverWithServer(server, frameSync)
-	serverConnection, err := net.Dial("tcp", fmt.Sprintf("%v:%v", serverAddress, serverPort))
-	if err != nil {
-		log.Fatalln("[RTSP] cannot connect to the server:", err)
-	}
-
+	rtpReceiver.SetLogger(server.log())
+	rtpReceiver.routines = server.routines
 	rtspClient.rtcpSender = NewRtcpSender(rtpReceiver)
+	rtspClient.rtcpSender.SetLogger(server.log())
+	rtspClient.rtcpSender.routines = server.routines
+	nackGenerator := NewNackGenerator(rtspClient.rtcpSender)
+	nackGenerator.routines = server.routines
+	rtpReceiver.SetNackGenerator(nackGenerator)
 	rtspClient.frameSync = frameSync
 	rtspClient.rtpReceiver = rtpReceiver
 	rtspClient.serverConnection = serverConnection
 	rtspClient.isServerside = true
 
-	return rtspClient
+	return rtspClient, nil
 }
 
+// onSetup protects received stream with master key announced by DESCRIBE, RTSPS clients describe the stream first
 func (rc *RtspClient) onSetup() {
-	log.Println("[GUI] setup button has been pressed.")
+	rc.guiLogger().Debug("setup button pressed")
 	if rc.state == state.Init {
+		clientsideServerPort, err := freeport.GetFreePort()
+		if err != nil {
+			rc.logger.Error("cannot allocate free port", logging.ErrorKey, err)
+			return
+		}
+		rc.clientsideSrvPort = clientsideServerPort
 		rc.sequentialNumber = 1
+		if rc.secure && rc.srtpKey == nil {
+			// master key of the stream is announced in SDP
+			rc.exchange(message.Describe)
+			rc.sequentialNumber++
+		}
+		if rc.srtpKey != nil {
+			srtpContext, err := newSrtpContext(rc.srtpKey)
+			if err != nil {
+				rc.logger.Error("stream can't be protected", logging.ErrorKey, err)
+				return
+			}
+			rc.rtpReceiver.SetSrtpContext(srtpContext)
+			rc.rtcpSender.SetSrtpContext(srtpContext)
+		}
 
-		rc.sendRequest(message.Setup)
-		replyCode := rc.parseResponse()
+		replyCode := rc.exchange(message.Setup)
 
 		if replyCode == "200" {
 			rc.state = state.Ready
-			log.Println("[RTSP] State change to READY")
+			rc.logger.Info("state changed", "state", state.State(state.Ready))
 		}
 	}
 }
 
+// onRecord publishes the stream, master key of RTSPS publisher is sent with the first RECORD
 func (rc *RtspClient) onRecord() {
-	log.Println("[GUI] record button has been pressed.")
+	rc.guiLogger().Debug("record button pressed")
 	if rc.state == state.Ready {
 		rc.sequentialNumber++
-		rc.sendRequest(message.Record)
+		var listener net.Listener
 		if rc.server == nil {
-			rc.server = NewClientsideServer(rc.clientsideSrvPort)
-			log.Printf("[RTSP] received new connection from %v",
-				rc.server.clientConnection.RemoteAddr().String())
+			// the server connects back as soon as it accepts the request
+			var err error
+			listener, err = ListenClientside(rc.ctx, rc.clientsideSrvPort)
+			if err != nil {
+				rc.logger.Error("stream can't be published", logging.ErrorKey, err)
+				return
+			}
+			if rc.secure {
+				masterKey, err := srtp.GenerateMasterKey()
+				if err != nil {
+					rc.logger.Error("cannot generate master key", logging.ErrorKey, err)
+					_ = listener.Close()
+					return
+				}
+				rc.recordKey = &masterKey
+			}
+		}
+		replyCode := rc.exchange(message.Record)
+		if replyCode != "200" {
+			if listener != nil {
+				_ = listener.Close()
+			}
+			return
+		}
+		if rc.server == nil {
+			ctx, cancel := context.WithTimeout(rc.ctx, ConnectBackTimeout)
+			server, err := NewClientsideServer(ctx, listener)
+			cancel()
+			if err != nil {
+				rc.logger.Error("stream can't be published", logging.ErrorKey, err)
+				return
+			}
+			rc.server = server
+			rc.server.describedKey = rc.recordKey
+			rc.server.SetCongestionStrategy(rc.publishStrategy)
+			rc.logger.Info("server connected back to publish the stream",
+				logging.RemoteAddressKey, rc.server.clientConnection.RemoteAddr().String())
 			// setup
 			rc.server.ParseRequest()
-			rc.server.SendResponse()
 
-			rc.broadcast = NewBroadcast(rc.server, rc.frameSync, rc.view)
+			if rc.frameSource == nil {
+				rc.frameSource = NewWebcamSource(0)
+			}
+			rc.broadcast = NewBroadcast(rc.server, rc.frameSync, rc.view, rc.frameSource)
 		}
-		replyCode := rc.parseResponse()
-		if replyCode == "200" {
-			// play
-			rc.server.ParseRequest()
-			rc.server.SendResponse()
-			rc.broadcast.Start()
-			rc.state = state.Recording
-			log.Println("[RTSP] State change to RECORDING")
+		// play
+		rc.server.ParseRequest()
+		err := rc.broadcast.Start()
+		if err != nil {
+			rc.logger.Error("stream can't be published", logging.ErrorKey, err)
+			return
 		}
+		rc.state = state.Recording
+		rc.logger.Info("state changed", "state", state.State(state.Recording))
 	}
 }
 
 func (rc *RtspClient) onPlay() {
-	log.Println("[GUI] play button has been pressed.")
+	rc.guiLogger().Debug("play button pressed")
 
 	if rc.state == state.Ready {
 		rc.sequentialNumber++
 		rc.rtpReceiver.SetStartTime(time.Now().UnixNano() / int64(time.Millisecond))
 
-		rc.sendRequest(message.Play)
-		replyCode := rc.parseResponse()
+		replyCode := rc.exchange(message.Play)
 
 		if replyCode == "200" {
+			err := rc.startReceiving()
+			if err != nil {
+				rc.logger.Error("stream can't be received", logging.ErrorKey, err)
+				return
+			}
 			rc.state = state.Playing
-			rc.rtpReceiver.Start()
-			rc.rtcpSender.Start()
-			if !rc.isServerside {
+			if rc.imageRefresh != nil {
 				rc.imageRefresh.Start()
 			}
-			log.Println("[RTSP] State change to Playing")
+			rc.logger.Info("state changed", "state", state.State(state.Playing))
 		}
 	}
 }
 
+// startReceiving starts receiver of the stream and its feedback, neither runs when one of them fails
+func (rc *RtspClient) startReceiving() error {
+	err := rc.rtpReceiver.Start(rc.ctx)
+	if err != nil {
+		return err
+	}
+	err = rc.rtcpSender.Start(rc.ctx)
+	if err != nil {
+		rc.rtpReceiver.Stop()
+		return err
+	}
+	return nil
+}
+
 func (rc *RtspClient) onPause() {
-	log.Println("[GUI] pause button has been pressed.")
+	rc.guiLogger().Debug("pause button pressed")
 
 	if rc.state == state.Playing {
 		rc.sequentialNumber++
 
-		rc.sendRequest(message.Pause)
-		replyCode := rc.parseResponse()
+		replyCode := rc.exchange(message.Pause)
 
 		if replyCode == "200" {
 			rc.state = state.Ready
@@ -171,40 +406,64 @@ func (rc *RtspClient) onPause() {
 				rc.imageRefresh.Stop()
 			}
 
-			log.Println("[RTSP] State change to READY")
+			rc.logger.Info("state changed", "state", state.State(state.Ready))
 		}
 	} else if rc.state == state.Recording {
+		rc.sequentialNumber++
 		rc.sendRequest(message.Pause)
 		// pause
 		rc.server.ParseRequest()
-		rc.server.SendResponse()
 
 		replyCode := rc.parseResponse()
 		if replyCode == "200" {
 			rc.state = state.Ready
 			rc.broadcast.Stop()
-			log.Println("[RTSP] State change to READY")
+			rc.logger.Info("state changed", "state", state.State(state.Ready))
 		}
 	}
 }
 
+func (rc *RtspClient) onRewind() {
+	rc.guiLogger().Debug("rewind button pressed")
+	rc.timeShift += RewindStep
+	rc.restartPlayback()
+}
+
+func (rc *RtspClient) onLive() {
+	rc.guiLogger().Debug("live button pressed")
+	rc.timeShift = 0
+	rc.restartPlayback()
+}
+
+// restartPlayback applies changed time shift to the ongoing playback
+func (rc *RtspClient) restartPlayback() {
+	if rc.state == state.Playing {
+		rc.onPause()
+		rc.onPlay()
+	}
+}
+
 func (rc *RtspClient) onDescribe() {
-	log.Println("[GUI] describe button has been pressed.")
+	rc.guiLogger().Debug("describe button pressed")
 
 	rc.sequentialNumber++
-	rc.sendRequest(message.Describe)
-	replyCode := rc.parseResponse()
+	replyCode := rc.exchange(message.Describe)
 
 	if replyCode == "200" {
-		log.Println("[RTSP] received response for DESCRIBE")
+		rc.logger.Debug("received response for DESCRIBE")
 	}
 }
 
 func (rc *RtspClient) onTeardown() {
-	log.Println("[GUI] teardown button has been pressed.")
+	rc.guiLogger().Debug("teardown button pressed")
 
 	rc.sequentialNumber++
 	rc.sendRequest(message.Teardown)
+	if rc.server != nil {
+		// the server tears down the published stream before it answers
+		rc.server.ParseRequest()
+		rc.broadcast.Stop()
+	}
 	replyCode := rc.parseResponse()
 	if replyCode == "200" {
 		rc.state = state.Init
@@ -213,28 +472,16 @@ func (rc *RtspClient) onTeardown() {
 		}
 		rc.rtpReceiver.Close()
 		rc.rtcpSender.Close()
-
-		if rc.server != nil {
-			// teardown
-			rc.server.ParseRequest()
-			rc.server.SendResponse()
-
-			rc.broadcast.Stop()
-		}
-		log.Println("[RTSP] new client State: INIT")
+		rc.logger.Info("state changed", "state", state.State(state.Init))
 	}
 }
 
 func (rc *RtspClient) sendRequest(requestType message.Message) {
 	request := fmt.Sprintf("%v %v RTSP/1.0\r\nCSeq: %v\r\n",
 		requestType, rc.videoFileName, rc.sequentialNumber)
+	body := ""
 
 	if requestType == message.Setup {
-		clientsideServerPort, err := freeport.GetFreePort()
-		if err != nil {
-			log.Fatalln("[RTSP] cannot allocate free port:", err)
-		}
-		rc.clientsideSrvPort = clientsideServerPort
 		request += fmt.Sprintf("Transport: RTP/UDP;client_port=%v,%v\r\n",
 			rc.rtpReceiver.listeningPort, rc.clientsideSrvPort)
 	} else if requestType == message.Describe {
@@ -242,21 +489,114 @@ func (rc *RtspClient) sendRequest(requestType message.Message) {
 	} else {
 		request += fmt.Sprintf("Session: %v\r\n", rc.sessionId)
 	}
+	if rc.challenge != nil && rc.credentials != nil {
+		request += fmt.Sprintf("Authorization: %v\r\n", rc.authorization(requestType))
+	}
+
+	if requestType == message.Play && rc.timeShift > 0 {
+		request += fmt.Sprintf("Range: %v\r\n", util.FormatClockRange(time.Now().Add(-rc.timeShift)))
+	}
+	if requestType == message.Record && rc.server == nil && rc.recordKey != nil {
+		// the server receives master key of the published stream before it connects back
+		body = fmt.Sprintf("v=0\r\nm=video 0 RTP/SAVP %v\r\n%v\r\n", MjpegType,
+			srtp.FormatCryptoAttribute(1, *rc.recordKey))
+		request += fmt.Sprintf("Content-Type: application/sdp\r\nContent-Length: %v\r\n", len(body))
+	}
+	// empty line ends headers of the request
+	request += "\r\n" + body
 
 	_, err := rc.serverConnection.Write([]byte(request))
 	if err != nil {
-		log.Println("[RTSP] error while sending request to the server:", err)
+		rc.logger.Error("error while sending request to the server", logging.ErrorKey, err)
 		return
 	}
 }
 
+// exchange sends request and returns reply code of the response, request rejected with authentication challenge
+// is repeated with credentials
+func (rc *RtspClient) exchange(requestType message.Message) string {
+	hadChallenge := rc.challenge != nil
+	rc.sendRequest(requestType)
+	replyCode := rc.parseResponse()
+	if replyCode == "401" && rc.credentials != nil && rc.challenge != nil && !hadChallenge {
+		rc.logger.Info("server requires authentication, repeating request with credentials")
+		rc.sequentialNumber++
+		rc.sendRequest(requestType)
+		replyCode = rc.parseResponse()
+	}
+	return replyCode
+}
+
+// authorization answers the latest challenge of the server
+func (rc *RtspClient) authorization(requestType message.Message) string {
+	password, _ := rc.credentials.Password()
+	credentials := auth.Credentials{
+		Scheme:   rc.challenge.Scheme,
+		Username: rc.credentials.Username(),
+		Password: password,
+	}
+	if rc.challenge.Scheme != auth.DigestScheme {
+		return credentials.String()
+	}
+
+	rc.nonceCount++
+	credentials.Realm = rc.challenge.Realm
+	credentials.Nonce = rc.challenge.Nonce
+	credentials.Uri = rc.videoFileName
+	credentials.Algorithm = rc.challenge.Algorithm
+	credentials.Qop = rc.challenge.Qop
+	if credentials.Qop != "" {
+		credentials.NonceCount = fmt.Sprintf("%08x", rc.nonceCount)
+		credentials.Cnonce = strings.Replace(uuid.New().String(), "-", "", -1)
+	}
+	credentialsHash, err := auth.HashCredentials(credentials.Algorithm, credentials.Username, credentials.Realm,
+		password)
+	if err == nil {
+		credentials.Response, err = auth.DigestResponse(credentials.Algorithm, credentialsHash, credentials.Nonce,
+			credentials.NonceCount, credentials.Cnonce, credentials.Qop, string(requestType), credentials.Uri)
+	}
+	if err != nil {
+		rc.logger.Error("cannot answer authentication challenge", logging.ErrorKey, err)
+	}
+	return credentials.String()
+}
+
+// selectChallenge picks the strongest supported challenge of 401 response
+func selectChallenge(responseLines []string) *auth.Challenge {
+	var result *auth.Challenge
+	rank := func(challenge *auth.Challenge) int {
+		if challenge == nil {
+			return -1
+		}
+		if challenge.Scheme == auth.BasicScheme {
+			return 0
+		}
+		for index, algorithm := range auth.Algorithms {
+			if strings.EqualFold(challenge.Algorithm, algorithm) {
+				return len(auth.Algorithms) - index
+			}
+		}
+		return -1
+	}
+	for _, line := range responseLines {
+		nameAndValue := strings.SplitN(line, ":", 2)
+		if len(nameAndValue) != 2 || !strings.EqualFold(nameAndValue[0], "WWW-Authenticate") {
+			continue
+		}
+		challenge, err := auth.ParseChallenge(nameAndValue[1])
+		if err == nil && rank(&challenge) > rank(result) {
+			result = &challenge
+		}
+	}
+	return result
+}
+
 func (rc *RtspClient) parseResponse() string {
-	log.Println("[RTSP] received response from server")
 
 	responseBytes := make([]byte, 10000)
 	_, err := rc.serverConnection.Read(responseBytes)
 	if err != nil {
-		log.Println("[RTSP] error while reading response from server:", err)
+		rc.logger.Error("error while reading response from server", logging.ErrorKey, err)
 		return ""
 	}
 	responseLines := strings.Split(string(responseBytes), "\r\n")
@@ -266,27 +606,76 @@ func (rc *RtspClient) parseResponse() string {
 
 	requestElements := make([]string, 0)
 	for _, line := range responseLines {
-		log.Println("\t[RTSP message]", line)
+		rc.logger.Debug("response line received", "line", line)
 		requestElements = append(requestElements, strings.Split(line, " ")...)
+		if strings.HasPrefix(line, srtp.CryptoAttribute) {
+			masterKey, err := srtp.ParseCryptoAttribute(strings.TrimPrefix(line, srtp.CryptoAttribute))
+			if err != nil {
+				rc.logger.Error("invalid master key of the stream", logging.ErrorKey, err)
+			} else {
+				rc.srtpKey = &masterKey
+			}
+		}
+		if strings.HasPrefix(line, util.FrameSizeAttribute) {
+			width, height, err := util.ParseFrameSize(strings.TrimPrefix(line, util.FrameSizeAttribute))
+			if err != nil {
+				rc.logger.Error("invalid resolution of the stream", logging.ErrorKey, err)
+			} else {
+				rc.rtpReceiver.SetSourceResolution(width, height)
+			}
+		}
+	}
+	if len(requestElements) < 2 {
+		rc.logger.Error("malformed response from server")
+		return ""
 	}
 	replyCode := requestElements[1]
 	if replyCode == "200" {
 		if rc.state == state.Init {
-			sessionId := requestElements[6]
-			rc.sessionId = sessionId
-			ports, err := util.ParseParameter(requestElements[8], "server_port")
+			sessionId, err := util.ParseHeader(requestElements, "Session")
+			if err == nil {
+				rc.sessionId = sessionId
+				// serverside client logs with fields of the server session it belongs to
+				if !rc.isServerside {
+					rc.logger = rc.logger.With(logging.SessionKey, sessionId)
+					rc.rtpReceiver.SetLogger(rc.logger)
+					rc.rtcpSender.SetLogger(rc.logger)
+				}
+			}
+			transport, err := util.ParseHeader(requestElements, "Transport")
+			var ports []string
+			if err == nil {
+				ports, err = util.ParseParameter(transport, "server_port")
+			}
 			if err == nil {
 				serverAddress := strings.Split(rc.serverConnection.RemoteAddr().String(), ":")[0]
-				rc.rtcpSender.InitConnection(fmt.Sprintf("%v:%v", serverAddress, ports[0]))
+				err = rc.rtcpSender.InitConnection(rc.ctx, fmt.Sprintf("%v:%v", serverAddress, ports[0]))
+				if err != nil {
+					rc.logger.Error("feedback can't be sent to the server", logging.ErrorKey, err)
+				}
 			}
 		}
 	} else {
-		log.Printf("[RTSP] server returned response with error code %v", replyCode)
+		rc.logger.Warn("server returned error response", "status", replyCode)
+		if replyCode == "401" {
+			rc.challenge = selectChallenge(responseLines)
+			rc.nonceCount = 0
+		}
 	}
 	return replyCode
 }
 
+// CloseConnection releases the client, stream published by the client is stopped as well
 func (rc *RtspClient) CloseConnection() {
+	if rc.removeCollector != nil {
+		rc.removeCollector()
+	}
+	if rc.broadcast != nil {
+		rc.broadcast.Stop()
+	}
+	if rc.server != nil {
+		rc.server.release()
+	}
 	if rc.imageRefresh != nil {
 		rc.imageRefresh.Stop()
 	}
@@ -295,6 +684,6 @@ func (rc *RtspClient) CloseConnection() {
 
 	err := rc.serverConnection.Close()
 	if err != nil {
-		log.Println("[RTSP] error while closing connection:", err)
+		rc.logger.Error("error while closing connection", logging.ErrorKey, err)
 	}
 }
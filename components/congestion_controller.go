@@ -1,10 +1,12 @@
 package components
 
 import (
+	"image"
 	"image/jpeg"
-	"log"
+	"streming_server/logging"
 	"streming_server/util"
 	"streming_server/video"
+	"sync"
 	"time"
 )
 
@@ -15,66 +17,203 @@ type CongestionController struct {
 	rtpSender           *RtpSender
 	rtcpReceiver        *RtcpReceiver
 	frameSync           *video.FrameSync
-	qualityAdjuster     *video.QualityAdjuster
+	transcodeCache      *video.StreamTranscodeCache
 	interval            time.Duration
 	doneCheck           chan bool
+	routines            *routineGroup
+	started             bool
 	prevCongestionLevel int
+	fecGroupSize        int
+	strategy            CongestionStrategy
+	logger              *logging.Logger
+	targets             CongestionTargets
+	sourceResolution    image.Point
+	primaryResolution   image.Point
+	mutex               sync.Mutex
 }
 
-func NewCongestionController(rtcpReceiver *RtcpReceiver, frameSync *video.FrameSync) *CongestionController {
-	return &CongestionController{
+func NewCongestionController(rtcpReceiver *RtcpReceiver, frameSync *video.FrameSync,
+	strategyFactory CongestionStrategyFactory) *CongestionController {
+	strategy := strategyFactory(time.Duration(frameSync.FramePeriod) * time.Millisecond)
+	result := &CongestionController{
 		rtcpReceiver:        rtcpReceiver,
 		frameSync:           frameSync,
-		qualityAdjuster:     video.NewQualityAdjuster(),
 		interval:            DefaultCongestionInterval * time.Millisecond,
-		doneCheck:           make(chan bool),
 		prevCongestionLevel: util.NoCongestion,
+		strategy:            strategy,
+		logger:              ccLogger,
+		targets:             strategy.Targets(),
 	}
+	rtcpReceiver.SetCongestionController(result)
+	return result
 }
 
 func (cc *CongestionController) SetRtpSender(rtpSender *RtpSender) {
 	cc.rtpSender = rtpSender
 }
 
+// SetLogger attaches fields of the session to messages of the controller
+func (cc *CongestionController) SetLogger(logger *logging.Logger) {
+	cc.logger = logger.With(logging.ComponentKey, "CC")
+}
+
+// SetInterval sets how often targets are updated, it has to be set before Start
+func (cc *CongestionController) SetInterval(interval time.Duration) {
+	cc.interval = interval
+}
+
+// SetTranscodeCache sets cache shared by sessions of the same stream, without it every frame is transcoded
+// by the session itself
+func (cc *CongestionController) SetTranscodeCache(transcodeCache *video.StreamTranscodeCache) {
+	cc.transcodeCache = transcodeCache
+}
+
+// SetFecGroupSize sets number of media packets per parity packet used without congestion, 0 disables FEC,
+// requires rtp sender to be set
+func (cc *CongestionController) SetFecGroupSize(groupSize int) {
+	cc.fecGroupSize = groupSize
+	cc.rtpSender.fecEncoder.SetGroupSize(cc.resolveFecGroupSize(cc.prevCongestionLevel))
+}
+
+// resolveFecGroupSize halves the group with every congestion level, so more losses can be recovered
+func (cc *CongestionController) resolveFecGroupSize(congestionLevel int) int {
+	if cc.fecGroupSize <= 0 {
+		return 0
+	}
+	groupSize := cc.fecGroupSize >> congestionLevel
+	if groupSize < 2 {
+		groupSize = 2
+	}
+	return groupSize
+}
+
+func (cc *CongestionController) OnReceiverReport(feedback ReceiverFeedback) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	cc.strategy.OnReceiverReport(feedback)
+}
+
+func (cc *CongestionController) OnRemb(bitrate int) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	cc.strategy.OnRemb(bitrate)
+}
+
+// TargetBitrate returns bandwidth estimate of the strategy, 0 when it's unknown
+func (cc *CongestionController) TargetBitrate() int {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	return cc.strategy.Targets().Bitrate
+}
+
+// Targets returns current targets of congestion strategy
+func (cc *CongestionController) Targets() CongestionTargets {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	return cc.strategy.Targets()
+}
+
+// SetPrimaryResolution sets resolution of the primary simulcast layer, frames of lower layers don't change
+// source resolution of the strategy then, zero resolution makes every frame define it
+func (cc *CongestionController) SetPrimaryResolution(resolution image.Point) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	cc.primaryResolution = resolution
+}
+
+// adjustSendRate applies targets of congestion strategy and adapts FEC redundancy to reported loss
 func (cc *CongestionController) adjustSendRate() {
-	if cc.prevCongestionLevel != cc.rtcpReceiver.congestionLevel {
-		sendDelay := cc.frameSync.FramePeriod +
-			cc.rtcpReceiver.congestionLevel*int(float64(cc.frameSync.FramePeriod)*0.1)
-		cc.rtpSender.UpdateInterval(time.Duration(sendDelay) * time.Millisecond)
-		cc.prevCongestionLevel = cc.rtcpReceiver.congestionLevel
-		log.Println("[CC] send delay has been changed to", sendDelay)
+	congestionLevel := cc.rtcpReceiver.CongestionLevel()
+	if cc.prevCongestionLevel != congestionLevel {
+		if cc.fecGroupSize > 0 {
+			fecGroupSize := cc.resolveFecGroupSize(congestionLevel)
+			cc.rtpSender.fecEncoder.SetGroupSize(fecGroupSize)
+			cc.logger.Info("fec group size changed", "groupSize", fecGroupSize)
+		}
+		cc.prevCongestionLevel = congestionLevel
 	}
+
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	targets := cc.strategy.Targets()
+	if targets == cc.targets {
+		return
+	}
+	if targets.FrameInterval != cc.targets.FrameInterval {
+		cc.rtpSender.SetFrameInterval(targets.FrameInterval)
+	}
+	cc.targets = targets
+	cc.logger.Info("targets changed", "bitrate", targets.Bitrate, "frameInterval", targets.FrameInterval,
+		"quality", targets.Quality, "width", targets.Width, "height", targets.Height)
 }
 
+// AdjustCompressionQuality re-encodes frame with quality and resolution required by current targets
 func (cc *CongestionController) AdjustCompressionQuality(frameBuffer []byte, imageLength int) []byte {
-	if cc.rtcpReceiver.congestionLevel > util.NoCongestion {
-		lowerQuality := jpeg.DefaultQuality -
-			int(jpeg.DefaultQuality*0.15*float64(cc.rtcpReceiver.congestionLevel))
-		cc.qualityAdjuster.ChangeCompressionQuality(lowerQuality)
-		frameBytes := cc.qualityAdjuster.Compress(frameBuffer[0:imageLength])
-		log.Println("[CC] quality changed to", lowerQuality)
+	frameBytes := frameBuffer[0:imageLength]
+	resolution, err := video.FrameResolution(frameBytes)
+	if err != nil {
+		cc.logger.Error("cannot read frame resolution", logging.ErrorKey, err)
 		return frameBytes
-	} else {
-		return frameBuffer
 	}
+
+	cc.mutex.Lock()
+	sourceResolution := cc.primaryResolution
+	if sourceResolution == (image.Point{}) {
+		sourceResolution = resolution
+	}
+	if sourceResolution != cc.sourceResolution {
+		cc.sourceResolution = sourceResolution
+		cc.strategy.OnSourceResolution(sourceResolution.X, sourceResolution.Y)
+	}
+	targets := cc.strategy.Targets()
+	cc.mutex.Unlock()
+
+	downscale := targets.Width > 0 && targets.Width < resolution.X
+	if targets.Quality < jpeg.DefaultQuality || downscale {
+		compressedFrame, err := cc.transcodeCache.Transcode(frameBytes, video.TranscodeTier{
+			Quality: targets.Quality,
+			Width:   targets.Width,
+			Height:  targets.Height,
+		})
+		if err != nil {
+			cc.logger.Error("cannot re-encode frame", logging.ErrorKey, err)
+		} else {
+			frameBytes = compressedFrame
+		}
+	}
+
+	cc.mutex.Lock()
+	cc.strategy.OnFrameSent(len(frameBytes))
+	cc.mutex.Unlock()
+	return frameBytes
 }
 
 func (cc *CongestionController) Start() {
+	if cc.started {
+		return
+	}
+	cc.started = true
 	cc.ticker = time.NewTicker(cc.interval)
+	cc.doneCheck = make(chan bool)
 
-	go func() {
+	ticker, doneCheck := cc.ticker, cc.doneCheck
+	cc.routines.Go("congestion controller", func() {
 		for {
 			select {
-			case <-cc.doneCheck:
+			case <-doneCheck:
 				return
-			case <-cc.ticker.C:
+			case <-ticker.C:
 				cc.adjustSendRate()
 			}
 		}
-	}()
+	})
 }
 
+// Stop may be called without Start and repeatedly
 func (cc *CongestionController) Stop() {
-	cc.doneCheck <- true
-	cc.ticker.Stop()
+	if cc.started {
+		close(cc.doneCheck)
+		cc.ticker.Stop()
+		cc.started = false
+	}
 }
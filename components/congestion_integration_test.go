@@ -0,0 +1,266 @@
+package components
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net"
+	"sort"
+	"streming_server/netem"
+	"streming_server/video"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// impairedSession streams synthetic frames from RtpSender to RtpReceiver through impairment relay,
+// feedback goes directly back to the sender
+type impairedSession struct {
+	relay       *netem.Relay
+	frameSync   *video.FrameSync
+	controller  *CongestionController
+	rtpSender   *RtpSender
+	rtpReceiver *RtpReceiver
+	rtcpSender  *RtcpSender
+	doneCheck   chan bool
+}
+
+func startImpairedSession(t *testing.T, impairment netem.Impairment) *impairedSession {
+	frame := syntheticFrame(t)
+	session := &impairedSession{
+		frameSync: video.NewFrameSync(),
+		doneCheck: make(chan bool),
+	}
+
+	mainChannel := make(chan *StreamPacket, 64)
+	go func() {
+		for range mainChannel {
+		}
+	}()
+	ctx := context.Background()
+	rtpReceiver, err := NewRtpReceiverWithServer(ctx,
+		&RtspServer{mainChannel: mainChannel, videoFileName: "/impaired"}, video.NewFrameSync())
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.rtpReceiver = rtpReceiver
+
+	relay, err := netem.NewRelay("127.0.0.1:0", "127.0.0.1:"+session.rtpReceiver.listeningPort, impairment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay.Start()
+	session.relay = relay
+
+	rtcpReceiver, err := NewRtcpReceiver(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.controller = NewCongestionController(rtcpReceiver, session.frameSync, NewBandwidthStrategy)
+	session.rtpSender, err = NewRtpSender(ctx, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}, relay.Address().Port,
+		session.controller, rtcpReceiver, session.frameSync)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rtcpReceiver.SetRtpSender(session.rtpSender)
+	session.controller.SetRtpSender(session.rtpSender)
+	session.controller.Start()
+
+	session.rtcpSender = NewRtcpSender(session.rtpReceiver)
+	err = session.rtcpSender.InitConnection(ctx, fmt.Sprint("127.0.0.1:", rtcpReceiver.ServerPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, start := range []func(context.Context) error{
+		session.rtpReceiver.Start, session.rtcpSender.Start, session.rtpSender.Start,
+	} {
+		if err := start(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(video.DefaultFramePeriod) * time.Millisecond)
+		defer ticker.Stop()
+		// every frame gets its own copy, as frames of the live stream differ
+		for seqNum := 1; ; seqNum++ {
+			select {
+			case <-session.doneCheck:
+				return
+			case <-ticker.C:
+				session.frameSync.AddFrame(append([]byte(nil), frame...), seqNum)
+			}
+		}
+	}()
+	return session
+}
+
+// bitrateWindow is period over which single sample of sent bitrate is taken
+const bitrateWindow = time.Second
+
+// sentBitrate measures bitrate offered by the sender to the relay over given period, it's median of samples
+// taken over elapsed rather than planned time, so a stall of the test itself doesn't skew the result
+func (s *impairedSession) sentBitrate(period time.Duration) float64 {
+	samples := make([]float64, 0, period/bitrateWindow)
+	for len(samples) == 0 || time.Duration(len(samples))*bitrateWindow < period {
+		before := s.relay.Stats()
+		startTime := time.Now()
+		time.Sleep(bitrateWindow)
+		after := s.relay.Stats()
+		samples = append(samples, float64(after.ReceivedBytes-before.ReceivedBytes)*8/time.Since(startTime).Seconds())
+	}
+	sort.Float64s(samples)
+	return samples[len(samples)/2]
+}
+
+func (s *impairedSession) close() {
+	close(s.doneCheck)
+	s.rtpSender.Close()
+	s.controller.Stop()
+	s.rtcpSender.Close()
+	s.rtpReceiver.Close()
+	s.relay.Close()
+}
+
+// syntheticFrame returns 640x480 frame with enough detail to exceed capped links
+func syntheticFrame(t *testing.T) []byte {
+	source := image.NewRGBA(image.Rect(0, 0, 640, 480))
+	for y := 0; y < 480; y++ {
+		for x := 0; x < 640; x++ {
+			source.SetRGBA(x, y, color.RGBA{R: uint8(x * y), G: uint8(y), B: uint8(x ^ y), A: 255})
+		}
+	}
+	buffer := new(bytes.Buffer)
+	err := jpeg.Encode(buffer, source, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func skipCongestionTest(t *testing.T) {
+	if testing.Short() {
+		t.Skip("congestion integration test takes several seconds")
+	}
+	if raceEnabled {
+		t.Skip("congestion integration test measures bitrate, which race detector distorts")
+	}
+}
+
+// CPU starvation of that length delays packets like a congested queue, so the sender rightly backs off
+const maxSchedulerStall = 20 * time.Millisecond
+
+// stallWatch records the longest delay of a goroutine which only waits for a timer
+type stallWatch struct {
+	longest   int64
+	doneCheck chan bool
+}
+
+func watchStalls() *stallWatch {
+	watch := &stallWatch{doneCheck: make(chan bool)}
+	go func() {
+		const tick = 5 * time.Millisecond
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+		lastTick := time.Now()
+		for {
+			select {
+			case <-watch.doneCheck:
+				return
+			case now := <-ticker.C:
+				if stall := int64(now.Sub(lastTick) - tick); stall > atomic.LoadInt64(&watch.longest) {
+					atomic.StoreInt64(&watch.longest, stall)
+				}
+				lastTick = now
+			}
+		}
+	}()
+	return watch
+}
+
+func (w *stallWatch) close() {
+	close(w.doneCheck)
+}
+
+// failUnlessStalled reports failure of bitrate assertion, result of the test which was starved of CPU
+// doesn't tell anything about congestion control, so the test is skipped like under race detector
+func (w *stallWatch) failUnlessStalled(t *testing.T, format string, args ...interface{}) {
+	t.Helper()
+	if stall := time.Duration(atomic.LoadInt64(&w.longest)); stall >= maxSchedulerStall {
+		t.Skipf("scheduler stalled for %v, bitrate isn't meaningful: "+format, append([]interface{}{stall}, args...)...)
+	}
+	t.Errorf(format, args...)
+}
+
+func TestSenderConvergesToBandwidthCap(t *testing.T) {
+	skipCongestionTest(t)
+	t.Parallel()
+	const capacity = 2 * 1000 * 1000
+	session := startImpairedSession(t, netem.Impairment{
+		Delay:     20 * time.Millisecond,
+		Bandwidth: capacity,
+		Seed:      1,
+	})
+	defer session.close()
+	stalls := watchStalls()
+	defer stalls.close()
+
+	time.Sleep(10 * time.Second)
+	bitrate := session.sentBitrate(5 * time.Second)
+	if bitrate > 1.5*capacity || bitrate < 0.25*capacity {
+		stalls.failUnlessStalled(t, "sender didn't converge to link capacity %v bps, sent %.0f bps", capacity, bitrate)
+	}
+	if target := session.controller.TargetBitrate(); target > 2*capacity {
+		t.Errorf("target bitrate %v bps is far above link capacity %v bps", target, capacity)
+	}
+}
+
+func TestSenderBacksOffUnderBurstLoss(t *testing.T) {
+	skipCongestionTest(t)
+	t.Parallel()
+	session := startImpairedSession(t, netem.Impairment{Seed: 2})
+	defer session.close()
+
+	time.Sleep(2 * time.Second)
+	unimpairedBitrate := session.sentBitrate(3 * time.Second)
+
+	session.relay.SetImpairment(netem.Impairment{
+		BurstLoss: netem.GilbertElliott{P: 0.1, R: 0.2, LossBad: 0.9},
+		Seed:      2,
+	})
+	time.Sleep(8 * time.Second)
+	bitrate := session.sentBitrate(4 * time.Second)
+	if bitrate > 0.6*unimpairedBitrate {
+		t.Errorf("sender didn't back off under 30%% bursty loss, sent %.0f bps, without loss %.0f bps",
+			bitrate, unimpairedBitrate)
+	}
+}
+
+func TestSenderKeepsRateUnderJitter(t *testing.T) {
+	skipCongestionTest(t)
+	t.Parallel()
+	session := startImpairedSession(t, netem.Impairment{Seed: 3})
+	defer session.close()
+	stalls := watchStalls()
+	defer stalls.close()
+
+	time.Sleep(2 * time.Second)
+	unimpairedBitrate := session.sentBitrate(3 * time.Second)
+
+	// jitter without queue growth or loss must not be taken for congestion
+	session.relay.SetImpairment(netem.Impairment{
+		Delay:  30 * time.Millisecond,
+		Jitter: 5 * time.Millisecond,
+		Seed:   3,
+	})
+	time.Sleep(5 * time.Second)
+	bitrate := session.sentBitrate(4 * time.Second)
+	if bitrate < 0.8*unimpairedBitrate {
+		stalls.failUnlessStalled(t, "sender backed off under jitter only, sent %.0f bps, without jitter %.0f bps",
+			bitrate, unimpairedBitrate)
+	}
+}
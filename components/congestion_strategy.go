@@ -0,0 +1,75 @@
+package components
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const DefaultCongestionStrategy = "bandwidth"
+
+// ReceiverFeedback holds data of single receiver report
+type ReceiverFeedback struct {
+	FractionLost  float64
+	RoundTripTime time.Duration
+	// interarrival jitter (RFC 3550) in microseconds
+	Jitter int
+	// smoothed difference between inter-arrival and inter-departure time of frames in microseconds
+	DelayGradient   int
+	ReceivedBitrate int
+}
+
+// CongestionTargets describe how the stream should be sent
+type CongestionTargets struct {
+	// bits per second, 0 when strategy doesn't estimate bandwidth
+	Bitrate int
+	// minimal distance between sent frames, frames coming more often are dropped
+	FrameInterval time.Duration
+	// jpeg quality, frames are re-encoded only below jpeg.DefaultQuality
+	Quality int
+	// dimensions of sent frames, 0 keeps original resolution
+	Width  int
+	Height int
+}
+
+// CongestionStrategy turns feedback events into sending targets, calls are serialized by CongestionController
+type CongestionStrategy interface {
+	OnReceiverReport(feedback ReceiverFeedback)
+	// OnRemb handles receiver estimated maximum bitrate
+	OnRemb(bitrate int)
+	// OnFrameSent reports size of every sent frame after re-encoding
+	OnFrameSent(size int)
+	// OnSourceResolution is called before the first frame and whenever resolution of source frames changes
+	OnSourceResolution(width int, height int)
+	Targets() CongestionTargets
+}
+
+// CongestionStrategyFactory creates strategy for single session, framePeriod is the period of source frames
+type CongestionStrategyFactory func(framePeriod time.Duration) CongestionStrategy
+
+var congestionStrategies = map[string]CongestionStrategyFactory{
+	"bandwidth": NewBandwidthStrategy,
+	"legacy":    NewLegacyStrategy,
+}
+
+// RegisterCongestionStrategy makes strategy selectable by name, it should be called before server starts
+func RegisterCongestionStrategy(name string, factory CongestionStrategyFactory) {
+	congestionStrategies[name] = factory
+}
+
+func FindCongestionStrategy(name string) (CongestionStrategyFactory, error) {
+	factory, ok := congestionStrategies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown congestion strategy %q, available: %v", name, CongestionStrategyNames())
+	}
+	return factory, nil
+}
+
+func CongestionStrategyNames() []string {
+	result := make([]string, 0, len(congestionStrategies))
+	for name := range congestionStrategies {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
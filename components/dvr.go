@@ -0,0 +1,310 @@
+package components
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"streming_server/logging"
+	"streming_server/protocol/rtp"
+	"streming_server/video"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const DefaultDvrSegmentDuration = 10 * time.Second
+const DefaultDvrRetention = time.Hour
+const dvrSegmentExtension = ".seg"
+const dvrQueueSize = 64
+
+type dvrSegment struct {
+	startTime time.Time
+	fileName  string
+}
+
+type dvrFrame struct {
+	packet    *rtp.Packet
+	timestamp time.Time
+}
+
+// Dvr continuously records single mount point into time based segments, segments older than retention are removed
+type Dvr struct {
+	segmentWriter   *video.SegmentWriter
+	segments        []*dvrSegment
+	mutex           sync.Mutex
+	directory       string
+	segmentDuration time.Duration
+	retention       time.Duration
+	frameChannel    chan *dvrFrame
+	doneCheck       chan bool
+	// DvrManager.Close stops the dvr while the fan-out loop may still feed it
+	started int32
+}
+
+func NewDvr(directory string, segmentDuration time.Duration, retention time.Duration) (*Dvr, error) {
+	err := os.MkdirAll(directory, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	dvr := &Dvr{
+		segments:        make([]*dvrSegment, 0),
+		directory:       directory,
+		segmentDuration: segmentDuration,
+		retention:       retention,
+		frameChannel:    make(chan *dvrFrame, dvrQueueSize),
+		doneCheck:       make(chan bool),
+	}
+	err = dvr.loadSegments()
+	if err != nil {
+		return nil, err
+	}
+	dvr.removeExpiredSegments()
+	return dvr, nil
+}
+
+// segments which were recorded before server restart are still available for playback
+func (dvr *Dvr) loadSegments() error {
+	files, err := ioutil.ReadDir(dvr.directory)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), dvrSegmentExtension) {
+			continue
+		}
+		startTime, err := strconv.ParseInt(strings.TrimSuffix(file.Name(), dvrSegmentExtension), 10, 64)
+		if err != nil {
+			continue
+		}
+		dvr.segments = append(dvr.segments, &dvrSegment{
+			startTime: time.Unix(0, startTime),
+			fileName:  filepath.Join(dvr.directory, file.Name()),
+		})
+	}
+	sort.Slice(dvr.segments, func(i, j int) bool {
+		return dvr.segments[i].startTime.Before(dvr.segments[j].startTime)
+	})
+	return nil
+}
+
+// Feed passes packet to the dvr without blocking the caller
+func (dvr *Dvr) Feed(packet *rtp.Packet) {
+	if atomic.LoadInt32(&dvr.started) == 0 {
+		return
+	}
+	select {
+	case dvr.frameChannel <- &dvrFrame{packet: packet, timestamp: time.Now()}:
+	default:
+		dvrLogger.Warn("dvr queue is full, frame dropped")
+	}
+}
+
+func (dvr *Dvr) openSegment(startTime time.Time) error {
+	fileName := filepath.Join(dvr.directory, fmt.Sprint(startTime.UnixNano(), dvrSegmentExtension))
+	segmentWriter, err := video.NewSegmentWriter(fileName)
+	if err != nil {
+		return err
+	}
+
+	dvr.mutex.Lock()
+	dvr.segments = append(dvr.segments, &dvrSegment{startTime: startTime, fileName: fileName})
+	dvr.mutex.Unlock()
+
+	dvr.segmentWriter = segmentWriter
+	return nil
+}
+
+func (dvr *Dvr) closeSegment() {
+	if dvr.segmentWriter == nil {
+		return
+	}
+	err := dvr.segmentWriter.Close()
+	if err != nil {
+		dvrLogger.Error("error while closing segment", logging.ErrorKey, err)
+	}
+	dvr.segmentWriter = nil
+}
+
+func (dvr *Dvr) removeExpiredSegments() {
+	dvr.mutex.Lock()
+	defer dvr.mutex.Unlock()
+
+	expirationTime := time.Now().Add(-dvr.retention)
+	// segment expires when the next one started before expiration time
+	for len(dvr.segments) > 1 && dvr.segments[1].startTime.Before(expirationTime) {
+		err := os.Remove(dvr.segments[0].fileName)
+		if err != nil {
+			dvrLogger.Error("cannot remove expired segment", logging.ErrorKey, err)
+		}
+		dvr.segments = dvr.segments[1:]
+	}
+}
+
+func (dvr *Dvr) writeFrame(frame *dvrFrame) {
+	if dvr.segmentWriter != nil && frame.timestamp.Sub(dvr.lastSegment().startTime) >= dvr.segmentDuration {
+		dvr.closeSegment()
+		dvr.removeExpiredSegments()
+	}
+	if dvr.segmentWriter == nil {
+		err := dvr.openSegment(frame.timestamp)
+		if err != nil {
+			dvrLogger.Error("cannot create segment", logging.ErrorKey, err)
+			return
+		}
+	}
+
+	err := dvr.segmentWriter.WriteFrame(&video.SegmentFrame{
+		Timestamp: frame.timestamp,
+		SeqNum:    frame.packet.Header.SequenceNumber,
+		Data:      frame.packet.Payload,
+	})
+	if err != nil {
+		dvrLogger.Error("error while writing frame", logging.ErrorKey, err)
+	}
+}
+
+func (dvr *Dvr) lastSegment() *dvrSegment {
+	dvr.mutex.Lock()
+	defer dvr.mutex.Unlock()
+	if len(dvr.segments) == 0 {
+		return nil
+	}
+	return dvr.segments[len(dvr.segments)-1]
+}
+
+// segmentAt returns segment containing frames from given time or the oldest one when time is out of retention
+func (dvr *Dvr) segmentAt(moment time.Time) *dvrSegment {
+	dvr.mutex.Lock()
+	defer dvr.mutex.Unlock()
+	if len(dvr.segments) == 0 {
+		return nil
+	}
+	result := dvr.segments[0]
+	for _, segment := range dvr.segments {
+		if segment.startTime.After(moment) {
+			break
+		}
+		result = segment
+	}
+	return result
+}
+
+// nextSegment returns nil when given segment is the latest one
+func (dvr *Dvr) nextSegment(current *dvrSegment) *dvrSegment {
+	dvr.mutex.Lock()
+	defer dvr.mutex.Unlock()
+	for _, segment := range dvr.segments {
+		if segment.startTime.After(current.startTime) {
+			return segment
+		}
+	}
+	return nil
+}
+
+func (dvr *Dvr) Start() {
+	atomic.StoreInt32(&dvr.started, 1)
+
+	go func() {
+		for {
+			select {
+			case <-dvr.doneCheck:
+				return
+			case frame := <-dvr.frameChannel:
+				dvr.writeFrame(frame)
+			}
+		}
+	}()
+}
+
+func (dvr *Dvr) Stop() {
+	if atomic.CompareAndSwapInt32(&dvr.started, 1, 0) {
+		dvr.doneCheck <- true
+		dvr.closeSegment()
+	}
+}
+
+// DvrManager keeps separate dvr for every mount point, dvr is created with the first received frame
+type DvrManager struct {
+	dvrs            map[string]*Dvr
+	mutex           sync.Mutex
+	directory       string
+	segmentDuration time.Duration
+	retention       time.Duration
+}
+
+func NewDvrManager(directory string, segmentDuration time.Duration, retention time.Duration) *DvrManager {
+	return &DvrManager{
+		dvrs:            make(map[string]*Dvr),
+		directory:       directory,
+		segmentDuration: segmentDuration,
+		retention:       retention,
+	}
+}
+
+// dvrFor returns dvr of the mount point, it has to be called with locked mutex
+func (m *DvrManager) dvrFor(path string) *Dvr {
+	dvr, found := m.dvrs[path]
+	if found {
+		return dvr
+	}
+	dvr, err := NewDvr(filepath.Join(m.directory, streamDirectoryName(path)), m.segmentDuration, m.retention)
+	if err != nil {
+		dvrLogger.Error("cannot start dvr", logging.PathKey, path, logging.ErrorKey, err)
+		dvr = nil
+	} else {
+		dvr.Start()
+		dvrLogger.Info("recording started", logging.PathKey, path)
+	}
+	m.dvrs[path] = dvr
+	return dvr
+}
+
+func (m *DvrManager) Feed(path string, packet *rtp.Packet) {
+	m.mutex.Lock()
+	dvr := m.dvrFor(path)
+	m.mutex.Unlock()
+
+	if dvr != nil {
+		dvr.Feed(packet)
+	}
+}
+
+// Get returns nil when mount point has never been recorded
+func (m *DvrManager) Get(path string) *Dvr {
+	if m == nil {
+		return nil
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	_, err := os.Stat(filepath.Join(m.directory, streamDirectoryName(path)))
+	if _, found := m.dvrs[path]; !found && err != nil {
+		return nil
+	}
+	return m.dvrFor(path)
+}
+
+func (m *DvrManager) Close() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, dvr := range m.dvrs {
+		if dvr != nil {
+			dvr.Stop()
+		}
+	}
+}
+
+// streamDirectoryName converts mount point path to the name safe to use in file system
+func streamDirectoryName(path string) string {
+	name := strings.Trim(path, "/")
+	name = strings.ReplaceAll(name, "/", "_")
+	name = strings.ReplaceAll(name, "..", "_")
+	if name == "" {
+		return "default"
+	}
+	return name
+}
@@ -0,0 +1,131 @@
+package components
+
+import (
+	"io"
+	"streming_server/logging"
+	"streming_server/video"
+	"sync/atomic"
+	"time"
+)
+
+// gaps in the recording (e.g. paused publisher) longer than this are skipped during playback
+const maxDvrFrameGap = time.Second
+const dvrLiveEdgePollInterval = 10 * time.Millisecond
+
+// DvrPlayer feeds session with recorded frames starting from given moment, when it reaches
+// the end of recording the onLive callback is called and player stops
+type DvrPlayer struct {
+	dvr       *Dvr
+	frameSync *video.FrameSync
+	startTime time.Time
+	scale     float64
+	onLive    func()
+	seqNum    int
+	doneCheck chan bool
+	routines  *routineGroup
+	// Stop may run on another goroutine than Start, so started is accessed atomically
+	started int32
+}
+
+func NewDvrPlayer(dvr *Dvr, frameSync *video.FrameSync, startTime time.Time, scale float64,
+	onLive func()) *DvrPlayer {
+	if scale <= 0 {
+		scale = 1
+	}
+	return &DvrPlayer{
+		dvr:       dvr,
+		frameSync: frameSync,
+		startTime: startTime,
+		scale:     scale,
+		onLive:    onLive,
+		doneCheck: make(chan bool),
+	}
+}
+
+// wait returns false when player has been stopped in the meantime
+func (p *DvrPlayer) wait(duration time.Duration) bool {
+	if duration <= 0 {
+		return true
+	}
+	select {
+	case <-p.doneCheck:
+		return false
+	case <-time.After(duration):
+		return true
+	}
+}
+
+func (p *DvrPlayer) play() {
+	segment := p.dvr.segmentAt(p.startTime)
+	if segment == nil {
+		p.onLive()
+		return
+	}
+	reader, err := video.NewSegmentReader(segment.fileName)
+	if err != nil {
+		dvrLogger.Error("cannot open segment", logging.ErrorKey, err)
+		p.onLive()
+		return
+	}
+	defer func() { reader.Close() }()
+
+	var mediaTime time.Time
+	var wallTime time.Time
+	for {
+		frame, err := reader.NextFrame()
+		if err == io.EOF {
+			next := p.dvr.nextSegment(segment)
+			if next == nil {
+				// end of recording reached, session continues with live stream
+				p.onLive()
+				return
+			}
+			nextReader, err := video.NewSegmentReader(next.fileName)
+			if err != nil {
+				dvrLogger.Error("cannot open segment", logging.ErrorKey, err)
+				if !p.wait(dvrLiveEdgePollInterval) {
+					return
+				}
+				continue
+			}
+			reader.Close()
+			reader = nextReader
+			segment = next
+			continue
+		} else if err != nil {
+			dvrLogger.Error("error while reading segment", logging.ErrorKey, err)
+			p.onLive()
+			return
+		}
+
+		if frame.Timestamp.Before(p.startTime) {
+			continue
+		}
+
+		if mediaTime.IsZero() || frame.Timestamp.Sub(mediaTime) > maxDvrFrameGap {
+			mediaTime = frame.Timestamp
+			wallTime = time.Now()
+		}
+		delay := time.Duration(float64(frame.Timestamp.Sub(mediaTime)) / p.scale)
+		if !p.wait(time.Until(wallTime.Add(delay))) {
+			return
+		}
+		mediaTime = frame.Timestamp
+		wallTime = wallTime.Add(delay)
+
+		p.seqNum++
+		p.frameSync.AddFrame(frame.Data, p.seqNum)
+	}
+}
+
+func (p *DvrPlayer) Start() {
+	atomic.StoreInt32(&p.started, 1)
+	dvrLogger.Info("playback started", "from", p.startTime)
+	p.routines.Go("dvr player", p.play)
+}
+
+func (p *DvrPlayer) Stop() {
+	if atomic.CompareAndSwapInt32(&p.started, 1, 0) {
+		close(p.doneCheck)
+	}
+}
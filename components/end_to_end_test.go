@@ -0,0 +1,741 @@
+package components
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/big"
+	"net"
+	"net/url"
+	"streming_server/protocol/rtp"
+	"streming_server/protocol/rtsp/message"
+	"streming_server/protocol/rtsp/state"
+	"streming_server/protocol/srtp"
+	"streming_server/video"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	syntheticWidth  = 160
+	syntheticHeight = 120
+	// number of distinct frames published by synthetic source
+	syntheticFramesNumber = 32
+	waitTimeout           = 10 * time.Second
+)
+
+// testHarness runs RTSP listener on ephemeral port, frames published by synthetic source are recognized
+// by their content, clients connect over RTSPS when tlsConfig is set
+type testHarness struct {
+	t         *testing.T
+	listener  *RtspListener
+	host      string
+	port      string
+	frames    map[string]int
+	tlsConfig *tls.Config
+	server    *StreamingServer
+	doneCheck chan bool
+	waitGroup sync.WaitGroup
+}
+
+// startHarness starts listener whose sessions require credentials when authenticator isn't nil
+func startHarness(t *testing.T, authenticator *Authenticator) *testHarness {
+	listener, err := NewRtspListener("127.0.0.1:0", []StreamConsumer{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return runHarness(t, listener, authenticator, nil)
+}
+
+// startSecureHarness starts RTSPS listener with self-signed certificate trusted by clients of the harness
+func startSecureHarness(t *testing.T) *testHarness {
+	serverConfig, clientConfig := selfSignedTlsConfigs(t)
+	listener, err := NewSecureRtspListener("127.0.0.1:0", serverConfig, []StreamConsumer{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return runHarness(t, listener, nil, clientConfig)
+}
+
+func runHarness(t *testing.T, listener *RtspListener, authenticator *Authenticator,
+	tlsConfig *tls.Config) *testHarness {
+	snapshotCache := NewSnapshotCache()
+	listener.consumers = []StreamConsumer{snapshotCache}
+	listener.configure = func(srv *RtspServer) {
+		srv.SetCongestionStrategy(newFixedStrategy)
+		srv.SetSnapshotCache(snapshotCache)
+		srv.SetAuthenticator(authenticator)
+	}
+	listener.Start()
+	return newHarness(t, listener, tlsConfig)
+}
+
+// fixedStrategy sends every frame as it was published, adaptive strategy may downscale or re-encode frames
+// whenever the loaded machine delays feedback, then clients of the harness can't recognize them
+type fixedStrategy struct {
+	framePeriod time.Duration
+}
+
+// fixedStrategyName selects fixedStrategy in configuration of streaming server
+const fixedStrategyName = "fixed"
+
+func init() {
+	RegisterCongestionStrategy(fixedStrategyName, newFixedStrategy)
+}
+
+func newFixedStrategy(framePeriod time.Duration) CongestionStrategy {
+	return &fixedStrategy{framePeriod: framePeriod}
+}
+
+func (s *fixedStrategy) OnReceiverReport(ReceiverFeedback) {}
+
+func (s *fixedStrategy) OnRemb(int) {}
+
+func (s *fixedStrategy) OnFrameSent(int) {}
+
+func (s *fixedStrategy) OnSourceResolution(int, int) {}
+
+func (s *fixedStrategy) Targets() CongestionTargets {
+	return CongestionTargets{FrameInterval: s.framePeriod, Quality: jpeg.DefaultQuality}
+}
+
+// newHarness connects clients to the started listener
+func newHarness(t *testing.T, listener *RtspListener, tlsConfig *tls.Config) *testHarness {
+	host, port, err := net.SplitHostPort(listener.Address().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	harness := &testHarness{
+		t:         t,
+		listener:  listener,
+		host:      host,
+		port:      port,
+		frames:    make(map[string]int),
+		tlsConfig: tlsConfig,
+		doneCheck: make(chan bool),
+	}
+	for index := 0; index < syntheticFramesNumber; index++ {
+		harness.frames[string(encodeSyntheticFrame(t, index))] = index
+	}
+	t.Cleanup(harness.close)
+	return harness
+}
+
+// publish feeds the mount point with synthetic frames as recording client would do
+func (h *testHarness) publish(path string) {
+	frames := make([][]byte, syntheticFramesNumber)
+	for frame, index := range h.frames {
+		frames[index] = []byte(frame)
+	}
+	h.waitGroup.Add(1)
+	go func() {
+		defer h.waitGroup.Done()
+		ticker := time.NewTicker(time.Duration(video.DefaultFramePeriod) * time.Millisecond)
+		defer ticker.Stop()
+		for seqNum := 1; ; seqNum++ {
+			select {
+			case <-h.doneCheck:
+				return
+			case <-ticker.C:
+				frame := frames[seqNum%syntheticFramesNumber]
+				h.listener.Publish(&StreamPacket{
+					Path:   path,
+					Packet: rtp.NewPacket(rtp.NewHeader(MjpegType, seqNum, 0), len(frame), frame),
+				})
+			}
+		}
+	}()
+}
+
+func (h *testHarness) close() {
+	close(h.doneCheck)
+	h.waitGroup.Wait()
+	if h.server != nil {
+		h.server.Close()
+	} else {
+		h.listener.Close()
+	}
+}
+
+func (h *testHarness) newClient(path string) (*RtspClient, *recordingView) {
+	client := h.newHeadlessClient(path)
+	view := &recordingView{frameSync: client.FrameSync()}
+	client.SetView(view)
+	return client, view
+}
+
+func (h *testHarness) newHeadlessClient(path string) *RtspClient {
+	var client *RtspClient
+	var err error
+	if h.tlsConfig != nil {
+		client, err = NewSecureHeadlessClient(context.Background(), h.host, h.port, path, h.tlsConfig, nil)
+	} else {
+		client, err = NewHeadlessClient(context.Background(), h.host, h.port, path, nil)
+	}
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	client.SetCongestionStrategy(newFixedStrategy)
+	return client
+}
+
+// findSession returns server session of the mount point, nil when there is none
+func (h *testHarness) findSession(path string) *RtspServer {
+	var result *RtspServer
+	h.listener.sessions.Range(func(srv *RtspServer, _ chan *rtp.Packet) bool {
+		if srv.Path() == path {
+			result = srv
+		}
+		return result == nil
+	})
+	return result
+}
+
+// selfSignedTlsConfigs returns configuration of the server with certificate for 127.0.0.1
+// and configuration of clients which trust it
+func selfSignedTlsConfigs(t *testing.T) (*tls.Config, *tls.Config) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "streming_server test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	certificateBytes, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(certificateBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(certificate)
+	serverConfig := &tls.Config{Certificates: []tls.Certificate{{
+		Certificate: [][]byte{certificateBytes},
+		PrivateKey:  privateKey,
+	}}}
+	return serverConfig, &tls.Config{RootCAs: pool}
+}
+
+// expectSessionState waits until any server session of the mount point gets to the expected state
+func (h *testHarness) expectSessionState(path string, expected state.State) {
+	h.t.Helper()
+	waitFor(h.t, fmt.Sprintf("server session in state %v", expected), func() bool {
+		found := false
+		h.listener.sessions.Range(func(srv *RtspServer, _ chan *rtp.Packet) bool {
+			found = srv.Path() == path && srv.State() == expected
+			return !found
+		})
+		return found
+	})
+}
+
+// checkFrames asserts that frames come from the synthetic source in increasing order of sequence numbers
+func (h *testHarness) checkFrames(view *recordingView) {
+	h.t.Helper()
+	frames, seqNums := view.received()
+	for index, frame := range frames {
+		if _, found := h.frames[string(frame)]; !found {
+			h.t.Fatalf("frame no. %v wasn't published by the source", seqNums[index])
+		}
+		if index > 0 && seqNums[index] <= seqNums[index-1] {
+			h.t.Fatalf("frame no. %v received after frame no. %v", seqNums[index], seqNums[index-1])
+		}
+	}
+}
+
+// recordingView keeps frames released by the client's frame sync
+type recordingView struct {
+	frameSync *video.FrameSync
+	frames    [][]byte
+	seqNums   []int
+	mutex     sync.Mutex
+}
+
+func (v *recordingView) UpdateImage() {
+	if !v.frameSync.Ready() {
+		return
+	}
+	frame := v.frameSync.NextFrame()
+	seqNum := v.frameSync.LastSeqNum()
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.frames = append(v.frames, frame)
+	v.seqNums = append(v.seqNums, seqNum)
+}
+
+func (v *recordingView) UpdateStatistics(int, int, float64, int) {}
+
+func (v *recordingView) UpdateResolution(int, int, int, int) {}
+
+func (v *recordingView) received() ([][]byte, []int) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return append([][]byte(nil), v.frames...), append([]int(nil), v.seqNums...)
+}
+
+func (v *recordingView) count() int {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return len(v.frames)
+}
+
+func (v *recordingView) waitForFrames(t *testing.T, frames int) {
+	t.Helper()
+	waitFor(t, fmt.Sprintf("%v frames", frames), func() bool {
+		return v.count() >= frames
+	})
+}
+
+// syntheticSource broadcasts frames without webcam
+type syntheticSource struct {
+	index  int
+	opened bool
+	mutex  sync.Mutex
+}
+
+func (s *syntheticSource) Open() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.opened = true
+	return nil
+}
+
+func (s *syntheticSource) Read() (image.Image, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.index++
+	return syntheticImage(s.index % syntheticFramesNumber), nil
+}
+
+func (s *syntheticSource) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.opened = false
+	return nil
+}
+
+func syntheticImage(index int) image.Image {
+	result := image.NewRGBA(image.Rect(0, 0, syntheticWidth, syntheticHeight))
+	for y := 0; y < syntheticHeight; y++ {
+		for x := 0; x < syntheticWidth; x++ {
+			result.SetRGBA(x, y, color.RGBA{R: uint8(x + 8*index), G: uint8(y), B: uint8(8 * index), A: 255})
+		}
+	}
+	return result
+}
+
+func encodeSyntheticFrame(t *testing.T, index int) []byte {
+	buffer := new(bytes.Buffer)
+	err := jpeg.Encode(buffer, syntheticImage(index), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func waitFor(t *testing.T, description string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(waitTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", description)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func expectClientState(t *testing.T, client *RtspClient, expected state.State) {
+	t.Helper()
+	if client.state != expected {
+		t.Fatalf("client is in state %v, expected %v", client.state, expected)
+	}
+}
+
+func TestPlaybackEndToEnd(t *testing.T) {
+	const path = "/synthetic"
+	harness := startHarness(t, nil)
+	harness.publish(path)
+	waitFor(t, "published frame", func() bool {
+		frame, _ := harness.listener.consumers[0].(*SnapshotCache).Latest(path)
+		return frame != nil
+	})
+
+	client, view := harness.newClient(path)
+	defer client.CloseConnection()
+
+	client.onDescribe()
+	expectClientState(t, client, state.Init)
+	client.rtpReceiver.resolutionMutex.Lock()
+	sourceResolution := client.rtpReceiver.sourceResolution
+	client.rtpReceiver.resolutionMutex.Unlock()
+	if sourceResolution != image.Pt(syntheticWidth, syntheticHeight) {
+		t.Fatalf("described resolution %v, expected %vx%v", sourceResolution, syntheticWidth, syntheticHeight)
+	}
+
+	client.onSetup()
+	expectClientState(t, client, state.Ready)
+	harness.expectSessionState(path, state.Ready)
+
+	client.onPlay()
+	expectClientState(t, client, state.Playing)
+	harness.expectSessionState(path, state.Playing)
+	view.waitForFrames(t, 20)
+	harness.checkFrames(view)
+
+	client.onPause()
+	expectClientState(t, client, state.Ready)
+	harness.expectSessionState(path, state.Ready)
+	// frames which were on the way may still be released
+	time.Sleep(200 * time.Millisecond)
+	pausedFrames := view.count()
+	time.Sleep(300 * time.Millisecond)
+	if view.count() != pausedFrames {
+		t.Fatalf("%v frames received while paused", view.count()-pausedFrames)
+	}
+
+	client.onPlay()
+	expectClientState(t, client, state.Playing)
+	view.waitForFrames(t, pausedFrames+20)
+	harness.checkFrames(view)
+
+	client.onTeardown()
+	expectClientState(t, client, state.Init)
+	harness.expectSessionState(path, state.Init)
+}
+
+func TestRecordEndToEnd(t *testing.T) {
+	const path = "/recorded"
+	harness := startHarness(t, nil)
+
+	publisher := harness.newHeadlessClient(path)
+	defer publisher.CloseConnection()
+	source := &syntheticSource{}
+	publisher.SetFrameSource(source)
+
+	publisher.onSetup()
+	expectClientState(t, publisher, state.Ready)
+	publisher.onRecord()
+	expectClientState(t, publisher, state.Recording)
+	harness.expectSessionState(path, state.Recording)
+
+	viewer, view := harness.newClient(path)
+	defer viewer.CloseConnection()
+	viewer.onSetup()
+	viewer.onPlay()
+	expectClientState(t, viewer, state.Playing)
+	view.waitForFrames(t, 20)
+	harness.checkFrames(view)
+	frames, _ := view.received()
+	resolution, err := video.FrameResolution(frames[len(frames)-1])
+	if err != nil || resolution != image.Pt(syntheticWidth, syntheticHeight) {
+		t.Fatalf("viewer received frame of resolution %v (%v), expected primary layer", resolution, err)
+	}
+
+	publisher.onPause()
+	expectClientState(t, publisher, state.Ready)
+	harness.expectSessionState(path, state.Ready)
+	time.Sleep(300 * time.Millisecond)
+	pausedFrames := view.count()
+	time.Sleep(300 * time.Millisecond)
+	if view.count() != pausedFrames {
+		t.Fatalf("%v frames received while publisher was paused", view.count()-pausedFrames)
+	}
+
+	publisher.onRecord()
+	expectClientState(t, publisher, state.Recording)
+	view.waitForFrames(t, pausedFrames+20)
+	harness.checkFrames(view)
+
+	viewer.onTeardown()
+	expectClientState(t, viewer, state.Init)
+	publisher.onTeardown()
+	expectClientState(t, publisher, state.Init)
+	harness.expectSessionState(path, state.Init)
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+	if source.opened {
+		t.Fatal("frame source wasn't closed after teardown")
+	}
+}
+
+func TestMalformedRequestsAreRejected(t *testing.T) {
+	harness := startHarness(t, nil)
+	connection, err := net.Dial("tcp", net.JoinHostPort(harness.host, harness.port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+	reader := bufio.NewReader(connection)
+
+	requests := []struct {
+		request        string
+		expectedStatus string
+	}{
+		{"OPTIONS\r\n\r\n", "400"},
+		{"PLAY /synthetic RTSP/1.0\r\nCSeq: x\r\n\r\n", "400"},
+		{"SETUP /synthetic RTSP/1.0\r\nCSeq: 3\r\nTransport: RTP/UDP;client_port\r\n\r\n", "461"},
+		{"SETUP /synthetic RTSP/1.0\r\nCSeq: 4\r\nTransport: RTP/UDP;client_port=5000\r\n\r\n", "461"},
+	}
+	for _, request := range requests {
+		_, err = connection.Write([]byte(request.request))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// session must survive, so every request gets its response
+		err = connection.SetReadDeadline(time.Now().Add(waitTimeout))
+		if err != nil {
+			t.Fatal(err)
+		}
+		statusLine, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("no response to %q: %v", request.request, err)
+		}
+		if fields := strings.Fields(statusLine); len(fields) < 2 || fields[1] != request.expectedStatus {
+			t.Fatalf("request %q answered with %q, expected status %v", request.request, statusLine,
+				request.expectedStatus)
+		}
+		for !strings.HasPrefix(statusLine, "Session:") {
+			statusLine, err = reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestFailedSetupKeepsSession(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	connection, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+	serverConnection, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(serverConnection, make(chan *StreamPacket), make(chan *rtp.Packet))
+	routines := newRoutineGroup()
+	srv.attach(context.Background(), routines)
+	// master key of invalid size can't protect the stream
+	srv.describedKey = &srtp.MasterKey{Key: []byte{1}, Salt: []byte{2}}
+	ended := make(chan bool)
+	go func() {
+		defer close(ended)
+		srv.Start()
+	}()
+
+	reader := bufio.NewReader(connection)
+	requests := []struct {
+		request        string
+		expectedStatus string
+	}{
+		{"SETUP /synthetic RTSP/1.0\r\nCSeq: 1\r\nTransport: RTP/UDP;client_port=5000,5001\r\n\r\n", "500"},
+		{"TEARDOWN /synthetic RTSP/1.0\r\nCSeq: 2\r\n\r\n", "200"},
+	}
+	for _, request := range requests {
+		_, err = connection.Write([]byte(request.request))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = connection.SetReadDeadline(time.Now().Add(waitTimeout))
+		if err != nil {
+			t.Fatal(err)
+		}
+		statusLine, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("no response to %q: %v", request.request, err)
+		}
+		if fields := strings.Fields(statusLine); len(fields) < 2 || fields[1] != request.expectedStatus {
+			t.Fatalf("request %q answered with %q, expected status %v", request.request, statusLine,
+				request.expectedStatus)
+		}
+		for !strings.HasPrefix(statusLine, "Session:") {
+			statusLine, err = reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if srv.State() != state.Init {
+			t.Fatalf("session is in state %v after %q", srv.State(), request.request)
+		}
+	}
+
+	connection.Close()
+	select {
+	case <-ended:
+	case <-time.After(waitTimeout):
+		t.Fatal("session didn't end after the client disconnected")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+	defer cancel()
+	if running := routines.Wait(ctx); running != nil {
+		t.Errorf("goroutines of the session are still running: %v", running)
+	}
+}
+
+func TestAuthenticatedSessions(t *testing.T) {
+	const path = "/synthetic"
+	authenticator := NewAuthenticator(DefaultRealm)
+	authenticator.AddUser("viewer", "secret", []string{path}, nil)
+	harness := startHarness(t, authenticator)
+	harness.publish(path)
+
+	anonymous, _ := harness.newClient(path)
+	defer anonymous.CloseConnection()
+	anonymous.onSetup()
+	expectClientState(t, anonymous, state.Init)
+
+	impostor, _ := harness.newClient(path)
+	defer impostor.CloseConnection()
+	impostor.SetCredentials(url.UserPassword("viewer", "guess"))
+	impostor.onSetup()
+	expectClientState(t, impostor, state.Init)
+
+	viewer, view := harness.newClient(path)
+	defer viewer.CloseConnection()
+	viewer.SetCredentials(url.UserPassword("viewer", "secret"))
+	viewer.onSetup()
+	expectClientState(t, viewer, state.Ready)
+	viewer.onPlay()
+	expectClientState(t, viewer, state.Playing)
+	view.waitForFrames(t, 5)
+	harness.checkFrames(view)
+	viewer.onTeardown()
+	expectClientState(t, viewer, state.Init)
+
+	// viewer can't publish, rejected RECORD mustn't leave the client waiting for the server
+	publisher := harness.newHeadlessClient(path)
+	defer publisher.CloseConnection()
+	publisher.SetCredentials(url.UserPassword("viewer", "secret"))
+	source := &syntheticSource{}
+	publisher.SetFrameSource(source)
+	publisher.onSetup()
+	expectClientState(t, publisher, state.Ready)
+	publisher.onRecord()
+	expectClientState(t, publisher, state.Ready)
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+	if source.opened {
+		t.Fatal("frame source was opened after rejected RECORD")
+	}
+}
+
+func TestRecordIsAuthorizedForSetupPath(t *testing.T) {
+	authenticator := NewAuthenticator(DefaultRealm)
+	authenticator.AddUser("publisher", "secret", []string{"/b"}, []string{"/a"})
+	harness := startHarness(t, authenticator)
+
+	// stream is published to the path of SETUP, permission to the path of RECORD request doesn't matter
+	publisher := harness.newHeadlessClient("/b")
+	defer publisher.CloseConnection()
+	publisher.SetCredentials(url.UserPassword("publisher", "secret"))
+	publisher.SetFrameSource(&syntheticSource{})
+	publisher.onSetup()
+	expectClientState(t, publisher, state.Ready)
+	publisher.videoFileName = "/a"
+	if replyCode := publisher.exchange(message.Record); replyCode != "403" {
+		t.Fatalf("RECORD of other path than SETUP answered with %v", replyCode)
+	}
+	harness.expectSessionState("/b", state.Ready)
+}
+
+func TestPlayIsAuthorizedForSetupPath(t *testing.T) {
+	authenticator := NewAuthenticator(DefaultRealm)
+	authenticator.AddUser("viewer", "secret", []string{"/b"}, []string{"/a"})
+	harness := startHarness(t, authenticator)
+	harness.publish("/a")
+
+	// session streams the path of SETUP, read permission to the path of PLAY request doesn't matter
+	viewer := harness.newHeadlessClient("/a")
+	defer viewer.CloseConnection()
+	viewer.SetCredentials(url.UserPassword("viewer", "secret"))
+	viewer.onSetup()
+	expectClientState(t, viewer, state.Ready)
+	viewer.videoFileName = "/b"
+	if replyCode := viewer.exchange(message.Play); replyCode != "403" {
+		t.Fatalf("PLAY of other path than SETUP answered with %v", replyCode)
+	}
+	harness.expectSessionState("/a", state.Ready)
+}
+
+func TestSecureSessions(t *testing.T) {
+	const path = "/secure"
+	harness := startSecureHarness(t)
+
+	// media keys are announced by DESCRIBE, so SETUP without it is refused
+	connection, err := tls.Dial("tcp", net.JoinHostPort(harness.host, harness.port), harness.tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+	_, err = connection.Write([]byte(
+		"SETUP /secure RTSP/1.0\r\nCSeq: 1\r\nTransport: RTP/UDP;client_port=5000,5001\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = connection.SetReadDeadline(time.Now().Add(waitTimeout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	statusLine, err := bufio.NewReader(connection).ReadString('\n')
+	if err != nil || !strings.HasPrefix(statusLine, "RTSP/1.0 455") {
+		t.Fatalf("SETUP without DESCRIBE answered with %q (%v)", statusLine, err)
+	}
+
+	publisher := harness.newHeadlessClient(path)
+	defer publisher.CloseConnection()
+	publisher.SetFrameSource(&syntheticSource{})
+	publisher.onSetup()
+	expectClientState(t, publisher, state.Ready)
+	publisher.onRecord()
+	expectClientState(t, publisher, state.Recording)
+	harness.expectSessionState(path, state.Recording)
+	if publisher.server.rtpSender.srtpContext == nil ||
+		harness.findSession(path).recvClient.rtpReceiver.srtpContext == nil {
+		t.Fatal("published stream isn't protected")
+	}
+
+	viewer, view := harness.newClient(path)
+	defer viewer.CloseConnection()
+	viewer.onSetup()
+	expectClientState(t, viewer, state.Ready)
+	if viewer.rtpReceiver.srtpContext == nil || viewer.rtcpSender.srtpContext == nil {
+		t.Fatal("received stream isn't protected")
+	}
+	viewer.onPlay()
+	expectClientState(t, viewer, state.Playing)
+	view.waitForFrames(t, 20)
+	harness.checkFrames(view)
+
+	viewer.onTeardown()
+	expectClientState(t, viewer, state.Init)
+	publisher.onTeardown()
+	expectClientState(t, publisher, state.Init)
+}
@@ -0,0 +1,102 @@
+package components
+
+import (
+	"streming_server/logging"
+	"streming_server/protocol/fec"
+	"streming_server/protocol/rtp"
+)
+
+// number of recent media packets kept for recovery
+const fecHistorySize = 64
+const maxPendingFecPackets = 16
+
+// FecDecoder recovers single lost packet of every protected group
+type FecDecoder struct {
+	received      map[uint16]*rtp.Packet
+	receivedOrder []uint16
+	pending       []*fec.Packet
+}
+
+func NewFecDecoder() *FecDecoder {
+	return &FecDecoder{
+		received:      make(map[uint16]*rtp.Packet),
+		receivedOrder: make([]uint16, 0, fecHistorySize),
+		pending:       make([]*fec.Packet, 0, maxPendingFecPackets),
+	}
+}
+
+// OnMediaPacket stores media packet and returns packets recovered thanks to it
+func (d *FecDecoder) OnMediaPacket(packet *rtp.Packet) []*rtp.Packet {
+	d.store(packet)
+	return d.recover()
+}
+
+// OnFecPacket handles parity packet and returns recovered packets
+func (d *FecDecoder) OnFecPacket(packet *rtp.Packet) []*rtp.Packet {
+	fecPacket, err := fec.NewPacketFromBytes(packet.Payload)
+	if err != nil {
+		fecLogger.Error("invalid parity packet", logging.ErrorKey, err)
+		return nil
+	}
+	if len(d.pending) == maxPendingFecPackets {
+		d.pending = d.pending[1:]
+	}
+	d.pending = append(d.pending, fecPacket)
+	return d.recover()
+}
+
+func (d *FecDecoder) store(packet *rtp.Packet) {
+	seqNum := uint16(packet.Header.SequenceNumber)
+	if _, ok := d.received[seqNum]; ok {
+		return
+	}
+	if len(d.receivedOrder) == fecHistorySize {
+		delete(d.received, d.receivedOrder[0])
+		d.receivedOrder = d.receivedOrder[1:]
+	}
+	d.received[seqNum] = packet
+	d.receivedOrder = append(d.receivedOrder, seqNum)
+}
+
+// recover repeats until no pending parity packet can be used, as every recovery may enable next one
+func (d *FecDecoder) recover() []*rtp.Packet {
+	result := make([]*rtp.Packet, 0)
+	for recovered := true; recovered; {
+		recovered = false
+		remaining := d.pending[:0]
+		for _, fecPacket := range d.pending {
+			receivedPackets, missing := d.collectGroup(fecPacket)
+			if len(missing) > 1 {
+				remaining = append(remaining, fecPacket)
+				continue
+			}
+			if len(missing) == 0 {
+				continue
+			}
+			packet, err := fecPacket.Recover(receivedPackets, missing[0])
+			if err != nil {
+				fecLogger.Error("recovery failed", logging.ErrorKey, err)
+				continue
+			}
+			fecLogger.Debug("packet recovered", "sequenceNumber", missing[0])
+			d.store(packet)
+			result = append(result, packet)
+			recovered = true
+		}
+		d.pending = remaining
+	}
+	return result
+}
+
+func (d *FecDecoder) collectGroup(fecPacket *fec.Packet) ([]*rtp.Packet, []int) {
+	receivedPackets := make([]*rtp.Packet, 0, fec.MaxGroupSize)
+	missing := make([]int, 0)
+	for _, seqNum := range fecPacket.ProtectedSeqNums() {
+		if packet, ok := d.received[uint16(seqNum)]; ok {
+			receivedPackets = append(receivedPackets, packet)
+		} else {
+			missing = append(missing, seqNum)
+		}
+	}
+	return receivedPackets, missing
+}
@@ -0,0 +1,81 @@
+package components
+
+import (
+	"streming_server/logging"
+	"streming_server/protocol/fec"
+	"streming_server/protocol/rtp"
+	"sync"
+)
+
+// parity packets are sent as separate stream with own numbering
+const FecType = 127
+const FecSsrc = 10001
+
+const maxUdpPayloadSize = 65507
+
+// FecEncoder groups sent media packets and produces XOR parity packet for every complete group
+type FecEncoder struct {
+	group     []*rtp.Packet
+	groupSize int
+	fecSeqNum int
+	mutex     sync.Mutex
+}
+
+// NewFecEncoder creates encoder, group size 0 disables protection
+func NewFecEncoder(groupSize int) *FecEncoder {
+	encoder := &FecEncoder{
+		group: make([]*rtp.Packet, 0, fec.MaxGroupSize),
+	}
+	encoder.SetGroupSize(groupSize)
+	return encoder
+}
+
+// SetGroupSize changes redundancy, smaller groups recover more losses at the cost of bandwidth
+func (e *FecEncoder) SetGroupSize(groupSize int) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if groupSize > fec.MaxGroupSize {
+		groupSize = fec.MaxGroupSize
+	}
+	if groupSize == 1 {
+		groupSize = 2
+	}
+	e.groupSize = groupSize
+	e.group = e.group[:0]
+}
+
+func (e *FecEncoder) GroupSize() int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.groupSize
+}
+
+// Protect adds sent packet to current group and returns parity packet when the group is complete
+func (e *FecEncoder) Protect(packet *rtp.Packet) *rtp.Packet {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.groupSize <= 0 {
+		return nil
+	}
+	e.group = append(e.group, packet)
+	if len(e.group) < e.groupSize {
+		return nil
+	}
+	group := e.group
+	e.group = make([]*rtp.Packet, 0, fec.MaxGroupSize)
+
+	fecPacket, err := fec.NewPacket(group)
+	if err != nil {
+		fecLogger.Error("cannot protect group", logging.ErrorKey, err)
+		return nil
+	}
+	payload := fecPacket.TransformToBytes()
+	if rtp.HeaderSize+len(payload) > maxUdpPayloadSize {
+		fecLogger.Warn("parity packet exceeds maximal datagram size, group left unprotected")
+		return nil
+	}
+	e.fecSeqNum++
+	header := rtp.NewHeader(FecType, e.fecSeqNum, group[len(group)-1].Header.Timestamp)
+	header.Ssrc = FecSsrc
+	return rtp.NewPacket(header, len(payload), payload)
+}
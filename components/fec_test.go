@@ -0,0 +1,84 @@
+package components
+
+import (
+	"bytes"
+	"streming_server/protocol/rtp"
+	"testing"
+)
+
+// protectedGroup sends group of media packets with different lengths, markers and timestamps
+// through the encoder, numbering wraps inside the group
+func protectedGroup(t *testing.T, groupSize int) ([]*rtp.Packet, *rtp.Packet) {
+	t.Helper()
+	encoder := NewFecEncoder(groupSize)
+	packets := make([]*rtp.Packet, 0, groupSize)
+	var parity *rtp.Packet
+	for index := 0; index < groupSize; index++ {
+		payload := make([]byte, 10+index*37)
+		for offset := range payload {
+			payload[offset] = byte(index*31 + offset)
+		}
+		header := rtp.NewHeader(26, (65534+index)%65536, 90000+index/2*3000)
+		header.Marker = index % 2
+		packet := rtp.NewPacket(header, len(payload), payload)
+		packets = append(packets, packet)
+		parity = encoder.Protect(packet)
+		if index < groupSize-1 && parity != nil {
+			t.Fatalf("parity sent after %v packets of group of %v", index+1, groupSize)
+		}
+	}
+	if parity == nil {
+		t.Fatal("complete group isn't protected")
+	}
+	// packets travel as datagrams
+	received := make([]*rtp.Packet, 0, groupSize)
+	for _, packet := range append(packets, parity) {
+		datagram := packet.TransformToBytes()
+		parsed, err := rtp.NewPacketFromBytes(datagram, len(datagram))
+		if err != nil {
+			t.Fatal(err)
+		}
+		received = append(received, parsed)
+	}
+	return received[:groupSize], received[groupSize]
+}
+
+func TestFecRecoversEachPacketOfGroup(t *testing.T) {
+	const groupSize = 5
+	for lost := 0; lost < groupSize; lost++ {
+		packets, parity := protectedGroup(t, groupSize)
+		decoder := NewFecDecoder()
+		recovered := make([]*rtp.Packet, 0)
+		for index, packet := range packets {
+			if index != lost {
+				recovered = append(recovered, decoder.OnMediaPacket(packet)...)
+			}
+		}
+		recovered = append(recovered, decoder.OnFecPacket(parity)...)
+
+		if len(recovered) != 1 {
+			t.Fatalf("%v packets recovered when packet %v of group is lost", len(recovered), lost)
+		}
+		expected := packets[lost].TransformToBytes()
+		if actual := recovered[0].TransformToBytes(); !bytes.Equal(actual, expected) {
+			t.Errorf("packet %v recovered as %x, expected %x", lost, actual, expected)
+		}
+	}
+}
+
+func TestFecDoesNotRecoverTwoLossesOfGroup(t *testing.T) {
+	packets, parity := protectedGroup(t, 4)
+	decoder := NewFecDecoder()
+	recovered := decoder.OnMediaPacket(packets[0])
+	recovered = append(recovered, decoder.OnMediaPacket(packets[3])...)
+	recovered = append(recovered, decoder.OnFecPacket(parity)...)
+	if len(recovered) != 0 {
+		t.Fatalf("%v packets recovered from group with two losses", len(recovered))
+	}
+
+	// parity waits for one of the lost packets, retransmission for example
+	recovered = decoder.OnMediaPacket(packets[2])
+	if len(recovered) != 1 || !bytes.Equal(recovered[0].TransformToBytes(), packets[1].TransformToBytes()) {
+		t.Fatalf("packets %v recovered after second loss is received", recovered)
+	}
+}
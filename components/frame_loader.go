@@ -3,12 +3,15 @@ package components
 import (
 	"streming_server/protocol/rtp"
 	"streming_server/video"
+	"sync/atomic"
 )
 
 type FrameLoader struct {
 	frameSync      *video.FrameSync
 	privateChannel chan *rtp.Packet
+	routines       *routineGroup
 	started        bool
+	timeShifted    int32
 	doneCheck      chan bool
 }
 
@@ -16,28 +19,45 @@ func NewFrameLoader(frameSync *video.FrameSync, privateChannel chan *rtp.Packet)
 	return &FrameLoader{
 		frameSync:      frameSync,
 		started:        false,
-		doneCheck:      make(chan bool),
 		privateChannel: privateChannel,
 	}
 }
 
+// SetTimeShifted makes loader drop live frames while session is fed from the recording
+func (fl *FrameLoader) SetTimeShifted(timeShifted bool) {
+	if timeShifted {
+		atomic.StoreInt32(&fl.timeShifted, 1)
+	} else {
+		atomic.StoreInt32(&fl.timeShifted, 0)
+	}
+}
+
 func (fl *FrameLoader) Start() {
+	if fl.started {
+		return
+	}
 	fl.started = true
+	fl.doneCheck = make(chan bool)
 
-	go func() {
+	doneCheck := fl.doneCheck
+	fl.routines.Go("frame loader", func() {
 		for {
 			select {
-			case <-fl.doneCheck:
+			case <-doneCheck:
 				return
 			case packet := <-fl.privateChannel:
-				fl.frameSync.AddFrame(packet.Payload, packet.Header.SequenceNumber)
+				if atomic.LoadInt32(&fl.timeShifted) == 0 &&
+					!fl.frameSync.AddFrame(packet.Payload, packet.Header.SequenceNumber) {
+					droppedFramesMetric.Inc(lateFrameReason)
+				}
 			}
 		}
-	}()
+	})
 }
 
 func (fl *FrameLoader) Stop() {
 	if fl.started {
-		fl.doneCheck <- true
+		close(fl.doneCheck)
+		fl.started = false
 	}
 }
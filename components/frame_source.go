@@ -0,0 +1,45 @@
+package components
+
+import (
+	"gocv.io/x/gocv"
+	"image"
+)
+
+// FrameSource provides frames broadcast by the client
+type FrameSource interface {
+	Open() error
+	Read() (image.Image, error)
+	Close() error
+}
+
+// WebcamSource captures frames from video device
+type WebcamSource struct {
+	device       int
+	videoCapture *gocv.VideoCapture
+	videoMat     gocv.Mat
+}
+
+func NewWebcamSource(device int) *WebcamSource {
+	return &WebcamSource{
+		device:   device,
+		videoMat: gocv.NewMat(),
+	}
+}
+
+func (s *WebcamSource) Open() error {
+	videoCapture, err := gocv.VideoCaptureDevice(s.device)
+	if err != nil {
+		return err
+	}
+	s.videoCapture = videoCapture
+	return nil
+}
+
+func (s *WebcamSource) Read() (image.Image, error) {
+	s.videoCapture.Read(&s.videoMat)
+	return s.videoMat.ToImage()
+}
+
+func (s *WebcamSource) Close() error {
+	return s.videoCapture.Close()
+}
@@ -0,0 +1,250 @@
+package components
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"streming_server/logging"
+	"streming_server/protocol/mpegts"
+	"streming_server/protocol/rtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultHlsSegmentDuration = 2 * time.Second
+const DefaultHlsWindowSize = 6
+const hlsCleanupInterval = time.Second
+
+// SegmentMuxer converts jpeg frames into media segment, custom encoder (e.g. transcoding to h264)
+// can be plugged in through HlsManager muxer factory
+type SegmentMuxer interface {
+	WriteFrame(frame []byte, presentationTime time.Duration) error
+	Finish() []byte
+}
+
+// MuxerFactory creates muxer and returns it with file extension and content type of produced segments
+type MuxerFactory func() (SegmentMuxer, string, string)
+
+func NewMpegTsMuxer() (SegmentMuxer, string, string) {
+	return mpegts.NewMuxer(), "ts", "video/mp2t"
+}
+
+type hlsSegment struct {
+	seqNum   int
+	duration time.Duration
+	data     []byte
+}
+
+// HlsStream keeps sliding window of the latest segments of single mount point
+type HlsStream struct {
+	muxer            SegmentMuxer
+	segments         []*hlsSegment
+	mutex            sync.Mutex
+	extension        string
+	contentType      string
+	streamStartTime  time.Time
+	segmentStartTime time.Time
+	lastFrameTime    time.Time
+	segmentDuration  time.Duration
+	windowSize       int
+	nextSeqNum       int
+	framesInSegment  int
+}
+
+func NewHlsStream(muxerFactory MuxerFactory, segmentDuration time.Duration, windowSize int) *HlsStream {
+	muxer, extension, contentType := muxerFactory()
+	return &HlsStream{
+		muxer:           muxer,
+		segments:        make([]*hlsSegment, 0),
+		extension:       extension,
+		contentType:     contentType,
+		streamStartTime: time.Now(),
+		// new stream isn't idle until its first frame comes
+		lastFrameTime:   time.Now(),
+		segmentDuration: segmentDuration,
+		windowSize:      windowSize,
+	}
+}
+
+func (hs *HlsStream) addFrame(frame []byte) {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+
+	now := time.Now()
+	if hs.framesInSegment == 0 {
+		hs.segmentStartTime = now
+	}
+	err := hs.muxer.WriteFrame(frame, now.Sub(hs.streamStartTime))
+	if err != nil {
+		hlsLogger.Error("error while muxing frame", logging.ErrorKey, err)
+		return
+	}
+	hs.framesInSegment++
+	hs.lastFrameTime = now
+
+	if now.Sub(hs.segmentStartTime) >= hs.segmentDuration {
+		hs.finishSegment(now)
+	}
+}
+
+// finishSegment closes current segment and slides the window, it has to be called with locked mutex
+func (hs *HlsStream) finishSegment(now time.Time) {
+	hs.segments = append(hs.segments, &hlsSegment{
+		seqNum:   hs.nextSeqNum,
+		duration: now.Sub(hs.segmentStartTime),
+		data:     hs.muxer.Finish(),
+	})
+	hs.nextSeqNum++
+	hs.framesInSegment = 0
+
+	// old segments are released as soon as they leave the playlist
+	if len(hs.segments) > hs.windowSize {
+		hs.segments = hs.segments[len(hs.segments)-hs.windowSize:]
+	}
+}
+
+func (hs *HlsStream) Playlist() string {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+
+	targetDuration := hs.segmentDuration
+	for _, segment := range hs.segments {
+		if segment.duration > targetDuration {
+			targetDuration = segment.duration
+		}
+	}
+	mediaSequence := hs.nextSeqNum
+	if len(hs.segments) > 0 {
+		mediaSequence = hs.segments[0].seqNum
+	}
+
+	playlist := new(strings.Builder)
+	playlist.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	fmt.Fprintf(playlist, "#EXT-X-TARGETDURATION:%v\n", int(targetDuration.Seconds()+0.999))
+	fmt.Fprintf(playlist, "#EXT-X-MEDIA-SEQUENCE:%v\n", mediaSequence)
+	for _, segment := range hs.segments {
+		fmt.Fprintf(playlist, "#EXTINF:%.3f,\n%v.%v\n", segment.duration.Seconds(), segment.seqNum, hs.extension)
+	}
+	return playlist.String()
+}
+
+// Segment returns nil when segment is no longer (or not yet) available
+func (hs *HlsStream) Segment(seqNum int) []byte {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+	for _, segment := range hs.segments {
+		if segment.seqNum == seqNum {
+			return segment.data
+		}
+	}
+	return nil
+}
+
+func (hs *HlsStream) idleSince() time.Time {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+	return hs.lastFrameTime
+}
+
+// HlsManager produces HLS stream for every live mount point and serves it over http:
+// /hls/<path>/index.m3u8 and /hls/<path>/<number>.<extension>
+type HlsManager struct {
+	streams         map[string]*HlsStream
+	mutex           sync.Mutex
+	muxerFactory    MuxerFactory
+	segmentDuration time.Duration
+	windowSize      int
+	ticker          *time.Ticker
+	doneCheck       chan bool
+}
+
+func NewHlsManager(muxerFactory MuxerFactory, segmentDuration time.Duration, windowSize int) *HlsManager {
+	manager := &HlsManager{
+		streams:         make(map[string]*HlsStream),
+		muxerFactory:    muxerFactory,
+		segmentDuration: segmentDuration,
+		windowSize:      windowSize,
+		ticker:          time.NewTicker(hlsCleanupInterval),
+		doneCheck:       make(chan bool),
+	}
+
+	go func() {
+		for {
+			select {
+			case <-manager.doneCheck:
+				return
+			case <-manager.ticker.C:
+				manager.removeIdleStreams()
+			}
+		}
+	}()
+	return manager
+}
+
+func (m *HlsManager) Feed(path string, packet *rtp.Packet) {
+	m.mutex.Lock()
+	stream, found := m.streams[path]
+	if !found {
+		stream = NewHlsStream(m.muxerFactory, m.segmentDuration, m.windowSize)
+		m.streams[path] = stream
+		hlsLogger.Info("stream started", logging.PathKey, path)
+	}
+	m.mutex.Unlock()
+
+	stream.addFrame(packet.Payload)
+}
+
+// streams without frames for the whole window are no longer live, so they are removed with all segments
+func (m *HlsManager) removeIdleStreams() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for path, stream := range m.streams {
+		if time.Since(stream.idleSince()) > m.segmentDuration*time.Duration(m.windowSize) {
+			delete(m.streams, path)
+			hlsLogger.Info("idle stream removed", logging.PathKey, path)
+		}
+	}
+}
+
+func (m *HlsManager) stream(path string) *HlsStream {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.streams[path]
+}
+
+func (m *HlsManager) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	resource := strings.TrimPrefix(request.URL.Path, "/hls/")
+	separator := strings.LastIndex(resource, "/")
+	if separator < 0 {
+		http.NotFound(writer, request)
+		return
+	}
+	stream := m.stream(resource[:separator])
+	if stream == nil {
+		http.NotFound(writer, request)
+		return
+	}
+
+	fileName := resource[separator+1:]
+	if fileName == "index.m3u8" {
+		writer.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		writer.Header().Set("Cache-Control", "no-cache")
+		_, _ = writer.Write([]byte(stream.Playlist()))
+		return
+	}
+
+	seqNum, err := strconv.Atoi(strings.TrimSuffix(fileName, "."+stream.extension))
+	segment := stream.Segment(seqNum)
+	if err != nil || segment == nil {
+		http.NotFound(writer, request)
+		return
+	}
+	writer.Header().Set("Content-Type", stream.contentType)
+	_, _ = writer.Write(segment)
+}
+
+func (m *HlsManager) Close() {
+	m.ticker.Stop()
+	close(m.doneCheck)
+}
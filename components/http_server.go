@@ -0,0 +1,50 @@
+package components
+
+import (
+	"context"
+	"net/http"
+	"streming_server/logging"
+	"time"
+)
+
+const httpShutdownTimeout = 5 * time.Second
+
+// HttpServer hosts http based outputs of the streaming server
+type HttpServer struct {
+	server *http.Server
+	mux    *http.ServeMux
+}
+
+func NewHttpServer(address string) *HttpServer {
+	mux := http.NewServeMux()
+	return &HttpServer{
+		server: &http.Server{
+			Addr:    address,
+			Handler: mux,
+		},
+		mux: mux,
+	}
+}
+
+func (hs *HttpServer) Handle(pattern string, handler http.Handler) {
+	hs.mux.Handle(pattern, handler)
+}
+
+func (hs *HttpServer) Start() {
+	go func() {
+		httpLogger.Info("server listening", "address", hs.server.Addr)
+		err := hs.server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			httpLogger.Error("server error", logging.ErrorKey, err)
+		}
+	}()
+}
+
+func (hs *HttpServer) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+	err := hs.server.Shutdown(ctx)
+	if err != nil {
+		httpLogger.Error("error while closing server", logging.ErrorKey, err)
+	}
+}
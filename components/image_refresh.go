@@ -1,13 +1,12 @@
 package components
 
 import (
-	"streming_server/ui"
 	"streming_server/video"
 	"time"
 )
 
 type ImageRefresh struct {
-	view      *ui.View
+	view      ClientView
 	frameSync *video.FrameSync
 	ticker    *time.Ticker
 	interval  time.Duration
@@ -15,7 +14,7 @@ type ImageRefresh struct {
 	started   bool
 }
 
-func NewImageRefresh(view *ui.View, frameSync *video.FrameSync) *ImageRefresh {
+func NewImageRefresh(view ClientView, frameSync *video.FrameSync) *ImageRefresh {
 	return &ImageRefresh{
 		view:      view,
 		frameSync: frameSync,
@@ -29,7 +28,7 @@ func (ir *ImageRefresh) SetInterval(interval time.Duration) {
 }
 
 func (ir *ImageRefresh) updateImageInGui() {
-	if !ir.frameSync.Empty() {
+	if ir.frameSync.Ready() {
 		ir.view.UpdateImage()
 	}
 }
@@ -39,16 +38,16 @@ func (ir *ImageRefresh) Start() {
 	ir.ticker = time.NewTicker(33 * time.Millisecond)
 	ir.interval = 33 * time.Millisecond
 
-	go func() {
+	go func(ticker *time.Ticker, doneCheck chan bool) {
 		for {
 			select {
-			case <-ir.doneCheck:
+			case <-doneCheck:
 				return
-			case <-ir.ticker.C:
+			case <-ticker.C:
 				ir.updateImageInGui()
 			}
 		}
-	}()
+	}(ir.ticker, ir.doneCheck)
 }
 
 func (ir *ImageRefresh) Stop() {
@@ -0,0 +1,38 @@
+package components
+
+import (
+	"image/jpeg"
+	"streming_server/util"
+	"time"
+)
+
+// LegacyStrategy maps fraction lost to one of five congestion levels, every level stretches frame interval
+// by 10% and lowers jpeg quality by 15%
+type LegacyStrategy struct {
+	framePeriod     time.Duration
+	congestionLevel int
+}
+
+func NewLegacyStrategy(framePeriod time.Duration) CongestionStrategy {
+	return &LegacyStrategy{
+		framePeriod:     framePeriod,
+		congestionLevel: util.NoCongestion,
+	}
+}
+
+func (s *LegacyStrategy) OnReceiverReport(feedback ReceiverFeedback) {
+	s.congestionLevel = util.ResolveCongestionLevel(feedback.FractionLost)
+}
+
+func (s *LegacyStrategy) OnRemb(int) {}
+
+func (s *LegacyStrategy) OnFrameSent(int) {}
+
+func (s *LegacyStrategy) OnSourceResolution(int, int) {}
+
+func (s *LegacyStrategy) Targets() CongestionTargets {
+	return CongestionTargets{
+		FrameInterval: s.framePeriod + time.Duration(s.congestionLevel)*s.framePeriod/10,
+		Quality:       jpeg.DefaultQuality - int(jpeg.DefaultQuality*0.15*float64(s.congestionLevel)),
+	}
+}
@@ -0,0 +1,19 @@
+package components
+
+import "streming_server/logging"
+
+// loggers of components which aren't bound to single session, session components attach fields
+// of the session to their loggers with SetLogger
+var (
+	rtspLogger     = logging.With(logging.ComponentKey, "RTSP")
+	rtpLogger      = logging.With(logging.ComponentKey, "RTP")
+	rtcpLogger     = logging.With(logging.ComponentKey, "RTCP")
+	ccLogger       = logging.With(logging.ComponentKey, "CC")
+	fecLogger      = logging.With(logging.ComponentKey, "FEC")
+	dvrLogger      = logging.With(logging.ComponentKey, "DVR")
+	recorderLogger = logging.With(logging.ComponentKey, "REC")
+	hlsLogger      = logging.With(logging.ComponentKey, "HLS")
+	mjpegLogger    = logging.With(logging.ComponentKey, "MJPEG")
+	httpLogger     = logging.With(logging.ComponentKey, "HTTP")
+	videoLogger    = logging.With(logging.ComponentKey, "VIDEO")
+)
@@ -0,0 +1,14 @@
+package components
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// every packet is logged, which would bury test output
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
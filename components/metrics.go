@@ -0,0 +1,71 @@
+package components
+
+import (
+	"strconv"
+	"streming_server/metrics"
+	"streming_server/protocol/rtp"
+	"streming_server/protocol/rtsp/state"
+)
+
+// directions of RTCP reports
+const sentDirection = "sent"
+const receivedDirection = "received"
+
+// types of RTCP reports
+const receiverReportType = "receiver_report"
+const senderReportType = "sender_report"
+const nackType = "nack"
+const rembType = "remb"
+const byeType = "bye"
+
+// reasons of dropped frames
+const lateFrameReason = "late"
+const frameRateReason = "frame_rate"
+
+var (
+	sessionsMetric = metrics.NewSampledGauge("streaming_sessions",
+		"Active RTSP sessions by state.", "state")
+	congestionLevelMetric = metrics.NewSampledGauge("streaming_sessions_congestion_level",
+		"Playing sessions by congestion level resolved from receiver reports.", "level")
+	frameSyncDepthMetric = metrics.NewSampledGauge("streaming_frame_sync_depth",
+		"Frames queued in frame synchronizers of the stream.", "stream")
+	droppedFramesMetric = metrics.NewCounter("streaming_dropped_frames_total",
+		"Frames dropped because they came after newer frame or to keep target frame rate.", "reason")
+	rtpSentPacketsMetric = metrics.NewCounter("streaming_rtp_sent_packets_total",
+		"RTP media packets sent, retransmissions and parity packets excluded.", "stream")
+	rtpSentBytesMetric = metrics.NewCounter("streaming_rtp_sent_bytes_total",
+		"RTP payload bytes sent, retransmissions and parity packets excluded.", "stream")
+	rtpReceivedPacketsMetric = metrics.NewCounter("streaming_rtp_received_packets_total",
+		"RTP media packets received, including restored retransmissions and recovered packets.", "stream")
+	rtpReceivedBytesMetric = metrics.NewCounter("streaming_rtp_received_bytes_total",
+		"RTP payload bytes received.", "stream")
+	rtcpReportsMetric = metrics.NewCounter("streaming_rtcp_reports_total",
+		"RTCP packets by direction and type.", "direction", "type")
+	fractionLostMetric = metrics.NewHistogram("streaming_rtcp_fraction_lost",
+		"Fraction of lost packets in receiver reports.",
+		[]float64{0, 0.01, 0.02, 0.05, 0.1, 0.2, 0.5}, "direction")
+	jitterMetric = metrics.NewHistogram("streaming_rtcp_jitter_seconds",
+		"Interarrival jitter in receiver reports.",
+		[]float64{0.001, 0.002, 0.005, 0.01, 0.02, 0.05, 0.1, 0.2}, "direction")
+)
+
+// observeReceiverReport records loss and jitter, which is carried in microseconds, of the receiver report
+func observeReceiverReport(direction string, fractionLost float64, jitter uint32) {
+	rtcpReportsMetric.Inc(direction, receiverReportType)
+	fractionLostMetric.Observe(fractionLost, direction)
+	jitterMetric.Observe(float64(jitter)/1e6, direction)
+}
+
+// collectMetrics counts sessions by state and congestion level, and frames queued for the playing ones
+func (m *SessionManager) collectMetrics() {
+	playing := state.State(state.Playing).String()
+	m.Range(func(srv *RtspServer, _ chan *rtp.Packet) bool {
+		info := srv.Info()
+		sessionsMetric.Add(1, info.State)
+		if info.State == playing {
+			congestionLevelMetric.Add(1, strconv.Itoa(info.CongestionLevel))
+			frameSyncDepthMetric.Add(float64(info.QueuedFrames), info.Path)
+		}
+		return true
+	})
+}
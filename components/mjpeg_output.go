@@ -0,0 +1,139 @@
+package components
+
+import (
+	"fmt"
+	"net/http"
+	"streming_server/logging"
+	"streming_server/protocol/rtp"
+	"strings"
+	"sync"
+)
+
+const mjpegBoundary = "mjpegframe"
+const mjpegClientQueueSize = 2
+
+type mjpegClient struct {
+	path          string
+	frameChannel  chan []byte
+	droppedFrames int
+}
+
+// offer never blocks, when client can't keep up the oldest queued frame is replaced with the newest one
+func (c *mjpegClient) offer(frame []byte) {
+	for {
+		select {
+		case c.frameChannel <- frame:
+			return
+		default:
+		}
+		select {
+		case <-c.frameChannel:
+			c.droppedFrames++
+		default:
+		}
+	}
+}
+
+// MjpegOutput serves live mount points as multipart/x-mixed-replace stream of jpeg frames: /mjpeg/<path>
+type MjpegOutput struct {
+	clients map[*mjpegClient]bool
+	mutex   sync.Mutex
+	closed  bool
+}
+
+func NewMjpegOutput() *MjpegOutput {
+	return &MjpegOutput{
+		clients: make(map[*mjpegClient]bool),
+	}
+}
+
+func (o *MjpegOutput) Feed(path string, packet *rtp.Packet) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	for client := range o.clients {
+		if client.path == path {
+			client.offer(packet.Payload)
+		}
+	}
+}
+
+func (o *MjpegOutput) subscribe(path string) *mjpegClient {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.closed {
+		return nil
+	}
+	client := &mjpegClient{
+		path:         path,
+		frameChannel: make(chan []byte, mjpegClientQueueSize),
+	}
+	o.clients[client] = true
+	return client
+}
+
+func (o *MjpegOutput) unsubscribe(client *mjpegClient, remoteAddress string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.clients[client] {
+		delete(o.clients, client)
+		close(client.frameChannel)
+	}
+	mjpegLogger.Info("client disconnected", logging.RemoteAddressKey, remoteAddress,
+		"droppedFrames", client.droppedFrames)
+}
+
+func (o *MjpegOutput) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	path := strings.TrimPrefix(request.URL.Path, "/mjpeg/")
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		http.Error(writer, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	client := o.subscribe(path)
+	if client == nil {
+		http.Error(writer, "server is closing", http.StatusServiceUnavailable)
+		return
+	}
+	defer o.unsubscribe(client, request.RemoteAddr)
+	mjpegLogger.Info("client subscribed", logging.RemoteAddressKey, request.RemoteAddr, logging.PathKey, path)
+
+	writer.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+mjpegBoundary)
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+		case frame, open := <-client.frameChannel:
+			if !open {
+				return
+			}
+			_, err := fmt.Fprintf(writer, "--%v\r\nContent-Type: image/jpeg\r\nContent-Length: %v\r\n\r\n",
+				mjpegBoundary, len(frame))
+			if err == nil {
+				_, err = writer.Write(frame)
+			}
+			if err == nil {
+				_, err = writer.Write([]byte("\r\n"))
+			}
+			if err != nil {
+				mjpegLogger.Error("error while sending frame", logging.RemoteAddressKey, request.RemoteAddr,
+					logging.ErrorKey, err)
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func (o *MjpegOutput) Close() {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.closed = true
+	for client := range o.clients {
+		delete(o.clients, client)
+		close(client.frameChannel)
+	}
+}
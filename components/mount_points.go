@@ -0,0 +1,72 @@
+package components
+
+import (
+	"strings"
+	"sync"
+)
+
+// MountPoint is path configured on the server, published frames come from its source when it is set
+type MountPoint struct {
+	Path   string
+	Source *SourceConfig
+}
+
+// Stream returns name under which packets of the mount point are published, it is used by http outputs as well
+func (m *MountPoint) Stream() string {
+	return strings.TrimPrefix(m.Path, "/")
+}
+
+// MountPoints restricts sessions to the configured paths, every path is allowed when none is configured,
+// the set can be updated while sessions use it
+type MountPoints struct {
+	mountPoints map[string]*MountPoint
+	mutex       sync.RWMutex
+}
+
+func NewMountPoints(configs []MountPointConfig) *MountPoints {
+	result := &MountPoints{}
+	result.Update(configs)
+	return result
+}
+
+// Update replaces configured mount points, sessions check the new ones with their next request
+func (m *MountPoints) Update(configs []MountPointConfig) {
+	mountPoints := make(map[string]*MountPoint)
+	for _, config := range configs {
+		path := mountPointOf(config.Path)
+		mountPoints[path] = &MountPoint{Path: path, Source: config.Source}
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.mountPoints = mountPoints
+}
+
+// Restricted reports whether sessions are limited to the configured paths
+func (m *MountPoints) Restricted() bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return len(m.mountPoints) > 0
+}
+
+// Find returns mount point of request url, nil when the path isn't configured
+func (m *MountPoints) Find(requestUrl string) *MountPoint {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.mountPoints[mountPointOf(requestUrl)]
+}
+
+// Allows reports whether sessions may use path of request url
+func (m *MountPoints) Allows(requestUrl string) bool {
+	return !m.Restricted() || m.Find(requestUrl) != nil
+}
+
+// All returns every configured mount point
+func (m *MountPoints) All() []*MountPoint {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	result := make([]*MountPoint, 0, len(m.mountPoints))
+	for _, mountPoint := range m.mountPoints {
+		result = append(result, mountPoint)
+	}
+	return result
+}
@@ -0,0 +1,135 @@
+package components
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const nackCheckInterval = 20 * time.Millisecond
+const nackRetryInterval = 100 * time.Millisecond
+const maxNackRetries = 3
+const maxNackAge = RetransmissionDeadline
+const maxMissingPackets = 256
+
+type missingPacket struct {
+	detectedAt time.Time
+	lastNackAt time.Time
+	nackCount  int
+}
+
+// NackGenerator detects gaps in received sequence numbers and requests retransmission of lost packets
+type NackGenerator struct {
+	rtcpSender    *RtcpSender
+	missing       map[uint16]*missingPacket
+	mutex         sync.Mutex
+	highestSeqNum uint16
+	mediaSsrc     uint32
+	initialized   bool
+	ticker        *time.Ticker
+	doneCheck     chan bool
+	routines      *routineGroup
+	started       bool
+}
+
+func NewNackGenerator(rtcpSender *RtcpSender) *NackGenerator {
+	return &NackGenerator{
+		rtcpSender: rtcpSender,
+		missing:    make(map[uint16]*missingPacket),
+		doneCheck:  make(chan bool),
+		started:    false,
+	}
+}
+
+func (g *NackGenerator) OnPacket(sequenceNumber int, ssrc int) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	seqNum := uint16(sequenceNumber)
+	g.mediaSsrc = uint32(ssrc)
+	if !g.initialized {
+		g.highestSeqNum = seqNum
+		g.initialized = true
+		return
+	}
+
+	delete(g.missing, seqNum)
+	distance := seqNum - g.highestSeqNum
+	if distance == 0 || distance >= 0x8000 {
+		// duplicated, reordered or retransmitted packet
+		return
+	}
+	now := time.Now()
+	for lost := g.highestSeqNum + 1; lost != seqNum; lost++ {
+		if len(g.missing) >= maxMissingPackets {
+			break
+		}
+		g.missing[lost] = &missingPacket{detectedAt: now}
+	}
+	g.highestSeqNum = seqNum
+}
+
+func (g *NackGenerator) collectLostPackets() []int {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := time.Now()
+	result := make([]int, 0)
+	for seqNum, missing := range g.missing {
+		if missing.nackCount >= maxNackRetries || now.Sub(missing.detectedAt) > maxNackAge {
+			delete(g.missing, seqNum)
+			continue
+		}
+		if missing.nackCount == 0 || now.Sub(missing.lastNackAt) >= nackRetryInterval {
+			missing.nackCount++
+			missing.lastNackAt = now
+			result = append(result, int(seqNum))
+		}
+	}
+
+	// ordering relative to the highest number handles wrapping of sequence numbers
+	highestSeqNum := g.highestSeqNum
+	sort.Slice(result, func(i, j int) bool {
+		return highestSeqNum-uint16(result[i]) > highestSeqNum-uint16(result[j])
+	})
+	return result
+}
+
+func (g *NackGenerator) sendNacks() {
+	lostSeqNums := g.collectLostPackets()
+	if len(lostSeqNums) == 0 {
+		return
+	}
+	g.mutex.Lock()
+	mediaSsrc := g.mediaSsrc
+	g.mutex.Unlock()
+
+	g.rtcpSender.SendNack(mediaSsrc, lostSeqNums)
+	rtcpLogger.Debug("retransmission requested", "sequenceNumbers", lostSeqNums)
+}
+
+func (g *NackGenerator) Start() {
+	g.started = true
+	g.ticker = time.NewTicker(nackCheckInterval)
+	g.doneCheck = make(chan bool)
+
+	ticker, doneCheck := g.ticker, g.doneCheck
+	g.routines.Go("nack generator", func() {
+		for {
+			select {
+			case <-doneCheck:
+				return
+			case <-ticker.C:
+				g.sendNacks()
+			}
+		}
+	})
+}
+
+func (g *NackGenerator) Stop() {
+	if g.started {
+		close(g.doneCheck)
+		g.ticker.Stop()
+		g.started = false
+	}
+}
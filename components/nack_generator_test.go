@@ -0,0 +1,54 @@
+package components
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNackGeneratorRequestsGaps(t *testing.T) {
+	generator := NewNackGenerator(nil)
+	for _, seqNum := range []int{65533, 65534, 1, 3} {
+		generator.OnPacket(seqNum, 9999)
+	}
+	// the oldest packets are requested first, numbering wraps
+	if lost := generator.collectLostPackets(); !reflect.DeepEqual(lost, []int{65535, 0, 2}) {
+		t.Fatalf("lost packets %v requested", lost)
+	}
+	if lost := generator.collectLostPackets(); len(lost) != 0 {
+		t.Fatalf("lost packets %v requested again before retry interval", lost)
+	}
+
+	// received and reordered packets aren't requested any more, packet before the gaps doesn't open new one
+	generator.OnPacket(0, 9999)
+	generator.OnPacket(2, 9999)
+	generator.OnPacket(65534, 9999)
+	for _, missing := range generator.missing {
+		missing.lastNackAt = missing.lastNackAt.Add(-nackRetryInterval)
+	}
+	if lost := generator.collectLostPackets(); !reflect.DeepEqual(lost, []int{65535}) {
+		t.Fatalf("lost packets %v requested after retry interval", lost)
+	}
+}
+
+func TestNackGeneratorGivesUp(t *testing.T) {
+	generator := NewNackGenerator(nil)
+	generator.OnPacket(10, 9999)
+	generator.OnPacket(12, 9999)
+	for retry := 0; retry < maxNackRetries; retry++ {
+		if lost := generator.collectLostPackets(); !reflect.DeepEqual(lost, []int{11}) {
+			t.Fatalf("retry %v requested %v", retry, lost)
+		}
+		generator.missing[11].lastNackAt = time.Now().Add(-nackRetryInterval)
+	}
+	if lost := generator.collectLostPackets(); len(lost) != 0 || len(generator.missing) != 0 {
+		t.Fatalf("packet requested %v times is still missing", maxNackRetries)
+	}
+
+	// packet which is too old to be played isn't requested
+	generator.OnPacket(14, 9999)
+	generator.missing[13].detectedAt = time.Now().Add(-maxNackAge - time.Millisecond)
+	if lost := generator.collectLostPackets(); len(lost) != 0 {
+		t.Fatalf("packets %v requested after deadline", lost)
+	}
+}
@@ -0,0 +1,169 @@
+package components
+
+import (
+	"context"
+	"golang.org/x/net/ipv4"
+	"net"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// maxDatagramSize is the largest payload of UDP datagram, whole frames may be sent in single packet
+const maxDatagramSize = 65507
+
+// readBatchSize is number of datagrams taken from the socket with single read where batch reads are available
+const readBatchSize = 16
+
+// rtcpReadBatchSize is smaller, as RTCP packets come only few times per second
+const rtcpReadBatchSize = 2
+
+// batch reads aren't implemented on windows
+var batchReadSupported = runtime.GOOS != "windows"
+
+// buffers are shared by read loops of all sessions, the loop holds them only while the read batch is handled
+var (
+	datagramBuffers = newBufferPool(maxDatagramSize)
+	rtcpBuffers     = newBufferPool(maxRtcpPacketSize)
+)
+
+func newBufferPool(size int) *sync.Pool {
+	return &sync.Pool{
+		New: func() interface{} {
+			buffer := make([]byte, size)
+			return &buffer
+		},
+	}
+}
+
+// packetReader reads datagrams of the connection in dedicated loop, several datagrams are read at once
+// where the platform supports it
+type packetReader struct {
+	connection net.PacketConn
+	raw        syscall.RawConn
+	batch      *ipv4.PacketConn
+	messages   []ipv4.Message
+	buffers    *sync.Pool
+}
+
+// newPacketReader creates reader taking up to batchSize datagrams at once into buffers of the pool
+func newPacketReader(connection net.PacketConn, batchSize int, buffers *sync.Pool) *packetReader {
+	reader := &packetReader{
+		connection: connection,
+		messages:   make([]ipv4.Message, 1),
+		buffers:    buffers,
+	}
+	if syscallConnection, ok := connection.(syscall.Conn); ok {
+		if raw, err := syscallConnection.SyscallConn(); err == nil {
+			reader.raw = raw
+		}
+	}
+	if udpConnection, ok := connection.(*net.UDPConn); ok && batchReadSupported && batchSize > 1 {
+		reader.batch = ipv4.NewPacketConn(udpConnection)
+		reader.messages = make([]ipv4.Message, batchSize)
+		// datagrams of the batch are handled one after another, so only the kernel knows when each of them arrived
+		if timestampSpace > 0 && enableTimestamps(udpConnection) == nil {
+			for index := range reader.messages {
+				reader.messages[index].OOB = make([]byte, timestampSpace)
+			}
+		}
+	}
+	return reader
+}
+
+// run passes every datagram with its arrival time to handle until stop is closed or ctx is done, data is valid only
+// until handle returns, closing stop has to be followed by interrupt, so pending read returns, read failing otherwise
+// is returned
+func (r *packetReader) run(ctx context.Context, stop chan bool,
+	handle func(data []byte, address net.Addr, arrival time.Time)) error {
+	finished := make(chan bool)
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			r.interrupt()
+		case <-finished:
+		}
+	}()
+
+	for {
+		err := r.readBatch(handle)
+		if err != nil {
+			select {
+			case <-stop:
+				return nil
+			default:
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// readBatch waits for datagrams and passes them to handle, buffers are taken from the pool only once
+// a datagram is ready, so idle loops don't hold them
+func (r *packetReader) readBatch(handle func(data []byte, address net.Addr, arrival time.Time)) error {
+	if r.raw != nil {
+		if err := r.raw.Read(datagramReady); err != nil {
+			return err
+		}
+	}
+	r.acquireBuffers()
+	defer r.releaseBuffers()
+	count, err := r.read()
+	if err != nil {
+		return err
+	}
+	received := time.Now()
+	for _, message := range r.messages[:count] {
+		arrival, ok := parseTimestamp(message.OOB[:message.NN])
+		if !ok {
+			arrival = received
+		}
+		handle(message.Buffers[0][:message.N], message.Addr, arrival)
+	}
+	return nil
+}
+
+// acquireBuffers takes buffer of every message from the pool
+func (r *packetReader) acquireBuffers() {
+	for index := range r.messages {
+		buffer := r.buffers.Get().(*[]byte)
+		r.messages[index].Buffers = [][]byte{*buffer}
+	}
+}
+
+func (r *packetReader) releaseBuffers() {
+	for index := range r.messages {
+		buffer := r.messages[index].Buffers[0]
+		r.buffers.Put(&buffer)
+		r.messages[index].Buffers = nil
+	}
+}
+
+// read blocks until at least one datagram arrives and returns number of the read messages
+func (r *packetReader) read() (int, error) {
+	if r.batch != nil {
+		return r.batch.ReadBatch(r.messages, 0)
+	}
+	message := &r.messages[0]
+	var err error
+	message.N, message.Addr, err = r.connection.ReadFrom(message.Buffers[0])
+	if err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// interrupt makes pending read fail, reset has to be called before the next run
+func (r *packetReader) interrupt() error {
+	return r.connection.SetReadDeadline(time.Now())
+}
+
+// reset allows reads which were interrupted
+func (r *packetReader) reset() error {
+	return r.connection.SetReadDeadline(time.Time{})
+}
@@ -0,0 +1,55 @@
+package components
+
+import (
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// timestampSpace is size of control message which carries receive time of the datagram
+var timestampSpace = syscall.CmsgSpace(int(unsafe.Sizeof(syscall.Timespec{})))
+
+// enableTimestamps makes the kernel attach time the datagram was received to every datagram
+func enableTimestamps(connection *net.UDPConn) error {
+	rawConnection, err := connection.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var optionErr error
+	err = rawConnection.Control(func(fd uintptr) {
+		optionErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_TIMESTAMPNS, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return optionErr
+}
+
+// parseTimestamp returns receive time found in control messages of the datagram
+func parseTimestamp(oob []byte) (time.Time, bool) {
+	messages, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return time.Time{}, false
+	}
+	for _, message := range messages {
+		if message.Header.Level != syscall.SOL_SOCKET || message.Header.Type != syscall.SCM_TIMESTAMPNS ||
+			len(message.Data) < int(unsafe.Sizeof(syscall.Timespec{})) {
+			continue
+		}
+		timestamp := (*syscall.Timespec)(unsafe.Pointer(&message.Data[0]))
+		return time.Unix(timestamp.Unix()), true
+	}
+	return time.Time{}, false
+}
+
+// datagramReady peeks at the socket without taking the datagram, false makes the poller wait until one arrives
+func datagramReady(fd uintptr) bool {
+	var probe [1]byte
+	for {
+		_, _, err := syscall.Recvfrom(int(fd), probe[:], syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		if err != syscall.EINTR {
+			return err != syscall.EAGAIN
+		}
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+// +build !linux
+
+package components
+
+import (
+	"net"
+	"time"
+)
+
+// receive time of datagrams isn't taken from the kernel, it's the time the read returned
+const timestampSpace = 0
+
+func enableTimestamps(connection *net.UDPConn) error {
+	return nil
+}
+
+func parseTimestamp(oob []byte) (time.Time, bool) {
+	return time.Time{}, false
+}
+
+// readiness isn't checked, buffers are held also while the read waits for datagram
+func datagramReady(fd uintptr) bool {
+	return true
+}
@@ -0,0 +1,136 @@
+package components
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// packetSize is size of datagrams sent to the reader, as fragmented media of usual MTU
+const packetSize = 1200
+
+func listenLoopback(t testing.TB) (net.PacketConn, net.Conn) {
+	connection, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender, err := net.Dial("udp", connection.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return connection, sender
+}
+
+func TestPacketReaderDeliversAndStops(t *testing.T) {
+	for _, batchSize := range []int{1, rtcpReadBatchSize, readBatchSize} {
+		connection, sender := listenLoopback(t)
+		reader := newPacketReader(connection, batchSize, rtcpBuffers)
+		ctx, cancel := context.WithCancel(context.Background())
+		received := make(chan []byte, 8)
+		arrivals := make(chan time.Time, 8)
+		stopped := make(chan error)
+		go func() {
+			stopped <- reader.run(ctx, make(chan bool), func(data []byte, address net.Addr, arrival time.Time) {
+				if address.String() != sender.LocalAddr().String() {
+					t.Errorf("datagram came from %v", address)
+				}
+				received <- append([]byte(nil), data...)
+				arrivals <- arrival
+			})
+		}()
+
+		for index := byte(0); index < 4; index++ {
+			sent := time.Now()
+			if _, err := sender.Write([]byte{index, index}); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case data := <-received:
+				if len(data) != 2 || data[0] != index {
+					t.Errorf("datagram %v received as %v", index, data)
+				}
+				if arrival := <-arrivals; arrival.Before(sent) || arrival.After(time.Now()) {
+					t.Errorf("datagram %v sent at %v arrived at %v", index, sent, arrival)
+				}
+			case <-time.After(waitTimeout):
+				t.Fatalf("datagram %v wasn't received", index)
+			}
+		}
+
+		// cancelled read returns without any datagram
+		cancel()
+		select {
+		case err := <-stopped:
+			if err != nil {
+				t.Errorf("cancelled reader failed: %v", err)
+			}
+		case <-time.After(waitTimeout):
+			t.Fatalf("reader with batch %v didn't stop", batchSize)
+		}
+		sender.Close()
+		connection.Close()
+	}
+}
+
+// burstSize is number of datagrams sent before the reader takes them, the socket buffers them without loss
+const burstSize = 64
+
+// benchmarkPacketReader sends bursts of datagrams to running read loop and waits until each burst is handled,
+// so waking of the loop and taking of buffers is measured together with reading
+func benchmarkPacketReader(b *testing.B, batchSize int) {
+	connection, sender := listenLoopback(b)
+	defer connection.Close()
+	defer sender.Close()
+	reader := newPacketReader(connection, batchSize, datagramBuffers)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	handled := make(chan bool)
+	received := 0
+	go func() {
+		stopped <- reader.run(ctx, make(chan bool), func(data []byte, address net.Addr, arrival time.Time) {
+			received++
+			if received%burstSize == 0 || received == b.N {
+				handled <- true
+			}
+		})
+	}()
+
+	packet := make([]byte, packetSize)
+	b.SetBytes(packetSize)
+	b.ResetTimer()
+	start := time.Now()
+	for sent := 0; sent < b.N; {
+		burst := burstSize
+		if b.N-sent < burst {
+			burst = b.N - sent
+		}
+		for index := 0; index < burst; index++ {
+			if _, err := sender.Write(packet); err != nil {
+				b.Fatal(err)
+			}
+		}
+		sent += burst
+		// lost datagram fails the benchmark instead of blocking it
+		select {
+		case <-handled:
+		case <-time.After(waitTimeout):
+			b.Fatalf("datagrams up to %v weren't handled", sent)
+		}
+	}
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "packets/s")
+	b.StopTimer()
+
+	cancel()
+	if err := <-stopped; err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkPacketReaderSingle(b *testing.B) {
+	benchmarkPacketReader(b, 1)
+}
+
+func BenchmarkPacketReaderBatch(b *testing.B) {
+	benchmarkPacketReader(b, readBatchSize)
+}
@@ -0,0 +1,7 @@
+//go:build race
+// +build race
+
+package components
+
+// raceEnabled reports whether tests run with race detector, which slows encoding down too much for timing assertions
+const raceEnabled = true
@@ -0,0 +1,45 @@
+package components
+
+// receivedWindowSize is number of the latest sequence numbers remembered to detect duplicated packets
+const receivedWindowSize = 1024
+
+// receivedWindow remembers which of the latest sequence numbers were received, copies of a packet come
+// as retransmission of late original, as packet recovered by parity and retransmitted as well or from
+// duplicating network
+type receivedWindow struct {
+	received      [receivedWindowSize / 64]uint64
+	highestSeqNum uint16
+	initialized   bool
+}
+
+// add marks the sequence number as received, it reports false when the number was received already,
+// number older than the window starts new numbering, as it happens when the stream is published again
+func (w *receivedWindow) add(seqNum uint16) bool {
+	distance := seqNum - w.highestSeqNum
+	if !w.initialized || distance < 0x8000 && distance >= receivedWindowSize ||
+		distance >= 0x8000 && -distance >= receivedWindowSize {
+		w.received = [receivedWindowSize / 64]uint64{}
+		w.highestSeqNum = seqNum
+		w.initialized = true
+		w.mark(seqNum)
+		return true
+	}
+	if distance != 0 && distance < 0x8000 {
+		// numbers skipped over are still missing, they may come later
+		for skipped := w.highestSeqNum + 1; skipped != seqNum; skipped++ {
+			w.received[skipped%receivedWindowSize/64] &^= 1 << (skipped % 64)
+		}
+		w.highestSeqNum = seqNum
+		w.mark(seqNum)
+		return true
+	}
+	if w.received[seqNum%receivedWindowSize/64]&(1<<(seqNum%64)) != 0 {
+		return false
+	}
+	w.mark(seqNum)
+	return true
+}
+
+func (w *receivedWindow) mark(seqNum uint16) {
+	w.received[seqNum%receivedWindowSize/64] |= 1 << (seqNum % 64)
+}
@@ -0,0 +1,183 @@
+package components
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"streming_server/logging"
+	"streming_server/protocol/rtp"
+	"streming_server/video"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const DefaultRecordingDuration = 10 * time.Minute
+const DefaultRecordingSize = 512 * 1024 * 1024
+const recorderQueueSize = 64
+
+type Recorder struct {
+	aviWriter       *video.AviWriter
+	directory       string
+	streamName      string
+	maxDuration     time.Duration
+	maxSize         int64
+	framesPerSecond int
+	fileStartTime   time.Time
+	packetChannel   chan *rtp.Packet
+	doneCheck       chan bool
+	// Feed checks started on the goroutine receiving the stream while Stop clears it on the closing one
+	started int32
+}
+
+// NewRecorder creates recorder which stores the stream in MJPEG AVI files inside given directory,
+// the file is rotated when it lasts longer than maxDuration or grows bigger than maxSize (zero disables the limit)
+func NewRecorder(directory string, streamName string, maxDuration time.Duration, maxSize int64) *Recorder {
+	return &Recorder{
+		directory:       directory,
+		streamName:      streamName,
+		maxDuration:     maxDuration,
+		maxSize:         maxSize,
+		framesPerSecond: 1000 / video.DefaultFramePeriod,
+		packetChannel:   make(chan *rtp.Packet, recorderQueueSize),
+		doneCheck:       make(chan bool),
+	}
+}
+
+// Feed passes packet to the recorder without blocking the caller, packet is dropped when disk can't keep up
+func (rec *Recorder) Feed(packet *rtp.Packet) {
+	if atomic.LoadInt32(&rec.started) == 0 {
+		return
+	}
+	select {
+	case rec.packetChannel <- packet:
+	default:
+		recorderLogger.Warn("recorder queue is full, frame dropped")
+	}
+}
+
+func (rec *Recorder) openFile() error {
+	err := os.MkdirAll(rec.directory, 0755)
+	if err != nil {
+		return err
+	}
+	rec.fileStartTime = time.Now()
+	fileName := filepath.Join(rec.directory,
+		fmt.Sprintf("%v_%v.avi", streamDirectoryName(rec.streamName), rec.fileStartTime.Format("20060102_150405.000")))
+	aviWriter, err := video.NewAviWriter(fileName, rec.framesPerSecond)
+	if err != nil {
+		return err
+	}
+	rec.aviWriter = aviWriter
+	recorderLogger.Info("recording to file", "file", fileName)
+	return nil
+}
+
+func (rec *Recorder) closeFile() {
+	if rec.aviWriter == nil {
+		return
+	}
+	err := rec.aviWriter.Close()
+	if err != nil {
+		recorderLogger.Error("error while closing recording", logging.ErrorKey, err)
+	}
+	rec.aviWriter = nil
+}
+
+func (rec *Recorder) rotationRequired() bool {
+	if rec.aviWriter.Full() {
+		return true
+	}
+	if rec.maxDuration > 0 && time.Since(rec.fileStartTime) >= rec.maxDuration {
+		return true
+	}
+	return rec.maxSize > 0 && rec.aviWriter.Size >= rec.maxSize
+}
+
+func (rec *Recorder) writeFrame(packet *rtp.Packet) {
+	if rec.aviWriter != nil && rec.rotationRequired() {
+		rec.closeFile()
+	}
+	if rec.aviWriter == nil {
+		err := rec.openFile()
+		if err != nil {
+			recorderLogger.Error("cannot create recording file", logging.ErrorKey, err)
+			return
+		}
+	}
+
+	err := rec.aviWriter.WriteFrame(packet.Payload)
+	if err != nil {
+		recorderLogger.Error("error while writing frame", logging.ErrorKey, err)
+	}
+}
+
+func (rec *Recorder) Start() {
+	atomic.StoreInt32(&rec.started, 1)
+
+	go func() {
+		for {
+			select {
+			case <-rec.doneCheck:
+				return
+			case packet := <-rec.packetChannel:
+				rec.writeFrame(packet)
+			}
+		}
+	}()
+}
+
+// Stop writes frames which are still queued and finalizes the recording
+func (rec *Recorder) Stop() {
+	if atomic.CompareAndSwapInt32(&rec.started, 1, 0) {
+		rec.doneCheck <- true
+		for drained := false; !drained; {
+			select {
+			case packet := <-rec.packetChannel:
+				rec.writeFrame(packet)
+			default:
+				drained = true
+			}
+		}
+		rec.closeFile()
+	}
+}
+
+// RecorderManager records every mount point into separate files
+type RecorderManager struct {
+	recorders   map[string]*Recorder
+	mutex       sync.Mutex
+	directory   string
+	maxDuration time.Duration
+	maxSize     int64
+}
+
+func NewRecorderManager(directory string, maxDuration time.Duration, maxSize int64) *RecorderManager {
+	return &RecorderManager{
+		recorders:   make(map[string]*Recorder),
+		directory:   directory,
+		maxDuration: maxDuration,
+		maxSize:     maxSize,
+	}
+}
+
+func (m *RecorderManager) Feed(path string, packet *rtp.Packet) {
+	m.mutex.Lock()
+	recorder, found := m.recorders[path]
+	if !found {
+		recorder = NewRecorder(m.directory, path, m.maxDuration, m.maxSize)
+		recorder.Start()
+		m.recorders[path] = recorder
+	}
+	m.mutex.Unlock()
+
+	recorder.Feed(packet)
+}
+
+func (m *RecorderManager) Close() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, recorder := range m.recorders {
+		recorder.Stop()
+	}
+}
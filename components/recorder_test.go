@@ -0,0 +1,39 @@
+package components
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"streming_server/protocol/rtp"
+	"testing"
+)
+
+func TestRecorderWritesQueuedFramesOnStop(t *testing.T) {
+	directory, err := ioutil.TempDir("", "recordings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	recorder := NewRecorder(directory, "cam", DefaultRecordingDuration, 0)
+	recorder.Start()
+	const framesNumber = recorderQueueSize / 2
+	for index := 0; index < framesNumber; index++ {
+		recorder.Feed(&rtp.Packet{Payload: encodeSyntheticFrame(t, index)})
+	}
+	recorder.Stop()
+
+	files, err := filepath.Glob(filepath.Join(directory, "*.avi"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("recording files %v, error %v", files, err)
+	}
+	file, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// total frames field of the main AVI header
+	if recorded := binary.LittleEndian.Uint32(file[48:52]); recorded != framesNumber {
+		t.Errorf("%v frames recorded, expected %v", recorded, framesNumber)
+	}
+}
@@ -0,0 +1,89 @@
+package components
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// routineGroup counts running goroutines by name, so shutdown can wait for them and report the ones which
+// didn't stop, goroutines of nil group aren't tracked
+type routineGroup struct {
+	running map[string]int
+	total   int
+	idle    chan struct{}
+	mutex   sync.Mutex
+}
+
+func newRoutineGroup() *routineGroup {
+	return &routineGroup{
+		running: make(map[string]int),
+	}
+}
+
+// Go runs routine in new goroutine which is counted under the name until the routine returns
+func (g *routineGroup) Go(name string, routine func()) {
+	if g == nil {
+		go routine()
+		return
+	}
+	g.mutex.Lock()
+	if g.total == 0 {
+		g.idle = make(chan struct{})
+	}
+	g.total++
+	g.running[name]++
+	g.mutex.Unlock()
+
+	go func() {
+		defer g.done(name)
+		routine()
+	}()
+}
+
+func (g *routineGroup) done(name string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.running[name]--
+	if g.running[name] == 0 {
+		delete(g.running, name)
+	}
+	g.total--
+	if g.total == 0 {
+		close(g.idle)
+	}
+}
+
+// Wait returns once every goroutine returned or ctx is done, goroutines which are still running are returned
+// as their names with counts
+func (g *routineGroup) Wait(ctx context.Context) []string {
+	g.mutex.Lock()
+	idle := g.idle
+	total := g.total
+	g.mutex.Unlock()
+	if total == 0 {
+		return nil
+	}
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+	}
+	return g.Running()
+}
+
+// Running returns sorted names of the running goroutines, names of several goroutines are followed by their count
+func (g *routineGroup) Running() []string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	result := make([]string, 0, len(g.running))
+	for name, count := range g.running {
+		if count > 1 {
+			name = fmt.Sprintf("%v (%v)", name, count)
+		}
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -1,85 +1,269 @@
 package components
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"math"
 	"net"
+	"streming_server/logging"
 	"streming_server/protocol/rtcp"
+	"streming_server/protocol/rtp"
+	"streming_server/protocol/srtp"
 	"streming_server/util"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const maxRtcpPacketSize = 1500
+
+// DefaultRoundTripTime is assumed until the first measurement is available
+const DefaultRoundTripTime = 100 * time.Millisecond
+
 type RtcpReceiver struct {
-	ticker          *time.Ticker
-	interval        time.Duration
-	udpCon          net.PacketConn
-	congestionLevel int
-	buffer          []byte
-	doneCheck       chan bool
-	started         bool
-	ServerPort      string
-}
-
-func NewRtcpReceiver() *RtcpReceiver {
-	address := fmt.Sprintf(":0")
-	udpConn, err := net.ListenPacket("udp", address)
+	rtpSender            *RtpSender
+	congestionController *CongestionController
+	udpCon               net.PacketConn
+	reader               *packetReader
+	congestionLevel      int32
+	roundTripTime        int64
+	fractionLost         uint64
+	srtpContext          *srtp.Context
+	logger               *logging.Logger
+	peerAddress          atomic.Value
+	doneCheck            chan bool
+	routines             *routineGroup
+	running              sync.WaitGroup
+	started              bool
+	closed               bool
+	ServerPort           string
+}
+
+// NewRtcpReceiver opens port for feedback of the client on any free port
+func NewRtcpReceiver(ctx context.Context) (*RtcpReceiver, error) {
+	var listenConfig net.ListenConfig
+	udpConn, err := listenConfig.ListenPacket(ctx, "udp", ":0")
 	if err != nil {
-		log.Fatalln("[RTCP] error while opening connection:", err)
+		return nil, fmt.Errorf("cannot open rtcp port: %v", err)
+	}
+	_, serverPort, err := net.SplitHostPort(udpConn.LocalAddr().String())
+	if err != nil {
+		_ = udpConn.Close()
+		return nil, err
 	}
-	serverPort := strings.Split(udpConn.LocalAddr().String(), ":")[3]
 
 	return &RtcpReceiver{
-		interval:        DefaultRtcpInterval * time.Millisecond,
 		udpCon:          udpConn,
-		buffer:          make([]byte, 24),
+		reader:          newPacketReader(udpConn, rtcpReadBatchSize, rtcpBuffers),
 		doneCheck:       make(chan bool),
-		congestionLevel: util.NoCongestion,
+		congestionLevel: int32(util.NoCongestion),
+		roundTripTime:   int64(DefaultRoundTripTime),
+		logger:          rtcpLogger,
 		ServerPort:      serverPort,
+	}, nil
+}
+
+// SetLogger attaches fields of the session to messages of the receiver
+func (r *RtcpReceiver) SetLogger(logger *logging.Logger) {
+	r.logger = logger.With(logging.ComponentKey, "RTCP")
+}
+
+// SetRtpSender sets sender which answers retransmission requests and provides data for sender reports
+func (r *RtcpReceiver) SetRtpSender(rtpSender *RtpSender) {
+	r.rtpSender = rtpSender
+}
+
+// SetCongestionController sets controller which receives feedback of the receiver
+func (r *RtcpReceiver) SetCongestionController(congestionController *CongestionController) {
+	r.congestionController = congestionController
+}
+
+// SetSrtpContext verifies feedback and protects sender reports with SRTCP
+func (r *RtcpReceiver) SetSrtpContext(srtpContext *srtp.Context) {
+	r.srtpContext = srtpContext
+}
+
+func (r *RtcpReceiver) RoundTripTime() time.Duration {
+	return time.Duration(atomic.LoadInt64(&r.roundTripTime))
+}
+
+// FractionLost returns fraction of packets lost according to the last receiver report
+func (r *RtcpReceiver) FractionLost() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&r.fractionLost))
+}
+
+// CongestionLevel returns congestion level resolved from the last receiver report
+func (r *RtcpReceiver) CongestionLevel() int {
+	return int(atomic.LoadInt32(&r.congestionLevel))
+}
+
+// receive handles single feedback packet of the client
+func (r *RtcpReceiver) receive(packetBytes []byte, address net.Addr, arrivalTime time.Time) {
+	packetLength := len(packetBytes)
+	r.peerAddress.Store(address)
+	if r.srtpContext != nil {
+		var err error
+		packetBytes, err = r.srtpContext.DecryptRtcp(packetBytes)
+		if err != nil {
+			r.logger.Error("dropped feedback packet", logging.ErrorKey, err)
+			return
+		}
+		packetLength = len(packetBytes)
+	}
+	if packetLength < 2 {
+		return
+	}
+
+	switch packetBytes[1] {
+	case rtcp.ByeType:
+		rtcpReportsMetric.Inc(receivedDirection, byeType)
+		r.logger.Info("peer left the session")
+		return
+	case rtcp.TransportFeedbackType:
+		nackPacket, err := rtcp.NewNackPacketFromBytes(packetBytes)
+		if err != nil {
+			r.logger.Error("invalid feedback packet", logging.ErrorKey, err)
+			return
+		}
+		rtcpReportsMetric.Inc(receivedDirection, nackType)
+		if r.rtpSender != nil {
+			r.rtpSender.Retransmit(nackPacket.LostSeqNums())
+		}
+	case rtcp.PayloadSpecificFeedbackType:
+		rembPacket, err := rtcp.NewRembPacketFromBytes(packetBytes)
+		if err != nil {
+			r.logger.Error("invalid feedback packet", logging.ErrorKey, err)
+			return
+		}
+		rtcpReportsMetric.Inc(receivedDirection, rembType)
+		if r.congestionController != nil {
+			r.congestionController.OnRemb(int(rembPacket.Bitrate))
+		}
+	default:
+		if packetLength < rtcp.HeaderSize+rtcp.BodySize {
+			r.logger.Warn("receiver report too short", "length", packetLength)
+			return
+		}
+		rtcpPacket, err := rtcp.NewPacketFromBytes(packetBytes)
+		if err != nil {
+			r.logger.Error("invalid receiver report", logging.ErrorKey, err)
+			return
+		}
+		rtcpPacket.Log(r.logger)
+		observeReceiverReport(receivedDirection, rtcpPacket.FractionLost, rtcpPacket.Jitter)
+
+		atomic.StoreInt32(&r.congestionLevel, int32(util.ResolveCongestionLevel(rtcpPacket.FractionLost)))
+		atomic.StoreUint64(&r.fractionLost, math.Float64bits(rtcpPacket.FractionLost))
+		if rtcpPacket.LastSenderReport != 0 {
+			roundTripTime := rtcp.RoundTripTime(arrivalTime, rtcpPacket.LastSenderReport,
+				rtcpPacket.DelaySinceLastSenderReport)
+			atomic.StoreInt64(&r.roundTripTime, int64(roundTripTime))
+			r.logger.Debug("round trip time measured", "rtt", roundTripTime)
+		}
+		if r.congestionController != nil {
+			r.congestionController.OnReceiverReport(ReceiverFeedback{
+				FractionLost:    rtcpPacket.FractionLost,
+				RoundTripTime:   r.RoundTripTime(),
+				Jitter:          int(rtcpPacket.Jitter),
+				DelayGradient:   int(rtcpPacket.DelayGradient),
+				ReceivedBitrate: int(rtcpPacket.ReceivedBitrate),
+			})
+		}
 	}
+
+	// every feedback is answered with sender report, so the next one allows to measure round trip time
+	r.sendSenderReport(address)
 }
 
-func (r *RtcpReceiver) receive() {
-	_, _, err := r.udpCon.ReadFrom(r.buffer)
+func (r *RtcpReceiver) sendSenderReport(address net.Addr) {
+	if r.rtpSender == nil {
+		return
+	}
+	err := r.write(r.rtpSender.senderReport().TransformToBytes(), address)
 	if err != nil {
-		log.Println("[RTCP] error while reading packet:", err)
+		r.logger.Error("error while sending sender report", logging.ErrorKey, err)
 		return
 	}
-	rtcpPacket := rtcp.NewPacketFromBytes(r.buffer)
-	rtcpPacket.Log()
+	rtcpReportsMetric.Inc(sentDirection, senderReportType)
+}
 
-	r.congestionLevel = util.ResolveCongestionLevel(rtcpPacket.FractionLost)
+// SendBye announces end of the sent streams to the receiver, it is skipped when no feedback came
+// from the receiver yet, so its address isn't known
+func (r *RtcpReceiver) SendBye(reason string) {
+	address, _ := r.peerAddress.Load().(net.Addr)
+	if address == nil {
+		return
+	}
+	err := r.write(rtcp.NewByePacket([]uint32{rtp.DefaultSsrc, RtxSsrc}, reason).TransformToBytes(), address)
+	if err != nil {
+		r.logger.Debug("bye wasn't sent", logging.ErrorKey, err)
+		return
+	}
+	rtcpReportsMetric.Inc(sentDirection, byeType)
 }
 
-func (r *RtcpReceiver) Start() {
+// write sends packet to the receiver, protected by SRTCP when the context is set
+func (r *RtcpReceiver) write(packet []byte, address net.Addr) error {
+	var err error
+	if r.srtpContext != nil {
+		packet, err = r.srtpContext.EncryptRtcp(packet)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = r.udpCon.WriteTo(packet, address)
+	return err
+}
+
+// Start receives feedback until Stop is called or ctx is done, closed receiver can't be started
+func (r *RtcpReceiver) Start(ctx context.Context) error {
+	if r.closed {
+		return errors.New("rtcp receiver is closed")
+	}
+	if r.started {
+		return nil
+	}
 	r.started = true
-	r.ticker = time.NewTicker(r.interval)
 	r.doneCheck = make(chan bool)
+	err := r.reader.reset()
+	if err != nil {
+		r.logger.Error("error while resetting read deadline", logging.ErrorKey, err)
+	}
 
-	go func() {
-		for {
-			select {
-			case <-r.doneCheck:
-				return
-			case <-r.ticker.C:
-				r.receive()
-			}
+	r.running.Add(1)
+	doneCheck := r.doneCheck
+	r.routines.Go("rtcp receiver", func() {
+		defer r.running.Done()
+		err := r.reader.run(ctx, doneCheck, r.receive)
+		if err != nil {
+			r.logger.Error("error while reading packet", logging.ErrorKey, err)
 		}
-	}()
+	})
+	return nil
 }
 
 func (r *RtcpReceiver) Stop() {
 	if r.started {
 		close(r.doneCheck)
-		r.ticker.Stop()
+		err := r.reader.interrupt()
+		if err != nil {
+			r.logger.Error("error while interrupting read", logging.ErrorKey, err)
+		}
+		r.running.Wait()
 		r.started = false
 	}
 }
 
+// Close may be called repeatedly, the connection is closed once
 func (r *RtcpReceiver) Close() {
 	r.Stop()
+	if r.closed {
+		return
+	}
+	r.closed = true
 	err := r.udpCon.Close()
 	if err != nil {
-		log.Println("[RTCP] error while closing connection:", err)
+		r.logger.Error("error while closing connection", logging.ErrorKey, err)
 	}
 }
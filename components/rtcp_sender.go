@@ -1,13 +1,18 @@
 package components
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
 	"net"
+	"streming_server/logging"
 	"streming_server/protocol/rtcp"
+	"streming_server/protocol/srtp"
+	"sync"
 	"time"
 )
 
-const DefaultRtcpInterval = 5
+const DefaultRtcpInterval = 1
 
 type RtcpSender struct {
 	rtpReceiver        *RtpReceiver
@@ -17,7 +22,18 @@ type RtcpSender struct {
 	doneCheck          chan bool
 	lastHighSeqNum     int
 	lastCumulativeLost int
+	lastTotalBytes     int
+	lastFeedbackTime   time.Time
+	lastSenderReport   uint32
+	senderReportTime   time.Time
+	senderReportMutex  sync.Mutex
+	srtpContext        *srtp.Context
+	logger             *logging.Logger
+	routines           *routineGroup
+	byeReceived        chan struct{}
+	byeOnce            sync.Once
 	started            bool
+	closed             bool
 }
 
 func NewRtcpSender(rtpReceiver *RtpReceiver) *RtcpSender {
@@ -26,30 +42,91 @@ func NewRtcpSender(rtpReceiver *RtpReceiver) *RtcpSender {
 	result := RtcpSender{
 		rtpReceiver: rtpReceiver,
 		interval:    interval,
-		doneCheck:   make(chan bool),
+		logger:      rtcpLogger,
+		byeReceived: make(chan struct{}),
 		started:     false,
 	}
 
 	return &result
 }
 
-func (s *RtcpSender) InitConnection(serverAddress string) {
-	address, err := net.ResolveUDPAddr("udp", serverAddress)
+// SetLogger attaches fields of the session to messages of the sender
+func (s *RtcpSender) SetLogger(logger *logging.Logger) {
+	s.logger = logger.With(logging.ComponentKey, "RTCP")
+}
+
+// SetInterval sets how often feedback is sent to the server, it has to be set before Start
+func (s *RtcpSender) SetInterval(interval time.Duration) {
+	s.interval = interval
+}
+
+// SetSrtpContext protects feedback and verifies sender reports with SRTCP, it has to be set before InitConnection
+func (s *RtcpSender) SetSrtpContext(srtpContext *srtp.Context) {
+	s.srtpContext = srtpContext
+}
+
+// InitConnection connects to rtcp receiver of the server and starts reading its sender reports
+func (s *RtcpSender) InitConnection(ctx context.Context, serverAddress string) error {
+	var dialer net.Dialer
+	senderConnection, err := dialer.DialContext(ctx, "udp", serverAddress)
 	if err != nil {
-		log.Fatalln("[RTCP] error while resolving rtcp address:", err)
+		return fmt.Errorf("cannot connect to rtcp receiver: %v", err)
 	}
-	senderConnection, err := net.DialUDP("udp", nil, address)
-	if err != nil {
-		log.Fatalln("[RTCP] error while connecting with rtcp receiver:", err)
+	s.serverConnection = senderConnection.(*net.UDPConn)
+	s.closed = false
+	s.routines.Go("sender report reader", s.receiveSenderReports)
+	return nil
+}
+
+// ByeReceived is closed once the server announces end of the stream
+func (s *RtcpSender) ByeReceived() <-chan struct{} {
+	return s.byeReceived
+}
+
+// receiveSenderReports runs until the connection is closed
+func (s *RtcpSender) receiveSenderReports() {
+	buffer := make([]byte, maxRtcpPacketSize)
+	for {
+		packetLength, err := s.serverConnection.Read(buffer)
+		if err != nil {
+			return
+		}
+		packetBytes := buffer[:packetLength]
+		if s.srtpContext != nil {
+			packetBytes, err = s.srtpContext.DecryptRtcp(packetBytes)
+			if err != nil {
+				s.logger.Error("dropped sender report", logging.ErrorKey, err)
+				continue
+			}
+		}
+		if len(packetBytes) > 1 && packetBytes[1] == rtcp.ByeType {
+			rtcpReportsMetric.Inc(receivedDirection, byeType)
+			s.logger.Info("server ended the stream")
+			s.byeOnce.Do(func() { close(s.byeReceived) })
+			continue
+		}
+		report, err := rtcp.NewSenderReportFromBytes(packetBytes)
+		if err != nil {
+			s.logger.Error("invalid sender report", logging.ErrorKey, err)
+			continue
+		}
+		rtcpReportsMetric.Inc(receivedDirection, senderReportType)
+		s.senderReportMutex.Lock()
+		s.lastSenderReport = report.CompactNtpTimestamp()
+		s.senderReportTime = time.Now()
+		s.senderReportMutex.Unlock()
+		if report.TargetBitrate != 0 {
+			s.rtpReceiver.SetTargetBitrate(int(report.TargetBitrate))
+		}
 	}
-	s.serverConnection = senderConnection
 }
 
 func (s *RtcpSender) sendFeedback() {
-	expectedPacketsNumber := s.rtpReceiver.highestRecvSeqNum - s.lastHighSeqNum
-	lostPacketsNumber := s.rtpReceiver.cumulativeLost - s.lastCumulativeLost
-	s.lastHighSeqNum = s.rtpReceiver.highestRecvSeqNum
-	s.lastCumulativeLost = s.rtpReceiver.cumulativeLost
+	stats := s.rtpReceiver.receptionStats()
+	expectedPacketsNumber := stats.highestRecvSeqNum - s.lastHighSeqNum
+	lostPacketsNumber := stats.cumulativeLost - s.lastCumulativeLost
+	s.lastHighSeqNum = stats.highestRecvSeqNum
+	s.lastCumulativeLost = stats.cumulativeLost
 
 	lastFractionLost := 0.0
 	if expectedPacketsNumber != 0 {
@@ -57,42 +134,118 @@ func (s *RtcpSender) sendFeedback() {
 	}
 
 	rtpPacket := rtcp.NewPacket(lastFractionLost, s.lastCumulativeLost, s.lastHighSeqNum)
-	_, err := s.serverConnection.Write(rtpPacket.TransformToBytes())
+	now := time.Now()
+	if !s.lastFeedbackTime.IsZero() {
+		receivedBits := (stats.totalBytes - s.lastTotalBytes) * 8
+		rtpPacket.ReceivedBitrate = uint32(float64(receivedBits) / now.Sub(s.lastFeedbackTime).Seconds())
+	}
+	s.lastTotalBytes = stats.totalBytes
+	s.lastFeedbackTime = now
+	rtpPacket.DelayGradient = int32(stats.delayGradient)
+	rtpPacket.Jitter = uint32(stats.jitter)
+	s.senderReportMutex.Lock()
+	if !s.senderReportTime.IsZero() {
+		rtpPacket.LastSenderReport = s.lastSenderReport
+		// delay is expressed in units of 1/65536 seconds
+		rtpPacket.DelaySinceLastSenderReport = uint32(time.Since(s.senderReportTime) * 65536 / time.Second)
+	}
+	s.senderReportMutex.Unlock()
+
+	err := s.write(rtpPacket.TransformToBytes())
+	if err != nil {
+		s.logger.Error("error while sending packet", logging.ErrorKey, err)
+		return
+	}
+	observeReceiverReport(sentDirection, rtpPacket.FractionLost, rtpPacket.Jitter)
+	s.logger.Debug("receiver report sent", "fractionLost", lastFractionLost)
+}
+
+// SendNack requests retransmission of lost packets of the media stream
+func (s *RtcpSender) SendNack(mediaSsrc uint32, lostSeqNums []int) {
+	if s.serverConnection == nil {
+		return
+	}
+	nackPacket := rtcp.NewNackPacket(rtcp.ReceiverSsrc, mediaSsrc, lostSeqNums)
+	err := s.write(nackPacket.TransformToBytes())
+	if err != nil {
+		s.logger.Error("error while sending nack", logging.ErrorKey, err)
+		return
+	}
+	rtcpReportsMetric.Inc(sentDirection, nackType)
+}
+
+// SendBye announces end of the session to the sender of the stream
+func (s *RtcpSender) SendBye(reason string) {
+	if s.serverConnection == nil {
+		return
+	}
+	err := s.write(rtcp.NewByePacket([]uint32{rtcp.ReceiverSsrc}, reason).TransformToBytes())
 	if err != nil {
-		log.Println("[RTCP] error while sending packet:", err)
+		s.logger.Debug("bye wasn't sent", logging.ErrorKey, err)
 		return
 	}
-	log.Println("[RTCP] feedback packet has been sent to the server.")
+	rtcpReportsMetric.Inc(sentDirection, byeType)
+}
+
+// write sends packet to the rtcp receiver, protected by SRTCP when the context is set
+func (s *RtcpSender) write(packet []byte) error {
+	var err error
+	if s.srtpContext != nil {
+		packet, err = s.srtpContext.EncryptRtcp(packet)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = s.serverConnection.Write(packet)
+	return err
 }
 
-func (s *RtcpSender) Start() {
+// Start sends feedback until Stop is called or ctx is done, the connection has to be initialized first
+func (s *RtcpSender) Start(ctx context.Context) error {
+	if s.serverConnection == nil || s.closed {
+		return errors.New("rtcp connection isn't initialized")
+	}
+	if s.started {
+		return nil
+	}
 	s.started = true
 	s.ticker = time.NewTicker(s.interval)
+	s.doneCheck = make(chan bool)
 
-	go func() {
+	ticker, doneCheck := s.ticker, s.doneCheck
+	s.routines.Go("rtcp sender", func() {
 		for {
 			select {
-			case <-s.doneCheck:
+			case <-doneCheck:
+				return
+			case <-ctx.Done():
 				return
-			case <-s.ticker.C:
+			case <-ticker.C:
 				s.sendFeedback()
 			}
 		}
-	}()
+	})
+	return nil
 }
 
 func (s *RtcpSender) Stop() {
 	if s.started {
-		s.doneCheck <- true
+		close(s.doneCheck)
 		s.ticker.Stop()
 		s.started = false
 	}
 }
 
+// Close may be called repeatedly, the connection is closed once
 func (s *RtcpSender) Close() {
 	s.Stop()
+	if s.serverConnection == nil || s.closed {
+		// connection is made after SETUP
+		return
+	}
+	s.closed = true
 	err := s.serverConnection.Close()
 	if err != nil {
-		log.Println("[RTCP] error while closing connection:", err)
+		s.logger.Error("error while closing connection", logging.ErrorKey, err)
 	}
 }
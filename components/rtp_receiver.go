@@ -1,166 +1,383 @@
 package components
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"math"
 	"net"
+	"streming_server/logging"
 	"streming_server/protocol/rtp"
-	"streming_server/ui"
+	"streming_server/protocol/srtp"
 	"streming_server/video"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-const DefaultRtpInterval = 1
+const delayGradientSmoothing = 0.1
 
 type RtpReceiver struct {
 	server            *RtspServer
 	frameSync         *video.FrameSync
-	view              *ui.View
-	ticker            *time.Ticker
-	interval          time.Duration
+	view              ClientView
+	recorder          *Recorder
+	nackGenerator     *NackGenerator
+	fecDecoder        *FecDecoder
+	srtpContext       *srtp.Context
+	stream            string
+	logger            *logging.Logger
 	udpCon            net.PacketConn
+	reader            *packetReader
 	highestRecvSeqNum int
 	cumulativeLost    int
+	lastArrivalTime   time.Time
+	lastTimestamp     int
+	delayGradient     float64
+	jitter            float64
+	targetBitrate     int64
+	sourceResolution  image.Point
+	lastResolution    image.Point
+	resolutionMutex   sync.Mutex
 	recvPacketsNum    int
+	receivedSeqNums   receivedWindow
 	totalBytes        int
+	statsMutex        sync.Mutex
 	doneCheck         chan bool
+	routines          *routineGroup
+	running           sync.WaitGroup
 	startTime         int64
 	totalPlayTime     int64
 	started           bool
+	closed            bool
 	listeningPort     string
 }
 
-func NewRtpReceiver(frameSync *video.FrameSync, view *ui.View) *RtpReceiver {
-	udpConn, err := net.ListenPacket("udp", ":0")
+// receptionStats is snapshot of reception statistics reported in receiver reports
+type receptionStats struct {
+	highestRecvSeqNum int
+	cumulativeLost    int
+	totalBytes        int
+	delayGradient     float64
+	jitter            float64
+}
+
+// NewRtpReceiver opens port for the stream on any free port, view presents received frames and may be nil
+func NewRtpReceiver(ctx context.Context, frameSync *video.FrameSync, view ClientView) (*RtpReceiver, error) {
+	var listenConfig net.ListenConfig
+	udpConn, err := listenConfig.ListenPacket(ctx, "udp", ":0")
 	if err != nil {
-		log.Fatalln("[RTP] cannot make rtp connection:", err)
+		return nil, fmt.Errorf("cannot open rtp port: %v", err)
+	}
+	_, listeningPort, err := net.SplitHostPort(udpConn.LocalAddr().String())
+	if err != nil {
+		_ = udpConn.Close()
+		return nil, err
 	}
-	listeningPort := strings.Split(udpConn.LocalAddr().String(), ":")[3]
 
 	return &RtpReceiver{
 		frameSync:     frameSync,
 		view:          view,
-		interval:      DefaultRtpInterval * time.Millisecond,
 		udpCon:        udpConn,
+		reader:        newPacketReader(udpConn, readBatchSize, datagramBuffers),
+		fecDecoder:    NewFecDecoder(),
+		logger:        rtpLogger,
 		doneCheck:     make(chan bool),
 		started:       false,
 		listeningPort: listeningPort,
-	}
+	}, nil
 }
 
-func NewRtpReceiverWithServer(server *RtspServer, frameSync *video.FrameSync) *RtpReceiver {
-	rtpReceiver := NewRtpReceiver(frameSync, nil)
+// NewRtpReceiverWithServer creates receiver which forwards the published stream to mount point of the server
+func NewRtpReceiverWithServer(ctx context.Context, server *RtspServer, frameSync *video.FrameSync) (*RtpReceiver,
+	error) {
+	rtpReceiver, err := NewRtpReceiver(ctx, frameSync, nil)
+	if err != nil {
+		return nil, err
+	}
 	rtpReceiver.server = server
-	return rtpReceiver
+	return rtpReceiver, nil
 }
 
-func (r *RtpReceiver) SetStartTime(startTime int64) {
-	r.startTime = startTime
+// SetLogger attaches fields of the session to messages of the receiver
+func (r *RtpReceiver) SetLogger(logger *logging.Logger) {
+	r.logger = logger.With(logging.ComponentKey, "RTP")
 }
 
-func (r *RtpReceiver) receive() {
-	log.Println("[RTP] received packet")
-	buf := make([]byte, 65507)
-	packetLength, _, err := r.udpCon.ReadFrom(buf)
+// SetRecorder sets optional recorder which receives copy of every incoming packet
+func (r *RtpReceiver) SetRecorder(recorder *Recorder) {
+	r.recorder = recorder
+}
 
-	if packetLength == 0 {
-		return
+// SetNackGenerator sets generator which requests retransmission of lost packets
+func (r *RtpReceiver) SetNackGenerator(nackGenerator *NackGenerator) {
+	r.nackGenerator = nackGenerator
+}
+
+// SetSrtpContext verifies and decrypts incoming packets with SRTP, unprotected packets are dropped
+func (r *RtpReceiver) SetSrtpContext(srtpContext *srtp.Context) {
+	r.srtpContext = srtpContext
+}
+
+// restoreRetransmission rebuilds original packet from RTX packet whose payload starts with original sequence number
+func restoreRetransmission(rtpPacket *rtp.Packet) *rtp.Packet {
+	if rtpPacket.Header.PayloadType != RtxType || len(rtpPacket.Payload) < 2 {
+		return rtpPacket
 	}
+	originalSeqNum := int(rtpPacket.Payload[0])<<8 | int(rtpPacket.Payload[1])
+	payload := rtpPacket.Payload[2:]
+	header := rtp.NewHeader(MjpegType, originalSeqNum, rtpPacket.Header.Timestamp)
+	return rtp.NewPacket(header, len(payload), payload)
+}
 
+// SetStream sets name of the received stream which labels metrics, receiver of the server uses stream of the session
+func (r *RtpReceiver) SetStream(stream string) {
+	r.stream = stream
+}
+
+func (r *RtpReceiver) SetStartTime(startTime int64) {
+	r.startTime = startTime
+}
+
+// parsePackets returns media packets delivered by the datagram, retransmissions are restored and parity packets
+// may deliver recovered packets, copies of already received packets are dropped, the packets don't refer
+// to the datagram which is reused by the next read
+func (r *RtpReceiver) parsePackets(datagram []byte, arrival time.Time) []*rtp.Packet {
+	if len(datagram) < rtp.HeaderSize {
+		return nil
+	}
+	var packetBytes []byte
+	if r.srtpContext != nil {
+		var err error
+		// decrypted packet is a copy
+		packetBytes, err = r.srtpContext.DecryptRtp(datagram)
+		if err != nil {
+			r.logger.Error("dropped packet", logging.ErrorKey, err)
+			return nil
+		}
+	} else {
+		packetBytes = append([]byte(nil), datagram...)
+	}
+	rtpPacket, err := rtp.NewPacketFromBytes(packetBytes, len(packetBytes))
 	if err != nil {
-		log.Println("[RTP] error while reading packet:", err)
+		r.logger.Error("invalid packet", logging.ErrorKey, err)
+		return nil
 	}
-	buf = buf[:packetLength]
-
-	rtpPacket := rtp.NewPacketFromBytes(buf, packetLength)
-	rtpPacket.Header.Log()
 
 	//current unix time in milliseconds
 	currentTime := time.Now().UnixNano() / int64(time.Millisecond)
 	r.totalPlayTime += currentTime - r.startTime
 	r.startTime = currentTime
 
-	r.recvPacketsNum++
-	if rtpPacket.Header.SequenceNumber > r.highestRecvSeqNum {
-		r.highestRecvSeqNum = rtpPacket.Header.SequenceNumber
+	rtpPacket.Header.Log(r.logger)
+	if SimulcastLayer(rtpPacket.Header.Ssrc) > 0 {
+		// lower simulcast layers are best effort, loss recovery and statistics cover the primary layer
+		return []*rtp.Packet{rtpPacket}
+	}
+	var result []*rtp.Packet
+	if rtpPacket.Header.PayloadType == FecType {
+		result = r.fecDecoder.OnFecPacket(rtpPacket)
+	} else {
+		retransmission := rtpPacket.Header.PayloadType == RtxType
+		if !retransmission {
+			r.updateDelayGradient(rtpPacket.Header.Timestamp, arrival)
+		}
+		rtpPacket = restoreRetransmission(rtpPacket)
+		if retransmission {
+			r.logger.Debug("retransmission received", "sequenceNumber", rtpPacket.Header.SequenceNumber)
+		}
+		result = append([]*rtp.Packet{rtpPacket}, r.fecDecoder.OnMediaPacket(rtpPacket)...)
+	}
+
+	r.statsMutex.Lock()
+	defer r.statsMutex.Unlock()
+	delivered := result[:0]
+	for _, packet := range result {
+		if !r.receivedSeqNums.add(uint16(packet.Header.SequenceNumber)) {
+			r.logger.Debug("duplicated packet dropped", "sequenceNumber", packet.Header.SequenceNumber)
+			continue
+		}
+		delivered = append(delivered, packet)
+		if r.nackGenerator != nil {
+			r.nackGenerator.OnPacket(packet.Header.SequenceNumber, packet.Header.Ssrc)
+		}
+		r.recvPacketsNum++
+		if packet.Header.SequenceNumber > r.highestRecvSeqNum {
+			r.highestRecvSeqNum = packet.Header.SequenceNumber
+		}
+		r.cumulativeLost = r.highestRecvSeqNum - r.recvPacketsNum
+	}
+	return delivered
+}
+
+// updateDelayGradient compares inter-arrival time with inter-departure time carried by 90 kHz timestamps,
+// positive gradient means that packets queue up on the path, jitter is calculated as in RFC 3550
+func (r *RtpReceiver) updateDelayGradient(timestamp int, arrival time.Time) {
+	r.statsMutex.Lock()
+	defer r.statsMutex.Unlock()
+	if !r.lastArrivalTime.IsZero() {
+		departureDelta := time.Duration(int32(uint32(timestamp)-uint32(r.lastTimestamp))) * time.Second / rtpClockRate
+		if departureDelta >= 0 {
+			gradient := float64((arrival.Sub(r.lastArrivalTime) - departureDelta) / time.Microsecond)
+			r.delayGradient += delayGradientSmoothing * (gradient - r.delayGradient)
+			r.jitter += (math.Abs(gradient) - r.jitter) / 16
+		}
+	}
+	if r.lastArrivalTime.IsZero() || int32(uint32(timestamp)-uint32(r.lastTimestamp)) >= 0 {
+		r.lastArrivalTime = arrival
+		r.lastTimestamp = timestamp
 	}
-	r.cumulativeLost = r.highestRecvSeqNum - r.recvPacketsNum
+}
 
-	dataRate := 0.0
-	if r.totalPlayTime != 0 {
-		dataRate = float64(r.totalBytes) / (float64(r.totalPlayTime) / 1000)
+// receptionStats returns statistics of the primary layer, they are updated by the receiving goroutine
+func (r *RtpReceiver) receptionStats() receptionStats {
+	r.statsMutex.Lock()
+	defer r.statsMutex.Unlock()
+	return receptionStats{
+		highestRecvSeqNum: r.highestRecvSeqNum,
+		cumulativeLost:    r.cumulativeLost,
+		totalBytes:        r.totalBytes,
+		delayGradient:     r.delayGradient,
+		jitter:            r.jitter,
 	}
-	r.totalBytes += len(rtpPacket.Payload)
+}
 
-	r.view.UpdateStatistics(r.totalBytes, r.cumulativeLost, dataRate)
-	r.frameSync.AddFrame(rtpPacket.Payload, rtpPacket.Header.SequenceNumber)
+// SetTargetBitrate stores bandwidth estimate announced by the sender
+func (r *RtpReceiver) SetTargetBitrate(targetBitrate int) {
+	atomic.StoreInt64(&r.targetBitrate, int64(targetBitrate))
 }
 
-func (r *RtpReceiver) receiveAndForward() {
-	log.Println("[RTP] received and forwarded rtp packet")
-	buf := make([]byte, 65507)
-	packetLength, _, err := r.udpCon.ReadFrom(buf)
+// SetSourceResolution stores resolution announced in SDP, frames may be sent downscaled under congestion
+func (r *RtpReceiver) SetSourceResolution(width int, height int) {
+	r.resolutionMutex.Lock()
+	defer r.resolutionMutex.Unlock()
+	r.sourceResolution = image.Point{X: width, Y: height}
+	r.lastResolution = image.Point{}
+}
 
-	if packetLength == 0 {
+func (r *RtpReceiver) updateResolution(frame []byte) {
+	if r.view == nil {
 		return
 	}
-
+	resolution, err := video.FrameResolution(frame)
 	if err != nil {
-		log.Println("[RTP] error while reading packet:", err)
+		return
 	}
-	buf = buf[:packetLength]
-
-	rtpPacket := rtp.NewPacketFromBytes(buf, packetLength)
-	rtpPacket.Header.Log()
+	r.resolutionMutex.Lock()
+	defer r.resolutionMutex.Unlock()
+	if resolution == r.lastResolution {
+		return
+	}
+	r.lastResolution = resolution
+	sourceResolution := r.sourceResolution
+	if sourceResolution == (image.Point{}) {
+		sourceResolution = resolution
+	}
+	r.logger.Info("frame resolution changed", "width", resolution.X, "height", resolution.Y)
+	r.view.UpdateResolution(resolution.X, resolution.Y, sourceResolution.X, sourceResolution.Y)
+}
 
-	//current unix time in milliseconds
-	currentTime := time.Now().UnixNano() / int64(time.Millisecond)
-	r.totalPlayTime += currentTime - r.startTime
-	r.startTime = currentTime
+func (r *RtpReceiver) receive(datagram []byte, _ net.Addr, arrival time.Time) {
+	for _, rtpPacket := range r.parsePackets(datagram, arrival) {
+		r.statsMutex.Lock()
+		dataRate := 0.0
+		if r.totalPlayTime != 0 {
+			dataRate = float64(r.totalBytes) / (float64(r.totalPlayTime) / 1000)
+		}
+		r.totalBytes += len(rtpPacket.Payload)
+		totalBytes, cumulativeLost := r.totalBytes, r.cumulativeLost
+		r.statsMutex.Unlock()
 
-	r.recvPacketsNum++
-	if rtpPacket.Header.SequenceNumber > r.highestRecvSeqNum {
-		r.highestRecvSeqNum = rtpPacket.Header.SequenceNumber
+		if r.view != nil {
+			r.view.UpdateStatistics(totalBytes, cumulativeLost, dataRate, int(atomic.LoadInt64(&r.targetBitrate)))
+		}
+		rtpReceivedPacketsMetric.Inc(r.stream)
+		rtpReceivedBytesMetric.Add(float64(len(rtpPacket.Payload)), r.stream)
+		r.updateResolution(rtpPacket.Payload)
+		if !r.frameSync.AddFrame(rtpPacket.Payload, rtpPacket.Header.SequenceNumber) {
+			droppedFramesMetric.Inc(lateFrameReason)
+		}
+		if r.recorder != nil {
+			r.recorder.Feed(rtpPacket)
+		}
 	}
-	r.cumulativeLost = r.highestRecvSeqNum - r.recvPacketsNum
+}
 
-	r.totalBytes += len(rtpPacket.Payload)
-	r.server.mainChannel <- rtpPacket
+func (r *RtpReceiver) receiveAndForward(datagram []byte, _ net.Addr, arrival time.Time) {
+	for _, rtpPacket := range r.parsePackets(datagram, arrival) {
+		r.statsMutex.Lock()
+		r.totalBytes += len(rtpPacket.Payload)
+		r.statsMutex.Unlock()
+		rtpReceivedPacketsMetric.Inc(r.server.videoFileName)
+		rtpReceivedBytesMetric.Add(float64(len(rtpPacket.Payload)), r.server.videoFileName)
+		r.server.mainChannel <- &StreamPacket{
+			Path:   r.server.videoFileName,
+			Packet: rtpPacket,
+			Layer:  SimulcastLayer(rtpPacket.Header.Ssrc),
+		}
+	}
 }
 
-func (r *RtpReceiver) Start() {
+// Start receives the stream until Stop is called or ctx is done, closed receiver can't be started
+func (r *RtpReceiver) Start(ctx context.Context) error {
+	if r.closed {
+		return errors.New("rtp receiver is closed")
+	}
+	if r.started {
+		return nil
+	}
+	if r.nackGenerator != nil {
+		r.nackGenerator.Start()
+	}
 	r.started = true
-	r.ticker = time.NewTicker(r.interval)
 	r.doneCheck = make(chan bool)
+	err := r.reader.reset()
+	if err != nil {
+		r.logger.Error("error while resetting read deadline", logging.ErrorKey, err)
+	}
 
-	go func() {
-		for {
-			select {
-			case <-r.doneCheck:
-				return
-			case <-r.ticker.C:
-				if r.server != nil {
-					r.receiveAndForward()
-				} else {
-					r.receive()
-				}
-			}
+	receive := r.receive
+	if r.server != nil {
+		receive = r.receiveAndForward
+	}
+	r.running.Add(1)
+	doneCheck := r.doneCheck
+	r.routines.Go("rtp receiver", func() {
+		defer r.running.Done()
+		err := r.reader.run(ctx, doneCheck, receive)
+		if err != nil {
+			r.logger.Error("error while reading packet", logging.ErrorKey, err)
 		}
-	}()
+	})
+	return nil
 }
 
 func (r *RtpReceiver) Stop() {
+	if r.nackGenerator != nil {
+		r.nackGenerator.Stop()
+	}
 	if r.started {
 		close(r.doneCheck)
-		r.ticker.Stop()
+		// pending read is interrupted, so receiving goroutine doesn't outlive the session state it updates
+		err := r.reader.interrupt()
+		if err != nil {
+			r.logger.Error("error while interrupting read", logging.ErrorKey, err)
+		}
+		r.running.Wait()
 		r.started = false
 	}
 }
 
+// Close may be called repeatedly, the connection is closed once
 func (r *RtpReceiver) Close() {
 	r.Stop()
+	if r.closed {
+		return
+	}
+	r.closed = true
 	err := r.udpCon.Close()
 	if err != nil {
-		log.Println("[RTP] error while closing connection:", err)
+		r.logger.Error("error while closing connection", logging.ErrorKey, err)
 	}
 }
@@ -0,0 +1,137 @@
+package components
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"streming_server/protocol/rtp"
+	"streming_server/video"
+	"testing"
+	"time"
+)
+
+// sentPackets stores packets with the sequence numbers in history of the sender, so they can be retransmitted
+func sentPackets(sender *RtpSender, seqNums ...int) []*rtp.Packet {
+	result := make([]*rtp.Packet, 0, len(seqNums))
+	for _, seqNum := range seqNums {
+		payload := []byte{byte(seqNum >> 8), byte(seqNum), 0xFF, 0xD8}
+		packet := rtp.NewPacket(rtp.NewHeader(MjpegType, seqNum, 3000*seqNum), len(payload), payload)
+		sender.storeInHistory(packet)
+		result = append(result, packet)
+	}
+	return result
+}
+
+func TestRetransmissionIsRestored(t *testing.T) {
+	sender := &RtpSender{}
+	original := sentPackets(sender, 0x1234)[0]
+	rtxPacket := sender.prepareRetransmission(0x1234, 100*time.Millisecond)
+	if rtxPacket == nil {
+		t.Fatal("sent packet isn't retransmitted")
+	}
+	if rtxPacket.Header.PayloadType != RtxType || rtxPacket.Header.Ssrc != RtxSsrc {
+		t.Errorf("retransmission has payload type %v and ssrc %v", rtxPacket.Header.PayloadType,
+			rtxPacket.Header.Ssrc)
+	}
+	if sender.prepareRetransmission(0x1234, 100*time.Millisecond) != nil {
+		t.Error("packet retransmitted again before round trip time passed")
+	}
+
+	packetBytes := rtxPacket.TransformToBytes()
+	received, err := rtp.NewPacketFromBytes(packetBytes, len(packetBytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := restoreRetransmission(received)
+	if restored.Header.PayloadType != MjpegType || restored.Header.SequenceNumber != 0x1234 ||
+		restored.Header.Timestamp != original.Header.Timestamp || !bytes.Equal(restored.Payload, original.Payload) {
+		t.Errorf("packet %+v %v restored as %+v %v", original.Header, original.Payload, restored.Header,
+			restored.Payload)
+	}
+}
+
+func TestDuplicatedPacketsAreDropped(t *testing.T) {
+	frameSync := video.NewFrameSync()
+	receiver, err := NewRtpReceiver(context.Background(), frameSync, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Close()
+	sender := &RtpSender{}
+	packets := sentPackets(sender, 1, 2, 3, 4, 5)
+	retransmission := func(seqNum int) *rtp.Packet {
+		return sender.prepareRetransmission(seqNum, 0)
+	}
+	fecEncoder := NewFecEncoder(2)
+	fecEncoder.Protect(packets[3])
+	parity := fecEncoder.Protect(packets[4])
+
+	steps := []struct {
+		description string
+		packet      *rtp.Packet
+		delivered   []int
+	}{
+		{"first packet", packets[0], []int{1}},
+		{"packet after gap", packets[2], []int{3}},
+		{"retransmission of lost packet", retransmission(2), []int{2}},
+		{"late original of retransmitted packet", packets[1], []int{}},
+		{"packet duplicated by network", packets[2], []int{}},
+		{"packet of protected group", packets[4], []int{5}},
+		{"parity of group with lost packet", parity, []int{4}},
+		{"retransmission of recovered packet", retransmission(4), []int{}},
+	}
+	for _, step := range steps {
+		delivered := make([]int, 0)
+		for _, packet := range receiver.parsePackets(step.packet.TransformToBytes(), time.Now()) {
+			delivered = append(delivered, packet.Header.SequenceNumber)
+			frameSync.AddFrame(packet.Payload, packet.Header.SequenceNumber)
+		}
+		if !reflect.DeepEqual(delivered, step.delivered) {
+			t.Errorf("%v delivered %v, expected %v", step.description, delivered, step.delivered)
+		}
+	}
+
+	if stats := receiver.receptionStats(); stats.cumulativeLost != 0 || receiver.recvPacketsNum != len(packets) {
+		t.Errorf("%v packets received and %v lost, expected %v and none", receiver.recvPacketsNum,
+			stats.cumulativeLost, len(packets))
+	}
+	for index, packet := range packets {
+		if frame := frameSync.NextFrame(); !bytes.Equal(frame, packet.Payload) {
+			t.Fatalf("frame no. %v played as %v, expected %v", index+1, frame, packet.Payload)
+		}
+	}
+	if !frameSync.Empty() {
+		t.Errorf("%v frames played more than once", frameSync.Len())
+	}
+}
+
+func TestReceivedWindow(t *testing.T) {
+	var window receivedWindow
+	steps := []struct {
+		seqNum uint16
+		added  bool
+	}{
+		{65534, true},
+		{65534, false},
+		// numbering wraps
+		{2, true},
+		{0, true},
+		{65535, true},
+		{0, false},
+		{1, true},
+		{2, false},
+		// number skipped over after long jump is missing, the window is reused
+		{2 + receivedWindowSize, true},
+		{2 + receivedWindowSize/2, true},
+		{2 + receivedWindowSize/2, false},
+		// number older than the window starts new numbering of re-published stream
+		{1, true},
+		{1, false},
+		{2, true},
+	}
+	for index, step := range steps {
+		if added := window.add(step.seqNum); added != step.added {
+			t.Fatalf("step %v: number %v added %v, expected %v", index, step.seqNum, added, step.added)
+		}
+	}
+}
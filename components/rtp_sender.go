@@ -1,94 +1,318 @@
 package components
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
 	"net"
+	"strconv"
+	"streming_server/logging"
+	"streming_server/protocol/rtcp"
 	"streming_server/protocol/rtp"
+	"streming_server/protocol/srtp"
 	"streming_server/video"
-	"strings"
+	"sync"
 	"time"
 )
 
 const MjpegType = 26
 const DefaultInterval = 10
 
+// timestamps of MJPEG payload use 90 kHz clock (RFC 2435)
+const rtpClockRate = 90000
+
+// retransmissions are sent as separate RTX stream (RFC 4588)
+const RtxType = 97
+const RtxSsrc = 10000
+
+// RetransmissionDeadline is the maximal delay after which retransmitted packet is still useful for the receiver
+const RetransmissionDeadline = 500 * time.Millisecond
+const rtpHistorySize = 256
+
+type sentPacket struct {
+	packet          *rtp.Packet
+	sentAt          time.Time
+	retransmittedAt time.Time
+}
+
 type RtpSender struct {
 	rtcpReceiver         *RtcpReceiver
 	congestionController *CongestionController
 	frameSync            *video.FrameSync
+	fecEncoder           *FecEncoder
 	ticker               *time.Ticker
 	clientConnection     *net.UDPConn
+	srtpContext          *srtp.Context
+	logger               *logging.Logger
+	payloadType          int
+	stream               string
 	interval             time.Duration
+	history              [rtpHistorySize]*sentPacket
+	historyMutex         sync.Mutex
+	seqNum               int
+	rtxSeqNum            int
+	frameInterval        time.Duration
+	lastFrameSentAt      time.Time
+	startTime            time.Time
+	packetCount          uint32
+	octetCount           uint32
+	sentPackets          int64
+	sentBytes            int64
 	doneCheck            chan bool
+	routines             *routineGroup
+	running              sync.WaitGroup
 	started              bool
+	closed               bool
 }
 
+// NewRtpSender connects to RTP port of the client, the address of the client is taken from its RTSP connection
 func NewRtpSender(
-	clientAddress net.Addr, destinationPort int, congestionController *CongestionController,
+	ctx context.Context, clientAddress net.Addr, destinationPort int, congestionController *CongestionController,
 	rtcpReceiver *RtcpReceiver, frameSync *video.FrameSync,
-) *RtpSender {
+) (*RtpSender, error) {
 
-	addressAndPort := strings.Split(clientAddress.String(), ":")
-	address, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%v:%v", addressAndPort[0],
-		destinationPort))
+	host, _, err := net.SplitHostPort(clientAddress.String())
 	if err != nil {
-		log.Fatalln("[RTP] cannot resolve address:", err)
+		return nil, err
 	}
-	clientConnection, err := net.DialUDP("udp", nil, address)
+	var dialer net.Dialer
+	connection, err := dialer.DialContext(ctx, "udp", net.JoinHostPort(host, strconv.Itoa(destinationPort)))
 	if err != nil {
-		log.Fatalln("[RTP] cannot resolve rtp connection:", err)
+		return nil, fmt.Errorf("cannot connect to rtp port of the client: %v", err)
 	}
+	clientConnection := connection.(*net.UDPConn)
 
 	result := RtpSender{
 		rtcpReceiver:         rtcpReceiver,
 		congestionController: congestionController,
 		frameSync:            frameSync,
+		fecEncoder:           NewFecEncoder(0),
+		payloadType:          MjpegType,
 		interval:             time.Duration(DefaultInterval) * time.Millisecond,
+		startTime:            time.Now(),
 		clientConnection:     clientConnection,
+		logger:               rtpLogger,
 		started:              false,
 	}
 
-	return &result
+	return &result, nil
+}
+
+// SetLogger attaches fields of the session to messages of the sender
+func (s *RtpSender) SetLogger(logger *logging.Logger) {
+	s.logger = logger.With(logging.ComponentKey, "RTP")
+}
+
+// SetFrameInterval sets minimal distance between sent frames, frames coming more often are dropped
+// so they don't delay following ones
+func (s *RtpSender) SetFrameInterval(frameInterval time.Duration) {
+	s.historyMutex.Lock()
+	defer s.historyMutex.Unlock()
+	s.frameInterval = frameInterval
+}
+
+// SetPayloadType sets payload type of sent media announced by DESCRIBE, MjpegType is used by default
+func (s *RtpSender) SetPayloadType(payloadType int) {
+	s.payloadType = payloadType
+}
+
+// SetStream sets name of the sent stream which labels metrics
+func (s *RtpSender) SetStream(stream string) {
+	s.stream = stream
+}
+
+// SetSrtpContext protects sent packets with SRTP, the context is shared with rtcp receiver of the session
+func (s *RtpSender) SetSrtpContext(srtpContext *srtp.Context) {
+	s.srtpContext = srtpContext
+}
+
+// write sends packet to the client, protected by SRTP when the context is set
+func (s *RtpSender) write(packet []byte) error {
+	var err error
+	if s.srtpContext != nil {
+		packet, err = s.srtpContext.EncryptRtp(packet)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = s.clientConnection.Write(packet)
+	return err
+}
+
+// rtpTimestamp returns send time in 90 kHz clock units
+func (s *RtpSender) rtpTimestamp(moment time.Time) int {
+	return int(uint32(moment.Sub(s.startTime) * rtpClockRate / time.Second))
 }
 
 func (s *RtpSender) sendFrame() {
 	if s.frameSync.Empty() {
 		return
 	}
-	data := s.frameSync.NextFrame()
+	layers := s.frameSync.NextLayers()
+	data := layers[0]
+	now := time.Now()
+	s.historyMutex.Lock()
+	// small tolerance keeps frames which came slightly earlier due to ticker jitter
+	dropFrame := now.Sub(s.lastFrameSentAt) < s.frameInterval-s.interval/2
+	s.historyMutex.Unlock()
+	if dropFrame {
+		s.logger.Debug("frame dropped to keep target frame rate")
+		droppedFramesMetric.Inc(frameRateReason)
+		return
+	}
+
 	data = s.congestionController.AdjustCompressionQuality(data, len(data))
+	s.seqNum++
+	timestamp := s.rtpTimestamp(now)
 	rtpPacket := rtp.NewPacket(
-		rtp.NewHeader(
-			MjpegType, s.frameSync.CurrentSeqNum, s.frameSync.CurrentSeqNum*s.frameSync.FramePeriod,
-		),
+		rtp.NewHeader(s.payloadType, s.seqNum&0xFFFF, timestamp),
 		len(data), data,
 	)
-	_, err := s.clientConnection.Write(rtpPacket.TransformToBytes())
+	err := s.write(rtpPacket.TransformToBytes())
 	if err != nil {
-		log.Println("[RTP] error while sending packet:", err)
+		s.logger.Error("error while sending packet", logging.ErrorKey, err)
 		return
 	}
-	log.Printf("Sent frame no. %v with size %v", s.frameSync.CurrentSeqNum, len(data))
-	rtpPacket.Header.Log()
+	s.storeInHistory(rtpPacket)
+	s.logger.Trace("frame sent", "sequenceNumber", s.seqNum, "size", len(data))
+	rtpPacket.Header.Log(s.logger)
+
+	if fecPacket := s.fecEncoder.Protect(rtpPacket); fecPacket != nil {
+		err = s.write(fecPacket.TransformToBytes())
+		if err != nil {
+			s.logger.Error("error while sending parity packet", logging.ErrorKey, err)
+		}
+	}
+	s.sendSimulcastLayers(layers[1:], timestamp)
+}
+
+// sendSimulcastLayers sends lower layers of the frame, every layer has its own SSRC but shares sequence number
+// and timestamp with the primary layer, so receivers can switch between layers at frame boundaries,
+// lower layers are neither retransmitted nor protected by FEC
+func (s *RtpSender) sendSimulcastLayers(layers [][]byte, timestamp int) {
+	for index, data := range layers {
+		header := rtp.NewHeader(s.payloadType, s.seqNum&0xFFFF, timestamp)
+		header.Ssrc = SimulcastSsrc(index + 1)
+		err := s.write(rtp.NewPacket(header, len(data), data).TransformToBytes())
+		if err != nil {
+			s.logger.Error("error while sending simulcast layer", logging.ErrorKey, err)
+			return
+		}
+	}
 }
 
-func (s *RtpSender) Start() {
-	s.rtcpReceiver.Start()
+func (s *RtpSender) storeInHistory(rtpPacket *rtp.Packet) {
+	s.historyMutex.Lock()
+	defer s.historyMutex.Unlock()
+	s.history[rtpPacket.Header.SequenceNumber%rtpHistorySize] = &sentPacket{
+		packet: rtpPacket,
+		sentAt: time.Now(),
+	}
+	s.lastFrameSentAt = time.Now()
+	s.packetCount++
+	s.octetCount += uint32(len(rtpPacket.Payload))
+	s.sentPackets++
+	s.sentBytes += int64(len(rtpPacket.Payload))
+	rtpSentPacketsMetric.Inc(s.stream)
+	rtpSentBytesMetric.Add(float64(len(rtpPacket.Payload)), s.stream)
+}
+
+// SentStats returns number of media packets and payload bytes sent so far, retransmissions aren't counted
+func (s *RtpSender) SentStats() (int64, int64) {
+	s.historyMutex.Lock()
+	defer s.historyMutex.Unlock()
+	return s.sentPackets, s.sentBytes
+}
+
+// Retransmit resends requested packets which can still reach the receiver before the deadline
+func (s *RtpSender) Retransmit(lostSeqNums []int) {
+	roundTripTime := s.rtcpReceiver.RoundTripTime()
+	for _, seqNum := range lostSeqNums {
+		rtxPacket := s.prepareRetransmission(seqNum, roundTripTime)
+		if rtxPacket == nil {
+			continue
+		}
+		err := s.write(rtxPacket.TransformToBytes())
+		if err != nil {
+			s.logger.Error("error while sending retransmission", logging.ErrorKey, err)
+			return
+		}
+		s.logger.Debug("packet retransmitted", "sequenceNumber", seqNum)
+	}
+}
+
+func (s *RtpSender) prepareRetransmission(seqNum int, roundTripTime time.Duration) *rtp.Packet {
+	s.historyMutex.Lock()
+	defer s.historyMutex.Unlock()
+
+	entry := s.history[seqNum%rtpHistorySize]
+	if entry == nil || entry.packet.Header.SequenceNumber&0xFFFF != seqNum {
+		return nil
+	}
+	now := time.Now()
+	if now.Sub(entry.sentAt)+roundTripTime/2 > RetransmissionDeadline {
+		// packet would arrive too late to be played
+		return nil
+	}
+	if !entry.retransmittedAt.IsZero() && now.Sub(entry.retransmittedAt) < roundTripTime {
+		// previous retransmission is still on the way
+		return nil
+	}
+	entry.retransmittedAt = now
+
+	// RTX payload starts with original sequence number
+	payload := make([]byte, 2, 2+len(entry.packet.Payload))
+	payload[0] = byte(seqNum >> 8)
+	payload[1] = byte(seqNum & 0xFF)
+	payload = append(payload, entry.packet.Payload...)
+
+	s.rtxSeqNum++
+	header := rtp.NewHeader(RtxType, s.rtxSeqNum, entry.packet.Header.Timestamp)
+	header.Ssrc = RtxSsrc
+	return rtp.NewPacket(header, len(payload), payload)
+}
+
+func (s *RtpSender) senderReport() *rtcp.SenderReport {
+	s.historyMutex.Lock()
+	defer s.historyMutex.Unlock()
+	now := time.Now()
+	report := rtcp.NewSenderReport(rtp.DefaultSsrc, now, uint32(s.rtpTimestamp(now)), s.packetCount, s.octetCount)
+	report.TargetBitrate = uint32(s.congestionController.TargetBitrate())
+	return report
+}
+
+// Start sends frames and receives feedback until Stop is called or ctx is done, closed sender can't be started
+func (s *RtpSender) Start(ctx context.Context) error {
+	if s.closed {
+		return errors.New("rtp sender is closed")
+	}
+	if s.started {
+		return nil
+	}
+	err := s.rtcpReceiver.Start(ctx)
+	if err != nil {
+		return err
+	}
 	s.started = true
 	s.ticker = time.NewTicker(s.interval)
 	s.doneCheck = make(chan bool)
+	s.running.Add(1)
 
-	go func() {
+	ticker, doneCheck := s.ticker, s.doneCheck
+	s.routines.Go("rtp sender", func() {
+		defer s.running.Done()
 		for {
 			select {
-			case <-s.doneCheck:
+			case <-doneCheck:
 				return
-			case <-s.ticker.C:
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
 				s.sendFrame()
 			}
 		}
-	}()
+	})
+	return nil
 }
 
 func (s *RtpSender) Stop() {
@@ -97,20 +321,21 @@ func (s *RtpSender) Stop() {
 		s.started = false
 		close(s.doneCheck)
 		s.ticker.Stop()
+		// frame which is being sent mustn't overlap with the next start
+		s.running.Wait()
 	}
 }
 
+// Close may be called repeatedly, the connection is closed once
 func (s *RtpSender) Close() {
 	s.Stop()
 	s.rtcpReceiver.Close()
+	if s.closed {
+		return
+	}
+	s.closed = true
 	err := s.clientConnection.Close()
 	if err != nil {
-		log.Println("[RTP] error while closing connection:", err)
+		s.logger.Error("error while closing connection", logging.ErrorKey, err)
 	}
 }
-
-func (s *RtpSender) UpdateInterval(newInterval time.Duration) {
-	s.ticker.Stop()
-	s.interval = newInterval
-	s.Start()
-}
@@ -0,0 +1,216 @@
+package components
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"streming_server/logging"
+	"streming_server/metrics"
+	"streming_server/protocol/rtp"
+	"streming_server/protocol/rtsp/state"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultShutdownTimeout limits how long Close waits for sessions to release their resources
+const DefaultShutdownTimeout = 5 * time.Second
+
+// RtspListener accepts RTSP sessions and fans packets published to the mount points out to playing sessions
+// and stream consumers
+type RtspListener struct {
+	listeners       []net.Listener
+	mainChannel     chan *StreamPacket
+	sessions        *SessionManager
+	simulcastLayers *SimulcastLayers
+	consumers       []StreamConsumer
+	configure       func(srv *RtspServer)
+	removeCollector func()
+	ctx             context.Context
+	cancel          context.CancelFunc
+	routines        *routineGroup
+	doneCheck       chan bool
+	closed          int32
+	shutdown        int32
+}
+
+// NewRtspListener opens listening socket, configure is called for every accepted session before it starts
+func NewRtspListener(address string, consumers []StreamConsumer, configure func(srv *RtspServer)) (*RtspListener, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return newRtspListener(listener, consumers, configure), nil
+}
+
+// NewSecureRtspListener accepts RTSPS sessions, their media is protected by SRTP with keys exchanged over TLS
+func NewSecureRtspListener(address string, tlsConfig *tls.Config, consumers []StreamConsumer,
+	configure func(srv *RtspServer)) (*RtspListener, error) {
+	listener, err := tls.Listen("tcp", address, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return newRtspListener(listener, consumers, configure), nil
+}
+
+func newRtspListener(listener net.Listener, consumers []StreamConsumer, configure func(srv *RtspServer)) *RtspListener {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &RtspListener{
+		listeners:       []net.Listener{listener},
+		mainChannel:     make(chan *StreamPacket),
+		sessions:        NewSessionManager(),
+		simulcastLayers: NewSimulcastLayers(),
+		consumers:       consumers,
+		configure:       configure,
+		ctx:             ctx,
+		cancel:          cancel,
+		routines:        newRoutineGroup(),
+		doneCheck:       make(chan bool),
+	}
+}
+
+// Listen opens another listening socket sharing mount points of the listener, RTSPS socket when tlsConfig is set,
+// it has to be called before Start
+func (l *RtspListener) Listen(address string, tlsConfig *tls.Config) error {
+	var listener net.Listener
+	var err error
+	if tlsConfig != nil {
+		listener, err = tls.Listen("tcp", address, tlsConfig)
+	} else {
+		listener, err = net.Listen("tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+	l.listeners = append(l.listeners, listener)
+	return nil
+}
+
+// Address returns address of the socket opened by the constructor
+func (l *RtspListener) Address() net.Addr {
+	return l.listeners[0].Addr()
+}
+
+// Publish delivers packet to the mount point as if it was received from recording client
+func (l *RtspListener) Publish(streamPacket *StreamPacket) {
+	l.mainChannel <- streamPacket
+}
+
+// Start accepts sessions on every socket, sessions are counted in metrics until the listener is closed
+func (l *RtspListener) Start() {
+	l.removeCollector = metrics.Default.OnCollect(l.sessions.collectMetrics)
+	go l.runDataDisposer()
+	for _, listener := range l.listeners {
+		listener := listener
+		l.routines.Go("rtsp accept", func() {
+			l.accept(listener)
+		})
+	}
+}
+
+func (l *RtspListener) accept(listener net.Listener) {
+	for {
+		clientConnection, err := listener.Accept()
+		if err != nil {
+			if atomic.LoadInt32(&l.closed) == 1 {
+				return
+			}
+			rtspLogger.Error("error while connecting with client", logging.ErrorKey, err)
+			continue
+		}
+		privateChannel := make(chan *rtp.Packet)
+		srv := NewServer(clientConnection, l.mainChannel, privateChannel)
+		srv.attach(l.ctx, l.routines)
+		l.routines.Go("rtsp session", func() {
+			srv.SetSimulcastLayers(l.simulcastLayers)
+			if l.configure != nil {
+				l.configure(srv)
+			}
+			l.sessions.Add(srv, privateChannel)
+			srv.Start()
+			l.sessions.Remove(srv)
+		})
+	}
+}
+
+func (l *RtspListener) runDataDisposer() {
+	for {
+		select {
+		case streamPacket := <-l.mainChannel:
+			l.simulcastLayers.Feed(streamPacket)
+			// recordings and http outputs use the primary layer only
+			if streamPacket.Layer == 0 {
+				for _, consumer := range l.consumers {
+					consumer.Feed(streamPacket.Path, streamPacket.Packet)
+				}
+			}
+
+			l.sessions.Range(
+				func(srv *RtspServer, privateChan chan *rtp.Packet) bool {
+					if srv.State() == state.Playing && srv.Accepts(streamPacket) {
+						select {
+						case privateChan <- streamPacket.Packet:
+						case <-srv.Done():
+						}
+					}
+					return true
+				},
+			)
+		case <-l.doneCheck:
+			for _, consumer := range l.consumers {
+				consumer.Close()
+			}
+			l.doneCheck <- true
+			return
+		}
+	}
+}
+
+// Sessions returns manager of the sessions accepted by the listener
+func (l *RtspListener) Sessions() *SessionManager {
+	return l.sessions
+}
+
+// closeListeners stops accepting sessions, it is enough to release listener which wasn't started
+func (l *RtspListener) closeListeners() {
+	atomic.StoreInt32(&l.closed, 1)
+	if l.removeCollector != nil {
+		l.removeCollector()
+	}
+	for _, listener := range l.listeners {
+		err := listener.Close()
+		if err != nil {
+			rtspLogger.Error("error while closing listener", logging.ErrorKey, err)
+		}
+	}
+}
+
+// Shutdown stops accepting sessions, ends the running ones with RTCP BYE and waits until their goroutines return
+// or ctx is done, then consumers are closed, so recordings are finalized, goroutines which didn't return
+// are reported in the error, repeated calls return at once
+func (l *RtspListener) Shutdown(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&l.shutdown, 0, 1) {
+		return nil
+	}
+	l.closeListeners()
+	l.cancel()
+	leaked := l.routines.Wait(ctx)
+	// publishing sessions forward packets until they end
+	l.doneCheck <- true
+	<-l.doneCheck
+	if len(leaked) > 0 {
+		return fmt.Errorf("goroutines didn't stop: %v", strings.Join(leaked, ", "))
+	}
+	return nil
+}
+
+// Close shuts the listener down with DefaultShutdownTimeout
+func (l *RtspListener) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultShutdownTimeout)
+	defer cancel()
+	err := l.Shutdown(ctx)
+	if err != nil {
+		rtspLogger.Warn("listener wasn't shut down cleanly", logging.ErrorKey, err)
+	}
+}
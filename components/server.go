@@ -2,82 +2,351 @@ package components
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
-	"log"
+	"image"
 	"net"
-	"os"
 	"strconv"
+	"streming_server/logging"
 	"streming_server/protocol/rtp"
 	"streming_server/protocol/rtsp/message"
 	"streming_server/protocol/rtsp/state"
+	"streming_server/protocol/srtp"
 	"streming_server/util"
 	"streming_server/video"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// shutdownReason is sent in RTCP BYE to receivers of the sessions ended by the server
+const shutdownReason = "session ended by server"
+
+// StreamPacket is a packet published to the mount point identified by path
+type StreamPacket struct {
+	Path   string
+	Packet *rtp.Packet
+	// simulcast layer, 0 is the primary layer with full resolution
+	Layer int
+}
+
 type RtspServer struct {
 	recvClient           *RtspClient
 	rtpSender            *RtpSender
 	congestionController *CongestionController
 	frameLoader          *FrameLoader
 	frameSync            *video.FrameSync
+	dvrManager           *DvrManager
+	dvrPlayer            *DvrPlayer
+	snapshotCache        *SnapshotCache
+	fecGroupSize         int
+	congestionStrategy   CongestionStrategyFactory
+	transcodeCache       *video.TranscodeCache
+	transcodeStream      *video.StreamTranscodeCache
+	simulcastLayers      *SimulcastLayers
+	layerSelector        *LayerSelector
+	authenticator        *Authenticator
+	mountPoints          *MountPoints
+	payloadType          int
+	framePeriod          time.Duration
+	rtcpInterval         time.Duration
+	congestionInterval   time.Duration
+	nonce                string
+	username             string
+	srtpRequired         bool
+	describedKey         *srtp.MasterKey
 	clientConnection     net.Conn
-	State                state.State
-	mainChannel          chan *rtp.Packet
+	closeOnce            sync.Once
+	ctx                  context.Context
+	cancel               context.CancelFunc
+	routines             *routineGroup
+	mediaOpen            bool
+	startedAt            time.Time
+	state                state.State
+	// guards state, path and components of the session which are read by the fan-out and administration
+	stateMutex           sync.Mutex
+	mainChannel          chan *StreamPacket
 	privateChannel       chan *rtp.Packet
 	clientsideServerPort string
 	videoFileName        string
 	sessionId            string
 	sequentialNumber     int
 	isClientSide         bool
+	logger               atomic.Value
 }
 
-func NewServer(clientConnection net.Conn, mainChannel chan *rtp.Packet, privateChannel chan *rtp.Packet) *RtspServer {
-	log.Println("[RTSP] server started")
-	return &RtspServer{
+func NewServer(clientConnection net.Conn, mainChannel chan *StreamPacket, privateChannel chan *rtp.Packet) *RtspServer {
+	// media of RTSPS sessions has to be protected as well, keys are exchanged over TLS only
+	_, secure := clientConnection.(*tls.Conn)
+	srv := &RtspServer{
 		clientConnection: clientConnection,
 		sessionId:        uuid.New().String(),
-		State:            state.Init,
+		startedAt:        time.Now(),
+		state:            state.Init,
 		mainChannel:      mainChannel,
 		privateChannel:   privateChannel,
+		layerSelector:    NewLayerSelector(),
+		payloadType:      MjpegType,
+		srtpRequired:     secure,
 		isClientSide:     false,
 	}
+	srv.ctx, srv.cancel = context.WithCancel(context.Background())
+	srv.logger.Store(rtspLogger.With(logging.SessionKey, srv.sessionId,
+		logging.RemoteAddressKey, clientConnection.RemoteAddr().String()))
+	srv.log().Info("session started")
+	return srv
 }
 
-// used when client is currently streaming video
-func NewClientsideServer(port int) *RtspServer {
-	log.Println("[RTSP] clientside server started")
-	listener, err := net.Listen("tcp", fmt.Sprint(":", port))
+// ListenClientside opens port on which streaming client waits for the server
+func ListenClientside(ctx context.Context, port int) (net.Listener, error) {
+	var listenConfig net.ListenConfig
+	listener, err := listenConfig.Listen(ctx, "tcp", fmt.Sprint(":", port))
 	if err != nil {
-		log.Fatalln("[RTSP] cannot open connection:", err)
+		return nil, fmt.Errorf("cannot open clientside port: %v", err)
 	}
+	return listener, nil
+}
+
+// used when client is currently streaming video, the listener is closed after the server connects
+// or when ctx is done before
+func NewClientsideServer(ctx context.Context, listener net.Listener) (*RtspServer, error) {
+	rtspLogger.Info("clientside server started", "address", listener.Addr().String())
+	accepted := make(chan bool)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-accepted:
+		}
+		err := listener.Close()
+		if err != nil {
+			rtspLogger.Error("error while closing listener", logging.ErrorKey, err)
+		}
+	}()
 	clientConnection, err := listener.Accept()
+	close(accepted)
 	if err != nil {
-		log.Fatalln("[RTSP] error while connecting with client:", err)
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("server didn't connect: %v", err)
 	}
-	return &RtspServer{
+	srv := &RtspServer{
 		clientConnection: clientConnection,
 		sessionId:        uuid.New().String(),
-		State:            state.Init,
+		state:            state.Init,
+		payloadType:      MjpegType,
 		isClientSide:     false,
+		routines:         newRoutineGroup(),
+	}
+	srv.ctx, srv.cancel = context.WithCancel(context.Background())
+	srv.logger.Store(rtspLogger.With(logging.SessionKey, srv.sessionId,
+		logging.RemoteAddressKey, clientConnection.RemoteAddr().String()))
+	return srv, nil
+}
+
+// attach ends the session once ctx is cancelled and tracks goroutines of the session in the group,
+// it has to be called before Start
+func (srv *RtspServer) attach(ctx context.Context, routines *routineGroup) {
+	srv.cancel()
+	srv.ctx, srv.cancel = context.WithCancel(ctx)
+	srv.routines = routines
+}
+
+// Shutdown ends the session, receivers of its streams get RTCP BYE before the sockets are closed,
+// Start returns once everything is released
+func (srv *RtspServer) Shutdown() {
+	srv.cancel()
+}
+
+// Done is closed when the session ends or is being shut down
+func (srv *RtspServer) Done() <-chan struct{} {
+	return srv.ctx.Done()
+}
+
+// SetDvrManager enables time-shifted playback of the recorded mount points
+func (srv *RtspServer) SetDvrManager(dvrManager *DvrManager) {
+	srv.dvrManager = dvrManager
+}
+
+// SetSnapshotCache enables snapshots of the mount points through GET_PARAMETER request
+func (srv *RtspServer) SetSnapshotCache(snapshotCache *SnapshotCache) {
+	srv.snapshotCache = snapshotCache
+}
+
+// SetFecGroupSize enables forward error correction of sent stream, 0 disables it
+func (srv *RtspServer) SetFecGroupSize(fecGroupSize int) {
+	srv.fecGroupSize = fecGroupSize
+}
+
+// SetCongestionStrategy selects congestion control strategy of sessions, NewBandwidthStrategy is used by default
+func (srv *RtspServer) SetCongestionStrategy(congestionStrategy CongestionStrategyFactory) {
+	srv.congestionStrategy = congestionStrategy
+}
+
+// SetTranscodeCache sets cache which shares frames transcoded under congestion between sessions
+func (srv *RtspServer) SetTranscodeCache(transcodeCache *video.TranscodeCache) {
+	srv.transcodeCache = transcodeCache
+}
+
+// SetAuthenticator requires credentials and permissions to the mount points, nil allows everything to everyone
+func (srv *RtspServer) SetAuthenticator(authenticator *Authenticator) {
+	srv.authenticator = authenticator
+}
+
+// SetSimulcastLayers sets layers published to the mount points, without it only the primary layer is sent
+func (srv *RtspServer) SetSimulcastLayers(simulcastLayers *SimulcastLayers) {
+	srv.simulcastLayers = simulcastLayers
+}
+
+// SetMountPoints restricts sessions to the configured paths, nil allows every path, the mount points may be updated
+// while the session runs
+func (srv *RtspServer) SetMountPoints(mountPoints *MountPoints) {
+	srv.mountPoints = mountPoints
+}
+
+// SetPayloadType sets payload type of the played stream, MjpegType is used by default
+func (srv *RtspServer) SetPayloadType(payloadType int) {
+	srv.payloadType = payloadType
+}
+
+// SetFramePeriod sets frame period which congestion control starts from, 0 keeps video.DefaultFramePeriod
+func (srv *RtspServer) SetFramePeriod(framePeriod time.Duration) {
+	srv.framePeriod = framePeriod
+}
+
+// SetRtcpInterval sets interval of feedback sent to publishers, 0 keeps DefaultRtcpInterval
+func (srv *RtspServer) SetRtcpInterval(rtcpInterval time.Duration) {
+	srv.rtcpInterval = rtcpInterval
+}
+
+// SetCongestionInterval sets how often congestion control of the session updates targets,
+// 0 keeps DefaultCongestionInterval
+func (srv *RtspServer) SetCongestionInterval(congestionInterval time.Duration) {
+	srv.congestionInterval = congestionInterval
+}
+
+// Accepts reports whether packet published to the mount point should be sent to the playing session,
+// single simulcast layer closest to resolution required by congestion control is sent
+func (srv *RtspServer) Accepts(streamPacket *StreamPacket) bool {
+	if srv.Path() != streamPacket.Path {
+		return false
+	}
+	layers := srv.simulcastLayers.Resolutions(streamPacket.Path)
+	targetWidth := 0
+	if len(layers) > 0 {
+		srv.congestionController.SetPrimaryResolution(layers[0])
+		targetWidth = srv.congestionController.Targets().Width
+	}
+	return srv.layerSelector.Accept(streamPacket.Layer, streamPacket.Packet.Header.SequenceNumber,
+		SelectLayer(layers, targetWidth))
+}
+
+// log returns logger with fields of the session, the path is attached once SETUP selects the stream
+func (srv *RtspServer) log() *logging.Logger {
+	return srv.logger.Load().(*logging.Logger)
+}
+
+// Id returns identifier of the session sent in Session header
+func (srv *RtspServer) Id() string {
+	return srv.sessionId
+}
+
+// Info describes the session for administration
+func (srv *RtspServer) Info() SessionInfo {
+	srv.stateMutex.Lock()
+	defer srv.stateMutex.Unlock()
+	transport := "RTP/AVP/UDP"
+	if srv.srtpRequired {
+		transport = "RTP/SAVP/UDP"
+	}
+	result := SessionInfo{
+		Id:            srv.sessionId,
+		Path:          srv.videoFileName,
+		State:         srv.state.String(),
+		RemoteAddress: srv.clientConnection.RemoteAddr().String(),
+		Transport:     transport,
+		Username:      srv.username,
+		StartedAt:     srv.startedAt,
+	}
+	if srv.rtpSender != nil {
+		result.PacketsSent, result.BytesSent = srv.rtpSender.SentStats()
+		result.FractionLost = srv.rtpSender.rtcpReceiver.FractionLost()
+		result.CongestionLevel = srv.rtpSender.rtcpReceiver.CongestionLevel()
+		targets := srv.congestionController.Targets()
+		result.Quality = targets.Quality
+		result.TargetBitrate = targets.Bitrate
+	}
+	if srv.frameSync != nil {
+		result.QueuedFrames = srv.frameSync.Len()
+	}
+	if srv.recvClient != nil {
+		stats := srv.recvClient.rtpReceiver.receptionStats()
+		result.BytesReceived = int64(stats.totalBytes)
+		result.PacketsLost = stats.cumulativeLost
 	}
+	return result
+}
+
+func (srv *RtspServer) State() state.State {
+	srv.stateMutex.Lock()
+	defer srv.stateMutex.Unlock()
+	return srv.state
+}
+
+func (srv *RtspServer) setState(newState state.State) {
+	srv.stateMutex.Lock()
+	defer srv.stateMutex.Unlock()
+	srv.state = newState
+}
+
+// Path returns mount point requested by the client
+func (srv *RtspServer) Path() string {
+	srv.stateMutex.Lock()
+	defer srv.stateMutex.Unlock()
+	return srv.videoFileName
 }
 
 func (srv *RtspServer) SendResponse() {
-	response := util.FormatHeader(srv.sequentialNumber, srv.sessionId)
-	_, err := srv.clientConnection.Write([]byte(response))
+	srv.writeResponse([]byte(util.FormatHeader(srv.sequentialNumber, srv.sessionId)))
+}
+
+func (srv *RtspServer) sendErrorResponse(statusCode int, reason string) {
+	srv.writeResponse([]byte(util.FormatErrorHeader(srv.sequentialNumber, srv.sessionId, statusCode, reason)))
+}
+
+// writeResponse sends response to the client, the connection is closed when it fails, so the session ends
+// with the next read
+func (srv *RtspServer) writeResponse(response []byte) {
+	_, err := srv.clientConnection.Write(response)
 	if err != nil {
-		log.Fatalln("[RTSP] cannot send response:", err)
+		srv.log().Error("cannot send response", logging.ErrorKey, err)
+		srv.CloseConnection()
 	}
-
 }
 
+// Start handles requests until the client disconnects or the session is shut down, then components of the session
+// are released
 func (srv *RtspServer) Start() {
+	ended := make(chan bool)
+	watched := make(chan bool)
+	srv.routines.Go("rtsp session watcher", func() {
+		defer close(watched)
+		select {
+		case <-srv.ctx.Done():
+			// pending read of the next request fails, so the session ends
+			srv.CloseConnection()
+		case <-ended:
+		}
+	})
+
 	// waiting for initial SETUP request
 	for {
 		requestType := srv.ParseRequest()
-		if requestType == message.Setup || srv.State == state.Detached {
+		if requestType == message.Setup || srv.State() == state.Detached {
 			break
 		}
 	}
@@ -85,134 +354,520 @@ func (srv *RtspServer) Start() {
 	// handling further requests
 	for {
 		srv.ParseRequest()
-		if srv.State == state.Detached {
+		if srv.State() == state.Detached {
 			break
 		}
 	}
+
+	close(ended)
+	<-watched
+	srv.release()
+}
+
+// release stops components of the ended session, receivers get RTCP BYE when the server ended the session
+func (srv *RtspServer) release() {
+	if srv.ctx.Err() != nil {
+		if srv.mediaOpen {
+			srv.rtpSender.rtcpReceiver.SendBye(shutdownReason)
+		}
+		if srv.recvClient != nil {
+			srv.recvClient.rtcpSender.SendBye(shutdownReason)
+		}
+	}
+	srv.cancel()
+	srv.closeMedia()
+	if srv.frameLoader != nil {
+		srv.frameLoader.Stop()
+	}
+	if srv.recvClient != nil {
+		srv.recvClient.CloseConnection()
+	}
+	srv.CloseConnection()
+	srv.log().Info("session ended")
+}
+
+// closeMedia stops streams of the SETUP, it is called by TEARDOWN, repeated SETUP and when the session ends
+func (srv *RtspServer) closeMedia() {
+	if !srv.mediaOpen {
+		return
+	}
+	srv.mediaOpen = false
+	srv.stopDvrPlayer()
+	srv.congestionController.Stop()
+	srv.rtpSender.Close()
+	srv.transcodeCache.Release(srv.transcodeStream)
+	srv.transcodeStream = nil
 }
 
 func (srv *RtspServer) ParseRequest() message.Message {
 	bufferedReader := bufio.NewReader(srv.clientConnection)
-	requestElements := util.ReadRequestElements(bufferedReader)
+	requestLines := util.ReadRequestLines(bufferedReader)
+	requestElements := util.RequestElements(requestLines)
 	if len(requestElements) == 0 {
 		// client disconnected
-		srv.State = state.Detached
+		srv.setState(state.Detached)
 		return ""
 	}
 
-	requestType := requestElements[0]
-	seqNumber, err := strconv.Atoi(requestElements[4])
+	requestType, url, seqNumber, err := util.ParseRequestLine(requestElements)
 	if err != nil {
-		log.Fatalln("[RTSP] error while parsing request:", err)
+		srv.log().Error("error while parsing request", logging.ErrorKey, err)
+		srv.sendErrorResponse(400, "Bad Request")
+		return ""
 	}
 	srv.sequentialNumber = seqNumber
-	if requestType == message.Setup {
-		fileName := requestElements[1]
-		ports, err := util.ParseParameter(requestElements[6], "client_port")
-		if err == nil {
-			portAsInt, _ := strconv.Atoi(ports[0])
-			srv.OnSetup(portAsInt)
-			srv.clientsideServerPort = ports[1]
+	body, err := util.ReadRequestBody(bufferedReader, requestElements)
+	if err != nil {
+		srv.log().Error("error while reading request body", logging.ErrorKey, err)
+		srv.setState(state.Detached)
+		return ""
+	}
+	if !srv.authorize(requestType, url, requestLines) {
+		return ""
+	}
+	if !srv.checkMountPoint(requestType, url) {
+		return ""
+	}
 
+	if requestType == message.Setup {
+		ports, err := parseClientPorts(requestElements)
+		if err != nil {
+			srv.log().Error("error while parsing transport", logging.ErrorKey, err)
+			srv.sendErrorResponse(461, "Unsupported Transport")
+			return ""
 		}
-		srv.videoFileName = fileName
-	} else if requestType == message.Record && srv.State == state.Ready {
-		srv.onRecord()
-	} else if requestType == message.Play && srv.State == state.Ready {
-		srv.onPlay()
-	} else if requestType == message.Pause && (srv.State == state.Playing || srv.State == state.Recording) {
+		if srv.srtpRequired && srv.describedKey == nil {
+			srv.log().Warn("SETUP of RTSPS session requires master key announced by DESCRIBE")
+			srv.sendErrorResponse(455, "Method Not Valid in This State")
+			return ""
+		}
+		srv.stateMutex.Lock()
+		srv.videoFileName = srv.streamOf(url)
+		srv.stateMutex.Unlock()
+		srv.logger.Store(srv.log().With(logging.PathKey, srv.videoFileName))
+		srv.OnSetup(ports[0])
+		srv.clientsideServerPort = strconv.Itoa(ports[1])
+	} else if requestType == message.Record && srv.State() == state.Ready {
+		srv.onRecord(body)
+	} else if requestType == message.Play && srv.State() == state.Ready {
+		srv.onPlay(requestElements)
+	} else if requestType == message.Pause && (srv.State() == state.Playing || srv.State() == state.Recording) {
 		srv.OnPause()
 	} else if requestType == message.Teardown {
 		srv.OnTeardown()
 	} else if requestType == message.Describe {
-		srv.OnDescribe()
+		srv.OnDescribe(srv.streamOf(url))
+	} else if requestType == message.GetParameter {
+		srv.onGetParameter(srv.streamOf(url), body)
 	}
 
 	return message.Message(requestType)
 }
 
+// authorize checks credentials of the request and permission of the user to the mount point,
+// 401 with challenges is sent when credentials are missing or invalid and 403 when permission isn't granted,
+// digest nonce is issued once per connection and the user stays authenticated until next credentials fail,
+// requests of set up session act on the path fixed by SETUP, so the permission is checked against it
+// instead of request url
+func (srv *RtspServer) authorize(requestType string, url string, requestLines []string) bool {
+	if srv.authenticator == nil {
+		return true
+	}
+	if srv.nonce == "" {
+		srv.nonce = strings.Replace(uuid.New().String(), "-", "", -1)
+	}
+	authorization, err := util.ParseHeaderLine(requestLines, "Authorization")
+	if err == nil {
+		username, err := srv.authenticator.Authenticate(requestType, url, authorization, srv.nonce)
+		srv.stateMutex.Lock()
+		srv.username = username
+		srv.stateMutex.Unlock()
+		if err != nil {
+			srv.log().Error("authentication failed", logging.ErrorKey, err)
+			srv.sendUnauthorized()
+			return false
+		}
+	}
+
+	if sessionPath := srv.Path(); sessionPath != "" && (requestType == message.Record ||
+		requestType == message.Play || requestType == message.Pause || requestType == message.Teardown) {
+		url = sessionPath
+	}
+	permission := RequiredPermission(requestType)
+	if permission == 0 || srv.authenticator.Allowed(srv.username, url, permission) {
+		return true
+	}
+	if srv.username == "" {
+		srv.sendUnauthorized()
+	} else {
+		srv.log().Warn("request forbidden", "user", srv.username, "method", requestType, "url", url)
+		srv.sendErrorResponse(403, "Forbidden")
+	}
+	return false
+}
+
+// checkMountPoint answers 404 to requests of paths which aren't configured and 405 to RECORD of mount points
+// fed by the server itself
+func (srv *RtspServer) checkMountPoint(requestType string, url string) bool {
+	if srv.mountPoints == nil || !srv.mountPoints.Restricted() || (requestType != message.Describe &&
+		requestType != message.Setup && requestType != message.Record) {
+		return true
+	}
+	if requestType == message.Record {
+		url = srv.Path()
+	}
+	mountPoint := srv.mountPoints.Find(url)
+	if mountPoint == nil {
+		srv.log().Warn("mount point isn't configured", "url", url)
+		srv.sendErrorResponse(404, "Not Found")
+		return false
+	}
+	if requestType == message.Record && mountPoint.Source != nil {
+		srv.log().Warn("mount point is fed by its source and can't be published", "url", url)
+		srv.sendErrorResponse(405, "Method Not Allowed")
+		return false
+	}
+	return true
+}
+
+// streamOf returns name of the stream published to the mount point of request url, the url itself is used
+// when mount points aren't configured
+func (srv *RtspServer) streamOf(url string) string {
+	if srv.mountPoints == nil {
+		return url
+	}
+	if mountPoint := srv.mountPoints.Find(url); mountPoint != nil {
+		return mountPoint.Stream()
+	}
+	return url
+}
+
+func (srv *RtspServer) sendUnauthorized() {
+	response := util.FormatErrorHeader(srv.sequentialNumber, srv.sessionId, 401, "Unauthorized")
+	for _, challenge := range srv.authenticator.Challenges(srv.nonce) {
+		response += fmt.Sprintf("WWW-Authenticate: %v\r\n", challenge)
+	}
+	srv.writeResponse([]byte(response))
+}
+
+// findCryptoAttribute returns master key announced by SDP lines, nil when the stream isn't protected
+func findCryptoAttribute(lines []string) (*srtp.MasterKey, error) {
+	for _, line := range lines {
+		if strings.HasPrefix(line, srtp.CryptoAttribute) {
+			masterKey, err := srtp.ParseCryptoAttribute(strings.TrimPrefix(line, srtp.CryptoAttribute))
+			if err != nil {
+				return nil, err
+			}
+			return &masterKey, nil
+		}
+	}
+	return nil, nil
+}
+
+// newSrtpContext creates context of the key announced in SDP
+func newSrtpContext(masterKey *srtp.MasterKey) (*srtp.Context, error) {
+	srtpContext, err := srtp.NewContext(*masterKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create srtp context: %v", err)
+	}
+	return srtpContext, nil
+}
+
+// parseClientPorts returns RTP port and port of clientside server from Transport header of SETUP request
+func parseClientPorts(requestElements []string) ([2]int, error) {
+	var result [2]int
+	transport, err := util.ParseHeader(requestElements, "Transport")
+	if err != nil {
+		return result, err
+	}
+	ports, err := util.ParseParameter(transport, "client_port")
+	if err != nil {
+		return result, err
+	}
+	if len(ports) != 2 {
+		return result, errors.New("client_port requires pair of ports")
+	}
+	for index, port := range ports {
+		result[index], err = strconv.Atoi(port)
+		if err != nil || result[index] < 0 || result[index] > 65535 {
+			return result, fmt.Errorf("invalid client port %q", port)
+		}
+	}
+	return result, nil
+}
+
+// OnSetup opens streams of the session sent to RTP port of the client, streams of the previous SETUP are closed,
+// 461 is answered when the port can't be reached and 500 when the streams can't be opened
 func (srv *RtspServer) OnSetup(rtpDestinationPort int) {
-	srv.frameSync = video.NewFrameSync()
-	srv.frameLoader = NewFrameLoader(srv.frameSync, srv.privateChannel)
-	rtcpReceiver := NewRtcpReceiver()
-	srv.congestionController = NewCongestionController(rtcpReceiver, srv.frameSync)
-	srv.rtpSender = NewRtpSender(srv.clientConnection.RemoteAddr(), rtpDestinationPort,
-		srv.congestionController, rtcpReceiver, srv.frameSync)
+	srv.closeMedia()
+	if srv.frameLoader != nil {
+		srv.frameLoader.Stop()
+	}
+	var srtpContext *srtp.Context
+	if srv.describedKey != nil {
+		var err error
+		srtpContext, err = newSrtpContext(srv.describedKey)
+		if err != nil {
+			srv.setupFailed(err, 500, "Internal Server Error")
+			return
+		}
+		// every sender gets its own master key, so packet indexes are never reused with the same key
+		srv.describedKey = nil
+	}
+	rtcpReceiver, err := NewRtcpReceiver(srv.ctx)
+	if err != nil {
+		srv.setupFailed(err, 500, "Internal Server Error")
+		return
+	}
+	frameSync := video.NewFrameSync()
+	if srv.framePeriod > 0 {
+		frameSync.FramePeriod = int(srv.framePeriod / time.Millisecond)
+	}
+	congestionStrategy := srv.congestionStrategy
+	if congestionStrategy == nil {
+		congestionStrategy = NewBandwidthStrategy
+	}
+	congestionController := NewCongestionController(rtcpReceiver, frameSync, congestionStrategy)
+	rtpSender, err := NewRtpSender(srv.ctx, srv.clientConnection.RemoteAddr(), rtpDestinationPort,
+		congestionController, rtcpReceiver, frameSync)
+	if err != nil {
+		rtcpReceiver.Close()
+		srv.setupFailed(err, 461, "Unsupported Transport")
+		return
+	}
+
+	srv.frameLoader = NewFrameLoader(frameSync, srv.privateChannel)
+	srv.frameLoader.routines = srv.routines
+	rtcpReceiver.routines = srv.routines
+	congestionController.routines = srv.routines
+	rtpSender.routines = srv.routines
+	srv.stateMutex.Lock()
+	srv.frameSync = frameSync
+	srv.congestionController = congestionController
+	srv.rtpSender = rtpSender
+	srv.stateMutex.Unlock()
 
+	srv.rtpSender.SetPayloadType(srv.payloadType)
+	srv.rtpSender.SetStream(srv.videoFileName)
+	srv.rtpSender.SetLogger(srv.log())
+	rtcpReceiver.SetLogger(srv.log())
+	congestionController.SetLogger(srv.log())
+	if srv.congestionInterval > 0 {
+		srv.congestionController.SetInterval(srv.congestionInterval)
+	}
+
+	rtcpReceiver.SetRtpSender(srv.rtpSender)
+	if srtpContext != nil {
+		srv.rtpSender.SetSrtpContext(srtpContext)
+		rtcpReceiver.SetSrtpContext(srtpContext)
+	}
 	srv.congestionController.SetRtpSender(srv.rtpSender)
+	srv.transcodeStream = srv.transcodeCache.Stream(srv.videoFileName)
+	srv.congestionController.SetTranscodeCache(srv.transcodeStream)
+	srv.congestionController.SetFecGroupSize(srv.fecGroupSize)
 	srv.congestionController.Start()
+	srv.mediaOpen = true
 
-	srv.State = state.Ready
+	srv.setState(state.Ready)
 
-	_, err := srv.clientConnection.Write([]byte(util.PrepareSetupResponse(
-		srv.sequentialNumber, rtcpReceiver.ServerPort, srv.sessionId),
-	))
-	if err != nil {
-		log.Fatalln("[RTSP] error while sending message:", err)
-	}
+	srv.writeResponse([]byte(util.PrepareSetupResponse(srv.sequentialNumber, rtcpReceiver.ServerPort, srv.sessionId)))
 
-	log.Println("[RTSP] State changed: READY")
+	srv.log().Info("state changed", "state", state.State(state.Ready))
+}
+
+// setupFailed answers SETUP which couldn't open streams, streams of the previous SETUP are closed already,
+// so the session returns to Init
+func (srv *RtspServer) setupFailed(err error, statusCode int, reason string) {
+	srv.log().Error("cannot set up streams of the session", logging.ErrorKey, err)
+	if current := srv.State(); current == state.Ready || current == state.Playing {
+		srv.setState(state.Init)
+		srv.log().Info("state changed", "state", state.State(state.Init))
+	}
+	srv.sendErrorResponse(statusCode, reason)
 }
 
-func (srv *RtspServer) onRecord() {
-	if srv.State == state.Ready {
+// onRecord connects back to the clientside server of the publisher, master key of the published stream
+// comes in SDP body of the first RECORD, so it never travels over the unprotected connection back
+func (srv *RtspServer) onRecord(body []byte) {
+	if srv.State() == state.Ready {
+		var recordKey *srtp.MasterKey
+		if srv.recvClient == nil {
+			var err error
+			recordKey, err = findCryptoAttribute(strings.Split(string(body), "\r\n"))
+			if err == nil && recordKey == nil && srv.srtpRequired {
+				err = errors.New("RECORD of RTSPS session without master key")
+			}
+			if err != nil {
+				srv.log().Error("stream can't be published", logging.ErrorKey, err)
+				srv.sendErrorResponse(461, "Unsupported Transport")
+				return
+			}
+		}
+		// the client starts serving the stream only after RECORD is accepted
+		srv.SendResponse()
 		if srv.recvClient == nil {
 			address := strings.Split(srv.clientConnection.RemoteAddr().String(), ":")[0]
-			srv.recvClient = NewServersideClient(srv, address, srv.clientsideServerPort, "livestream")
+			recvClient, err := NewServersideClient(srv.ctx, srv, address, srv.clientsideServerPort, "livestream")
+			if err != nil {
+				// RECORD has been accepted already, the publisher learns about the failure from closed connection
+				srv.log().Error("cannot connect to the publisher", logging.ErrorKey, err)
+				srv.CloseConnection()
+				return
+			}
+			srv.stateMutex.Lock()
+			srv.recvClient = recvClient
+			srv.stateMutex.Unlock()
+			srv.recvClient.srtpKey = recordKey
+			if srv.rtcpInterval > 0 {
+				srv.recvClient.rtcpSender.SetInterval(srv.rtcpInterval)
+			}
 			srv.recvClient.onSetup()
 			srv.isClientSide = true
 		}
 		srv.recvClient.onPlay()
-		srv.SendResponse()
-		srv.State = state.Recording
-		log.Println("[RTSP] State changed: RECORDING")
+		if srv.recvClient.state != state.Playing {
+			srv.log().Error("publisher didn't start the stream")
+			srv.CloseConnection()
+			return
+		}
+		srv.setState(state.Recording)
+		srv.log().Info("state changed", "state", state.State(state.Recording))
 	}
 }
 
-func (srv *RtspServer) onPlay() {
+func (srv *RtspServer) onPlay(requestElements []string) {
+	var dvr *Dvr
+	var startTime time.Time
+	scale := 1.0
+	rangeValue, err := util.ParseHeader(requestElements, "Range")
+	if err == nil && strings.HasPrefix(rangeValue, "clock=") && !srv.isClientSide {
+		startTime, err = util.ParseClockRange(rangeValue)
+		dvr = srv.dvrManager.Get(srv.videoFileName)
+		if err != nil || dvr == nil {
+			srv.sendErrorResponse(457, "Invalid Range")
+			return
+		}
+		scaleValue, err := util.ParseHeader(requestElements, "Scale")
+		if err == nil {
+			scale, _ = strconv.ParseFloat(scaleValue, 64)
+		}
+	}
+
+	if !srv.isClientSide {
+		srv.frameSync.Reset()
+		srv.frameLoader.SetTimeShifted(dvr != nil)
+	}
+	err = srv.rtpSender.Start(srv.ctx)
+	if err != nil {
+		srv.log().Error("cannot start the stream", logging.ErrorKey, err)
+		srv.sendErrorResponse(500, "Internal Server Error")
+		return
+	}
 	srv.SendResponse()
 	if !srv.isClientSide {
 		srv.frameLoader.Start()
+		if dvr != nil {
+			srv.dvrPlayer = NewDvrPlayer(dvr, srv.frameSync, startTime, scale, srv.switchToLive)
+			srv.dvrPlayer.routines = srv.routines
+			srv.dvrPlayer.Start()
+		}
+	}
+	srv.setState(state.Playing)
+	srv.log().Info("state changed", "state", state.State(state.Playing))
+}
+
+// switchToLive is called when time-shifted playback caught up with the live stream
+func (srv *RtspServer) switchToLive() {
+	srv.frameSync.Reset()
+	srv.frameLoader.SetTimeShifted(false)
+	srv.log().With(logging.ComponentKey, "DVR").Info("playback caught up with live stream")
+}
+
+func (srv *RtspServer) stopDvrPlayer() {
+	if srv.dvrPlayer != nil {
+		srv.dvrPlayer.Stop()
+		srv.dvrPlayer = nil
 	}
-	srv.rtpSender.Start()
-	srv.State = state.Playing
-	log.Println("[RTSP] State changed: PLAYING")
 }
 
 func (srv *RtspServer) OnPause() {
-	if srv.State == state.Recording {
+	if srv.State() == state.Recording {
 		srv.recvClient.onPause()
 	} else {
+		srv.stopDvrPlayer()
 		srv.rtpSender.Stop()
 	}
 	srv.SendResponse()
-	srv.State = state.Ready
-	log.Println("[RTSP] State changed: READY")
+	srv.setState(state.Ready)
+	srv.log().Info("state changed", "state", state.State(state.Ready))
 }
 
 func (srv *RtspServer) OnTeardown() {
-	srv.congestionController.Stop()
-	srv.rtpSender.Close()
+	srv.closeMedia()
 	if srv.recvClient != nil {
 		srv.recvClient.onTeardown()
 	}
 	srv.SendResponse()
-	srv.State = state.Init
-	log.Println("[RTSP] State changed: INIT")
+	srv.setState(state.Init)
+	srv.log().Info("state changed", "state", state.State(state.Init))
 }
 
-func (srv *RtspServer) OnDescribe() {
-	_, err := srv.clientConnection.Write([]byte(util.PrepareDescribeResponse(
-		srv.sequentialNumber, os.Args[1], MjpegType, srv.sessionId, srv.videoFileName),
+// OnDescribe sends SDP of the stream, resolution of the source is known when the mount point is live,
+// frames may be downscaled under congestion and receivers can scale them back to it,
+// RTSPS sessions get master key of the stream in crypto attribute
+func (srv *RtspServer) OnDescribe(path string) {
+	fecType := 0
+	if srv.fecGroupSize > 0 {
+		fecType = FecType
+	}
+	var sourceResolution image.Point
+	if frame, _ := srv.snapshotCache.Latest(path); frame != nil {
+		sourceResolution, _ = video.FrameResolution(frame)
+	}
+	cryptoAttribute := ""
+	if srv.srtpRequired {
+		// the key protects stream of the following SETUP
+		masterKey, err := srtp.GenerateMasterKey()
+		if err != nil {
+			srv.log().Error("cannot generate master key", logging.ErrorKey, err)
+			srv.sendErrorResponse(500, "Internal Server Error")
+			return
+		}
+		srv.describedKey = &masterKey
+		cryptoAttribute = srtp.FormatCryptoAttribute(1, masterKey)
+	}
+	_, serverPort, _ := net.SplitHostPort(srv.clientConnection.LocalAddr().String())
+	srv.writeResponse([]byte(util.PrepareDescribeResponse(
+		srv.sequentialNumber, serverPort, srv.payloadType, RtxType, fecType, sourceResolution.X, sourceResolution.Y,
+		cryptoAttribute, srv.sessionId, srv.videoFileName),
 	))
-	if err != nil {
-		log.Fatalln("[RTSP] error while sending message:", err)
+}
+
+// onGetParameter answers keep-alive (empty body) and snapshot requests, snapshot is sent as jpeg body
+func (srv *RtspServer) onGetParameter(path string, body []byte) {
+	parameter := strings.TrimSpace(string(body))
+	if parameter == "" {
+		srv.SendResponse()
+		return
+	} else if parameter != SnapshotParameter {
+		srv.sendErrorResponse(451, "Parameter Not Understood")
+		return
 	}
+
+	frame, _ := srv.snapshotCache.Latest(path)
+	if frame == nil {
+		srv.sendErrorResponse(404, "Not Found")
+		return
+	}
+	srv.writeResponse(util.PrepareContentResponse(srv.sequentialNumber, srv.sessionId, "image/jpeg", frame))
 }
 
+// CloseConnection may be called repeatedly, the connection is closed once
 func (srv *RtspServer) CloseConnection() {
-	err := srv.clientConnection.Close()
-	if err != nil {
-		log.Println("[RTSP] error while closing connection:", err)
-	}
+	srv.closeOnce.Do(func() {
+		err := srv.clientConnection.Close()
+		if err != nil {
+			srv.log().Error("error while closing connection", logging.ErrorKey, err)
+		}
+	})
 }
@@ -0,0 +1,300 @@
+package components
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"streming_server/logging"
+	"streming_server/video"
+	"strings"
+	"time"
+)
+
+// WebcamSourceType is type of mount point source captured by the server itself, mount points without source
+// are fed by publishing clients
+const WebcamSourceType = "webcam"
+
+// Duration is written as string like "400ms" in the configuration file
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration has to be string like \"400ms\", got %v", string(data))
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// ServerConfig describes everything server_app runs, it is read from JSON file, drain timeout limits how long
+// sessions of mount point removed by reload may continue and shutdown timeout how long the server waits
+// for sessions to release their resources when it stops
+type ServerConfig struct {
+	Listeners       []ListenerConfig   `json:"listeners"`
+	MountPoints     []MountPointConfig `json:"mountPoints"`
+	DrainTimeout    Duration           `json:"drainTimeout"`
+	ShutdownTimeout Duration           `json:"shutdownTimeout"`
+	Auth            AuthConfig         `json:"auth"`
+	Media           MediaConfig        `json:"media"`
+	Congestion      CongestionConfig   `json:"congestion"`
+	Recording       RecordingConfig    `json:"recording"`
+	Dvr             DvrConfig          `json:"dvr"`
+	Http            HttpConfig         `json:"http"`
+	Admin           AdminConfig        `json:"admin"`
+	Log             LogConfig          `json:"log"`
+}
+
+// ListenerConfig is RTSP listener, RTSPS with SRTP protected media when certificate and key are set
+type ListenerConfig struct {
+	Address     string `json:"address"`
+	Certificate string `json:"certificate,omitempty"`
+	Key         string `json:"key,omitempty"`
+}
+
+// MountPointConfig is path which sessions may use, frames come from the source when it is set
+type MountPointConfig struct {
+	Path   string        `json:"path"`
+	Source *SourceConfig `json:"source,omitempty"`
+}
+
+// SourceConfig is frame source of the server, 0 simulcast layers uses DefaultSimulcastLayers
+type SourceConfig struct {
+	Type            string `json:"type"`
+	Device          int    `json:"device"`
+	SimulcastLayers int    `json:"simulcastLayers"`
+}
+
+// AuthConfig enables authentication when user file is set
+type AuthConfig struct {
+	Users string `json:"users"`
+	Realm string `json:"realm"`
+}
+
+type MediaConfig struct {
+	PayloadType  int      `json:"payloadType"`
+	FramePeriod  Duration `json:"framePeriod"`
+	RtcpInterval Duration `json:"rtcpInterval"`
+}
+
+type CongestionConfig struct {
+	Strategy     string   `json:"strategy"`
+	Interval     Duration `json:"interval"`
+	FecGroupSize int      `json:"fecGroupSize"`
+}
+
+// RecordingConfig enables recording of incoming streams when directory is set
+type RecordingConfig struct {
+	Directory string   `json:"directory"`
+	Duration  Duration `json:"duration"`
+	Size      int64    `json:"size"`
+}
+
+// DvrConfig enables time-shifted playback when directory is set
+type DvrConfig struct {
+	Directory string   `json:"directory"`
+	Retention Duration `json:"retention"`
+	Segment   Duration `json:"segment"`
+}
+
+// HttpConfig enables HLS, MJPEG and snapshot outputs when address is set
+type HttpConfig struct {
+	Address string `json:"address"`
+}
+
+// AdminConfig enables administration endpoints and Prometheus metrics when address is set,
+// it should be reachable by operators only
+type AdminConfig struct {
+	Address string `json:"address"`
+}
+
+// LogConfig sets minimal level and format of messages, it is applied by reload as well
+type LogConfig struct {
+	Level  string `json:"level"`
+	Format string `json:"format"`
+}
+
+// Apply sets level and format of the process wide log output
+func (c LogConfig) Apply() error {
+	level, err := logging.ParseLevel(c.Level)
+	if err != nil {
+		return err
+	}
+	if err = logging.SetFormat(c.Format); err != nil {
+		return err
+	}
+	logging.SetLevel(level)
+	return nil
+}
+
+// DefaultServerConfig returns configuration with default values and without listeners
+func DefaultServerConfig() *ServerConfig {
+	return &ServerConfig{
+		DrainTimeout:    Duration(DefaultDrainTimeout),
+		ShutdownTimeout: Duration(DefaultShutdownTimeout),
+		Auth:            AuthConfig{Realm: DefaultRealm},
+		Media: MediaConfig{
+			PayloadType:  MjpegType,
+			FramePeriod:  Duration(video.DefaultFramePeriod) * Duration(time.Millisecond),
+			RtcpInterval: Duration(DefaultRtcpInterval * time.Second),
+		},
+		Congestion: CongestionConfig{
+			Strategy: DefaultCongestionStrategy,
+			Interval: Duration(DefaultCongestionInterval * time.Millisecond),
+		},
+		Recording: RecordingConfig{
+			Duration: Duration(DefaultRecordingDuration),
+			Size:     DefaultRecordingSize,
+		},
+		Dvr: DvrConfig{
+			Retention: Duration(DefaultDvrRetention),
+			Segment:   Duration(DefaultDvrSegmentDuration),
+		},
+		Log: LogConfig{
+			Level:  logging.LevelInfo.String(),
+			Format: logging.TextFormat,
+		},
+	}
+}
+
+// LoadServerConfig reads configuration file, missing values are defaulted and unknown fields are rejected
+func LoadServerConfig(fileName string) (*ServerConfig, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	config := DefaultServerConfig()
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("%v: %v", fileName, err)
+	}
+	if err = config.Validate(); err != nil {
+		return nil, fmt.Errorf("%v: %v", fileName, err)
+	}
+	return config, nil
+}
+
+// Validate reports all problems of the configuration at once
+func (c *ServerConfig) Validate() error {
+	var problems []string
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if len(c.Listeners) == 0 {
+		problem("at least one listener is required")
+	}
+	addresses := make(map[string]bool)
+	for index, listener := range c.Listeners {
+		if listener.Address == "" {
+			problem("listeners[%v]: address is required", index)
+		} else if addresses[listener.Address] {
+			problem("listeners[%v]: address %v is used twice", index, listener.Address)
+		}
+		addresses[listener.Address] = true
+		if (listener.Certificate == "") != (listener.Key == "") {
+			problem("listeners[%v]: certificate and key have to be set together", index)
+		}
+	}
+
+	paths := make(map[string]bool)
+	for index, mountPoint := range c.MountPoints {
+		if !strings.HasPrefix(mountPoint.Path, "/") {
+			problem("mountPoints[%v]: path %q has to start with /", index, mountPoint.Path)
+		} else if paths[mountPoint.Path] {
+			problem("mountPoints[%v]: path %v is configured twice", index, mountPoint.Path)
+		}
+		paths[mountPoint.Path] = true
+		if source := mountPoint.Source; source != nil {
+			if source.Type != WebcamSourceType {
+				problem("mountPoints[%v]: unknown source type %q, available: [%v]", index, source.Type,
+					WebcamSourceType)
+			}
+			if source.Device < 0 {
+				problem("mountPoints[%v]: device can't be negative", index)
+			}
+			if source.SimulcastLayers < 0 || source.SimulcastLayers > MaxSimulcastLayers {
+				problem("mountPoints[%v]: simulcastLayers has to be between 0 and %v", index, MaxSimulcastLayers)
+			}
+		}
+	}
+
+	if c.DrainTimeout < 0 {
+		problem("drainTimeout can't be negative")
+	}
+	if c.ShutdownTimeout <= 0 {
+		problem("shutdownTimeout has to be positive")
+	}
+
+	if c.Auth.Users != "" && c.Auth.Realm == "" {
+		problem("auth: realm is required with users")
+	}
+
+	payloadType := c.Media.PayloadType
+	if payloadType != MjpegType && (payloadType < 96 || payloadType > 127) {
+		problem("media: payloadType has to be %v or dynamic type 96-127", MjpegType)
+	} else if payloadType == RtxType || payloadType == FecType {
+		problem("media: payloadType %v is used by retransmission or parity packets", payloadType)
+	}
+	if c.Media.FramePeriod < Duration(time.Millisecond) {
+		problem("media: framePeriod has to be at least 1ms")
+	}
+	if c.Media.RtcpInterval <= 0 {
+		problem("media: rtcpInterval has to be positive")
+	}
+
+	if _, err := FindCongestionStrategy(c.Congestion.Strategy); err != nil {
+		problem("congestion: %v", err)
+	}
+	if c.Congestion.Interval <= 0 {
+		problem("congestion: interval has to be positive")
+	}
+	if c.Congestion.FecGroupSize < 0 {
+		problem("congestion: fecGroupSize can't be negative")
+	}
+
+	if c.Recording.Directory != "" && (c.Recording.Duration <= 0 || c.Recording.Size <= 0) {
+		problem("recording: duration and size have to be positive")
+	}
+	if c.Dvr.Directory != "" && (c.Dvr.Segment <= 0 || c.Dvr.Retention < c.Dvr.Segment) {
+		problem("dvr: segment has to be positive and retention can't be shorter than segment")
+	}
+
+	if c.Admin.Address != "" && c.Admin.Address == c.Http.Address {
+		problem("admin: address has to differ from address of http outputs")
+	}
+
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		problem("log: %v", err)
+	}
+	if err := logging.ValidateFormat(c.Log.Format); err != nil {
+		problem("log: %v", err)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %v", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// TlsConfig loads certificate of RTSPS listener, it returns nil for plain RTSP listener
+func (l ListenerConfig) TlsConfig() (*tls.Config, error) {
+	if l.Certificate == "" {
+		return nil, nil
+	}
+	certificate, err := tls.LoadX509KeyPair(l.Certificate, l.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{certificate}}, nil
+}
@@ -0,0 +1,98 @@
+package components
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeServerConfig(t *testing.T, content string) (string, func()) {
+	directory, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFileName := filepath.Join(directory, "server.json")
+	err = ioutil.WriteFile(configFileName, []byte(content), 0600)
+	if err != nil {
+		os.RemoveAll(directory)
+		t.Fatal(err)
+	}
+	return configFileName, func() { os.RemoveAll(directory) }
+}
+
+func TestLoadServerConfig(t *testing.T) {
+	configFileName, cleanup := writeServerConfig(t, `{
+		"listeners": [{"address": ":8554"}, {"address": ":8322", "certificate": "cert.pem", "key": "key.pem"}],
+		"mountPoints": [{"path": "/live"}, {"path": "/camera", "source": {"type": "webcam", "device": 1}}],
+		"media": {"payloadType": 100, "framePeriod": "40ms"},
+		"congestion": {"strategy": "legacy", "interval": "1s", "fecGroupSize": 4}
+	}`)
+	defer cleanup()
+
+	config, err := LoadServerConfig(configFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Listeners) != 2 || config.Listeners[1].Key != "key.pem" {
+		t.Fatalf("unexpected listeners: %+v", config.Listeners)
+	}
+	if config.Media.PayloadType != 100 || time.Duration(config.Media.FramePeriod) != 40*time.Millisecond {
+		t.Fatalf("unexpected media: %+v", config.Media)
+	}
+	if time.Duration(config.Congestion.Interval) != time.Second || config.Congestion.FecGroupSize != 4 {
+		t.Fatalf("unexpected congestion: %+v", config.Congestion)
+	}
+	// values missing in the file keep their defaults
+	if time.Duration(config.Media.RtcpInterval) != DefaultRtcpInterval*time.Second || config.Auth.Realm != DefaultRealm {
+		t.Fatalf("defaults weren't kept: %+v %+v", config.Media, config.Auth)
+	}
+
+	mountPoints := NewMountPoints(config.MountPoints)
+	camera := mountPoints.Find("rtsp://localhost:8554/camera")
+	if camera == nil || camera.Stream() != "camera" || camera.Source.Device != 1 {
+		t.Fatalf("mount point of url not found: %+v", camera)
+	}
+	if mountPoints.Find("live") == nil || mountPoints.Find("/unknown") != nil {
+		t.Fatal("mount points don't match configured paths")
+	}
+}
+
+func TestLoadServerConfigRejectsUnknownFields(t *testing.T) {
+	configFileName, cleanup := writeServerConfig(t, `{"listeners": [{"address": ":8554"}], "listner": []}`)
+	defer cleanup()
+	if _, err := LoadServerConfig(configFileName); err == nil || !strings.Contains(err.Error(), "listner") {
+		t.Fatalf("unknown field accepted: %v", err)
+	}
+}
+
+func TestValidateServerConfig(t *testing.T) {
+	config := DefaultServerConfig()
+	config.Listeners = []ListenerConfig{{Address: ":8554", Certificate: "cert.pem"}}
+	config.MountPoints = []MountPointConfig{
+		{Path: "live"},
+		{Path: "/camera", Source: &SourceConfig{Type: "screen"}},
+		{Path: "/camera"},
+	}
+	config.Media.PayloadType = RtxType
+	config.Congestion.Strategy = "unknown"
+	config.Dvr = DvrConfig{Directory: "dvr", Segment: Duration(time.Minute), Retention: Duration(time.Second)}
+	config.Log.Level = "verbose"
+
+	err := config.Validate()
+	if err == nil {
+		t.Fatal("invalid configuration accepted")
+	}
+	for _, problem := range []string{"listeners[0]", "mountPoints[0]", "mountPoints[1]", "mountPoints[2]",
+		"media:", "congestion:", "dvr:", "log:"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("problem of %v not reported: %v", problem, err)
+		}
+	}
+
+	if err = DefaultServerConfig().Validate(); err == nil {
+		t.Fatal("configuration without listeners accepted")
+	}
+}
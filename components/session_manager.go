@@ -0,0 +1,167 @@
+package components
+
+import (
+	"sort"
+	"streming_server/protocol/rtp"
+	"streming_server/protocol/rtsp/state"
+	"sync"
+	"time"
+)
+
+type managedSession struct {
+	server         *RtspServer
+	privateChannel chan *rtp.Packet
+}
+
+// SessionManager keeps sessions of the listener, the fan-out iterates over snapshot of the sessions,
+// so slow session doesn't block accepting of the new ones
+type SessionManager struct {
+	sessions map[string]*managedSession
+	snapshot []*managedSession
+	mutex    sync.RWMutex
+}
+
+// SessionInfo describes session for administration, sent and lost counters belong to the played stream
+// and received ones to the published stream
+type SessionInfo struct {
+	Id              string    `json:"id"`
+	Path            string    `json:"path"`
+	State           string    `json:"state"`
+	RemoteAddress   string    `json:"remoteAddress"`
+	Transport       string    `json:"transport"`
+	Username        string    `json:"username,omitempty"`
+	StartedAt       time.Time `json:"startedAt"`
+	PacketsSent     int64     `json:"packetsSent"`
+	BytesSent       int64     `json:"bytesSent"`
+	BytesReceived   int64     `json:"bytesReceived"`
+	PacketsLost     int       `json:"packetsLost"`
+	FractionLost    float64   `json:"fractionLost"`
+	CongestionLevel int       `json:"congestionLevel"`
+	Quality         int       `json:"quality"`
+	TargetBitrate   int       `json:"targetBitrate"`
+	QueuedFrames    int       `json:"queuedFrames"`
+}
+
+func NewSessionManager() *SessionManager {
+	return &SessionManager{
+		sessions: make(map[string]*managedSession),
+	}
+}
+
+func (m *SessionManager) Add(srv *RtspServer, privateChannel chan *rtp.Packet) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.sessions[srv.sessionId] = &managedSession{server: srv, privateChannel: privateChannel}
+	m.updateSnapshot()
+}
+
+func (m *SessionManager) Remove(srv *RtspServer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.sessions, srv.sessionId)
+	m.updateSnapshot()
+}
+
+// updateSnapshot has to be called with the mutex locked
+func (m *SessionManager) updateSnapshot() {
+	snapshot := make([]*managedSession, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		snapshot = append(snapshot, session)
+	}
+	m.snapshot = snapshot
+}
+
+// Range calls function for every session until it returns false
+func (m *SessionManager) Range(function func(srv *RtspServer, privateChannel chan *rtp.Packet) bool) {
+	m.mutex.RLock()
+	snapshot := m.snapshot
+	m.mutex.RUnlock()
+	for _, session := range snapshot {
+		if !function(session.server, session.privateChannel) {
+			return
+		}
+	}
+}
+
+// Find returns session of given id, nil when there is none
+func (m *SessionManager) Find(sessionId string) *RtspServer {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if session, found := m.sessions[sessionId]; found {
+		return session.server
+	}
+	return nil
+}
+
+// Sessions returns description of every session, the oldest first
+func (m *SessionManager) Sessions() []SessionInfo {
+	result := make([]SessionInfo, 0)
+	m.Range(func(srv *RtspServer, _ chan *rtp.Packet) bool {
+		result = append(result, srv.Info())
+		return true
+	})
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartedAt.Before(result[j].StartedAt)
+	})
+	return result
+}
+
+// Streams returns mount points used by connected sessions
+func (m *SessionManager) Streams() []string {
+	streams := make(map[string]bool)
+	m.Range(func(srv *RtspServer, _ chan *rtp.Packet) bool {
+		if path := srv.Path(); path != "" {
+			streams[path] = true
+		}
+		return true
+	})
+	result := make([]string, 0, len(streams))
+	for stream := range streams {
+		result = append(result, stream)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// SessionCount returns number of connected sessions which use the mount point
+func (m *SessionManager) SessionCount(stream string) int {
+	result := 0
+	m.Range(func(srv *RtspServer, _ chan *rtp.Packet) bool {
+		if mountPointOf(srv.Path()) == mountPointOf(stream) && srv.State() != state.Detached {
+			result++
+		}
+		return true
+	})
+	return result
+}
+
+// Publishers returns sessions which publish to the mount point
+func (m *SessionManager) Publishers(stream string) []*RtspServer {
+	var result []*RtspServer
+	m.Range(func(srv *RtspServer, _ chan *rtp.Packet) bool {
+		if mountPointOf(srv.Path()) == mountPointOf(stream) && srv.State() == state.Recording {
+			result = append(result, srv)
+		}
+		return true
+	})
+	return result
+}
+
+// CloseSessions shuts down every session which uses the mount point
+func (m *SessionManager) CloseSessions(stream string) {
+	m.Range(func(srv *RtspServer, _ chan *rtp.Packet) bool {
+		if mountPointOf(srv.Path()) == mountPointOf(stream) {
+			srv.log().Info("session of mount point disconnected")
+			srv.Shutdown()
+		}
+		return true
+	})
+}
+
+// CloseAll shuts down every session
+func (m *SessionManager) CloseAll() {
+	m.Range(func(srv *RtspServer, _ chan *rtp.Packet) bool {
+		srv.Shutdown()
+		return true
+	})
+}
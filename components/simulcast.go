@@ -0,0 +1,144 @@
+package components
+
+import (
+	"image"
+	"streming_server/protocol/rtp"
+	"streming_server/video"
+	"sync"
+	"time"
+)
+
+// DefaultSimulcastLayers is number of encodings sent by publisher per frame: full, half and quarter resolution
+const DefaultSimulcastLayers = 3
+const MaxSimulcastLayers = 4
+
+// lower layers are sent with SSRC following the base, the primary layer uses rtp.DefaultSsrc
+const simulcastSsrcBase = 20000
+
+// layer which didn't deliver frame for that long is no longer offered to sessions
+const simulcastLayerTimeout = time.Second
+
+// SimulcastSsrc returns SSRC of given layer
+func SimulcastSsrc(layer int) int {
+	if layer == 0 {
+		return rtp.DefaultSsrc
+	}
+	return simulcastSsrcBase + layer
+}
+
+// SimulcastLayer returns layer of the stream with given SSRC, unknown streams belong to the primary layer
+func SimulcastLayer(ssrc int) int {
+	if ssrc > simulcastSsrcBase && ssrc < simulcastSsrcBase+MaxSimulcastLayers {
+		return ssrc - simulcastSsrcBase
+	}
+	return 0
+}
+
+// SimulcastResolution returns resolution of given layer, every layer halves the previous one and dimensions
+// are rounded down to multiple of 8 like in video.ResolutionLadder, so congestion control can use layers directly
+func SimulcastResolution(width int, height int, layer int) image.Point {
+	if layer == 0 {
+		return image.Point{X: width, Y: height}
+	}
+	return image.Point{X: width >> layer / 8 * 8, Y: height >> layer / 8 * 8}
+}
+
+type simulcastLayer struct {
+	resolution  image.Point
+	lastFrameAt time.Time
+}
+
+// SimulcastLayers keeps resolution of layers currently published to every mount point
+type SimulcastLayers struct {
+	streams map[string][]simulcastLayer
+	mutex   sync.Mutex
+}
+
+func NewSimulcastLayers() *SimulcastLayers {
+	return &SimulcastLayers{
+		streams: make(map[string][]simulcastLayer),
+	}
+}
+
+func (l *SimulcastLayers) Feed(streamPacket *StreamPacket) {
+	if streamPacket.Layer >= MaxSimulcastLayers {
+		return
+	}
+	resolution, err := video.FrameResolution(streamPacket.Packet.Payload)
+	if err != nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	layers := l.streams[streamPacket.Path]
+	for len(layers) <= streamPacket.Layer {
+		layers = append(layers, simulcastLayer{})
+	}
+	layers[streamPacket.Layer] = simulcastLayer{resolution: resolution, lastFrameAt: time.Now()}
+	l.streams[streamPacket.Path] = layers
+}
+
+// Resolutions returns resolution of every layer of the stream, inactive layers have zero resolution
+func (l *SimulcastLayers) Resolutions(path string) []image.Point {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	layers := l.streams[path]
+	result := make([]image.Point, len(layers))
+	for index, layer := range layers {
+		if time.Since(layer.lastFrameAt) < simulcastLayerTimeout {
+			result[index] = layer.resolution
+		}
+	}
+	return result
+}
+
+// SelectLayer returns the smallest active layer which is still at least as wide as target width of congestion
+// control, so the session transcodes as little as possible, target width 0 selects the primary layer
+func SelectLayer(layers []image.Point, targetWidth int) int {
+	result := 0
+	if targetWidth == 0 {
+		return result
+	}
+	for index, resolution := range layers {
+		if resolution.X >= targetWidth {
+			result = index
+		}
+	}
+	return result
+}
+
+// LayerSelector forwards packets of single layer to the session, all layers of the same frame share sequence
+// number, so layer is switched with the first frame of the new layer following the last forwarded one
+type LayerSelector struct {
+	currentLayer int
+	lastSeqNum   int
+	forwarded    bool
+}
+
+func NewLayerSelector() *LayerSelector {
+	return &LayerSelector{}
+}
+
+func (s *LayerSelector) Accept(layer int, seqNum int, wantedLayer int) bool {
+	if layer != wantedLayer {
+		// frames of current layer are dropped until the wanted one starts, so no frame is sent twice
+		return false
+	}
+	if s.forwarded {
+		// much older number means that the stream was published again with new numbering
+		distance := int16(uint16(seqNum - s.lastSeqNum))
+		if distance <= 0 && distance > -rtpHistorySize {
+			return false
+		}
+	}
+	if layer != s.currentLayer {
+		rtpLogger.Debug("simulcast layer switched", "from", s.currentLayer, "to", layer)
+		s.currentLayer = layer
+	}
+	s.lastSeqNum = seqNum
+	s.forwarded = true
+	return true
+}
@@ -0,0 +1,105 @@
+package components
+
+import (
+	"image"
+	"testing"
+	"time"
+)
+
+func TestSelectLayer(t *testing.T) {
+	layers := []image.Point{{X: 640, Y: 480}, {X: 320, Y: 240}, {X: 160, Y: 120}}
+	withInactive := []image.Point{{X: 640, Y: 480}, {}, {X: 160, Y: 120}}
+	tests := []struct {
+		name        string
+		layers      []image.Point
+		targetWidth int
+		expected    int
+	}{
+		{"no target", layers, 0, 0},
+		{"target of primary layer", layers, 640, 0},
+		{"target of middle layer", layers, 320, 1},
+		{"target between layers", layers, 300, 1},
+		{"target below the smallest layer", layers, 100, 2},
+		{"target above every layer", layers, 1280, 0},
+		{"no layers", nil, 320, 0},
+		{"inactive layer is skipped", withInactive, 300, 0},
+		{"layer below inactive one", withInactive, 160, 2},
+	}
+	for _, test := range tests {
+		if actual := SelectLayer(test.layers, test.targetWidth); actual != test.expected {
+			t.Errorf("%v: layer %v selected, expected %v", test.name, actual, test.expected)
+		}
+	}
+}
+
+type layerPacket struct {
+	layer       int
+	seqNum      int
+	wantedLayer int
+	accepted    bool
+}
+
+func TestLayerSelectorAccept(t *testing.T) {
+	tests := []struct {
+		name    string
+		packets []layerPacket
+	}{
+		{"only wanted layer is forwarded", []layerPacket{
+			{0, 10, 0, true}, {1, 10, 0, false}, {2, 10, 0, false},
+			{1, 11, 0, false}, {0, 11, 0, true},
+		}},
+		{"layer is switched at the next frame", []layerPacket{
+			{0, 10, 0, true}, {1, 10, 0, false},
+			{0, 11, 1, false}, {1, 11, 1, true}, {1, 12, 1, true},
+		}},
+		{"frame already sent in other layer isn't sent again", []layerPacket{
+			{0, 10, 0, true}, {1, 10, 1, false}, {0, 11, 1, false}, {1, 11, 1, true},
+			{0, 12, 0, true}, {1, 12, 0, false}, {0, 11, 0, false},
+		}},
+		{"late frame is dropped", []layerPacket{
+			{0, 10, 0, true}, {0, 12, 0, true}, {0, 11, 0, false}, {0, 12, 0, false}, {0, 13, 0, true},
+		}},
+		{"numbering wraps", []layerPacket{
+			{0, 65534, 0, true}, {1, 65535, 1, true}, {1, 0, 1, true}, {0, 1, 0, true}, {0, 65535, 0, false},
+		}},
+		{"stream published again with new numbering", []layerPacket{
+			{1, 5000, 1, true}, {1, 5000 - rtpHistorySize, 1, true}, {1, 5001 - rtpHistorySize, 1, true},
+			{1, 5000 - rtpHistorySize, 1, false},
+		}},
+		{"nothing is forwarded while wanted layer is inactive", []layerPacket{
+			{1, 10, 1, true}, {0, 11, 1, false}, {2, 11, 1, false}, {0, 12, 1, false},
+			{0, 13, 0, true}, {1, 13, 0, false},
+		}},
+	}
+	for _, test := range tests {
+		selector := NewLayerSelector()
+		for index, packet := range test.packets {
+			if actual := selector.Accept(packet.layer, packet.seqNum, packet.wantedLayer); actual != packet.accepted {
+				t.Errorf("%v: packet %v of layer %v with seqnum %v accepted %v, expected %v", test.name, index,
+					packet.layer, packet.seqNum, actual, packet.accepted)
+			}
+		}
+	}
+}
+
+func TestSimulcastLayersExpire(t *testing.T) {
+	layers := NewSimulcastLayers()
+	layers.streams["cam"] = []simulcastLayer{
+		{resolution: image.Point{X: 640, Y: 480}, lastFrameAt: time.Now()},
+		{resolution: image.Point{X: 320, Y: 240}, lastFrameAt: time.Now().Add(-simulcastLayerTimeout)},
+		{resolution: image.Point{X: 160, Y: 120}, lastFrameAt: time.Now()},
+	}
+	resolutions := layers.Resolutions("cam")
+	expected := []image.Point{{X: 640, Y: 480}, {}, {X: 160, Y: 120}}
+	if len(resolutions) != len(expected) {
+		t.Fatalf("resolutions %v, expected %v", resolutions, expected)
+	}
+	for index := range expected {
+		if resolutions[index] != expected[index] {
+			t.Errorf("resolutions %v, expected %v", resolutions, expected)
+		}
+	}
+	if resolutions := layers.Resolutions("other"); len(resolutions) != 0 {
+		t.Errorf("resolutions %v of unpublished stream", resolutions)
+	}
+}
@@ -0,0 +1,86 @@
+package components
+
+import (
+	"net/http"
+	"strconv"
+	"streming_server/protocol/rtp"
+	"streming_server/video"
+	"strings"
+	"sync"
+	"time"
+)
+
+const SnapshotParameter = "snapshot"
+
+type snapshot struct {
+	frame     []byte
+	timestamp time.Time
+}
+
+// SnapshotCache keeps the latest frame of every mount point,
+// over http it is available as /snapshot/<path>.jpg?width=<pixels>&quality=<1-100>
+type SnapshotCache struct {
+	snapshots map[string]*snapshot
+	mutex     sync.Mutex
+}
+
+func NewSnapshotCache() *SnapshotCache {
+	return &SnapshotCache{
+		snapshots: make(map[string]*snapshot),
+	}
+}
+
+func (sc *SnapshotCache) Feed(path string, packet *rtp.Packet) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.snapshots[path] = &snapshot{frame: packet.Payload, timestamp: time.Now()}
+}
+
+// Latest returns nil when nothing has been published to the mount point yet
+func (sc *SnapshotCache) Latest(path string) ([]byte, time.Time) {
+	if sc == nil {
+		return nil, time.Time{}
+	}
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	latest, found := sc.snapshots[path]
+	if !found {
+		return nil, time.Time{}
+	}
+	return latest.frame, latest.timestamp
+}
+
+func (sc *SnapshotCache) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	path := strings.TrimSuffix(strings.TrimPrefix(request.URL.Path, "/snapshot/"), ".jpg")
+	frame, timestamp := sc.Latest(path)
+	if frame == nil {
+		http.NotFound(writer, request)
+		return
+	}
+
+	width, widthErr := strconv.Atoi(request.URL.Query().Get("width"))
+	quality, qualityErr := strconv.Atoi(request.URL.Query().Get("quality"))
+	if widthErr == nil || qualityErr == nil {
+		qualityAdjuster := video.NewQualityAdjuster()
+		if qualityErr == nil && quality > 0 && quality <= 100 {
+			qualityAdjuster.ChangeCompressionQuality(quality)
+		}
+		scaledFrame, err := qualityAdjuster.CompressWithWidth(frame, width)
+		if err != nil {
+			http.Error(writer, "cannot process frame", http.StatusInternalServerError)
+			return
+		}
+		frame = scaledFrame
+	}
+
+	writer.Header().Set("Content-Type", "image/jpeg")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.Header().Set("Last-Modified", timestamp.UTC().Format(http.TimeFormat))
+	_, _ = writer.Write(frame)
+}
+
+func (sc *SnapshotCache) Close() {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.snapshots = make(map[string]*snapshot)
+}
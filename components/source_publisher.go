@@ -0,0 +1,126 @@
+package components
+
+import (
+	"bytes"
+	"image/jpeg"
+	"streming_server/logging"
+	"streming_server/protocol/rtp"
+	"time"
+)
+
+// SourcePublisher feeds mount point with frames captured by the server itself, sessions play them the same way
+// as streams of recording clients
+type SourcePublisher struct {
+	listener    *RtspListener
+	stream      string
+	source      SourceConfig
+	frameSource FrameSource
+	ticker      *time.Ticker
+	interval    time.Duration
+	seqNum      int
+	layers      int
+	doneCheck   chan bool
+	started     bool
+}
+
+func NewSourcePublisher(listener *RtspListener, mountPoint *MountPoint, frameSource FrameSource,
+	framePeriod time.Duration) *SourcePublisher {
+	var source SourceConfig
+	if mountPoint.Source != nil {
+		source = *mountPoint.Source
+	}
+	layers := DefaultSimulcastLayers
+	if source.SimulcastLayers > 0 {
+		layers = source.SimulcastLayers
+	}
+	return &SourcePublisher{
+		listener:    listener,
+		stream:      mountPoint.Stream(),
+		source:      source,
+		frameSource: frameSource,
+		interval:    framePeriod,
+		seqNum:      1,
+		layers:      layers,
+		doneCheck:   make(chan bool),
+	}
+}
+
+// NewMountPointSource creates frame source described by the mount point, nil when it is fed by clients
+func NewMountPointSource(mountPoint *MountPoint) FrameSource {
+	if mountPoint.Source == nil {
+		return nil
+	}
+	switch mountPoint.Source.Type {
+	case WebcamSourceType:
+		return NewWebcamSource(mountPoint.Source.Device)
+	}
+	return nil
+}
+
+// Feeds reports whether the publisher captures source configured for the mount point with given frame period
+func (p *SourcePublisher) Feeds(mountPoint *MountPoint, framePeriod time.Duration) bool {
+	return mountPoint.Source != nil && *mountPoint.Source == p.source && mountPoint.Stream() == p.stream &&
+		framePeriod == p.interval
+}
+
+func (p *SourcePublisher) nextFrame() {
+	img, err := p.frameSource.Read()
+	if err != nil {
+		videoLogger.Error("unable to read frame of mount point source", logging.ErrorKey, err)
+		return
+	}
+	buffer := new(bytes.Buffer)
+	err = jpeg.Encode(buffer, img, nil)
+	if err != nil {
+		videoLogger.Error("unable to compress frame to jpeg", logging.ErrorKey, err)
+		return
+	}
+
+	for layer, data := range encodeSimulcastLayers(img, buffer.Bytes(), p.layers) {
+		header := rtp.NewHeader(MjpegType, p.seqNum&0xFFFF, 0)
+		header.Ssrc = SimulcastSsrc(layer)
+		p.listener.Publish(&StreamPacket{
+			Path:   p.stream,
+			Packet: rtp.NewPacket(header, len(data), data),
+			Layer:  layer,
+		})
+	}
+	p.seqNum++
+}
+
+// Start opens the frame source and publishes its frames every frame period
+func (p *SourcePublisher) Start() error {
+	err := p.frameSource.Open()
+	if err != nil {
+		return err
+	}
+
+	p.started = true
+	p.ticker = time.NewTicker(p.interval)
+
+	go func(ticker *time.Ticker, doneCheck chan bool) {
+		for {
+			select {
+			case <-doneCheck:
+				return
+			case <-ticker.C:
+				p.nextFrame()
+			}
+		}
+	}(p.ticker, p.doneCheck)
+	rtspLogger.Info("mount point is fed by its source", logging.PathKey, p.stream)
+	return nil
+}
+
+func (p *SourcePublisher) Stop() {
+	if p.started {
+		p.doneCheck <- true
+		p.ticker.Stop()
+		p.started = false
+
+		err := p.frameSource.Close()
+		if err != nil {
+			videoLogger.Error("cannot close mount point source", logging.ErrorKey, err)
+		}
+	}
+}
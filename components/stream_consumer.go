@@ -0,0 +1,12 @@
+package components
+
+import (
+	"streming_server/protocol/rtp"
+)
+
+// StreamConsumer receives every packet published to any of the mount points,
+// Feed must not block because it is called from the main fan-out loop
+type StreamConsumer interface {
+	Feed(path string, packet *rtp.Packet)
+	Close()
+}
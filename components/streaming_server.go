@@ -0,0 +1,384 @@
+package components
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"streming_server/logging"
+	"streming_server/video"
+	"sync"
+	"time"
+)
+
+// DefaultDrainTimeout is how long sessions of mount point removed by reload may continue before they are
+// disconnected
+const DefaultDrainTimeout = time.Minute
+
+const drainCheckInterval = time.Second
+
+// drainingMountPoint is removed mount point whose sessions are allowed to finish, its source keeps feeding them
+type drainingMountPoint struct {
+	sourcePublisher *SourcePublisher
+	deadline        time.Time
+}
+
+// StreamingServer runs listeners, stream consumers, mount point sources and http endpoints of the configuration,
+// Reload applies changed configuration without disconnecting sessions of the remaining mount points,
+// mutex guards config, authenticator, sources and draining mount points
+type StreamingServer struct {
+	config           *ServerConfig
+	configFileName   string
+	rtspListener     *RtspListener
+	authenticator    *Authenticator
+	mountPoints      *MountPoints
+	sourcePublishers map[string]*SourcePublisher
+	draining         map[string]*drainingMountPoint
+	mutex            sync.Mutex
+	snapshotCache    *SnapshotCache
+	transcodeCache   *video.TranscodeCache
+	dvrManager       *DvrManager
+	httpServer       *HttpServer
+	adminServer      *HttpServer
+	ticker           *time.Ticker
+	doneCheck        chan bool
+	started          bool
+}
+
+// NewStreamingServer prepares server of validated configuration, ReloadConfigFile reads configFileName again,
+// it may be empty when the configuration doesn't come from file
+func NewStreamingServer(config *ServerConfig, configFileName string) *StreamingServer {
+	return &StreamingServer{
+		config:           config,
+		configFileName:   configFileName,
+		mountPoints:      NewMountPoints(config.MountPoints),
+		sourcePublishers: make(map[string]*SourcePublisher),
+		draining:         make(map[string]*drainingMountPoint),
+		snapshotCache:    NewSnapshotCache(),
+		transcodeCache:   video.NewTranscodeCache(),
+		doneCheck:        make(chan bool),
+	}
+}
+
+func loadAuthenticator(authConfig AuthConfig) (*Authenticator, error) {
+	if authConfig.Users == "" {
+		return nil, nil
+	}
+	return LoadAuthenticator(authConfig.Users, authConfig.Realm)
+}
+
+// Address returns address of the first RTSP listener
+func (s *StreamingServer) Address() net.Addr {
+	return s.rtspListener.Address()
+}
+
+func (s *StreamingServer) Start() error {
+	err := s.config.Log.Apply()
+	if err != nil {
+		return err
+	}
+	s.authenticator, err = loadAuthenticator(s.config.Auth)
+	if err != nil {
+		return err
+	}
+
+	consumers := []StreamConsumer{s.snapshotCache}
+	if s.config.Recording.Directory != "" {
+		consumers = append(consumers, NewRecorderManager(s.config.Recording.Directory,
+			time.Duration(s.config.Recording.Duration), s.config.Recording.Size))
+	}
+	if s.config.Dvr.Directory != "" {
+		s.dvrManager = NewDvrManager(s.config.Dvr.Directory, time.Duration(s.config.Dvr.Segment),
+			time.Duration(s.config.Dvr.Retention))
+		consumers = append(consumers, s.dvrManager)
+	}
+	if s.config.Http.Address != "" {
+		hlsManager := NewHlsManager(NewMpegTsMuxer, DefaultHlsSegmentDuration, DefaultHlsWindowSize)
+		mjpegOutput := NewMjpegOutput()
+		consumers = append(consumers, hlsManager, mjpegOutput)
+		s.httpServer = NewHttpServer(s.config.Http.Address)
+		s.httpServer.Handle("/hls/", hlsManager)
+		s.httpServer.Handle("/mjpeg/", mjpegOutput)
+		s.httpServer.Handle("/snapshot/", s.snapshotCache)
+	}
+
+	err = s.openListeners(consumers)
+	if err != nil {
+		return err
+	}
+	if s.httpServer != nil {
+		s.httpServer.Start()
+	}
+	if s.config.Admin.Address != "" {
+		s.adminServer = NewHttpServer(s.config.Admin.Address)
+		s.handleAdministration(s.adminServer)
+		s.adminServer.Start()
+	}
+	s.rtspListener.Start()
+
+	s.mutex.Lock()
+	err = s.updateSources(time.Duration(s.config.Media.FramePeriod))
+	s.mutex.Unlock()
+	if err != nil {
+		s.Close()
+		return err
+	}
+
+	s.started = true
+	s.ticker = time.NewTicker(drainCheckInterval)
+	go func(ticker *time.Ticker, doneCheck chan bool) {
+		for {
+			select {
+			case <-doneCheck:
+				return
+			case <-ticker.C:
+				s.drain()
+			}
+		}
+	}(s.ticker, s.doneCheck)
+	return nil
+}
+
+// openListeners opens every configured socket, sessions of all of them share mount points
+func (s *StreamingServer) openListeners(consumers []StreamConsumer) error {
+	for index, listenerConfig := range s.config.Listeners {
+		tlsConfig, err := listenerConfig.TlsConfig()
+		if err == nil {
+			if index > 0 {
+				err = s.rtspListener.Listen(listenerConfig.Address, tlsConfig)
+			} else if tlsConfig != nil {
+				s.rtspListener, err = NewSecureRtspListener(listenerConfig.Address, tlsConfig, consumers, s.configure)
+			} else {
+				s.rtspListener, err = NewRtspListener(listenerConfig.Address, consumers, s.configure)
+			}
+		}
+		if err != nil {
+			if s.rtspListener != nil {
+				s.rtspListener.closeListeners()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// configure applies the current configuration to new session
+func (s *StreamingServer) configure(srv *RtspServer) {
+	s.mutex.Lock()
+	config := s.config
+	authenticator := s.authenticator
+	s.mutex.Unlock()
+
+	congestionStrategy, _ := FindCongestionStrategy(config.Congestion.Strategy)
+	srv.SetDvrManager(s.dvrManager)
+	srv.SetSnapshotCache(s.snapshotCache)
+	srv.SetFecGroupSize(config.Congestion.FecGroupSize)
+	srv.SetCongestionStrategy(congestionStrategy)
+	srv.SetCongestionInterval(time.Duration(config.Congestion.Interval))
+	srv.SetTranscodeCache(s.transcodeCache)
+	srv.SetAuthenticator(authenticator)
+	srv.SetMountPoints(s.mountPoints)
+	srv.SetPayloadType(config.Media.PayloadType)
+	srv.SetFramePeriod(time.Duration(config.Media.FramePeriod))
+	srv.SetRtcpInterval(time.Duration(config.Media.RtcpInterval))
+}
+
+// ReloadConfigFile reads configuration file again and applies it
+func (s *StreamingServer) ReloadConfigFile() error {
+	if s.configFileName == "" {
+		return errors.New("server wasn't started with configuration file")
+	}
+	config, err := LoadServerConfig(s.configFileName)
+	if err != nil {
+		return err
+	}
+	return s.Reload(config)
+}
+
+// Reload applies changed configuration, sessions of the remaining mount points aren't touched,
+// removed mount points refuse new sessions and their sessions are disconnected once drain timeout passes,
+// credentials are replaced for connected sessions as well, congestion and media parameters are used
+// by the following sessions, log level and format are switched at once, listeners, recording, dvr
+// and http endpoints are changed by restart only
+func (s *StreamingServer) Reload(config *ServerConfig) error {
+	err := config.Validate()
+	if err != nil {
+		return err
+	}
+	authenticator, err := loadAuthenticator(config.Auth)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.reportRestartRequired(config)
+	if authenticator != nil && s.authenticator != nil {
+		s.authenticator.Replace(authenticator)
+	} else {
+		// enabling or disabling authentication applies to new sessions only
+		s.authenticator = authenticator
+	}
+	s.updateMountPoints(config.MountPoints, time.Now().Add(time.Duration(config.DrainTimeout)))
+	s.config = config
+	// validated configuration can't fail here
+	_ = config.Log.Apply()
+	err = s.updateSources(time.Duration(config.Media.FramePeriod))
+	if err != nil {
+		rtspLogger.Error("source of mount point can't be started", logging.ErrorKey, err)
+	}
+	rtspLogger.Info("configuration reloaded")
+	return nil
+}
+
+func (s *StreamingServer) reportRestartRequired(config *ServerConfig) {
+	changes := map[string]bool{
+		"listeners": !reflect.DeepEqual(s.config.Listeners, config.Listeners),
+		"recording": s.config.Recording != config.Recording,
+		"dvr":       s.config.Dvr != config.Dvr,
+		"http":      s.config.Http != config.Http,
+		"admin":     s.config.Admin != config.Admin,
+	}
+	for section, changed := range changes {
+		if changed {
+			rtspLogger.Warn("changes take effect after restart", "section", section)
+		}
+	}
+}
+
+// updateMountPoints replaces mount points and starts draining of the removed ones, they are retired
+// also when no mount point remains and every path is allowed, so their sources stop
+func (s *StreamingServer) updateMountPoints(configs []MountPointConfig, deadline time.Time) {
+	wasRestricted := s.mountPoints.Restricted()
+	previous := s.mountPoints.All()
+	s.mountPoints.Update(configs)
+
+	for stream, draining := range s.draining {
+		if s.mountPoints.Find(stream) != nil {
+			// mount point added again keeps its sessions, the source is checked with the others
+			if draining.sourcePublisher != nil {
+				s.sourcePublishers[stream] = draining.sourcePublisher
+			}
+			delete(s.draining, stream)
+		}
+	}
+	for _, mountPoint := range previous {
+		if s.mountPoints.Find(mountPoint.Path) == nil {
+			s.retire(mountPoint.Stream(), deadline)
+		}
+	}
+	if !wasRestricted && s.mountPoints.Restricted() {
+		// sessions of every path were allowed so far
+		for _, stream := range s.rtspListener.Sessions().Streams() {
+			if !s.mountPoints.Allows(stream) {
+				s.retire(stream, deadline)
+			}
+		}
+	}
+}
+
+func (s *StreamingServer) retire(stream string, deadline time.Time) {
+	if _, found := s.draining[stream]; found {
+		return
+	}
+	s.draining[stream] = &drainingMountPoint{
+		sourcePublisher: s.sourcePublishers[stream],
+		deadline:        deadline,
+	}
+	delete(s.sourcePublishers, stream)
+	rtspLogger.Info("mount point removed, draining its sessions", logging.PathKey, stream)
+}
+
+// updateSources starts sources of the mount points and restarts the changed ones, it returns the first error
+func (s *StreamingServer) updateSources(framePeriod time.Duration) error {
+	var result error
+	for _, mountPoint := range s.mountPoints.All() {
+		stream := mountPoint.Stream()
+		sourcePublisher := s.sourcePublishers[stream]
+		if sourcePublisher != nil && !sourcePublisher.Feeds(mountPoint, framePeriod) {
+			sourcePublisher.Stop()
+			delete(s.sourcePublishers, stream)
+			sourcePublisher = nil
+		}
+		frameSource := NewMountPointSource(mountPoint)
+		if sourcePublisher != nil || frameSource == nil {
+			continue
+		}
+		sourcePublisher = NewSourcePublisher(s.rtspListener, mountPoint, frameSource, framePeriod)
+		if err := sourcePublisher.Start(); err != nil {
+			if result == nil {
+				result = err
+			}
+			continue
+		}
+		s.sourcePublishers[stream] = sourcePublisher
+	}
+	return result
+}
+
+// drain disconnects sessions of removed mount points once they are over or drain timeout passes
+func (s *StreamingServer) drain() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	for stream, draining := range s.draining {
+		if s.rtspListener.Sessions().SessionCount(stream) > 0 && now.Before(draining.deadline) {
+			continue
+		}
+		s.rtspListener.Sessions().CloseSessions(stream)
+		if draining.sourcePublisher != nil {
+			draining.sourcePublisher.Stop()
+		}
+		delete(s.draining, stream)
+		rtspLogger.Info("mount point retired", logging.PathKey, stream)
+	}
+}
+
+// ShutdownTimeout returns shutdown timeout of the current configuration
+func (s *StreamingServer) ShutdownTimeout() time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return time.Duration(s.config.ShutdownTimeout)
+}
+
+// Close shuts the server down with shutdown timeout of the configuration
+func (s *StreamingServer) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.ShutdownTimeout())
+	defer cancel()
+	err := s.Shutdown(ctx)
+	if err != nil {
+		rtspLogger.Warn("server wasn't shut down cleanly", logging.ErrorKey, err)
+	}
+}
+
+// Shutdown stops sources and accepting of sessions, sessions are ended with RTCP BYE, then it waits until
+// their goroutines return or ctx is done, recordings are finalized and http endpoints are closed,
+// goroutines which didn't return are reported in the error
+func (s *StreamingServer) Shutdown(ctx context.Context) error {
+	if s.started {
+		s.doneCheck <- true
+		s.ticker.Stop()
+		s.started = false
+	}
+	s.mutex.Lock()
+	for stream, sourcePublisher := range s.sourcePublishers {
+		sourcePublisher.Stop()
+		delete(s.sourcePublishers, stream)
+	}
+	for stream, draining := range s.draining {
+		if draining.sourcePublisher != nil {
+			draining.sourcePublisher.Stop()
+		}
+		delete(s.draining, stream)
+	}
+	s.mutex.Unlock()
+
+	err := s.rtspListener.Shutdown(ctx)
+	if s.httpServer != nil {
+		s.httpServer.Close()
+	}
+	if s.adminServer != nil {
+		s.adminServer.Close()
+	}
+	return err
+}
@@ -0,0 +1,308 @@
+package components
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"streming_server/protocol/rtsp/state"
+	"streming_server/util"
+	"streming_server/video"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startStreamingServer starts server of the configuration, whose first listener has to use ephemeral port,
+// sessions use fixed congestion strategy of the harness
+func startStreamingServer(t *testing.T, config *ServerConfig) *testHarness {
+	config.Congestion.Strategy = fixedStrategyName
+	server := NewStreamingServer(config, "")
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	harness := newHarness(t, server.rtspListener, nil)
+	harness.server = server
+	return harness
+}
+
+// describe sends DESCRIBE request over new connection and returns status code of the response
+func (h *testHarness) describe(path string) int {
+	statusCode, _ := h.request("DESCRIBE", path, "")
+	return statusCode
+}
+
+// snapshot requests snapshot of the mount point with GET_PARAMETER and returns status code and the frame
+func (h *testHarness) snapshot(url string) (int, []byte) {
+	statusCode, reader := h.request("GET_PARAMETER", url, SnapshotParameter)
+	if statusCode != 200 {
+		return statusCode, nil
+	}
+	frame, err := util.ReadRequestBody(reader, util.ReadRequestElements(reader))
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return statusCode, frame
+}
+
+// request sends request with the body over new connection, which is closed with the test, and returns status code
+// of the response and reader of its remainder
+func (h *testHarness) request(method string, url string, body string) (int, *bufio.Reader) {
+	h.t.Helper()
+	connection, err := net.Dial("tcp", net.JoinHostPort(h.host, h.port))
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	h.t.Cleanup(func() { connection.Close() })
+	_, err = fmt.Fprintf(connection, "%v %v RTSP/1.0\r\nCSeq: 1\r\nContent-Length: %v\r\n\r\n%v",
+		method, url, len(body), body)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	reader := bufio.NewReader(connection)
+	statusLine, err := reader.ReadString('\n')
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	fields := strings.Fields(statusLine)
+	if len(fields) < 2 {
+		h.t.Fatalf("invalid status line %q", statusLine)
+	}
+	statusCode, err := strconv.Atoi(fields[1])
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return statusCode, reader
+}
+
+func TestReloadMountPoints(t *testing.T) {
+	config := DefaultServerConfig()
+	config.Listeners = []ListenerConfig{{Address: "127.0.0.1:0"}}
+	config.MountPoints = []MountPointConfig{{Path: "/kept"}, {Path: "/removed"}}
+	config.DrainTimeout = Duration(time.Second)
+	harness := startStreamingServer(t, config)
+	harness.publish("kept")
+	harness.publish("removed")
+
+	keptClient, keptView := harness.newClient("/kept")
+	defer keptClient.CloseConnection()
+	keptClient.onSetup()
+	keptClient.onPlay()
+	removedClient, removedView := harness.newClient("/removed")
+	defer removedClient.CloseConnection()
+	removedClient.onSetup()
+	removedClient.onPlay()
+	keptView.waitForFrames(t, 10)
+	removedView.waitForFrames(t, 10)
+	if statusCode := harness.describe("/added"); statusCode != 404 {
+		t.Fatalf("DESCRIBE of unknown mount point answered with %v", statusCode)
+	}
+
+	reloaded := *config
+	reloaded.MountPoints = []MountPointConfig{{Path: "/kept"}, {Path: "/added"}}
+	if err := harness.server.Reload(&reloaded); err != nil {
+		t.Fatal(err)
+	}
+	if statusCode := harness.describe("/added"); statusCode != 200 {
+		t.Fatalf("DESCRIBE of added mount point answered with %v", statusCode)
+	}
+	if statusCode := harness.describe("/removed"); statusCode != 404 {
+		t.Fatalf("DESCRIBE of removed mount point answered with %v", statusCode)
+	}
+
+	// session of the removed mount point is served until drain timeout
+	removedView.waitForFrames(t, removedView.count()+5)
+	waitFor(t, "session of removed mount point disconnected", func() bool {
+		return harness.listener.Sessions().SessionCount("removed") == 0
+	})
+
+	keptFrames := keptView.count()
+	keptView.waitForFrames(t, keptFrames+20)
+	harness.checkFrames(keptView)
+	harness.expectSessionState("kept", state.Playing)
+}
+
+func TestSnapshotOfMountPoint(t *testing.T) {
+	config := DefaultServerConfig()
+	config.Listeners = []ListenerConfig{{Address: "127.0.0.1:0"}}
+	config.MountPoints = []MountPointConfig{{Path: "/cam"}}
+	harness := startStreamingServer(t, config)
+	harness.publish("cam")
+	waitFor(t, "published frame", func() bool {
+		frame, _ := harness.server.snapshotCache.Latest("cam")
+		return frame != nil
+	})
+
+	// relative and absolute urls name the same mount point
+	for _, url := range []string{"/cam", fmt.Sprintf("rtsp://%v/cam", net.JoinHostPort(harness.host, harness.port))} {
+		statusCode, frame := harness.snapshot(url)
+		if statusCode != 200 {
+			t.Fatalf("snapshot of %v answered with %v", url, statusCode)
+		}
+		if _, found := harness.frames[string(frame)]; !found {
+			t.Fatalf("snapshot of %v isn't published frame", url)
+		}
+	}
+	if statusCode, _ := harness.snapshot("/other"); statusCode != 404 {
+		t.Fatalf("snapshot of unknown mount point answered with %v", statusCode)
+	}
+}
+
+func TestReloadWithoutMountPointsStopsSources(t *testing.T) {
+	config := DefaultServerConfig()
+	config.Listeners = []ListenerConfig{{Address: "127.0.0.1:0"}}
+	config.MountPoints = []MountPointConfig{{Path: "/cam"}}
+	config.DrainTimeout = Duration(time.Second)
+	harness := startStreamingServer(t, config)
+
+	// source is started the way updateSources does, without webcam
+	source := &syntheticSource{}
+	sourcePublisher := NewSourcePublisher(harness.listener, harness.server.mountPoints.Find("/cam"), source,
+		time.Duration(config.Media.FramePeriod))
+	if err := sourcePublisher.Start(); err != nil {
+		t.Fatal(err)
+	}
+	harness.server.mutex.Lock()
+	harness.server.sourcePublishers["cam"] = sourcePublisher
+	harness.server.mutex.Unlock()
+	waitFor(t, "frame of the source", func() bool {
+		frame, _ := harness.server.snapshotCache.Latest("cam")
+		return frame != nil
+	})
+
+	// every path is allowed without mount points, the removed one is retired anyway
+	reloaded := *config
+	reloaded.MountPoints = nil
+	if err := harness.server.Reload(&reloaded); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "source of removed mount point stopped", func() bool {
+		source.mutex.Lock()
+		defer source.mutex.Unlock()
+		return !source.opened
+	})
+	if statusCode := harness.describe("/other"); statusCode != 200 {
+		t.Fatalf("DESCRIBE without mount points answered with %v", statusCode)
+	}
+}
+
+func TestTranscodeCacheIsDroppedWithLastSession(t *testing.T) {
+	config := DefaultServerConfig()
+	config.Listeners = []ListenerConfig{{Address: "127.0.0.1:0"}}
+	config.MountPoints = []MountPointConfig{{Path: "/cam"}}
+	harness := startStreamingServer(t, config)
+	harness.publish("cam")
+	cache := harness.server.transcodeCache
+	// peek returns current cache of the stream without holding it
+	peek := func() *video.StreamTranscodeCache {
+		stream := cache.Stream("cam")
+		cache.Release(stream)
+		return stream
+	}
+
+	clients := make([]*RtspClient, 0, 2)
+	for index := 0; index < 2; index++ {
+		client := harness.newHeadlessClient("/cam")
+		defer client.CloseConnection()
+		client.onSetup()
+		expectClientState(t, client, state.Ready)
+		clients = append(clients, client)
+	}
+	// sessions of the mount point share cache of its stream
+	stream := peek()
+	if peek() != stream {
+		t.Fatal("cache of the mount point isn't held by its sessions")
+	}
+
+	clients[0].onTeardown()
+	expectClientState(t, clients[0], state.Init)
+	if peek() != stream {
+		t.Fatal("cache of the mount point is dropped while it has session")
+	}
+	clients[1].onTeardown()
+	expectClientState(t, clients[1], state.Init)
+	waitFor(t, "cache of the mount point dropped", func() bool {
+		return peek() != stream
+	})
+}
+
+func TestReloadRejectsInvalidConfig(t *testing.T) {
+	config := DefaultServerConfig()
+	config.Listeners = []ListenerConfig{{Address: "127.0.0.1:0"}}
+	config.MountPoints = []MountPointConfig{{Path: "/kept"}}
+	harness := startStreamingServer(t, config)
+
+	reloaded := *config
+	reloaded.MountPoints = []MountPointConfig{{Path: "invalid"}}
+	if err := harness.server.Reload(&reloaded); err == nil {
+		t.Fatal("invalid configuration applied")
+	}
+	if statusCode := harness.describe("/kept"); statusCode != 200 {
+		t.Fatalf("mount point of the previous configuration answered with %v", statusCode)
+	}
+}
+
+func TestShutdownEndsSessions(t *testing.T) {
+	config := DefaultServerConfig()
+	config.Listeners = []ListenerConfig{{Address: "127.0.0.1:0"}}
+	harness := startStreamingServer(t, config)
+
+	publisher := harness.newHeadlessClient("/published")
+	defer publisher.CloseConnection()
+	publisher.SetFrameSource(&syntheticSource{})
+	publisher.onSetup()
+	publisher.onRecord()
+	harness.expectSessionState("/published", state.Recording)
+
+	viewer, view := harness.newClient("/published")
+	defer viewer.CloseConnection()
+	viewer.onSetup()
+	viewer.onPlay()
+	view.waitForFrames(t, 10)
+	// the server learns where to send BYE from receiver reports
+	waitFor(t, "sender report received", func() bool {
+		viewer.rtcpSender.senderReportMutex.Lock()
+		defer viewer.rtcpSender.senderReportMutex.Unlock()
+		return !viewer.rtcpSender.senderReportTime.IsZero()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+	defer cancel()
+	if err := harness.server.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-viewer.StreamEnded():
+	case <-time.After(waitTimeout):
+		t.Fatal("viewer didn't get BYE")
+	}
+	if running := harness.listener.routines.Running(); len(running) > 0 {
+		t.Fatalf("goroutines still running: %v", running)
+	}
+	if sessions := harness.listener.Sessions().Sessions(); len(sessions) > 0 {
+		t.Fatalf("sessions weren't removed: %+v", sessions)
+	}
+	if _, err := net.Dial("tcp", net.JoinHostPort(harness.host, harness.port)); err == nil {
+		t.Fatal("connection accepted after shutdown")
+	}
+}
+
+func TestShutdownReportsLeakedRoutines(t *testing.T) {
+	routines := newRoutineGroup()
+	release := make(chan bool)
+	routines.Go("stuck", func() { <-release })
+	routines.Go("stuck", func() { <-release })
+	routines.Go("finished", func() {})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	leaked := routines.Wait(ctx)
+	if len(leaked) != 1 || leaked[0] != "stuck (2)" {
+		t.Fatalf("unexpected leaked goroutines: %v", leaked)
+	}
+	close(release)
+	if leaked = routines.Wait(context.Background()); leaked != nil {
+		t.Fatalf("goroutines reported after they returned: %v", leaked)
+	}
+}
@@ -1,6 +1,6 @@
 module streming_server
 
-go 1.14
+go 1.18
 
 require (
 	fyne.io/fyne v1.2.4
@@ -8,5 +8,5 @@ require (
 	github.com/kyroy/priority-queue v0.0.0-20180327160706-6e21825e7e0c
 	github.com/phayes/freeport v0.0.0-20180830031419-95f893ade6f2
 	gocv.io/x/gocv v0.23.0
-	golang.org/x/net v0.0.0-20200324143707-d3edc9973b7e // indirect
+	golang.org/x/net v0.0.0-20200324143707-d3edc9973b7e
 )
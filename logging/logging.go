@@ -0,0 +1,334 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Level of the message, messages below the level of the output are discarded
+type Level int32
+
+const (
+	LevelTrace Level = -8
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+// formats of the output
+const TextFormat = "text"
+const JsonFormat = "json"
+
+// ComponentKey is key of the field naming the component, text format shows it as [COMPONENT] tag
+const ComponentKey = "component"
+
+// SessionKey, RemoteAddressKey and PathKey are keys of the fields which describe RTSP session
+const SessionKey = "session"
+const RemoteAddressKey = "remote"
+const PathKey = "path"
+
+// ErrorKey is key of the field with error of the message
+const ErrorKey = "error"
+
+const textTimeFormat = "2006/01/02 15:04:05.000000"
+
+// badKey replaces key which isn't string
+const badKey = "!BADKEY"
+
+var levelNames = map[Level]string{
+	LevelTrace: "TRACE",
+	LevelDebug: "DEBUG",
+	LevelInfo:  "INFO",
+	LevelWarn:  "WARN",
+	LevelError: "ERROR",
+}
+
+func (l Level) String() string {
+	if name, found := levelNames[l]; found {
+		return name
+	}
+	return fmt.Sprintf("LEVEL(%d)", int32(l))
+}
+
+// ParseLevel returns level of case insensitive name, one of trace, debug, info, warn and error
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+	if strings.EqualFold(name, "warning") {
+		return LevelWarn, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q, expected one of trace, debug, info, warn and error", name)
+}
+
+// ValidateFormat checks that format is either text or json
+func ValidateFormat(format string) error {
+	if format != TextFormat && format != JsonFormat {
+		return fmt.Errorf("unknown log format %q, expected %v or %v", format, TextFormat, JsonFormat)
+	}
+	return nil
+}
+
+// sink is output shared by every logger, so level and format can be changed while components are running
+type sink struct {
+	writer io.Writer
+	json   bool
+	level  int32
+	mutex  sync.Mutex
+}
+
+var output = &sink{writer: os.Stderr, level: int32(LevelInfo)}
+
+// SetOutput sets writer of the messages, standard error is used by default
+func SetOutput(writer io.Writer) {
+	output.mutex.Lock()
+	defer output.mutex.Unlock()
+	output.writer = writer
+}
+
+// SetLevel sets minimal level of written messages, LevelInfo is used by default
+func SetLevel(level Level) {
+	atomic.StoreInt32(&output.level, int32(level))
+}
+
+// SetFormat switches between text and json output, text is used by default
+func SetFormat(format string) error {
+	if err := ValidateFormat(format); err != nil {
+		return err
+	}
+	output.mutex.Lock()
+	defer output.mutex.Unlock()
+	output.json = format == JsonFormat
+	return nil
+}
+
+// Enabled reports whether messages of the level are written
+func Enabled(level Level) bool {
+	return level >= Level(atomic.LoadInt32(&output.level))
+}
+
+type field struct {
+	key   string
+	value interface{}
+}
+
+// Logger writes messages with its fields attached, it is immutable and safe for concurrent use
+type Logger struct {
+	fields []field
+}
+
+var root = &Logger{}
+
+// With returns logger of the fields given as alternating keys and values
+func With(keyValues ...interface{}) *Logger {
+	return root.With(keyValues...)
+}
+
+// With returns copy of the logger extended by the fields, values of existing keys are replaced
+func (l *Logger) With(keyValues ...interface{}) *Logger {
+	fields := make([]field, len(l.fields), len(l.fields)+len(keyValues)/2)
+	copy(fields, l.fields)
+	for _, added := range toFields(keyValues) {
+		replaced := false
+		for index := range fields {
+			if fields[index].key == added.key {
+				fields[index].value = added.value
+				replaced = true
+			}
+		}
+		if !replaced {
+			fields = append(fields, added)
+		}
+	}
+	return &Logger{fields: fields}
+}
+
+func toFields(keyValues []interface{}) []field {
+	result := make([]field, 0, (len(keyValues)+1)/2)
+	for index := 0; index < len(keyValues); index += 2 {
+		key, ok := keyValues[index].(string)
+		if !ok || index+1 == len(keyValues) {
+			result = append(result, field{key: badKey, value: keyValues[index]})
+			index--
+			continue
+		}
+		result = append(result, field{key: key, value: keyValues[index+1]})
+	}
+	return result
+}
+
+func (l *Logger) Enabled(level Level) bool {
+	return Enabled(level)
+}
+
+// Trace is meant for dumps of single packets
+func (l *Logger) Trace(message string, keyValues ...interface{}) {
+	l.Log(LevelTrace, message, keyValues...)
+}
+
+func (l *Logger) Debug(message string, keyValues ...interface{}) {
+	l.Log(LevelDebug, message, keyValues...)
+}
+
+func (l *Logger) Info(message string, keyValues ...interface{}) {
+	l.Log(LevelInfo, message, keyValues...)
+}
+
+func (l *Logger) Warn(message string, keyValues ...interface{}) {
+	l.Log(LevelWarn, message, keyValues...)
+}
+
+func (l *Logger) Error(message string, keyValues ...interface{}) {
+	l.Log(LevelError, message, keyValues...)
+}
+
+// Fatal writes message at error level and exits the process
+func (l *Logger) Fatal(message string, keyValues ...interface{}) {
+	l.Log(LevelError, message, keyValues...)
+	os.Exit(1)
+}
+
+// Log writes message of the level when it is enabled, fields of the call follow fields of the logger
+func (l *Logger) Log(level Level, message string, keyValues ...interface{}) {
+	if !Enabled(level) {
+		return
+	}
+	fields := l.fields
+	if len(keyValues) > 0 {
+		fields = append(append(make([]field, 0, len(l.fields)+len(keyValues)/2), l.fields...),
+			toFields(keyValues)...)
+	}
+	now := time.Now()
+
+	output.mutex.Lock()
+	defer output.mutex.Unlock()
+	var buffer bytes.Buffer
+	if output.json {
+		formatJson(&buffer, now, level, message, fields)
+	} else {
+		formatText(&buffer, now, level, message, fields)
+	}
+	_, _ = output.writer.Write(buffer.Bytes())
+}
+
+// formatText writes line in form: time LEVEL [COMPONENT] message key=value ...
+func formatText(buffer *bytes.Buffer, now time.Time, level Level, message string, fields []field) {
+	buffer.WriteString(now.Format(textTimeFormat))
+	buffer.WriteByte(' ')
+	buffer.WriteString(level.String())
+	for _, field := range fields {
+		if field.key == ComponentKey {
+			fmt.Fprintf(buffer, " [%v]", field.value)
+		}
+	}
+	buffer.WriteByte(' ')
+	buffer.WriteString(message)
+	for _, field := range fields {
+		if field.key == ComponentKey {
+			continue
+		}
+		buffer.WriteByte(' ')
+		buffer.WriteString(field.key)
+		buffer.WriteByte('=')
+		buffer.WriteString(quoteIfNeeded(textValue(field.value)))
+	}
+	buffer.WriteByte('\n')
+}
+
+func textValue(value interface{}) string {
+	switch typedValue := value.(type) {
+	case nil:
+		return "<nil>"
+	case error:
+		return typedValue.Error()
+	case fmt.Stringer:
+		return typedValue.String()
+	case string:
+		return typedValue
+	}
+	return fmt.Sprint(value)
+}
+
+func quoteIfNeeded(value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\n\r\"=") {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+// formatJson writes single JSON object per line, fields keep their order
+func formatJson(buffer *bytes.Buffer, now time.Time, level Level, message string, fields []field) {
+	buffer.WriteString(`{"time":`)
+	writeJsonValue(buffer, now.Format(time.RFC3339Nano))
+	buffer.WriteString(`,"level":`)
+	writeJsonValue(buffer, level.String())
+	buffer.WriteString(`,"msg":`)
+	writeJsonValue(buffer, message)
+	for _, field := range fields {
+		buffer.WriteByte(',')
+		writeJsonValue(buffer, field.key)
+		buffer.WriteByte(':')
+		writeJsonValue(buffer, jsonValue(field.value))
+	}
+	buffer.WriteString("}\n")
+}
+
+func jsonValue(value interface{}) interface{} {
+	switch typedValue := value.(type) {
+	case error:
+		return typedValue.Error()
+	case time.Duration:
+		return typedValue.String()
+	case json.Marshaler:
+		return typedValue
+	case fmt.Stringer:
+		return typedValue.String()
+	}
+	return value
+}
+
+func writeJsonValue(buffer *bytes.Buffer, value interface{}) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		encoded, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buffer.Write(encoded)
+}
+
+// package level functions write messages without fields
+
+func Trace(message string, keyValues ...interface{}) {
+	root.Log(LevelTrace, message, keyValues...)
+}
+
+func Debug(message string, keyValues ...interface{}) {
+	root.Log(LevelDebug, message, keyValues...)
+}
+
+func Info(message string, keyValues ...interface{}) {
+	root.Log(LevelInfo, message, keyValues...)
+}
+
+func Warn(message string, keyValues ...interface{}) {
+	root.Log(LevelWarn, message, keyValues...)
+}
+
+func Error(message string, keyValues ...interface{}) {
+	root.Log(LevelError, message, keyValues...)
+}
+
+func Fatal(message string, keyValues ...interface{}) {
+	root.Log(LevelError, message, keyValues...)
+	os.Exit(1)
+}
@@ -0,0 +1,96 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureOutput redirects messages to the buffer until the test ends
+func captureOutput(t *testing.T, level Level, format string) *bytes.Buffer {
+	var buffer bytes.Buffer
+	SetOutput(&buffer)
+	SetLevel(level)
+	if err := SetFormat(format); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		SetOutput(os.Stderr)
+		SetLevel(LevelInfo)
+		_ = SetFormat(TextFormat)
+	})
+	return &buffer
+}
+
+func TestTextFormat(t *testing.T) {
+	buffer := captureOutput(t, LevelDebug, TextFormat)
+	session := With(ComponentKey, "RTSP", SessionKey, "1f2e", RemoteAddressKey, "10.0.0.1:5000")
+	rtp := session.With(ComponentKey, "RTP", PathKey, "/live")
+
+	rtp.Trace("sent packet")
+	rtp.Debug("sent frame", "size", 1200, "interval", 33*time.Millisecond)
+	session.Error("cannot send response", ErrorKey, errors.New("broken pipe"), "reason", "")
+
+	lines := strings.Split(strings.TrimSuffix(buffer.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got:\n%v", buffer.String())
+	}
+	expected := []string{
+		"DEBUG [RTP] sent frame session=1f2e remote=10.0.0.1:5000 path=/live size=1200 interval=33ms",
+		`ERROR [RTSP] cannot send response session=1f2e remote=10.0.0.1:5000 error="broken pipe" reason=""`,
+	}
+	for index, line := range lines {
+		// time is followed by the level
+		if !strings.HasSuffix(line, expected[index]) {
+			t.Errorf("line %q doesn't end with %q", line, expected[index])
+		}
+	}
+}
+
+func TestJsonFormat(t *testing.T) {
+	buffer := captureOutput(t, LevelInfo, JsonFormat)
+	With(ComponentKey, "RTCP", SessionKey, "1f2e").Info("round trip time", "rtt", 20*time.Millisecond,
+		"lost", 0.25, ErrorKey, errors.New("late"), 7)
+
+	var message map[string]interface{}
+	if err := json.Unmarshal(buffer.Bytes(), &message); err != nil {
+		t.Fatalf("invalid json %q: %v", buffer.String(), err)
+	}
+	expected := map[string]interface{}{
+		"level":      "INFO",
+		"msg":        "round trip time",
+		ComponentKey: "RTCP",
+		SessionKey:   "1f2e",
+		"rtt":        "20ms",
+		"lost":       0.25,
+		ErrorKey:     "late",
+		badKey:       float64(7),
+	}
+	for key, value := range expected {
+		if message[key] != value {
+			t.Errorf("field %v is %v, expected %v", key, message[key], value)
+		}
+	}
+	if _, err := time.Parse(time.RFC3339Nano, message["time"].(string)); err != nil {
+		t.Errorf("invalid time: %v", err)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for name, expected := range map[string]Level{"trace": LevelTrace, "DEBUG": LevelDebug, "Info": LevelInfo,
+		"warn": LevelWarn, "warning": LevelWarn, "error": LevelError} {
+		if level, err := ParseLevel(name); err != nil || level != expected {
+			t.Errorf("level %q parsed as %v, %v", name, level, err)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("unknown level accepted")
+	}
+	if err := ValidateFormat("xml"); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
@@ -0,0 +1,425 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"streming_server/logging"
+	"strings"
+	"sync"
+)
+
+// ContentType of the text exposition format understood by Prometheus
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// labelSeparator joins label values into key of the sample, it can't appear in valid UTF-8 text
+const labelSeparator = "\xff"
+
+var httpLogger = logging.With(logging.ComponentKey, "HTTP")
+
+// Default registry holds metrics of the streaming components
+var Default = NewRegistry()
+
+type metric interface {
+	write(writer *bufio.Writer)
+	reset()
+}
+
+type collector struct {
+	collect func()
+}
+
+// Registry keeps metrics in order of registration and writes them in the text exposition format,
+// collectors fill sampled gauges right before the metrics are written, collections are serialized,
+// so sampled gauges are never reset by concurrent scrape
+type Registry struct {
+	metrics      []metric
+	names        map[string]bool
+	sampled      []metric
+	collectors   []*collector
+	mutex        sync.Mutex
+	collectMutex sync.Mutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		names: make(map[string]bool),
+	}
+}
+
+func (r *Registry) register(name string, metric metric, sampled bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.names[name] {
+		panic("metrics: metric " + name + " registered twice")
+	}
+	r.names[name] = true
+	r.metrics = append(r.metrics, metric)
+	if sampled {
+		r.sampled = append(r.sampled, metric)
+	}
+}
+
+// OnCollect adds function called before every collection, it returns function which removes it
+func (r *Registry) OnCollect(collect func()) func() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	added := &collector{collect: collect}
+	r.collectors = append(r.collectors, added)
+	return func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		for index, registered := range r.collectors {
+			if registered == added {
+				r.collectors = append(r.collectors[:index:index], r.collectors[index+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Write resets sampled gauges, runs collectors and writes every metric in the text exposition format
+func (r *Registry) Write(writer io.Writer) error {
+	r.collectMutex.Lock()
+	defer r.collectMutex.Unlock()
+	r.mutex.Lock()
+	metrics := r.metrics
+	sampled := r.sampled
+	collectors := r.collectors
+	r.mutex.Unlock()
+
+	for _, metric := range sampled {
+		metric.reset()
+	}
+	for _, collector := range collectors {
+		collector.collect()
+	}
+	bufferedWriter := bufio.NewWriter(writer)
+	for _, metric := range metrics {
+		metric.write(bufferedWriter)
+	}
+	return bufferedWriter.Flush()
+}
+
+// ServeHTTP answers scrape of Prometheus
+func (r *Registry) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		writer.Header().Set("Allow", "GET, HEAD")
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writer.Header().Set("Content-Type", ContentType)
+	if err := r.Write(writer); err != nil {
+		httpLogger.Error("error while sending metrics", logging.ErrorKey, err)
+	}
+}
+
+// family holds samples of single metric distinguished by values of its labels
+type family struct {
+	name       string
+	help       string
+	metricType string
+	labelNames []string
+	samples    map[string]*sample
+	mutex      sync.Mutex
+}
+
+// sample of histogram counts observations per bucket, the counts aren't cumulative, and value is their sum
+type sample struct {
+	labelValues  []string
+	value        float64
+	bucketCounts []uint64
+	count        uint64
+}
+
+func newFamily(name string, help string, metricType string, labelNames []string) *family {
+	return &family{
+		name:       name,
+		help:       help,
+		metricType: metricType,
+		labelNames: labelNames,
+		samples:    make(map[string]*sample),
+	}
+}
+
+// sample has to be called with the mutex locked
+func (f *family) sample(labelValues []string) *sample {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metrics: %v expects %v label values, got %v", f.name, len(f.labelNames),
+			len(labelValues)))
+	}
+	key := strings.Join(labelValues, labelSeparator)
+	result, found := f.samples[key]
+	if !found {
+		result = &sample{labelValues: append([]string(nil), labelValues...)}
+		f.samples[key] = result
+	}
+	return result
+}
+
+// find returns copy of the sample without creating it, zero sample when there is none
+func (f *family) find(labelValues []string) sample {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if found := f.samples[strings.Join(labelValues, labelSeparator)]; found != nil {
+		return *found
+	}
+	return sample{}
+}
+
+func (f *family) reset() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.samples = make(map[string]*sample)
+}
+
+// sortedSamples returns copy of the samples ordered by label values, it has to be called with the mutex locked
+func (f *family) sortedSamples() []sample {
+	keys := make([]string, 0, len(f.samples))
+	for key := range f.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	result := make([]sample, 0, len(keys))
+	for _, key := range keys {
+		copied := *f.samples[key]
+		copied.bucketCounts = append([]uint64(nil), copied.bucketCounts...)
+		result = append(result, copied)
+	}
+	return result
+}
+
+func (f *family) writeHeader(writer *bufio.Writer) {
+	fmt.Fprintf(writer, "# HELP %v %v\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(writer, "# TYPE %v %v\n", f.name, f.metricType)
+}
+
+func (f *family) writeSimple(writer *bufio.Writer) {
+	f.mutex.Lock()
+	samples := f.sortedSamples()
+	f.mutex.Unlock()
+	f.writeHeader(writer)
+	for _, sample := range samples {
+		writeSample(writer, f.name, f.labelNames, sample.labelValues, "", "", sample.value)
+	}
+}
+
+// writeSample writes single line, extra label is appended to the labels of the family when its name isn't empty
+func writeSample(writer *bufio.Writer, name string, labelNames []string, labelValues []string, extraName string,
+	extraValue string, value float64) {
+	writer.WriteString(name)
+	if len(labelNames) > 0 || extraName != "" {
+		writer.WriteByte('{')
+		for index, labelName := range labelNames {
+			if index > 0 {
+				writer.WriteByte(',')
+			}
+			fmt.Fprintf(writer, `%v="%v"`, labelName, escapeLabelValue(labelValues[index]))
+		}
+		if extraName != "" {
+			if len(labelNames) > 0 {
+				writer.WriteByte(',')
+			}
+			fmt.Fprintf(writer, `%v="%v"`, extraName, escapeLabelValue(extraValue))
+		}
+		writer.WriteByte('}')
+	}
+	writer.WriteByte(' ')
+	writer.WriteString(formatValue(value))
+	writer.WriteByte('\n')
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var helpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(help string) string {
+	return helpReplacer.Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return labelValueReplacer.Replace(value)
+}
+
+// Counter is monotonically increasing value per combination of label values
+type Counter struct {
+	family *family
+}
+
+// NewCounter registers counter in the default registry
+func NewCounter(name string, help string, labelNames ...string) *Counter {
+	return Default.NewCounter(name, help, labelNames...)
+}
+
+func (r *Registry) NewCounter(name string, help string, labelNames ...string) *Counter {
+	result := &Counter{family: newFamily(name, help, "counter", labelNames)}
+	r.register(name, result, false)
+	return result
+}
+
+// Add increases counter by non-negative value, label values follow order of label names
+func (c *Counter) Add(value float64, labelValues ...string) {
+	if value < 0 {
+		panic("metrics: counter " + c.family.name + " can't decrease")
+	}
+	c.family.mutex.Lock()
+	defer c.family.mutex.Unlock()
+	c.family.sample(labelValues).value += value
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Value returns current value of the counter
+func (c *Counter) Value(labelValues ...string) float64 {
+	return c.family.find(labelValues).value
+}
+
+func (c *Counter) write(writer *bufio.Writer) {
+	c.family.writeSimple(writer)
+}
+
+func (c *Counter) reset() {
+	c.family.reset()
+}
+
+// Gauge is value which may go up and down per combination of label values
+type Gauge struct {
+	family *family
+}
+
+// NewGauge registers gauge in the default registry
+func NewGauge(name string, help string, labelNames ...string) *Gauge {
+	return Default.NewGauge(name, help, labelNames...)
+}
+
+// NewSampledGauge registers gauge in the default registry, whose values are reset before every collection,
+// so they are filled by collectors only
+func NewSampledGauge(name string, help string, labelNames ...string) *Gauge {
+	return Default.NewSampledGauge(name, help, labelNames...)
+}
+
+func (r *Registry) NewGauge(name string, help string, labelNames ...string) *Gauge {
+	result := &Gauge{family: newFamily(name, help, "gauge", labelNames)}
+	r.register(name, result, false)
+	return result
+}
+
+func (r *Registry) NewSampledGauge(name string, help string, labelNames ...string) *Gauge {
+	result := &Gauge{family: newFamily(name, help, "gauge", labelNames)}
+	r.register(name, result, true)
+	return result
+}
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.family.mutex.Lock()
+	defer g.family.mutex.Unlock()
+	g.family.sample(labelValues).value = value
+}
+
+func (g *Gauge) Add(value float64, labelValues ...string) {
+	g.family.mutex.Lock()
+	defer g.family.mutex.Unlock()
+	g.family.sample(labelValues).value += value
+}
+
+// Value returns current value of the gauge
+func (g *Gauge) Value(labelValues ...string) float64 {
+	return g.family.find(labelValues).value
+}
+
+func (g *Gauge) write(writer *bufio.Writer) {
+	g.family.writeSimple(writer)
+}
+
+func (g *Gauge) reset() {
+	g.family.reset()
+}
+
+// Histogram counts observations in buckets given by their upper bounds
+type Histogram struct {
+	family  *family
+	buckets []float64
+}
+
+// NewHistogram registers histogram in the default registry, buckets have to be sorted in increasing order,
+// +Inf bucket is added implicitly
+func NewHistogram(name string, help string, buckets []float64, labelNames ...string) *Histogram {
+	return Default.NewHistogram(name, help, buckets, labelNames...)
+}
+
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labelNames ...string) *Histogram {
+	if !sort.Float64sAreSorted(buckets) {
+		panic("metrics: buckets of " + name + " aren't sorted")
+	}
+	for _, labelName := range labelNames {
+		if labelName == "le" {
+			panic("metrics: histogram " + name + " can't use label le")
+		}
+	}
+	result := &Histogram{
+		family:  newFamily(name, help, "histogram", labelNames),
+		buckets: buckets,
+	}
+	r.register(name, result, false)
+	return result
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.family.mutex.Lock()
+	defer h.family.mutex.Unlock()
+	sample := h.family.sample(labelValues)
+	if sample.bucketCounts == nil {
+		sample.bucketCounts = make([]uint64, len(h.buckets))
+	}
+	if index := sort.SearchFloat64s(h.buckets, value); index < len(h.buckets) {
+		sample.bucketCounts[index]++
+	}
+	sample.value += value
+	sample.count++
+}
+
+// Count returns number of observations
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	return h.family.find(labelValues).count
+}
+
+func (h *Histogram) write(writer *bufio.Writer) {
+	h.family.mutex.Lock()
+	samples := h.family.sortedSamples()
+	h.family.mutex.Unlock()
+	h.family.writeHeader(writer)
+	for _, sample := range samples {
+		cumulativeCount := uint64(0)
+		for index, upperBound := range h.buckets {
+			cumulativeCount += sample.bucketCounts[index]
+			writeSample(writer, h.family.name+"_bucket", h.family.labelNames, sample.labelValues, "le",
+				formatValue(upperBound), float64(cumulativeCount))
+		}
+		writeSample(writer, h.family.name+"_bucket", h.family.labelNames, sample.labelValues, "le", "+Inf",
+			float64(sample.count))
+		writeSample(writer, h.family.name+"_sum", h.family.labelNames, sample.labelValues, "", "", sample.value)
+		writeSample(writer, h.family.name+"_count", h.family.labelNames, sample.labelValues, "", "",
+			float64(sample.count))
+	}
+}
+
+func (h *Histogram) reset() {
+	h.family.reset()
+}
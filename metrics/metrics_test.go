@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTextExposition(t *testing.T) {
+	registry := NewRegistry()
+	packets := registry.NewCounter("rtp_packets_total", "Sent packets.", "stream")
+	sessions := registry.NewSampledGauge("sessions", "Sessions by state.", "state")
+	latency := registry.NewHistogram("latency_seconds", "Latency\nof frames.", []float64{0.1, 0.5})
+
+	packets.Add(3, `/live "hall"`)
+	packets.Inc("/garden")
+	sessions.Set(7, "STALE")
+	removeCollector := registry.OnCollect(func() {
+		sessions.Add(1, "PLAYING")
+		sessions.Add(1, "PLAYING")
+	})
+	latency.Observe(0.1)
+	latency.Observe(0.3)
+	latency.Observe(2)
+
+	var output bytes.Buffer
+	if err := registry.Write(&output); err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join([]string{
+		"# HELP rtp_packets_total Sent packets.",
+		"# TYPE rtp_packets_total counter",
+		`rtp_packets_total{stream="/garden"} 1`,
+		`rtp_packets_total{stream="/live \"hall\""} 3`,
+		"# HELP sessions Sessions by state.",
+		"# TYPE sessions gauge",
+		`sessions{state="PLAYING"} 2`,
+		`# HELP latency_seconds Latency\nof frames.`,
+		"# TYPE latency_seconds histogram",
+		`latency_seconds_bucket{le="0.1"} 1`,
+		`latency_seconds_bucket{le="0.5"} 2`,
+		`latency_seconds_bucket{le="+Inf"} 3`,
+		"latency_seconds_sum 2.4",
+		"latency_seconds_count 3",
+		"",
+	}, "\n")
+	if output.String() != expected {
+		t.Fatalf("unexpected exposition:\n%v\nexpected:\n%v", output.String(), expected)
+	}
+
+	removeCollector()
+	output.Reset()
+	if err := registry.Write(&output); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(output.String(), "sessions{") {
+		t.Fatalf("sampled gauge kept values of removed collector:\n%v", output.String())
+	}
+}
+
+func TestServeMetrics(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewCounter("requests_total", "Requests.").Inc()
+	server := httptest.NewServer(registry)
+	defer server.Close()
+
+	response, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != ContentType {
+		t.Fatalf("unexpected response %v with content type %q", response.Status, response.Header.Get("Content-Type"))
+	}
+	response, err = http.Post(server.URL, "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("POST answered with %v", response.Status)
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewGauge("depth", "Depth.")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("metric registered twice")
+		}
+	}()
+	registry.NewCounter("depth", "Depth.")
+}
@@ -0,0 +1,268 @@
+package netem
+
+import (
+	"math/rand"
+	"net"
+	"streming_server/logging"
+	"sync"
+	"time"
+)
+
+var netemLogger = logging.With(logging.ComponentKey, "NETEM")
+
+// DefaultQueueDelay limits queue of bandwidth capped link, packets which would wait longer are dropped
+const DefaultQueueDelay = 300 * time.Millisecond
+
+const maxDatagramSize = 65507
+
+// Impairment describes conditions of emulated network path
+type Impairment struct {
+	// probability of losing packet, ignored when bursty loss model is set
+	Loss float64
+	// bursty loss model, used when its transition probability P is greater than 0
+	BurstLoss GilbertElliott
+	Delay     time.Duration
+	// delay of every packet is drawn uniformly from Delay ± Jitter, so packets may be reordered as well
+	Jitter time.Duration
+	// probability that packet skips the delay and overtakes packets sent before it
+	Reorder   float64
+	Duplicate float64
+	// link capacity in bits per second, 0 means unlimited
+	Bandwidth int
+	// DefaultQueueDelay is used when 0
+	QueueDelay time.Duration
+	// seed of random generator, 0 uses current time
+	Seed int64
+}
+
+// GilbertElliott is two-state Markov loss model, packets are lost with LossGood probability in good state
+// and with LossBad probability in bad state
+type GilbertElliott struct {
+	// probability of transition from good to bad state per packet
+	P float64
+	// probability of transition from bad to good state per packet
+	R        float64
+	LossGood float64
+	LossBad  float64
+}
+
+// AverageLoss returns long-term loss probability of the model
+func (m GilbertElliott) AverageLoss() float64 {
+	if m.P+m.R == 0 {
+		return m.LossGood
+	}
+	badProbability := m.P / (m.P + m.R)
+	return (1-badProbability)*m.LossGood + badProbability*m.LossBad
+}
+
+// RelayStats counts packets passing the relay in forward direction
+type RelayStats struct {
+	Received       int64
+	ReceivedBytes  int64
+	Lost           int64
+	QueueDropped   int64
+	Duplicated     int64
+	Reordered      int64
+	Forwarded      int64
+	ForwardedBytes int64
+}
+
+// Relay forwards UDP datagrams from listening address to destination and applies impairment on the way,
+// datagrams sent back by the destination are returned to the last sender without impairment
+type Relay struct {
+	listenConn      *net.UDPConn
+	destinationConn *net.UDPConn
+	impairment      Impairment
+	random          *rand.Rand
+	burstLossBad    bool
+	linkFreeAt      time.Time
+	senderAddress   *net.UDPAddr
+	stats           RelayStats
+	closed          bool
+	mutex           sync.Mutex
+}
+
+func NewRelay(listenAddress string, destinationAddress string, impairment Impairment) (*Relay, error) {
+	localAddress, err := net.ResolveUDPAddr("udp", listenAddress)
+	if err != nil {
+		return nil, err
+	}
+	remoteAddress, err := net.ResolveUDPAddr("udp", destinationAddress)
+	if err != nil {
+		return nil, err
+	}
+	listenConn, err := net.ListenUDP("udp", localAddress)
+	if err != nil {
+		return nil, err
+	}
+	destinationConn, err := net.DialUDP("udp", nil, remoteAddress)
+	if err != nil {
+		listenConn.Close()
+		return nil, err
+	}
+	relay := &Relay{
+		listenConn:      listenConn,
+		destinationConn: destinationConn,
+	}
+	relay.SetImpairment(impairment)
+	return relay, nil
+}
+
+// Address returns address on which relay receives datagrams
+func (r *Relay) Address() *net.UDPAddr {
+	return r.listenConn.LocalAddr().(*net.UDPAddr)
+}
+
+// SetImpairment changes conditions of the path, packets already on the way keep their delivery time
+func (r *Relay) SetImpairment(impairment Impairment) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if impairment.QueueDelay == 0 {
+		impairment.QueueDelay = DefaultQueueDelay
+	}
+	if r.random == nil || impairment.Seed != r.impairment.Seed {
+		seed := impairment.Seed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		r.random = rand.New(rand.NewSource(seed))
+	}
+	r.impairment = impairment
+}
+
+func (r *Relay) Stats() RelayStats {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.stats
+}
+
+func (r *Relay) Start() {
+	go r.forward()
+	go r.returnResponses()
+}
+
+// forward runs until the relay is closed
+func (r *Relay) forward() {
+	buffer := make([]byte, maxDatagramSize)
+	for {
+		packetLength, address, err := r.listenConn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		packet := append([]byte(nil), buffer[:packetLength]...)
+		r.mutex.Lock()
+		r.senderAddress = address
+		r.mutex.Unlock()
+		r.impair(packet)
+	}
+}
+
+// returnResponses runs until the relay is closed
+func (r *Relay) returnResponses() {
+	buffer := make([]byte, maxDatagramSize)
+	for {
+		packetLength, err := r.destinationConn.Read(buffer)
+		if err != nil {
+			return
+		}
+		r.mutex.Lock()
+		address := r.senderAddress
+		r.mutex.Unlock()
+		if address != nil {
+			_, _ = r.listenConn.WriteToUDP(buffer[:packetLength], address)
+		}
+	}
+}
+
+func (r *Relay) impair(packet []byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.stats.Received++
+	r.stats.ReceivedBytes += int64(len(packet))
+	if r.lost() {
+		r.stats.Lost++
+		return
+	}
+	copies := 1
+	if r.random.Float64() < r.impairment.Duplicate {
+		copies = 2
+		r.stats.Duplicated++
+	}
+
+	now := time.Now()
+	for sent := 0; sent < copies; sent++ {
+		departure := now
+		if r.impairment.Bandwidth > 0 {
+			if r.linkFreeAt.Before(now) {
+				r.linkFreeAt = now
+			}
+			if r.linkFreeAt.Sub(now) > r.impairment.QueueDelay {
+				r.stats.QueueDropped++
+				continue
+			}
+			r.linkFreeAt = r.linkFreeAt.Add(time.Duration(len(packet)) * 8 * time.Second /
+				time.Duration(r.impairment.Bandwidth))
+			departure = r.linkFreeAt
+		}
+		delay := r.impairment.Delay
+		if r.impairment.Jitter > 0 {
+			delay += time.Duration(r.random.Int63n(int64(2*r.impairment.Jitter+1))) - r.impairment.Jitter
+		}
+		if r.random.Float64() < r.impairment.Reorder {
+			delay = 0
+			r.stats.Reordered++
+		}
+		if delay < 0 {
+			delay = 0
+		}
+		r.deliverAt(packet, departure.Add(delay))
+	}
+}
+
+// lost draws loss of the packet from the bursty model or from the random loss probability
+func (r *Relay) lost() bool {
+	model := r.impairment.BurstLoss
+	if model.P <= 0 {
+		return r.random.Float64() < r.impairment.Loss
+	}
+	if r.burstLossBad {
+		r.burstLossBad = r.random.Float64() >= model.R
+	} else {
+		r.burstLossBad = r.random.Float64() < model.P
+	}
+	if r.burstLossBad {
+		return r.random.Float64() < model.LossBad
+	}
+	return r.random.Float64() < model.LossGood
+}
+
+func (r *Relay) deliverAt(packet []byte, deliveryTime time.Time) {
+	time.AfterFunc(time.Until(deliveryTime), func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		if r.closed {
+			return
+		}
+		_, err := r.destinationConn.Write(packet)
+		if err != nil {
+			netemLogger.Error("error while forwarding packet", logging.ErrorKey, err)
+			return
+		}
+		r.stats.Forwarded++
+		r.stats.ForwardedBytes += int64(len(packet))
+	})
+}
+
+func (r *Relay) Close() {
+	r.mutex.Lock()
+	r.closed = true
+	r.mutex.Unlock()
+	err := r.listenConn.Close()
+	if err != nil {
+		netemLogger.Error("error while closing connection", logging.ErrorKey, err)
+	}
+	err = r.destinationConn.Close()
+	if err != nil {
+		netemLogger.Error("error while closing connection", logging.ErrorKey, err)
+	}
+}
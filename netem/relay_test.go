@@ -0,0 +1,83 @@
+package netem
+
+import (
+	"math"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGilbertElliottLossRate(t *testing.T) {
+	model := GilbertElliott{P: 0.05, R: 0.25, LossBad: 0.9}
+	relay := &Relay{
+		impairment: Impairment{BurstLoss: model},
+		random:     rand.New(rand.NewSource(1)),
+	}
+	lost, bursts := 0, 0
+	previousLost := false
+	const packets = 200000
+	for i := 0; i < packets; i++ {
+		packetLost := relay.lost()
+		if packetLost {
+			lost++
+			if !previousLost {
+				bursts++
+			}
+		}
+		previousLost = packetLost
+	}
+	lossRate := float64(lost) / packets
+	if math.Abs(lossRate-model.AverageLoss()) > 0.01 {
+		t.Errorf("loss rate %.3f, expected %.3f", lossRate, model.AverageLoss())
+	}
+	// random loss of the same rate would produce bursts of 1.2 packets on average
+	if averageBurst := float64(lost) / float64(bursts); averageBurst < 2 {
+		t.Errorf("losses aren't bursty, average burst length %.2f", averageBurst)
+	}
+}
+
+func TestRelayBandwidthCap(t *testing.T) {
+	destination, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer destination.Close()
+	relay, err := NewRelay("127.0.0.1:0", destination.LocalAddr().String(), Impairment{
+		Bandwidth:  1000 * 1000,
+		QueueDelay: time.Second,
+		Seed:       1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay.Start()
+	defer relay.Close()
+
+	sender, err := net.DialUDP("udp", nil, relay.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	// 40 packets of 10000 bits need 400 ms on 1 Mbps link
+	const packets = 40
+	start := time.Now()
+	for i := 0; i < packets; i++ {
+		_, err = sender.Write(make([]byte, 1250))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	buffer := make([]byte, maxDatagramSize)
+	_ = destination.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for i := 0; i < packets; i++ {
+		_, err = destination.Read(buffer)
+		if err != nil {
+			t.Fatalf("received %v of %v packets: %v", i, packets, err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond {
+		t.Errorf("packets passed capped link in %v", elapsed)
+	}
+}
@@ -0,0 +1,139 @@
+package fec
+
+import (
+	"encoding/binary"
+	"errors"
+	"streming_server/protocol/rtp"
+)
+
+// HeaderSize is size of FEC header and single ULP level header with short (16 bit) mask
+const HeaderSize = 14
+
+// MaxGroupSize is number of packets which can be protected with short mask
+const MaxGroupSize = 16
+
+const levelHeaderOffset = 10
+const rtpLengthOffset = 8
+
+// Packet is ULPFEC packet (RFC 5109) with single protection level covering whole payloads of protected packets
+type Packet struct {
+	// XOR of P, X and CC fields of protected packets
+	RecoveryFlags byte
+	// XOR of M and PT fields of protected packets
+	RecoveryMarkerType byte
+	SeqNumBase         uint16
+	RecoveryTimestamp  uint32
+	RecoveryLength     uint16
+	Mask               uint16
+	Payload            []byte
+}
+
+// NewPacket creates parity packet protecting given media packets, sequence numbers of protected packets
+// can't be farther than MaxGroupSize - 1 from the first one
+func NewPacket(protectedPackets []*rtp.Packet) (*Packet, error) {
+	if len(protectedPackets) == 0 {
+		return nil, errors.New("no packets to protect")
+	}
+	result := &Packet{
+		SeqNumBase: uint16(protectedPackets[0].Header.SequenceNumber),
+		Payload:    make([]byte, 0),
+	}
+	for _, packet := range protectedPackets {
+		offset := uint16(packet.Header.SequenceNumber) - result.SeqNumBase
+		if offset >= MaxGroupSize {
+			return nil, errors.New("protected packets out of mask range")
+		}
+		result.Mask |= 0x8000 >> offset
+		result.xorPacket(packet.Header.TransformToBytes(), packet.Payload)
+	}
+	return result, nil
+}
+
+func NewPacketFromBytes(payload []byte) (*Packet, error) {
+	if len(payload) < HeaderSize {
+		return nil, errors.New("fec packet too short")
+	}
+	if payload[0]&0x80 != 0 || payload[0]&0x40 != 0 {
+		return nil, errors.New("unsupported fec header extension or long mask")
+	}
+	protectionLength := int(binary.BigEndian.Uint16(payload[levelHeaderOffset : levelHeaderOffset+2]))
+	if len(payload) < HeaderSize+protectionLength {
+		return nil, errors.New("fec payload shorter than protection length")
+	}
+	return &Packet{
+		RecoveryFlags:      payload[0] & 0x3F,
+		RecoveryMarkerType: payload[1],
+		SeqNumBase:         binary.BigEndian.Uint16(payload[2:4]),
+		RecoveryTimestamp:  binary.BigEndian.Uint32(payload[4:8]),
+		RecoveryLength:     binary.BigEndian.Uint16(payload[rtpLengthOffset : rtpLengthOffset+2]),
+		Mask:               binary.BigEndian.Uint16(payload[levelHeaderOffset+2 : levelHeaderOffset+4]),
+		Payload:            payload[HeaderSize : HeaderSize+protectionLength],
+	}, nil
+}
+
+func (packet *Packet) xorPacket(headerBytes []byte, payload []byte) {
+	packet.RecoveryFlags ^= headerBytes[0] & 0x3F
+	packet.RecoveryMarkerType ^= headerBytes[1]
+	packet.RecoveryTimestamp ^= binary.BigEndian.Uint32(headerBytes[4:8])
+	packet.RecoveryLength ^= uint16(len(payload))
+	if len(payload) > len(packet.Payload) {
+		packet.Payload = append(packet.Payload, make([]byte, len(payload)-len(packet.Payload))...)
+	}
+	for index, item := range payload {
+		packet.Payload[index] ^= item
+	}
+}
+
+// ProtectedSeqNums returns sequence numbers of packets covered by the mask
+func (packet *Packet) ProtectedSeqNums() []int {
+	result := make([]int, 0, MaxGroupSize)
+	for offset := uint16(0); offset < MaxGroupSize; offset++ {
+		if packet.Mask&(0x8000>>offset) != 0 {
+			result = append(result, int(packet.SeqNumBase+offset))
+		}
+	}
+	return result
+}
+
+// Recover rebuilds single missing packet from parity and all other protected packets
+func (packet *Packet) Recover(receivedPackets []*rtp.Packet, missingSeqNum int) (*rtp.Packet, error) {
+	if len(receivedPackets) == 0 {
+		return nil, errors.New("no packets available for recovery")
+	}
+	recovery := &Packet{
+		RecoveryFlags:      packet.RecoveryFlags,
+		RecoveryMarkerType: packet.RecoveryMarkerType,
+		RecoveryTimestamp:  packet.RecoveryTimestamp,
+		RecoveryLength:     packet.RecoveryLength,
+		Payload:            append([]byte(nil), packet.Payload...),
+	}
+	for _, received := range receivedPackets {
+		recovery.xorPacket(received.Header.TransformToBytes(), received.Payload)
+	}
+	if int(recovery.RecoveryLength) > len(recovery.Payload) {
+		return nil, errors.New("recovered length exceeds protection length")
+	}
+
+	// version is not protected, ssrc is shared by all packets of the media stream
+	headerBytes := receivedPackets[0].Header.TransformToBytes()
+	headerBytes[0] = 2<<6 | recovery.RecoveryFlags
+	headerBytes[1] = recovery.RecoveryMarkerType
+	binary.BigEndian.PutUint16(headerBytes[2:4], uint16(missingSeqNum))
+	binary.BigEndian.PutUint32(headerBytes[4:8], recovery.RecoveryTimestamp)
+
+	packetBytes := append(headerBytes, recovery.Payload[:recovery.RecoveryLength]...)
+	return rtp.NewPacketFromBytes(packetBytes, len(packetBytes))
+}
+
+func (packet *Packet) TransformToBytes() []byte {
+	result := make([]byte, HeaderSize+len(packet.Payload))
+	result[0] = packet.RecoveryFlags & 0x3F
+	result[1] = packet.RecoveryMarkerType
+	binary.BigEndian.PutUint16(result[2:4], packet.SeqNumBase)
+	binary.BigEndian.PutUint32(result[4:8], packet.RecoveryTimestamp)
+	binary.BigEndian.PutUint16(result[rtpLengthOffset:rtpLengthOffset+2], packet.RecoveryLength)
+	binary.BigEndian.PutUint16(result[levelHeaderOffset:levelHeaderOffset+2], uint16(len(packet.Payload)))
+	binary.BigEndian.PutUint16(result[levelHeaderOffset+2:levelHeaderOffset+4], packet.Mask)
+	copy(result[HeaderSize:], packet.Payload)
+	return result
+}
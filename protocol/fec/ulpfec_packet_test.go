@@ -0,0 +1,38 @@
+package fec
+
+import (
+	"reflect"
+	"streming_server/protocol/rtp"
+	"testing"
+)
+
+func FuzzNewPacketFromBytes(f *testing.F) {
+	protectedPackets := []*rtp.Packet{
+		rtp.NewPacket(rtp.NewHeader(26, 100, 3000), 3, []byte{1, 2, 3}),
+		rtp.NewPacket(rtp.NewHeader(26, 101, 6000), 5, []byte{4, 5, 6, 7, 8}),
+	}
+	packet, err := NewPacket(protectedPackets)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(packet.TransformToBytes())
+	f.Add(packet.TransformToBytes()[:HeaderSize])
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		packet, err := NewPacketFromBytes(payload)
+		if err != nil {
+			return
+		}
+		parsed, err := NewPacketFromBytes(packet.TransformToBytes())
+		if err != nil {
+			t.Fatal("serialized fec packet can't be parsed:", err)
+		}
+		if !reflect.DeepEqual(packet, parsed) {
+			t.Fatalf("fec packet %v parsed as %v", packet, parsed)
+		}
+		// recovery must fail gracefully whatever the parity packet claims
+		for _, seqNum := range packet.ProtectedSeqNums() {
+			_, _ = packet.Recover(protectedPackets[:1], seqNum)
+		}
+	})
+}
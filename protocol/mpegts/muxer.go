@@ -0,0 +1,217 @@
+package mpegts
+
+import (
+	"bytes"
+	"time"
+)
+
+const PacketSize = 188
+
+const (
+	patPid   = 0x0000
+	pmtPid   = 0x1000
+	videoPid = 0x0100
+
+	// jpeg frames are carried as private data, registration descriptor identifies the format,
+	// PES packets use stream id of video, so length of long frames may be left unspecified
+	privateStreamType = 0x06
+	videoStreamId     = 0xE0
+	registrationTag   = 0x05
+	programNumber     = 1
+
+	// 90 kHz clock used by PTS and PCR
+	clockRate = 90000
+	// PTS is delayed against PCR to give decoders time for buffering
+	ptsOffset = 100 * time.Millisecond
+)
+
+// Muxer writes jpeg frames into MPEG transport stream, each frame is a separate PES packet
+type Muxer struct {
+	buffer             *bytes.Buffer
+	continuityCounters map[int]byte
+	tablesWritten      bool
+}
+
+func NewMuxer() *Muxer {
+	return &Muxer{
+		buffer:             new(bytes.Buffer),
+		continuityCounters: make(map[int]byte),
+	}
+}
+
+func (m *Muxer) nextContinuityCounter(pid int) byte {
+	counter := m.continuityCounters[pid]
+	m.continuityCounters[pid] = (counter + 1) & 0x0F
+	return counter
+}
+
+// WriteFrame adds frame presented at given time since the beginning of the stream
+func (m *Muxer) WriteFrame(frame []byte, presentationTime time.Duration) error {
+	if !m.tablesWritten {
+		m.writeSection(patPid, m.prepareProgramAssociationTable())
+		m.writeSection(pmtPid, m.prepareProgramMapTable())
+		m.tablesWritten = true
+	}
+
+	pcr := uint64(presentationTime * clockRate / time.Second)
+	pts := uint64((presentationTime + ptsOffset) * clockRate / time.Second)
+	m.writePes(frame, pts, pcr)
+	return nil
+}
+
+// Finish returns whole transport stream and resets muxer, so next frames start new segment
+func (m *Muxer) Finish() []byte {
+	result := m.buffer.Bytes()
+	m.buffer = new(bytes.Buffer)
+	m.tablesWritten = false
+	return result
+}
+
+func (m *Muxer) writeSection(pid int, section []byte) {
+	packet := make([]byte, PacketSize)
+	packet[0] = 0x47
+	packet[1] = 0x40 | byte(pid>>8)
+	packet[2] = byte(pid)
+	packet[3] = 0x10 | m.nextContinuityCounter(pid)
+	// pointer field
+	packet[4] = 0
+	copy(packet[5:], section)
+	for i := 5 + len(section); i < PacketSize; i++ {
+		packet[i] = 0xFF
+	}
+	m.buffer.Write(packet)
+}
+
+func (m *Muxer) prepareProgramAssociationTable() []byte {
+	section := []byte{
+		0x00,       // table id
+		0xB0, 0x0D, // section syntax indicator, section length
+		0x00, 0x01, // transport stream id
+		0xC1,       // version, current next indicator
+		0x00, 0x00, // section number, last section number
+		byte(programNumber >> 8), byte(programNumber),
+		0xE0 | byte(pmtPid>>8), byte(pmtPid & 0xFF),
+	}
+	return appendCrc(section)
+}
+
+func (m *Muxer) prepareProgramMapTable() []byte {
+	descriptor := []byte{registrationTag, 4, 'M', 'J', 'P', 'G'}
+	stream := []byte{
+		privateStreamType,
+		0xE0 | byte(videoPid>>8), byte(videoPid & 0xFF),
+		0xF0, byte(len(descriptor)),
+	}
+	stream = append(stream, descriptor...)
+
+	sectionLength := 9 + len(stream) + 4
+	section := []byte{
+		0x02, // table id
+		0xB0 | byte(sectionLength>>8), byte(sectionLength),
+		byte(programNumber >> 8), byte(programNumber),
+		0xC1,       // version, current next indicator
+		0x00, 0x00, // section number, last section number
+		0xE0 | byte(videoPid>>8), byte(videoPid & 0xFF), // PCR PID
+		0xF0, 0x00, // program info length
+	}
+	section = append(section, stream...)
+	return appendCrc(section)
+}
+
+func (m *Muxer) writePes(frame []byte, pts uint64, pcr uint64) {
+	header := []byte{
+		0x00, 0x00, 0x01, videoStreamId,
+		0x00, 0x00, // packet length
+		0x80, // marker bits
+		0x80, // PTS only
+		0x05, // header data length
+		0x21 | byte(pts>>29)&0x0E,
+		byte(pts >> 22),
+		0x01 | byte(pts>>14)&0xFE,
+		byte(pts >> 7),
+		0x01 | byte(pts<<1)&0xFE,
+	}
+	// length of too long frames is left unspecified (zero), which is allowed for video streams
+	pesLength := len(header) - 6 + len(frame)
+	if pesLength <= 0xFFFF {
+		header[4] = byte(pesLength >> 8)
+		header[5] = byte(pesLength)
+	}
+	pes := append(header, frame...)
+
+	first := true
+	for len(pes) > 0 {
+		packet := make([]byte, 4, PacketSize)
+		packet[0] = 0x47
+		packet[1] = byte(videoPid >> 8)
+		packet[2] = byte(videoPid & 0xFF)
+		if first {
+			packet[1] |= 0x40
+		}
+
+		adaptationField := make([]byte, 0)
+		if first {
+			adaptationField = append(adaptationField, 0x10) // PCR flag
+			adaptationField = append(adaptationField, encodePcr(pcr)...)
+		}
+		payloadSpace := PacketSize - 4
+		if len(adaptationField) > 0 {
+			payloadSpace -= 1 + len(adaptationField)
+		}
+		if len(pes) < payloadSpace {
+			// stuffing fills the last packet of the frame
+			stuffing := payloadSpace - len(pes)
+			if len(adaptationField) == 0 {
+				stuffing--
+				if stuffing > 0 {
+					adaptationField = append(adaptationField, 0x00)
+					stuffing--
+				}
+			}
+			for i := 0; i < stuffing; i++ {
+				adaptationField = append(adaptationField, 0xFF)
+			}
+			payloadSpace = len(pes)
+		}
+
+		if len(adaptationField) > 0 || payloadSpace < PacketSize-4 {
+			packet[3] = 0x30 | m.nextContinuityCounter(videoPid)
+			packet = append(packet, byte(len(adaptationField)))
+			packet = append(packet, adaptationField...)
+		} else {
+			packet[3] = 0x10 | m.nextContinuityCounter(videoPid)
+		}
+		packet = append(packet, pes[:payloadSpace]...)
+		pes = pes[payloadSpace:]
+		first = false
+
+		m.buffer.Write(packet)
+	}
+}
+
+func encodePcr(pcr uint64) []byte {
+	return []byte{
+		byte(pcr >> 25),
+		byte(pcr >> 17),
+		byte(pcr >> 9),
+		byte(pcr >> 1),
+		byte(pcr<<7) | 0x7E,
+		0x00,
+	}
+}
+
+// appendCrc appends CRC32/MPEG-2 checksum of the section
+func appendCrc(section []byte) []byte {
+	crc := uint32(0xFFFFFFFF)
+	for _, item := range section {
+		crc ^= uint32(item) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return append(section, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+}
@@ -0,0 +1,205 @@
+package mpegts
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// tsPacket is transport stream packet split into its fields
+type tsPacket struct {
+	pid               int
+	unitStart         bool
+	continuityCounter byte
+	adaptationField   []byte
+	payload           []byte
+}
+
+func parseTransportStream(t *testing.T, stream []byte) []tsPacket {
+	t.Helper()
+	if len(stream)%PacketSize != 0 {
+		t.Fatalf("stream of %v bytes isn't made of whole packets", len(stream))
+	}
+	result := make([]tsPacket, 0, len(stream)/PacketSize)
+	for offset := 0; offset < len(stream); offset += PacketSize {
+		data := stream[offset : offset+PacketSize]
+		if data[0] != 0x47 {
+			t.Fatalf("packet at %v starts with %#x", offset, data[0])
+		}
+		packet := tsPacket{
+			pid:               int(data[1]&0x1F)<<8 | int(data[2]),
+			unitStart:         data[1]&0x40 != 0,
+			continuityCounter: data[3] & 0x0F,
+		}
+		payloadStart := 4
+		if data[3]&0x20 != 0 {
+			length := int(data[4])
+			packet.adaptationField = data[5 : 5+length]
+			payloadStart = 5 + length
+		}
+		if data[3]&0x10 != 0 {
+			packet.payload = data[payloadStart:]
+		} else if payloadStart != PacketSize {
+			t.Fatalf("packet at %v without payload isn't filled by adaptation field", offset)
+		}
+		result = append(result, packet)
+	}
+	return result
+}
+
+// mpegCrc is CRC32/MPEG-2 computed bit by bit, checksum of section followed by its CRC is zero
+func mpegCrc(data []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, item := range data {
+		for bit := 7; bit >= 0; bit-- {
+			if (crc>>31)^uint32(item>>uint(bit)&1) != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// section returns table carried by the packet without pointer field and stuffing
+func section(t *testing.T, packet tsPacket) []byte {
+	t.Helper()
+	if !packet.unitStart || packet.payload[0] != 0 {
+		t.Fatalf("section of pid %v doesn't start in the packet", packet.pid)
+	}
+	table := packet.payload[1:]
+	length := int(table[1]&0x0F)<<8 | int(table[2])
+	return table[:3+length]
+}
+
+func TestMuxerWritesTables(t *testing.T) {
+	muxer := NewMuxer()
+	if err := muxer.WriteFrame([]byte{0xFF, 0xD8, 0xFF, 0xD9}, 0); err != nil {
+		t.Fatal(err)
+	}
+	packets := parseTransportStream(t, muxer.Finish())
+	if len(packets) != 3 || packets[0].pid != patPid || packets[1].pid != pmtPid || packets[2].pid != videoPid {
+		t.Fatalf("unexpected packets %+v", packets)
+	}
+
+	// the same table is written by other muxers with program 1 in PMT pid 0x1000
+	pat := section(t, packets[0])
+	expectedPat := []byte{0x00, 0xB0, 0x0D, 0x00, 0x01, 0xC1, 0x00, 0x00, 0x00, 0x01, 0xF0, 0x00,
+		0x2A, 0xB1, 0x04, 0xB2}
+	if !bytes.Equal(pat, expectedPat) {
+		t.Errorf("PAT %x, expected %x", pat, expectedPat)
+	}
+
+	pmt := section(t, packets[1])
+	if crc := mpegCrc(pmt); crc != 0 {
+		t.Errorf("PMT %x has invalid CRC", pmt)
+	}
+	if pmt[0] != 0x02 || int(pmt[8]&0x1F)<<8|int(pmt[9]) != videoPid {
+		t.Errorf("PMT %x doesn't point PCR to the video pid", pmt)
+	}
+	stream := pmt[12 : len(pmt)-4]
+	if stream[0] != privateStreamType || int(stream[1]&0x1F)<<8|int(stream[2]) != videoPid ||
+		!bytes.Equal(stream[5:], []byte{registrationTag, 4, 'M', 'J', 'P', 'G'}) {
+		t.Errorf("PMT describes stream %x", stream)
+	}
+}
+
+// writeFrames muxes frames one frame period apart from the presentation time and returns
+// video packets of each frame, following frames are written without tables
+func writeFrames(t *testing.T, muxer *Muxer, presentationTime time.Duration, frames ...[]byte) [][]tsPacket {
+	t.Helper()
+	for index, frame := range frames {
+		if err := muxer.WriteFrame(frame, presentationTime+time.Duration(index)*40*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result := make([][]tsPacket, 0, len(frames))
+	for _, packet := range parseTransportStream(t, muxer.Finish()) {
+		if packet.pid != videoPid {
+			continue
+		}
+		if packet.unitStart {
+			result = append(result, nil)
+		}
+		result[len(result)-1] = append(result[len(result)-1], packet)
+	}
+	return result
+}
+
+func testFrame(length int) []byte {
+	frame := make([]byte, length)
+	for index := range frame {
+		frame[index] = byte(index * 7)
+	}
+	return frame
+}
+
+func TestMuxerPacketizesFrames(t *testing.T) {
+	frames := [][]byte{testFrame(5000), testFrame(1), testFrame(183)}
+	muxer := NewMuxer()
+	pesPackets := writeFrames(t, muxer, 2*time.Second, frames...)
+	if len(pesPackets) != len(frames) {
+		t.Fatalf("%v frames written as %v PES packets", len(frames), len(pesPackets))
+	}
+
+	expectedCounter := byte(0)
+	for index, packets := range pesPackets {
+		pes := make([]byte, 0)
+		for _, packet := range packets {
+			if packet.continuityCounter != expectedCounter {
+				t.Fatalf("frame %v has continuity counter %v, expected %v", index, packet.continuityCounter,
+					expectedCounter)
+			}
+			expectedCounter = (expectedCounter + 1) & 0x0F
+			pes = append(pes, packet.payload...)
+		}
+
+		// PCR of the first packet is time of the frame, PTS is delayed by the offset,
+		// stuffing follows PCR when the frame fits into single packet
+		presentationTime := 2*time.Second + time.Duration(index)*40*time.Millisecond
+		adaptationField := packets[0].adaptationField
+		if len(adaptationField) < 7 || adaptationField[0] != 0x10 {
+			t.Fatalf("frame %v starts with adaptation field %x", index, adaptationField)
+		}
+		pcr := uint64(adaptationField[1])<<25 | uint64(adaptationField[2])<<17 | uint64(adaptationField[3])<<9 |
+			uint64(adaptationField[4])<<1 | uint64(adaptationField[5])>>7
+		if expected := uint64(presentationTime * clockRate / time.Second); pcr != expected {
+			t.Errorf("frame %v has PCR %v, expected %v", index, pcr, expected)
+		}
+
+		if !bytes.Equal(pes[:4], []byte{0x00, 0x00, 0x01, videoStreamId}) {
+			t.Fatalf("frame %v starts with PES header %x", index, pes[:4])
+		}
+		if length := int(pes[4])<<8 | int(pes[5]); length != len(pes)-6 {
+			t.Errorf("PES packet of frame %v has length %v, expected %v", index, length, len(pes)-6)
+		}
+		timestamp := pes[9:14]
+		if timestamp[0]&0xF1 != 0x21 || timestamp[2]&0x01 == 0 || timestamp[4]&0x01 == 0 {
+			t.Errorf("frame %v has PTS %x without marker bits", index, timestamp)
+		}
+		pts := uint64(timestamp[0]>>1&0x07)<<30 | uint64(timestamp[1])<<22 | uint64(timestamp[2]>>1)<<15 |
+			uint64(timestamp[3])<<7 | uint64(timestamp[4]>>1)
+		if expected := uint64((presentationTime + ptsOffset) * clockRate / time.Second); pts != expected {
+			t.Errorf("frame %v has PTS %v, expected %v", index, pts, expected)
+		}
+		if !bytes.Equal(pes[14:], frames[index]) {
+			t.Errorf("frame %v isn't carried unchanged", index)
+		}
+	}
+}
+
+func TestMuxerLeavesLengthOfLongFrameUnspecified(t *testing.T) {
+	frame := testFrame(0x10000)
+	pesPackets := writeFrames(t, NewMuxer(), 0, frame)
+	pes := make([]byte, 0)
+	for _, packet := range pesPackets[0] {
+		pes = append(pes, packet.payload...)
+	}
+	if pes[3] != videoStreamId || pes[4] != 0 || pes[5] != 0 {
+		t.Errorf("long frame is carried in PES packet %x", pes[:6])
+	}
+	if !bytes.Equal(pes[14:], frame) {
+		t.Error("long frame isn't carried unchanged")
+	}
+}
@@ -0,0 +1,85 @@
+package rtcp
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	ByeType      = 203
+	byeFixedSize = 4
+	maxByeSsrcs  = 31
+)
+
+// ByePacket announces that the sources stop sending (RFC 3550), reason is optional
+type ByePacket struct {
+	Ssrcs  []uint32
+	Reason string
+}
+
+func NewByePacket(ssrcs []uint32, reason string) *ByePacket {
+	return &ByePacket{
+		Ssrcs:  ssrcs,
+		Reason: reason,
+	}
+}
+
+func NewByePacketFromBytes(packetAsBytes []byte) (*ByePacket, error) {
+	if len(packetAsBytes) < byeFixedSize {
+		return nil, errors.New("bye packet too short")
+	}
+	if packetAsBytes[1] != ByeType {
+		return nil, errors.New("not a bye packet")
+	}
+	length := (int(binary.BigEndian.Uint16(packetAsBytes[2:4])) + 1) * 4
+	ssrcsNumber := int(packetAsBytes[0] & 0x1F)
+	if len(packetAsBytes) < length || length < byeFixedSize+4*ssrcsNumber {
+		return nil, errors.New("bye packet shorter than number of ssrcs")
+	}
+
+	result := &ByePacket{Ssrcs: make([]uint32, ssrcsNumber)}
+	for index := range result.Ssrcs {
+		offset := byeFixedSize + 4*index
+		result.Ssrcs[index] = binary.BigEndian.Uint32(packetAsBytes[offset : offset+4])
+	}
+	// reason follows the ssrcs as length prefixed text
+	reasonOffset := byeFixedSize + 4*ssrcsNumber
+	if reasonOffset < length {
+		reasonLength := int(packetAsBytes[reasonOffset])
+		if reasonOffset+1+reasonLength > length {
+			return nil, errors.New("bye reason longer than packet")
+		}
+		result.Reason = string(packetAsBytes[reasonOffset+1 : reasonOffset+1+reasonLength])
+	}
+	return result, nil
+}
+
+// TransformToBytes serializes at most 31 ssrcs and 255 bytes of the reason, the packet is padded to 32-bit words
+func (packet *ByePacket) TransformToBytes() []byte {
+	ssrcs := packet.Ssrcs
+	if len(ssrcs) > maxByeSsrcs {
+		ssrcs = ssrcs[:maxByeSsrcs]
+	}
+	reason := packet.Reason
+	if len(reason) > 255 {
+		reason = reason[:255]
+	}
+	length := byeFixedSize + 4*len(ssrcs)
+	if reason != "" {
+		length += (1 + len(reason) + 3) / 4 * 4
+	}
+	result := make([]byte, length)
+	result[0] = 2<<6 | byte(len(ssrcs))
+	result[1] = ByeType
+	binary.BigEndian.PutUint16(result[2:4], uint16(length/4-1))
+	for index, ssrc := range ssrcs {
+		offset := byeFixedSize + 4*index
+		binary.BigEndian.PutUint32(result[offset:offset+4], ssrc)
+	}
+	if reason != "" {
+		reasonOffset := byeFixedSize + 4*len(ssrcs)
+		result[reasonOffset] = byte(len(reason))
+		copy(result[reasonOffset+1:], reason)
+	}
+	return result
+}
@@ -0,0 +1,102 @@
+package rtcp
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	SenderReportType         = 200
+	ReceiverReportType       = 201
+	TransportFeedbackType    = 205
+	GenericNackFormat        = 1
+	nackFixedSize            = 12
+	nackItemSize             = 4
+	nackBitmaskPacketsNumber = 16
+)
+
+// NackPacket is Generic NACK transport layer feedback message (RFC 4585)
+type NackPacket struct {
+	SenderSsrc uint32
+	MediaSsrc  uint32
+	// every item holds packet id and bitmask of following lost packets
+	Items [][2]uint16
+}
+
+func NewNackPacket(senderSsrc uint32, mediaSsrc uint32, lostSeqNums []int) *NackPacket {
+	items := make([][2]uint16, 0)
+	for _, seqNum := range lostSeqNums {
+		seqNum := uint16(seqNum)
+		if len(items) > 0 {
+			last := &items[len(items)-1]
+			distance := seqNum - last[0]
+			if distance >= 1 && distance <= nackBitmaskPacketsNumber {
+				last[1] |= 1 << (distance - 1)
+				continue
+			}
+		}
+		items = append(items, [2]uint16{seqNum, 0})
+	}
+
+	return &NackPacket{
+		SenderSsrc: senderSsrc,
+		MediaSsrc:  mediaSsrc,
+		Items:      items,
+	}
+}
+
+func NewNackPacketFromBytes(packetAsBytes []byte) (*NackPacket, error) {
+	if len(packetAsBytes) < nackFixedSize {
+		return nil, errors.New("nack packet too short")
+	}
+	if packetAsBytes[0]&0x1F != GenericNackFormat || packetAsBytes[1] != TransportFeedbackType {
+		return nil, errors.New("not a generic nack packet")
+	}
+	length := (int(binary.BigEndian.Uint16(packetAsBytes[2:4])) + 1) * 4
+	if length > len(packetAsBytes) || length < nackFixedSize {
+		return nil, errors.New("invalid nack packet length")
+	}
+
+	result := &NackPacket{
+		SenderSsrc: binary.BigEndian.Uint32(packetAsBytes[4:8]),
+		MediaSsrc:  binary.BigEndian.Uint32(packetAsBytes[8:12]),
+		Items:      make([][2]uint16, 0),
+	}
+	for offset := nackFixedSize; offset+nackItemSize <= length; offset += nackItemSize {
+		result.Items = append(result.Items, [2]uint16{
+			binary.BigEndian.Uint16(packetAsBytes[offset : offset+2]),
+			binary.BigEndian.Uint16(packetAsBytes[offset+2 : offset+4]),
+		})
+	}
+	return result, nil
+}
+
+// LostSeqNums expands all items into list of lost sequence numbers
+func (packet *NackPacket) LostSeqNums() []int {
+	result := make([]int, 0)
+	for _, item := range packet.Items {
+		result = append(result, int(item[0]))
+		for bit := uint16(0); bit < nackBitmaskPacketsNumber; bit++ {
+			if item[1]&(1<<bit) != 0 {
+				result = append(result, int(item[0]+bit+1))
+			}
+		}
+	}
+	return result
+}
+
+func (packet *NackPacket) TransformToBytes() []byte {
+	length := nackFixedSize + len(packet.Items)*nackItemSize
+	result := make([]byte, length)
+	result[0] = 2<<6 | GenericNackFormat
+	result[1] = TransportFeedbackType
+	binary.BigEndian.PutUint16(result[2:4], uint16(length/4-1))
+	binary.BigEndian.PutUint32(result[4:8], packet.SenderSsrc)
+	binary.BigEndian.PutUint32(result[8:12], packet.MediaSsrc)
+	for index, item := range packet.Items {
+		offset := nackFixedSize + index*nackItemSize
+		binary.BigEndian.PutUint16(result[offset:offset+2], item[0])
+		binary.BigEndian.PutUint16(result[offset+2:offset+4], item[1])
+	}
+	return result
+}
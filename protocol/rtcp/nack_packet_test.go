@@ -0,0 +1,27 @@
+package rtcp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNackPacketRoundTrip(t *testing.T) {
+	// following numbers share item with bitmask, numbering wraps within the item
+	lostSeqNums := []int{65534, 65535, 0, 1, 17, 40}
+	packet := NewNackPacket(ReceiverSsrc, DefaultSsrc, lostSeqNums)
+	expectedItems := [][2]uint16{{65534, 0b111}, {17, 0}, {40, 0}}
+	if !reflect.DeepEqual(packet.Items, expectedItems) {
+		t.Fatalf("lost packets %v encoded as items %v, expected %v", lostSeqNums, packet.Items, expectedItems)
+	}
+
+	parsed, err := NewNackPacketFromBytes(packet.TransformToBytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.SenderSsrc != ReceiverSsrc || parsed.MediaSsrc != DefaultSsrc {
+		t.Errorf("ssrcs parsed as %v and %v", parsed.SenderSsrc, parsed.MediaSsrc)
+	}
+	if !reflect.DeepEqual(parsed.LostSeqNums(), lostSeqNums) {
+		t.Errorf("lost packets %v parsed as %v", lostSeqNums, parsed.LostSeqNums())
+	}
+}
@@ -0,0 +1,84 @@
+package rtcp
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	PayloadSpecificFeedbackType = 206
+	ApplicationLayerFormat      = 15
+	rembFixedSize               = 20
+	rembMantissaBits            = 18
+)
+
+var rembIdentifier = []byte("REMB")
+
+// RembPacket is Receiver Estimated Maximum Bitrate message (draft-alvestrand-rmcat-remb)
+type RembPacket struct {
+	SenderSsrc uint32
+	Bitrate    uint64
+	MediaSsrcs []uint32
+}
+
+func NewRembPacket(senderSsrc uint32, bitrate uint64, mediaSsrcs []uint32) *RembPacket {
+	return &RembPacket{
+		SenderSsrc: senderSsrc,
+		Bitrate:    bitrate,
+		MediaSsrcs: mediaSsrcs,
+	}
+}
+
+func NewRembPacketFromBytes(packetAsBytes []byte) (*RembPacket, error) {
+	if len(packetAsBytes) < rembFixedSize {
+		return nil, errors.New("remb packet too short")
+	}
+	if packetAsBytes[0]&0x1F != ApplicationLayerFormat || packetAsBytes[1] != PayloadSpecificFeedbackType ||
+		string(packetAsBytes[12:16]) != string(rembIdentifier) {
+		return nil, errors.New("not a remb packet")
+	}
+	ssrcsNumber := int(packetAsBytes[16])
+	if len(packetAsBytes) < rembFixedSize+4*ssrcsNumber {
+		return nil, errors.New("remb packet shorter than number of ssrcs")
+	}
+	exponent := packetAsBytes[17] >> 2
+	mantissa := uint64(packetAsBytes[17]&0x03)<<16 | uint64(packetAsBytes[18])<<8 | uint64(packetAsBytes[19])
+
+	result := &RembPacket{
+		SenderSsrc: binary.BigEndian.Uint32(packetAsBytes[4:8]),
+		Bitrate:    mantissa << exponent,
+		MediaSsrcs: make([]uint32, ssrcsNumber),
+	}
+	for index := range result.MediaSsrcs {
+		offset := rembFixedSize + 4*index
+		result.MediaSsrcs[index] = binary.BigEndian.Uint32(packetAsBytes[offset : offset+4])
+	}
+	return result, nil
+}
+
+func (packet *RembPacket) TransformToBytes() []byte {
+	length := rembFixedSize + 4*len(packet.MediaSsrcs)
+	result := make([]byte, length)
+	result[0] = 2<<6 | ApplicationLayerFormat
+	result[1] = PayloadSpecificFeedbackType
+	binary.BigEndian.PutUint16(result[2:4], uint16(length/4-1))
+	binary.BigEndian.PutUint32(result[4:8], packet.SenderSsrc)
+	// media source ssrc of the common header is unused
+	copy(result[12:16], rembIdentifier)
+	result[16] = byte(len(packet.MediaSsrcs))
+
+	exponent := uint(0)
+	mantissa := packet.Bitrate
+	for mantissa >= 1<<rembMantissaBits {
+		mantissa >>= 1
+		exponent++
+	}
+	result[17] = byte(exponent<<2) | byte(mantissa>>16)
+	result[18] = byte(mantissa >> 8)
+	result[19] = byte(mantissa)
+	for index, ssrc := range packet.MediaSsrcs {
+		offset := rembFixedSize + 4*index
+		binary.BigEndian.PutUint32(result[offset:offset+4], ssrc)
+	}
+	return result
+}
@@ -1,6 +1,13 @@
 package rtcp
 
+import "errors"
+
 const HeaderSize = 8
+const DefaultSsrc = 9999
+
+// ReceiverSsrc identifies reports and feedback of the receiver, it differs from SSRC of the media,
+// so both directions of the session can be protected by single SRTP master key
+const ReceiverSsrc = 9998
 
 type Header struct {
 	Version              byte
@@ -17,13 +24,16 @@ func NewHeader() *Header {
 		Version:              2,
 		Padding:              0,
 		ReceptionReportCount: 1,
-		PayloadType:          201,
+		PayloadType:          ReceiverReportType,
 		Length:               32,
-		Ssrc:                 9999,
+		Ssrc:                 ReceiverSsrc,
 	}
 }
 
-func NewHeaderFromBytes(payload []byte) *Header {
+func NewHeaderFromBytes(payload []byte) (*Header, error) {
+	if len(payload) < HeaderSize {
+		return nil, errors.New("rtcp header too short")
+	}
 	resultHeader := &Header{}
 	headerAsBytes := payload[:HeaderSize]
 
@@ -37,7 +47,7 @@ func NewHeaderFromBytes(payload []byte) *Header {
 		(int32(headerAsBytes[4]) << 24) + (int32(headerAsBytes[5]) << 16) +
 			(int32(headerAsBytes[6]) << 8) + int32(headerAsBytes[7])
 
-	return resultHeader
+	return resultHeader, nil
 }
 
 func (header *Header) TransformToBytes() [HeaderSize]byte {
@@ -2,17 +2,30 @@ package rtcp
 
 import (
 	"encoding/binary"
-	"log"
+	"errors"
 	"math"
+	"streming_server/logging"
 )
 
-const BodySize = 16
+const BodySize = 36
+
+// reports of older senders end after highest sequence number
+const minPacketSize = 24
 
 type Packet struct {
 	Header         Header
 	FractionLost   float64
 	CumulativeLost uint32
 	HighestSeqNum  uint32
+	// used by sender to calculate round trip time, zero when no sender report was received
+	LastSenderReport           uint32
+	DelaySinceLastSenderReport uint32
+	// bits per second received since the previous report
+	ReceivedBitrate uint32
+	// smoothed difference between inter-arrival and inter-departure time of frames, in microseconds
+	DelayGradient int32
+	// interarrival jitter (RFC 3550) in microseconds
+	Jitter uint32
 }
 
 func NewPacket(fractionLost float64, cumulativeLost int, highestSeqNum int) *Packet {
@@ -24,17 +37,30 @@ func NewPacket(fractionLost float64, cumulativeLost int, highestSeqNum int) *Pac
 	}
 }
 
-func NewPacketFromBytes(packetAsBytes []byte) *Packet {
-	headerBytes := packetAsBytes[:HeaderSize]
-	header := NewHeaderFromBytes(headerBytes)
+func NewPacketFromBytes(packetAsBytes []byte) (*Packet, error) {
+	if len(packetAsBytes) < minPacketSize {
+		return nil, errors.New("receiver report too short")
+	}
+	header, err := NewHeaderFromBytes(packetAsBytes)
+	if err != nil {
+		return nil, err
+	}
 	bits := binary.LittleEndian.Uint64(packetAsBytes[HeaderSize:16])
 
-	return &Packet{
+	packet := &Packet{
 		Header:         *header,
 		FractionLost:   math.Float64frombits(bits),
 		CumulativeLost: binary.LittleEndian.Uint32(packetAsBytes[16:20]),
 		HighestSeqNum:  binary.LittleEndian.Uint32(packetAsBytes[20:24]),
 	}
+	if len(packetAsBytes) >= HeaderSize+BodySize {
+		packet.LastSenderReport = binary.LittleEndian.Uint32(packetAsBytes[24:28])
+		packet.DelaySinceLastSenderReport = binary.LittleEndian.Uint32(packetAsBytes[28:32])
+		packet.ReceivedBitrate = binary.LittleEndian.Uint32(packetAsBytes[32:36])
+		packet.DelayGradient = int32(binary.LittleEndian.Uint32(packetAsBytes[36:40]))
+		packet.Jitter = binary.LittleEndian.Uint32(packetAsBytes[40:44])
+	}
+	return packet, nil
 }
 
 func (packet *Packet) TransformToBytes() []byte {
@@ -49,11 +75,20 @@ func (packet *Packet) TransformToBytes() []byte {
 	binary.LittleEndian.PutUint64(result[8:16], bits)
 	binary.LittleEndian.PutUint32(result[16:20], packet.CumulativeLost)
 	binary.LittleEndian.PutUint32(result[20:24], packet.HighestSeqNum)
+	binary.LittleEndian.PutUint32(result[24:28], packet.LastSenderReport)
+	binary.LittleEndian.PutUint32(result[28:32], packet.DelaySinceLastSenderReport)
+	binary.LittleEndian.PutUint32(result[32:36], packet.ReceivedBitrate)
+	binary.LittleEndian.PutUint32(result[36:40], uint32(packet.DelayGradient))
+	binary.LittleEndian.PutUint32(result[40:44], packet.Jitter)
 	return result
 }
 
-func (packet *Packet) Log() {
-	log.Printf("RTCP:\n"+
-		"Fraction Lost: %v, Cumulative Lost: %v, Highest Seq Num: %v",
-		packet.FractionLost, packet.CumulativeLost, packet.HighestSeqNum)
+// Log dumps the receiver report at trace level
+func (packet *Packet) Log(logger *logging.Logger) {
+	if !logger.Enabled(logging.LevelTrace) {
+		return
+	}
+	logger.Trace("receiver report", "fractionLost", packet.FractionLost, "cumulativeLost", packet.CumulativeLost,
+		"highestSeqNum", packet.HighestSeqNum, "receivedBitrate", packet.ReceivedBitrate,
+		"delayGradient", packet.DelayGradient, "jitter", packet.Jitter)
 }
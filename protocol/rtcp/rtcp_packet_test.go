@@ -0,0 +1,98 @@
+package rtcp
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func FuzzNewPacketFromBytes(f *testing.F) {
+	packet := NewPacket(0.25, 10, 400)
+	packet.Jitter = 1200
+	packetBytes := packet.TransformToBytes()
+	f.Add(packetBytes)
+	f.Add(packetBytes[:minPacketSize])
+	f.Add(packetBytes[:HeaderSize])
+
+	f.Fuzz(func(t *testing.T, packetAsBytes []byte) {
+		_, err := NewPacketFromBytes(packetAsBytes)
+		if err == nil && len(packetAsBytes) < minPacketSize {
+			t.Fatalf("receiver report parsed from %v bytes", len(packetAsBytes))
+		}
+	})
+}
+
+func FuzzNewNackPacketFromBytes(f *testing.F) {
+	f.Add(NewNackPacket(DefaultSsrc, DefaultSsrc, []int{10, 12, 30, 65535, 0}).TransformToBytes())
+	f.Add(NewNackPacket(DefaultSsrc, DefaultSsrc, []int{}).TransformToBytes())
+
+	f.Fuzz(func(t *testing.T, packetAsBytes []byte) {
+		packet, err := NewNackPacketFromBytes(packetAsBytes)
+		if err != nil {
+			return
+		}
+		parsed, err := NewNackPacketFromBytes(packet.TransformToBytes())
+		if err != nil {
+			t.Fatal("serialized nack packet can't be parsed:", err)
+		}
+		if !reflect.DeepEqual(packet, parsed) {
+			t.Fatalf("nack packet %v parsed as %v", packet, parsed)
+		}
+	})
+}
+
+func FuzzNewRembPacketFromBytes(f *testing.F) {
+	f.Add(NewRembPacket(DefaultSsrc, 2500000, []uint32{9999}).TransformToBytes())
+	f.Add(NewRembPacket(DefaultSsrc, 1<<40, []uint32{}).TransformToBytes())
+
+	f.Fuzz(func(t *testing.T, packetAsBytes []byte) {
+		packet, err := NewRembPacketFromBytes(packetAsBytes)
+		if err != nil {
+			return
+		}
+		if len(packetAsBytes) < rembFixedSize+4*len(packet.MediaSsrcs) {
+			t.Fatalf("%v ssrcs parsed from %v bytes", len(packet.MediaSsrcs), len(packetAsBytes))
+		}
+	})
+}
+
+func FuzzNewSenderReportFromBytes(f *testing.F) {
+	report := NewSenderReport(DefaultSsrc, time.Unix(1600000000, 0), 90000, 100, 150000)
+	report.TargetBitrate = 1500000
+	reportBytes := report.TransformToBytes()
+	f.Add(reportBytes)
+	f.Add(reportBytes[:senderInfoSize])
+
+	f.Fuzz(func(t *testing.T, packetAsBytes []byte) {
+		report, err := NewSenderReportFromBytes(packetAsBytes)
+		if err != nil {
+			return
+		}
+		parsed, err := NewSenderReportFromBytes(report.TransformToBytes())
+		if err != nil {
+			t.Fatal("serialized sender report can't be parsed:", err)
+		}
+		if *report != *parsed {
+			t.Fatalf("sender report %v parsed as %v", report, parsed)
+		}
+	})
+}
+
+func FuzzNewByePacketFromBytes(f *testing.F) {
+	f.Add(NewByePacket([]uint32{DefaultSsrc, 10000}, "server shutdown").TransformToBytes())
+	f.Add(NewByePacket([]uint32{}, "").TransformToBytes())
+
+	f.Fuzz(func(t *testing.T, packetAsBytes []byte) {
+		packet, err := NewByePacketFromBytes(packetAsBytes)
+		if err != nil {
+			return
+		}
+		parsed, err := NewByePacketFromBytes(packet.TransformToBytes())
+		if err != nil {
+			t.Fatal("serialized bye packet can't be parsed:", err)
+		}
+		if !reflect.DeepEqual(packet, parsed) {
+			t.Fatalf("bye packet %v parsed as %v", packet, parsed)
+		}
+	})
+}
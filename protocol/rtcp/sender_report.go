@@ -0,0 +1,91 @@
+package rtcp
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+const SenderReportSize = 32
+
+// sender info ends here, the rest is profile-specific extension
+const senderInfoSize = 28
+
+// seconds between NTP epoch (1900) and unix epoch (1970)
+const ntpEpochOffset = 2208988800
+
+// SenderReport carries sender info without reception report blocks (RFC 3550),
+// receivers use NTP timestamp to report round trip time
+type SenderReport struct {
+	Ssrc         uint32
+	NtpTimestamp uint64
+	RtpTimestamp uint32
+	PacketCount  uint32
+	OctetCount   uint32
+	// estimated available bandwidth in bits per second, sent as profile-specific extension
+	TargetBitrate uint32
+}
+
+func NewSenderReport(ssrc uint32, sendTime time.Time, rtpTimestamp uint32, packetCount uint32,
+	octetCount uint32) *SenderReport {
+	return &SenderReport{
+		Ssrc:         ssrc,
+		NtpTimestamp: ToNtpTime(sendTime),
+		RtpTimestamp: rtpTimestamp,
+		PacketCount:  packetCount,
+		OctetCount:   octetCount,
+	}
+}
+
+func NewSenderReportFromBytes(packetAsBytes []byte) (*SenderReport, error) {
+	if len(packetAsBytes) < senderInfoSize {
+		return nil, errors.New("sender report too short")
+	}
+	if packetAsBytes[1] != SenderReportType {
+		return nil, errors.New("not a sender report")
+	}
+	report := &SenderReport{
+		Ssrc:         binary.BigEndian.Uint32(packetAsBytes[4:8]),
+		NtpTimestamp: binary.BigEndian.Uint64(packetAsBytes[8:16]),
+		RtpTimestamp: binary.BigEndian.Uint32(packetAsBytes[16:20]),
+		PacketCount:  binary.BigEndian.Uint32(packetAsBytes[20:24]),
+		OctetCount:   binary.BigEndian.Uint32(packetAsBytes[24:28]),
+	}
+	length := (int(binary.BigEndian.Uint16(packetAsBytes[2:4])) + 1) * 4
+	if length >= SenderReportSize && len(packetAsBytes) >= SenderReportSize {
+		report.TargetBitrate = binary.BigEndian.Uint32(packetAsBytes[28:32])
+	}
+	return report, nil
+}
+
+func (report *SenderReport) TransformToBytes() []byte {
+	result := make([]byte, SenderReportSize)
+	result[0] = 2 << 6
+	result[1] = SenderReportType
+	binary.BigEndian.PutUint16(result[2:4], SenderReportSize/4-1)
+	binary.BigEndian.PutUint32(result[4:8], report.Ssrc)
+	binary.BigEndian.PutUint64(result[8:16], report.NtpTimestamp)
+	binary.BigEndian.PutUint32(result[16:20], report.RtpTimestamp)
+	binary.BigEndian.PutUint32(result[20:24], report.PacketCount)
+	binary.BigEndian.PutUint32(result[24:28], report.OctetCount)
+	binary.BigEndian.PutUint32(result[28:32], report.TargetBitrate)
+	return result
+}
+
+// CompactNtpTimestamp returns middle 32 bits of NTP timestamp used as LSR in reception reports
+func (report *SenderReport) CompactNtpTimestamp() uint32 {
+	return uint32(report.NtpTimestamp >> 16)
+}
+
+func ToNtpTime(moment time.Time) uint64 {
+	seconds := uint64(moment.Unix() + ntpEpochOffset)
+	fraction := uint64(moment.Nanosecond()) << 32 / uint64(time.Second)
+	return seconds<<32 | fraction
+}
+
+// RoundTripTime calculates RTT from LSR and DLSR (in 1/65536 s) fields of reception report received at given time
+func RoundTripTime(arrivalTime time.Time, lastSenderReport uint32, delaySinceLastSenderReport uint32) time.Duration {
+	arrival := uint32(ToNtpTime(arrivalTime) >> 16)
+	rtt := arrival - lastSenderReport - delaySinceLastSenderReport
+	return time.Duration(uint64(rtt) * uint64(time.Second) >> 16)
+}
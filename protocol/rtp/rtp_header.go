@@ -1,10 +1,12 @@
 package rtp
 
 import (
-	"log"
+	"errors"
+	"streming_server/logging"
 )
 
 const HeaderSize = 12
+const DefaultSsrc = 9999
 
 type Header struct {
 	Version        int
@@ -26,7 +28,7 @@ func NewHeader(payloadType int, sequenceNumber int, timestamp int) *Header {
 		Extension: 0,
 		CsrcCount: 0,
 		Marker:    0,
-		Ssrc:      9999,
+		Ssrc:      DefaultSsrc,
 
 		// parameters based on received values
 		PayloadType:    payloadType,
@@ -35,7 +37,10 @@ func NewHeader(payloadType int, sequenceNumber int, timestamp int) *Header {
 	}
 }
 
-func NewHeaderFromBytes(payload []byte) *Header {
+func NewHeaderFromBytes(payload []byte) (*Header, error) {
+	if len(payload) < HeaderSize {
+		return nil, errors.New("rtp header too short")
+	}
 	resultRtpHeader := &Header{}
 	headerAsBytes := payload[:HeaderSize]
 
@@ -54,7 +59,7 @@ func NewHeaderFromBytes(payload []byte) *Header {
 		(int(headerAsBytes[8]) << 24) + (int(headerAsBytes[9]) << 16) +
 			(int(headerAsBytes[10]) << 8) + int(headerAsBytes[11])
 
-	return resultRtpHeader
+	return resultRtpHeader, nil
 }
 
 func (header *Header) TransformToBytes() []byte {
@@ -74,10 +79,13 @@ func (header *Header) TransformToBytes() []byte {
 	}
 }
 
-func (header *Header) Log() {
-	log.Printf("RTP Header:\n"+
-		"Version: %v, Padding: %v, Extension: %v, CsrcCount: %v, Marker: %v, "+
-		"PayloadType: %v, SequenceNumber: %v, TimeStamp: %v, Ssrc: %v",
-		header.Version, header.Padding, header.Extension, header.CsrcCount, header.Marker,
-		header.PayloadType, header.SequenceNumber, header.Timestamp, header.Ssrc)
+// Log dumps the header at trace level
+func (header *Header) Log(logger *logging.Logger) {
+	if !logger.Enabled(logging.LevelTrace) {
+		return
+	}
+	logger.Trace("RTP header", "version", header.Version, "padding", header.Padding,
+		"extension", header.Extension, "csrcCount", header.CsrcCount, "marker", header.Marker,
+		"payloadType", header.PayloadType, "sequenceNumber", header.SequenceNumber,
+		"timestamp", header.Timestamp, "ssrc", header.Ssrc)
 }
@@ -1,5 +1,7 @@
 package rtp
 
+import "errors"
+
 type Packet struct {
 	Header      *Header
 	PayloadSize int
@@ -14,15 +16,21 @@ func NewPacket(rtpHeader *Header, payloadSize int, payload []byte) *Packet {
 	}
 }
 
-func NewPacketFromBytes(packetAsBytes []byte, packetSize int) *Packet {
-	headerBytes := packetAsBytes[:HeaderSize]
-	header := NewHeaderFromBytes(headerBytes)
+// NewPacketFromBytes parses first packetSize bytes of the datagram
+func NewPacketFromBytes(packetAsBytes []byte, packetSize int) (*Packet, error) {
+	if packetSize < 0 || packetSize > len(packetAsBytes) {
+		return nil, errors.New("rtp packet size out of buffer range")
+	}
+	header, err := NewHeaderFromBytes(packetAsBytes[:packetSize])
+	if err != nil {
+		return nil, err
+	}
 
 	return &Packet{
 		Header:      header,
 		PayloadSize: packetSize - HeaderSize,
 		Payload:     packetAsBytes[HeaderSize:packetSize],
-	}
+	}, nil
 }
 
 func (packet *Packet) TransformToBytes() []byte {
@@ -0,0 +1,28 @@
+package rtp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func FuzzNewPacketFromBytes(f *testing.F) {
+	packet := NewPacket(NewHeader(26, 1, 90000), 4, []byte{0xFF, 0xD8, 0xFF, 0xD9})
+	packetBytes := packet.TransformToBytes()
+	f.Add(packetBytes, len(packetBytes))
+	f.Add(packetBytes[:HeaderSize], HeaderSize)
+	f.Add(packetBytes, len(packetBytes)+1)
+
+	f.Fuzz(func(t *testing.T, packetAsBytes []byte, packetSize int) {
+		packet, err := NewPacketFromBytes(packetAsBytes, packetSize)
+		if err != nil {
+			return
+		}
+		if packetSize < HeaderSize || packetSize > len(packetAsBytes) {
+			t.Fatalf("packet of size %v parsed from %v bytes", packetSize, len(packetAsBytes))
+		}
+		if packet.PayloadSize != len(packet.Payload) ||
+			!bytes.Equal(packet.Payload, packetAsBytes[HeaderSize:packetSize]) {
+			t.Fatalf("payload of %v bytes doesn't match packet size %v", len(packet.Payload), packetSize)
+		}
+	})
+}
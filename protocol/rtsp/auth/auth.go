@@ -0,0 +1,261 @@
+package auth
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+const (
+	BasicScheme  = "Basic"
+	DigestScheme = "Digest"
+	MD5          = "MD5"
+	SHA256       = "SHA-256"
+	// quality of protection which covers method and uri
+	QopAuth = "auth"
+)
+
+// Algorithms lists supported digest algorithms, the strongest first
+var Algorithms = []string{SHA256, MD5}
+
+// Challenge is value of WWW-Authenticate header
+type Challenge struct {
+	Scheme    string
+	Realm     string
+	Nonce     string
+	Algorithm string
+	Qop       string
+}
+
+// Credentials is value of Authorization header, Basic credentials carry only username and password
+type Credentials struct {
+	Scheme     string
+	Username   string
+	Password   string
+	Realm      string
+	Nonce      string
+	Uri        string
+	Algorithm  string
+	Qop        string
+	NonceCount string
+	Cnonce     string
+	Response   string
+}
+
+func newHash(algorithm string) (hash.Hash, error) {
+	switch strings.ToUpper(algorithm) {
+	case MD5, "":
+		return md5.New(), nil
+	case SHA256:
+		return sha256.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported digest algorithm %q", algorithm)
+}
+
+// Hash returns hex encoded digest of colon separated parts, as in RFC 7616
+func Hash(algorithm string, parts ...string) (string, error) {
+	digest, err := newHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+	digest.Write([]byte(strings.Join(parts, ":")))
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// HashCredentials returns H(username:realm:password), which is stored instead of the password
+func HashCredentials(algorithm string, username string, realm string, password string) (string, error) {
+	return Hash(algorithm, username, realm, password)
+}
+
+// DigestResponse calculates response of the client from hashed credentials, qop may be empty for RFC 2069 clients
+func DigestResponse(algorithm string, credentialsHash string, nonce string, nonceCount string, cnonce string,
+	qop string, method string, uri string) (string, error) {
+	requestHash, err := Hash(algorithm, method, uri)
+	if err != nil {
+		return "", err
+	}
+	if qop == "" {
+		return Hash(algorithm, credentialsHash, nonce, requestHash)
+	}
+	return Hash(algorithm, credentialsHash, nonce, nonceCount, cnonce, qop, requestHash)
+}
+
+// quote formats quoted-string, only quotes and backslashes are escaped
+func quote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+func (c Challenge) String() string {
+	if c.Scheme == BasicScheme {
+		return fmt.Sprintf("%v realm=%v", BasicScheme, quote(c.Realm))
+	}
+	result := fmt.Sprintf("%v realm=%v, nonce=%v, algorithm=%v", DigestScheme, quote(c.Realm), quote(c.Nonce),
+		c.Algorithm)
+	if c.Qop != "" {
+		result += fmt.Sprintf(", qop=%v", quote(c.Qop))
+	}
+	return result
+}
+
+func (c Credentials) String() string {
+	if c.Scheme == BasicScheme {
+		return BasicScheme + " " + base64.StdEncoding.EncodeToString([]byte(c.Username+":"+c.Password))
+	}
+	result := fmt.Sprintf("%v username=%v, realm=%v, nonce=%v, uri=%v, algorithm=%v, response=%v",
+		DigestScheme, quote(c.Username), quote(c.Realm), quote(c.Nonce), quote(c.Uri), c.Algorithm, quote(c.Response))
+	if c.Qop != "" {
+		result += fmt.Sprintf(", qop=%v, nc=%v, cnonce=%v", c.Qop, c.NonceCount, quote(c.Cnonce))
+	}
+	return result
+}
+
+// ParseChallenge reads value of WWW-Authenticate header
+func ParseChallenge(value string) (Challenge, error) {
+	scheme, parameters, err := parseParameters(value)
+	if err != nil {
+		return Challenge{}, err
+	}
+	challenge := Challenge{
+		Scheme:    scheme,
+		Realm:     parameters["realm"],
+		Nonce:     parameters["nonce"],
+		Algorithm: parameters["algorithm"],
+	}
+	if scheme == DigestScheme {
+		if challenge.Nonce == "" {
+			return Challenge{}, errors.New("digest challenge without nonce")
+		}
+		if challenge.Algorithm == "" {
+			challenge.Algorithm = MD5
+		}
+		// qop may list several options
+		for _, qop := range strings.Split(parameters["qop"], ",") {
+			if strings.TrimSpace(qop) == QopAuth {
+				challenge.Qop = QopAuth
+			}
+		}
+	}
+	return challenge, nil
+}
+
+// ParseCredentials reads value of Authorization header
+func ParseCredentials(value string) (Credentials, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, BasicScheme+" ") {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(strings.TrimPrefix(value, BasicScheme)))
+		if err != nil {
+			return Credentials{}, errors.New("invalid basic credentials")
+		}
+		usernameAndPassword := strings.SplitN(string(decoded), ":", 2)
+		if len(usernameAndPassword) != 2 {
+			return Credentials{}, errors.New("basic credentials without password")
+		}
+		return Credentials{Scheme: BasicScheme, Username: usernameAndPassword[0], Password: usernameAndPassword[1]}, nil
+	}
+
+	scheme, parameters, err := parseParameters(value)
+	if err != nil {
+		return Credentials{}, err
+	}
+	if scheme != DigestScheme {
+		return Credentials{}, fmt.Errorf("unsupported authentication scheme %q", scheme)
+	}
+	credentials := Credentials{
+		Scheme:     DigestScheme,
+		Username:   parameters["username"],
+		Realm:      parameters["realm"],
+		Nonce:      parameters["nonce"],
+		Uri:        parameters["uri"],
+		Algorithm:  parameters["algorithm"],
+		Qop:        parameters["qop"],
+		NonceCount: parameters["nc"],
+		Cnonce:     parameters["cnonce"],
+		Response:   parameters["response"],
+	}
+	if credentials.Algorithm == "" {
+		credentials.Algorithm = MD5
+	}
+	if credentials.Username == "" || credentials.Nonce == "" || credentials.Response == "" {
+		return Credentials{}, errors.New("incomplete digest credentials")
+	}
+	if _, err := newHash(credentials.Algorithm); err != nil {
+		return Credentials{}, err
+	}
+	if credentials.Qop == "" {
+		// nonce count and cnonce are meaningful only with qop
+		credentials.NonceCount, credentials.Cnonce = "", ""
+	} else {
+		if credentials.Qop != QopAuth {
+			return Credentials{}, fmt.Errorf("unsupported qop %q", credentials.Qop)
+		}
+		if !isNonceCount(credentials.NonceCount) || credentials.Cnonce == "" {
+			return Credentials{}, errors.New("digest credentials without nonce count or cnonce")
+		}
+	}
+	return credentials, nil
+}
+
+// isNonceCount checks that nonce count consists of 8 hex digits
+func isNonceCount(value string) bool {
+	if len(value) != 8 {
+		return false
+	}
+	_, err := hex.DecodeString(value)
+	return err == nil
+}
+
+// parseParameters splits value into scheme and comma separated parameters, values may be quoted
+func parseParameters(value string) (string, map[string]string, error) {
+	value = strings.TrimSpace(value)
+	separator := strings.IndexByte(value, ' ')
+	if separator < 0 {
+		return value, map[string]string{}, nil
+	}
+	scheme := value[:separator]
+	rest := value[separator+1:]
+	parameters := make(map[string]string)
+	for {
+		rest = strings.TrimLeft(rest, " ,")
+		if rest == "" {
+			return scheme, parameters, nil
+		}
+		equalSign := strings.IndexByte(rest, '=')
+		if equalSign <= 0 {
+			return "", nil, errors.New("authentication parameter without value")
+		}
+		name := strings.ToLower(strings.TrimSpace(rest[:equalSign]))
+		rest = strings.TrimLeft(rest[equalSign+1:], " ")
+
+		var parameterValue strings.Builder
+		if strings.HasPrefix(rest, "\"") {
+			closed := false
+			index := 1
+			for ; index < len(rest); index++ {
+				if rest[index] == '\\' && index+1 < len(rest) {
+					index++
+				} else if rest[index] == '"' {
+					closed = true
+					break
+				}
+				parameterValue.WriteByte(rest[index])
+			}
+			if !closed {
+				return "", nil, errors.New("unterminated quoted authentication parameter")
+			}
+			rest = rest[index+1:]
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end < 0 {
+				end = len(rest)
+			}
+			parameterValue.WriteString(strings.TrimSpace(rest[:end]))
+			rest = rest[end:]
+		}
+		parameters[name] = parameterValue.String()
+	}
+}
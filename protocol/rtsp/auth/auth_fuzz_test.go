@@ -0,0 +1,24 @@
+package auth
+
+import "testing"
+
+func FuzzParseCredentials(f *testing.F) {
+	f.Add(`Digest username="Mufasa", realm="http-auth@example.org", nonce="abc", uri="/dir", algorithm=MD5, ` +
+		`response="8ca523f5e9506fed4657c9700eebdbec", qop=auth, nc=00000001, cnonce="f2/wE4q"`)
+	f.Add("Basic TXVmYXNhOkNpcmNsZSBvZiBMaWZl")
+	f.Add(`Digest username="unterminated`)
+
+	f.Fuzz(func(t *testing.T, value string) {
+		credentials, err := ParseCredentials(value)
+		if err != nil {
+			return
+		}
+		parsed, err := ParseCredentials(credentials.String())
+		if err != nil {
+			t.Fatalf("serialized credentials %q can't be parsed: %v", credentials.String(), err)
+		}
+		if parsed != credentials {
+			t.Fatalf("credentials %+v parsed as %+v", credentials, parsed)
+		}
+	})
+}
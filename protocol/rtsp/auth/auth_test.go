@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"testing"
+)
+
+// example of RFC 7616, section 3.9.1
+const (
+	exampleUsername = "Mufasa"
+	examplePassword = "Circle of Life"
+	exampleRealm    = "http-auth@example.org"
+	exampleNonce    = "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v"
+	exampleCnonce   = "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ"
+	exampleUri      = "/dir/index.html"
+)
+
+func TestDigestResponse(t *testing.T) {
+	expectedResponses := map[string]string{
+		MD5:    "8ca523f5e9506fed4657c9700eebdbec",
+		SHA256: "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1",
+	}
+	for algorithm, expected := range expectedResponses {
+		credentialsHash, err := HashCredentials(algorithm, exampleUsername, exampleRealm, examplePassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := DigestResponse(algorithm, credentialsHash, exampleNonce, "00000001", exampleCnonce,
+			QopAuth, "GET", exampleUri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response != expected {
+			t.Errorf("%v response %v, expected %v", algorithm, response, expected)
+		}
+	}
+}
+
+func TestParseCredentials(t *testing.T) {
+	credentials := Credentials{
+		Scheme:     DigestScheme,
+		Username:   exampleUsername,
+		Realm:      exampleRealm,
+		Nonce:      exampleNonce,
+		Uri:        exampleUri,
+		Algorithm:  SHA256,
+		Qop:        QopAuth,
+		NonceCount: "00000001",
+		Cnonce:     exampleCnonce,
+		Response:   "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1",
+	}
+	parsed, err := ParseCredentials(credentials.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed != credentials {
+		t.Fatalf("credentials %+v parsed as %+v", credentials, parsed)
+	}
+
+	basic := Credentials{Scheme: BasicScheme, Username: exampleUsername, Password: examplePassword}
+	parsed, err = ParseCredentials(basic.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed != basic {
+		t.Fatalf("credentials %+v parsed as %+v", basic, parsed)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	challenge, err := ParseChallenge(`Digest realm="http-auth@example.org", qop="auth, auth-int", ` +
+		`algorithm=SHA-256, nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Challenge{
+		Scheme:    DigestScheme,
+		Realm:     exampleRealm,
+		Nonce:     exampleNonce,
+		Algorithm: SHA256,
+		Qop:       QopAuth,
+	}
+	if challenge != expected {
+		t.Fatalf("challenge parsed as %+v, expected %+v", challenge, expected)
+	}
+}
@@ -1,12 +1,13 @@
 package message
 
 const (
-	Setup    = "SETUP"
-	Record   = "RECORD"
-	Play     = "PLAY"
-	Pause    = "PAUSE"
-	Teardown = "TEARDOWN"
-	Describe = "DESCRIBE"
+	Setup        = "SETUP"
+	Record       = "RECORD"
+	Play         = "PLAY"
+	Pause        = "PAUSE"
+	Teardown     = "TEARDOWN"
+	Describe     = "DESCRIBE"
+	GetParameter = "GET_PARAMETER"
 )
 
 type Message string
@@ -12,3 +12,19 @@ const (
 )
 
 type State int
+
+func (s State) String() string {
+	switch s {
+	case Detached:
+		return "DETACHED"
+	case Init:
+		return "INIT"
+	case Ready:
+		return "READY"
+	case Playing:
+		return "PLAYING"
+	case Recording:
+		return "RECORDING"
+	}
+	return "UNKNOWN"
+}
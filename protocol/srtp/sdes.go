@@ -0,0 +1,68 @@
+package srtp
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// ProtectionProfile is the only crypto suite supported: AES counter mode with 128-bit key and 80-bit HMAC-SHA1 tag
+const ProtectionProfile = "AES_CM_128_HMAC_SHA1_80"
+
+// CryptoAttribute announces master key in SDP (SDES, RFC 4568),
+// in form a=crypto:<tag> AES_CM_128_HMAC_SHA1_80 inline:<base64 of key and salt>
+const CryptoAttribute = "a=crypto:"
+
+const (
+	MasterKeySize  = 16
+	MasterSaltSize = 14
+)
+
+// MasterKey is master key and master salt from which session keys of SRTP and SRTCP are derived
+type MasterKey struct {
+	Key  []byte
+	Salt []byte
+}
+
+// GenerateMasterKey returns random master key, new one has to be used for every protected stream
+func GenerateMasterKey() (MasterKey, error) {
+	keyAndSalt := make([]byte, MasterKeySize+MasterSaltSize)
+	if _, err := rand.Read(keyAndSalt); err != nil {
+		return MasterKey{}, err
+	}
+	return MasterKey{Key: keyAndSalt[:MasterKeySize], Salt: keyAndSalt[MasterKeySize:]}, nil
+}
+
+// FormatCryptoAttribute returns SDP line with the master key, it has to be sent over protected connection only
+func FormatCryptoAttribute(tag int, masterKey MasterKey) string {
+	keyAndSalt := append(append([]byte{}, masterKey.Key...), masterKey.Salt...)
+	return fmt.Sprintf("%v%v %v inline:%v", CryptoAttribute, tag, ProtectionProfile,
+		base64.StdEncoding.EncodeToString(keyAndSalt))
+}
+
+// ParseCryptoAttribute reads master key from value of crypto attribute, lifetime of the key is ignored
+// and master key identifiers are not supported
+func ParseCryptoAttribute(value string) (MasterKey, error) {
+	fields := strings.Fields(value)
+	if len(fields) < 3 {
+		return MasterKey{}, fmt.Errorf("invalid crypto attribute %q", value)
+	}
+	if fields[1] != ProtectionProfile {
+		return MasterKey{}, fmt.Errorf("unsupported crypto suite %q", fields[1])
+	}
+	if !strings.HasPrefix(fields[2], "inline:") {
+		return MasterKey{}, fmt.Errorf("unsupported key method in %q", fields[2])
+	}
+	keyParameters := strings.Split(strings.TrimPrefix(fields[2], "inline:"), "|")
+	for _, parameter := range keyParameters[1:] {
+		if strings.Contains(parameter, ":") {
+			return MasterKey{}, fmt.Errorf("master key identifier %q not supported", parameter)
+		}
+	}
+	keyAndSalt, err := base64.StdEncoding.DecodeString(keyParameters[0])
+	if err != nil || len(keyAndSalt) != MasterKeySize+MasterSaltSize {
+		return MasterKey{}, fmt.Errorf("invalid inline key of crypto attribute %q", value)
+	}
+	return MasterKey{Key: keyAndSalt[:MasterKeySize], Salt: keyAndSalt[MasterKeySize:]}, nil
+}
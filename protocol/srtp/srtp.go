@@ -0,0 +1,347 @@
+package srtp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+const (
+	// AuthTagSize is size of HMAC-SHA1 tag truncated to 80 bits, appended to every protected packet
+	AuthTagSize = 10
+	authKeySize = 20
+	// SRTCP index with encryption flag follows encrypted part of SRTCP packet
+	srtcpIndexSize = 4
+	maxSrtcpIndex  = 1<<31 - 1
+	rtpHeaderSize  = 12
+	rtcpHeaderSize = 8
+	// replay protection remembers last 64 packets of every stream (RFC 3711, section 3.3.2)
+	replayWindowSize = 64
+)
+
+// labels of session keys derived from the master key (RFC 3711, section 4.3.1)
+const (
+	labelRtpEncryption byte = iota
+	labelRtpAuthentication
+	labelRtpSalt
+	labelRtcpEncryption
+	labelRtcpAuthentication
+	labelRtcpSalt
+)
+
+type sessionKeys struct {
+	block   cipher.Block
+	salt    []byte
+	authKey []byte
+}
+
+// replayWindow holds the highest authenticated index and bitmap of packets received before it
+type replayWindow struct {
+	highest uint64
+	bitmap  uint64
+	started bool
+}
+
+// rtpStream tracks rollover counter, which extends 16-bit sequence numbers to 48-bit packet index
+type rtpStream struct {
+	rolloverCounter uint32
+	lastSeqNum      uint16
+	replay          replayWindow
+}
+
+type rtcpStream struct {
+	index  uint32
+	replay replayWindow
+}
+
+// Context protects RTP and RTCP packets with session keys derived from single master key (RFC 3711),
+// state is kept per SSRC, so single context may protect outgoing streams and verify incoming ones
+// as long as their SSRCs differ
+type Context struct {
+	rtp         sessionKeys
+	rtcp        sessionKeys
+	rtpStreams  map[uint32]*rtpStream
+	rtcpStreams map[uint32]*rtcpStream
+	mutex       sync.Mutex
+}
+
+func NewContext(masterKey MasterKey) (*Context, error) {
+	if len(masterKey.Key) != MasterKeySize || len(masterKey.Salt) != MasterSaltSize {
+		return nil, errors.New("invalid size of master key or master salt")
+	}
+	block, err := aes.NewCipher(masterKey.Key)
+	if err != nil {
+		return nil, err
+	}
+	rtpKeys, err := newSessionKeys(block, masterKey.Salt, labelRtpEncryption, labelRtpAuthentication, labelRtpSalt)
+	if err != nil {
+		return nil, err
+	}
+	rtcpKeys, err := newSessionKeys(block, masterKey.Salt, labelRtcpEncryption, labelRtcpAuthentication,
+		labelRtcpSalt)
+	if err != nil {
+		return nil, err
+	}
+	return &Context{
+		rtp:         rtpKeys,
+		rtcp:        rtcpKeys,
+		rtpStreams:  make(map[uint32]*rtpStream),
+		rtcpStreams: make(map[uint32]*rtcpStream),
+	}, nil
+}
+
+func newSessionKeys(masterBlock cipher.Block, masterSalt []byte, encryptionLabel byte, authenticationLabel byte,
+	saltLabel byte) (sessionKeys, error) {
+	block, err := aes.NewCipher(deriveKey(masterBlock, masterSalt, encryptionLabel, MasterKeySize))
+	if err != nil {
+		return sessionKeys{}, err
+	}
+	return sessionKeys{
+		block:   block,
+		salt:    deriveKey(masterBlock, masterSalt, saltLabel, MasterSaltSize),
+		authKey: deriveKey(masterBlock, masterSalt, authenticationLabel, authKeySize),
+	}, nil
+}
+
+// deriveKey is AES-CM pseudo-random function of RFC 3711, section 4.3.3, keys are never refreshed
+// (key derivation rate 0), so the label is the only input besides the master salt
+func deriveKey(masterBlock cipher.Block, masterSalt []byte, label byte, size int) []byte {
+	iv := make([]byte, aes.BlockSize)
+	copy(iv, masterSalt)
+	iv[7] ^= label
+	result := make([]byte, size)
+	cipher.NewCTR(masterBlock, iv).XORKeyStream(result, result)
+	return result
+}
+
+// xorKeyStream encrypts or decrypts data with AES-CM, initial counter is session salt XOR SSRC XOR packet index
+func (k *sessionKeys) xorKeyStream(data []byte, ssrc uint32, index uint64) {
+	iv := make([]byte, aes.BlockSize)
+	copy(iv, k.salt)
+	for i := 0; i < 4; i++ {
+		iv[4+i] ^= byte(ssrc >> uint(24-8*i))
+	}
+	for i := 0; i < 6; i++ {
+		iv[8+i] ^= byte(index >> uint(40-8*i))
+	}
+	cipher.NewCTR(k.block, iv).XORKeyStream(data, data)
+}
+
+func (k *sessionKeys) authTag(parts ...[]byte) []byte {
+	mac := hmac.New(sha1.New, k.authKey)
+	for _, part := range parts {
+		mac.Write(part)
+	}
+	return mac.Sum(nil)[:AuthTagSize]
+}
+
+// accepts reports whether packet with the index wasn't received yet and isn't too old to tell
+func (w *replayWindow) accepts(index uint64) bool {
+	if !w.started || index > w.highest {
+		return true
+	}
+	delta := w.highest - index
+	return delta < replayWindowSize && w.bitmap&(1<<delta) == 0
+}
+
+func (w *replayWindow) add(index uint64) {
+	if !w.started {
+		w.started = true
+		w.highest = index
+		w.bitmap = 1
+	} else if index > w.highest {
+		shift := index - w.highest
+		if shift < replayWindowSize {
+			w.bitmap = w.bitmap<<shift | 1
+		} else {
+			w.bitmap = 1
+		}
+		w.highest = index
+	} else {
+		w.bitmap |= 1 << (w.highest - index)
+	}
+}
+
+// estimateRolloverCounter guesses rollover counter of the packet from the highest sequence number seen
+// (RFC 3711, appendix A)
+func (s *rtpStream) estimateRolloverCounter(seqNum uint16) uint32 {
+	if s.lastSeqNum < 1<<15 {
+		if int(seqNum)-int(s.lastSeqNum) > 1<<15 {
+			return s.rolloverCounter - 1
+		}
+	} else if int(s.lastSeqNum)-1<<15 > int(seqNum) {
+		return s.rolloverCounter + 1
+	}
+	return s.rolloverCounter
+}
+
+func (s *rtpStream) update(seqNum uint16, rolloverCounter uint32) {
+	if rolloverCounter == s.rolloverCounter+1 {
+		s.rolloverCounter = rolloverCounter
+		s.lastSeqNum = seqNum
+	} else if rolloverCounter == s.rolloverCounter && seqNum > s.lastSeqNum {
+		s.lastSeqNum = seqNum
+	}
+}
+
+func packetIndex(rolloverCounter uint32, seqNum uint16) uint64 {
+	return uint64(rolloverCounter)<<16 | uint64(seqNum)
+}
+
+func rolloverCounterBytes(rolloverCounter uint32) []byte {
+	result := make([]byte, 4)
+	binary.BigEndian.PutUint32(result, rolloverCounter)
+	return result
+}
+
+// rtpHeaderLength returns length of the header including CSRC list and header extension, which stay unencrypted
+func rtpHeaderLength(packet []byte) (int, error) {
+	if len(packet) < rtpHeaderSize {
+		return 0, errors.New("rtp packet too short")
+	}
+	if packet[0]>>6 != 2 {
+		return 0, errors.New("unsupported rtp version")
+	}
+	length := rtpHeaderSize + 4*int(packet[0]&0x0F)
+	if packet[0]&0x10 != 0 {
+		if len(packet) < length+4 {
+			return 0, errors.New("rtp header extension too short")
+		}
+		length += 4 + 4*int(binary.BigEndian.Uint16(packet[length+2:]))
+	}
+	if len(packet) < length {
+		return 0, errors.New("rtp header exceeds packet")
+	}
+	return length, nil
+}
+
+// EncryptRtp returns SRTP packet with encrypted payload and authentication tag
+func (c *Context) EncryptRtp(packet []byte) ([]byte, error) {
+	headerLength, err := rtpHeaderLength(packet)
+	if err != nil {
+		return nil, err
+	}
+	ssrc := binary.BigEndian.Uint32(packet[8:])
+	seqNum := binary.BigEndian.Uint16(packet[2:])
+
+	c.mutex.Lock()
+	stream, found := c.rtpStreams[ssrc]
+	if !found {
+		stream = &rtpStream{lastSeqNum: seqNum}
+		c.rtpStreams[ssrc] = stream
+	}
+	rolloverCounter := stream.estimateRolloverCounter(seqNum)
+	stream.update(seqNum, rolloverCounter)
+	c.mutex.Unlock()
+
+	result := make([]byte, len(packet), len(packet)+AuthTagSize)
+	copy(result, packet)
+	c.rtp.xorKeyStream(result[headerLength:], ssrc, packetIndex(rolloverCounter, seqNum))
+	return append(result, c.rtp.authTag(result, rolloverCounterBytes(rolloverCounter))...), nil
+}
+
+// DecryptRtp verifies authentication tag of SRTP packet and returns decrypted RTP packet,
+// replayed packets are rejected
+func (c *Context) DecryptRtp(packet []byte) ([]byte, error) {
+	if len(packet) < rtpHeaderSize+AuthTagSize {
+		return nil, errors.New("srtp packet too short")
+	}
+	authenticated := packet[:len(packet)-AuthTagSize]
+	headerLength, err := rtpHeaderLength(authenticated)
+	if err != nil {
+		return nil, err
+	}
+	ssrc := binary.BigEndian.Uint32(packet[8:])
+	seqNum := binary.BigEndian.Uint16(packet[2:])
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	stream, found := c.rtpStreams[ssrc]
+	if !found {
+		// the stream is remembered only once its first packet is authenticated
+		stream = &rtpStream{lastSeqNum: seqNum}
+	}
+	rolloverCounter := stream.estimateRolloverCounter(seqNum)
+	index := packetIndex(rolloverCounter, seqNum)
+	if !stream.replay.accepts(index) {
+		return nil, errors.New("replayed srtp packet")
+	}
+	expectedTag := c.rtp.authTag(authenticated, rolloverCounterBytes(rolloverCounter))
+	if !hmac.Equal(expectedTag, packet[len(authenticated):]) {
+		return nil, errors.New("srtp authentication failed")
+	}
+	c.rtpStreams[ssrc] = stream
+	stream.update(seqNum, rolloverCounter)
+	stream.replay.add(index)
+
+	result := append([]byte{}, authenticated...)
+	c.rtp.xorKeyStream(result[headerLength:], ssrc, index)
+	return result, nil
+}
+
+// EncryptRtcp returns SRTCP packet, everything after the first 8 bytes (header and SSRC of the sender)
+// is encrypted
+func (c *Context) EncryptRtcp(packet []byte) ([]byte, error) {
+	if len(packet) < rtcpHeaderSize {
+		return nil, errors.New("rtcp packet too short")
+	}
+	ssrc := binary.BigEndian.Uint32(packet[4:])
+
+	c.mutex.Lock()
+	stream, found := c.rtcpStreams[ssrc]
+	if !found {
+		stream = &rtcpStream{}
+		c.rtcpStreams[ssrc] = stream
+	}
+	index := stream.index
+	if index > maxSrtcpIndex {
+		c.mutex.Unlock()
+		return nil, errors.New("srtcp index exhausted, new master key is required")
+	}
+	stream.index++
+	c.mutex.Unlock()
+
+	result := make([]byte, len(packet), len(packet)+srtcpIndexSize+AuthTagSize)
+	copy(result, packet)
+	c.rtcp.xorKeyStream(result[rtcpHeaderSize:], ssrc, uint64(index))
+	// the highest bit marks encrypted packet
+	result = append(result, byte(index>>24)|0x80, byte(index>>16), byte(index>>8), byte(index))
+	return append(result, c.rtcp.authTag(result)...), nil
+}
+
+// DecryptRtcp verifies authentication tag of SRTCP packet and returns RTCP packet, replayed packets are rejected
+func (c *Context) DecryptRtcp(packet []byte) ([]byte, error) {
+	if len(packet) < rtcpHeaderSize+srtcpIndexSize+AuthTagSize {
+		return nil, errors.New("srtcp packet too short")
+	}
+	authenticated := packet[:len(packet)-AuthTagSize]
+	trailer := binary.BigEndian.Uint32(authenticated[len(authenticated)-srtcpIndexSize:])
+	encrypted := trailer>>31 == 1
+	index := uint64(trailer & maxSrtcpIndex)
+	ssrc := binary.BigEndian.Uint32(packet[4:])
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	stream, found := c.rtcpStreams[ssrc]
+	if !found {
+		stream = &rtcpStream{}
+	}
+	if !stream.replay.accepts(index) {
+		return nil, errors.New("replayed srtcp packet")
+	}
+	if !hmac.Equal(c.rtcp.authTag(authenticated), packet[len(authenticated):]) {
+		return nil, errors.New("srtcp authentication failed")
+	}
+	c.rtcpStreams[ssrc] = stream
+	stream.replay.add(index)
+
+	result := append([]byte{}, authenticated[:len(authenticated)-srtcpIndexSize]...)
+	if encrypted {
+		c.rtcp.xorKeyStream(result[rtcpHeaderSize:], ssrc, index)
+	}
+	return result, nil
+}
@@ -0,0 +1,54 @@
+package srtp
+
+import (
+	"bytes"
+	"testing"
+)
+
+var fuzzMasterKey = MasterKey{
+	Key:  []byte("0123456789abcdef"),
+	Salt: []byte("0123456789abcd"),
+}
+
+func FuzzDecryptRtp(f *testing.F) {
+	sender, err := NewContext(fuzzMasterKey)
+	if err != nil {
+		f.Fatal(err)
+	}
+	protected, _ := sender.EncryptRtp(rtpPacket(1, "jpeg frame"))
+	f.Add(protected)
+	f.Add(protected[:rtpHeaderSize+AuthTagSize])
+	f.Add(append([]byte{0x9F}, protected[1:]...))
+
+	f.Fuzz(func(t *testing.T, packet []byte) {
+		receiver, _ := NewContext(fuzzMasterKey)
+		decrypted, err := receiver.DecryptRtp(packet)
+		if err != nil {
+			return
+		}
+		if len(decrypted) != len(packet)-AuthTagSize || !bytes.Equal(decrypted[:rtpHeaderSize], packet[:rtpHeaderSize]) {
+			t.Fatalf("packet of %v bytes decrypted as %v bytes with another header", len(packet), len(decrypted))
+		}
+	})
+}
+
+func FuzzDecryptRtcp(f *testing.F) {
+	sender, err := NewContext(fuzzMasterKey)
+	if err != nil {
+		f.Fatal(err)
+	}
+	protected, _ := sender.EncryptRtcp([]byte{0x81, 201, 0, 7, 0, 0, 0x27, 0x0E, 1, 2, 3, 4})
+	f.Add(protected)
+	f.Add(protected[:rtcpHeaderSize+srtcpIndexSize+AuthTagSize])
+
+	f.Fuzz(func(t *testing.T, packet []byte) {
+		receiver, _ := NewContext(fuzzMasterKey)
+		decrypted, err := receiver.DecryptRtcp(packet)
+		if err != nil {
+			return
+		}
+		if len(decrypted) != len(packet)-srtcpIndexSize-AuthTagSize {
+			t.Fatalf("packet of %v bytes decrypted as %v bytes", len(packet), len(decrypted))
+		}
+	})
+}
@@ -0,0 +1,166 @@
+package srtp
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"testing"
+)
+
+func decodeHex(t *testing.T, value string) []byte {
+	t.Helper()
+	result, err := hex.DecodeString(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+// test vectors of RFC 3711, appendix B.3
+func TestKeyDerivation(t *testing.T) {
+	context, err := NewContext(MasterKey{
+		Key:  decodeHex(t, "E1F97A0D3E018BE0D64FA32C06DE4139"),
+		Salt: decodeHex(t, "0EC675AD498AFEEBB6960B3AABE6"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := make([]byte, aes.BlockSize)
+	context.rtp.block.Encrypt(encrypted, make([]byte, aes.BlockSize))
+	expectedBlock, _ := aes.NewCipher(decodeHex(t, "C61E7A93744F39EE10734AFE3FF7A087"))
+	expected := make([]byte, aes.BlockSize)
+	expectedBlock.Encrypt(expected, make([]byte, aes.BlockSize))
+	if !bytes.Equal(encrypted, expected) {
+		t.Error("derived cipher key doesn't match")
+	}
+	if salt := decodeHex(t, "30CBBC08863D8C85D49DB34A9AE1"); !bytes.Equal(context.rtp.salt, salt) {
+		t.Errorf("derived salt %x, expected %x", context.rtp.salt, salt)
+	}
+	if authKey := decodeHex(t, "CEBE321F6FF7716B6FD4AB49AF256A156D38BAA4"); !bytes.Equal(context.rtp.authKey, authKey) {
+		t.Errorf("derived authentication key %x, expected %x", context.rtp.authKey, authKey)
+	}
+}
+
+// test vectors of RFC 3711, appendix B.2
+func TestKeyStream(t *testing.T) {
+	block, err := aes.NewCipher(decodeHex(t, "2B7E151628AED2A6ABF7158809CF4F3C"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := sessionKeys{block: block, salt: decodeHex(t, "F0F1F2F3F4F5F6F7F8F9FAFBFCFD")}
+	keyStream := make([]byte, 3*aes.BlockSize)
+	keys.xorKeyStream(keyStream, 0, 0)
+	expected := decodeHex(t, "E03EAD0935C95E80E166B16DD92B4EB4"+
+		"D23513162B02D0F72A43A2FE4A5F97AB"+
+		"41E95B3BB0A2E8DD477901E4FCA894C0")
+	if !bytes.Equal(keyStream, expected) {
+		t.Fatalf("key stream %x, expected %x", keyStream, expected)
+	}
+}
+
+func newContexts(t *testing.T) (*Context, *Context) {
+	masterKey, err := GenerateMasterKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender, err := NewContext(masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver, err := NewContext(masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sender, receiver
+}
+
+func rtpPacket(seqNum uint16, payload string) []byte {
+	return append([]byte{0x80, 26, byte(seqNum >> 8), byte(seqNum), 0, 0, 0, 1, 0, 0, 0x27, 0x0F}, payload...)
+}
+
+func TestRtpProtection(t *testing.T) {
+	sender, receiver := newContexts(t)
+	// sequence numbers wrap around, rollover counter has to follow
+	for _, seqNum := range []uint16{65530, 65534, 65535, 0, 3, 2} {
+		packet := rtpPacket(seqNum, "jpeg frame")
+		protected, err := sender.EncryptRtp(packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(protected, []byte("jpeg frame")) {
+			t.Fatal("payload isn't encrypted")
+		}
+		decrypted, err := receiver.DecryptRtp(protected)
+		if err != nil {
+			t.Fatalf("packet %v: %v", seqNum, err)
+		}
+		if !bytes.Equal(decrypted, packet) {
+			t.Fatalf("packet %v decrypted as %x", seqNum, decrypted)
+		}
+		if _, err = receiver.DecryptRtp(protected); err == nil {
+			t.Fatalf("replayed packet %v accepted", seqNum)
+		}
+	}
+	if receiver.rtpStreams[9999].rolloverCounter != 1 {
+		t.Fatalf("rollover counter %v after wrap around", receiver.rtpStreams[9999].rolloverCounter)
+	}
+
+	protected, _ := sender.EncryptRtp(rtpPacket(4, "jpeg frame"))
+	protected[len(protected)-AuthTagSize-1] ^= 1
+	if _, err := receiver.DecryptRtp(protected); err == nil {
+		t.Fatal("tampered packet accepted")
+	}
+}
+
+func TestRtcpProtection(t *testing.T) {
+	sender, receiver := newContexts(t)
+	packet := []byte{0x81, 201, 0, 7, 0, 0, 0x27, 0x0E, 1, 2, 3, 4, 5, 6, 7, 8}
+	for index := 0; index < 3; index++ {
+		protected, err := sender.EncryptRtcp(packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := receiver.DecryptRtcp(protected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decrypted, packet) {
+			t.Fatalf("packet decrypted as %x", decrypted)
+		}
+		if _, err = receiver.DecryptRtcp(protected); err == nil {
+			t.Fatal("replayed packet accepted")
+		}
+	}
+
+	_, foreign := newContexts(t)
+	protected, _ := sender.EncryptRtcp(packet)
+	if _, err := foreign.DecryptRtcp(protected); err == nil {
+		t.Fatal("packet accepted with another master key")
+	}
+}
+
+func TestCryptoAttribute(t *testing.T) {
+	masterKey, err := GenerateMasterKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	attribute := FormatCryptoAttribute(1, masterKey)
+	parsed, err := ParseCryptoAttribute(attribute[len(CryptoAttribute):] + "|2^31")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(parsed.Key, masterKey.Key) || !bytes.Equal(parsed.Salt, masterKey.Salt) {
+		t.Fatalf("attribute %v parsed as %+v", attribute, parsed)
+	}
+
+	for _, invalid := range []string{
+		"1 AES_CM_128_HMAC_SHA1_32 inline:WVNfX19zZW1jdGwgKCkgewkyMjA7fQp9CnVubGVz",
+		"1 AES_CM_128_HMAC_SHA1_80 inline:c2hvcnQ=",
+		"1 AES_CM_128_HMAC_SHA1_80 inline:WVNfX19zZW1jdGwgKCkgewkyMjA7fQp9CnVubGVz|2^20|1:4",
+		"1 AES_CM_128_HMAC_SHA1_80",
+	} {
+		if _, err := ParseCryptoAttribute(invalid); err == nil {
+			t.Errorf("invalid attribute %q accepted", invalid)
+		}
+	}
+}
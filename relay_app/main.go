@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"streming_server/logging"
+	"streming_server/netem"
+	"syscall"
+	"time"
+)
+
+func main() {
+	loss := flag.Float64("loss", 0, "probability of losing packet")
+	burstP := flag.Float64("burst-p", 0, "probability of entering bursty loss state per packet, "+
+		"enables Gilbert-Elliott loss model instead of -loss")
+	burstR := flag.Float64("burst-r", 0.5, "probability of leaving bursty loss state per packet")
+	burstLoss := flag.Float64("burst-loss", 1, "probability of losing packet in bursty loss state")
+	delay := flag.Duration("delay", 0, "one way delay of packets")
+	jitter := flag.Duration("jitter", 0, "packets are delayed uniformly by delay ± jitter")
+	reorder := flag.Float64("reorder", 0, "probability that packet skips the delay and overtakes earlier packets")
+	duplicate := flag.Float64("duplicate", 0, "probability of duplicating packet")
+	bandwidth := flag.Int("bandwidth", 0, "link capacity in bits per second, 0 means unlimited")
+	queueDelay := flag.Duration("queue-delay", netem.DefaultQueueDelay, "maximal queueing delay of capped link")
+	seed := flag.Int64("seed", 0, "seed of random generator, 0 uses current time")
+	logFormat := flag.String("log-format", logging.TextFormat, "format of logged messages, text or json")
+	flag.Parse()
+
+	if err := logging.SetFormat(*logFormat); err != nil {
+		logging.Fatal("invalid arguments", logging.ErrorKey, err)
+	}
+
+	if flag.NArg() < 2 {
+		logging.Fatal("incorrect number of arguments, provide listening port and destination address")
+	}
+
+	relay, err := netem.NewRelay(fmt.Sprint(":", flag.Arg(0)), flag.Arg(1), netem.Impairment{
+		Loss: *loss,
+		BurstLoss: netem.GilbertElliott{
+			P:       *burstP,
+			R:       *burstR,
+			LossBad: *burstLoss,
+		},
+		Delay:      *delay,
+		Jitter:     *jitter,
+		Reorder:    *reorder,
+		Duplicate:  *duplicate,
+		Bandwidth:  *bandwidth,
+		QueueDelay: *queueDelay,
+		Seed:       *seed,
+	})
+	if err != nil {
+		logging.Fatal("cannot start relay", logging.ErrorKey, err)
+	}
+	relay.Start()
+	relayLogger := logging.With(logging.ComponentKey, "NETEM")
+	relayLogger.Info("relaying packets", "from", relay.Address(), "to", flag.Arg(1))
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sigs:
+			relay.Close()
+			relayLogger.Info("relay closed")
+			return
+		case <-ticker.C:
+			stats := relay.Stats()
+			relayLogger.Info("statistics", "received", stats.Received, "lost", stats.Lost,
+				"queueDropped", stats.QueueDropped, "duplicated", stats.Duplicated, "reordered", stats.Reordered,
+				"forwarded", stats.Forwarded, "forwardedBytes", stats.ForwardedBytes)
+		}
+	}
+}
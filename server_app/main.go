@@ -1,91 +1,115 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
-	"log"
-	"net"
 	"os"
 	"os/signal"
 	"streming_server/components"
-	"streming_server/protocol/rtp"
-	"streming_server/protocol/rtsp/state"
-	"sync"
+	"streming_server/logging"
+	"strings"
 	"syscall"
 )
 
 func main() {
-	if len(os.Args) < 2 {
-		log.Fatalln("[ERROR] incorrect number of arguments, please provide server port")
-	}
-
-	port := os.Args[1]
-	log.Println("[RTSP] server started")
-
-	mainChannel := make(chan *rtp.Packet)
-	serverMap := new(sync.Map)
+	configFileName := flag.String("config", "", "JSON configuration file of listeners, mount points, sources, "+
+		"authentication, congestion control, recording, http outputs and logging, "+
+		"other flags and the port are ignored with it")
+	recordDirectory := flag.String("record", "", "directory where incoming streams are recorded as avi files")
+	dvrDirectory := flag.String("dvr", "", "directory of the rolling recording used for time-shifted playback")
+	dvrRetention := flag.Duration("dvr-retention", components.DefaultDvrRetention, "how long dvr recording is kept")
+	dvrSegment := flag.Duration("dvr-segment", components.DefaultDvrSegmentDuration, "duration of single dvr segment")
+	httpAddress := flag.String("http", "", "address of http server providing HLS, MJPEG and snapshot outputs, e.g. :8080")
+	adminAddress := flag.String("admin", "", "address of JSON administration endpoints /mountpoints, /publishers "+
+		"and /sessions, and Prometheus /metrics, e.g. 127.0.0.1:9090")
+	fecGroupSize := flag.Int("fec", 0, "media packets protected by single parity packet without congestion, "+
+		"0 disables forward error correction")
+	congestionStrategyName := flag.String("cc", components.DefaultCongestionStrategy,
+		fmt.Sprint("congestion control strategy, one of: ", components.CongestionStrategyNames()))
+	userFileName := flag.String("users", "", "file with users allowed to read and publish streams, "+
+		"lines in form <username>:<MD5 hash>:<SHA-256 hash>:<read paths>:<publish paths>, "+
+		"no authentication is required without it")
+	realm := flag.String("realm", components.DefaultRealm, "authentication realm which user hashes are bound to")
+	userEntry := flag.String("user-entry", "", "prints line of the user file for <username>:<password> and exits")
+	certificateFileName := flag.String("cert", "", "pem file with certificate chain of the server, "+
+		"the server accepts only rtsps:// sessions with SRTP protected media when it is set")
+	keyFileName := flag.String("key", "", "pem file with private key of the certificate")
+	shutdownTimeout := flag.Duration("shutdown-timeout", components.DefaultShutdownTimeout,
+		"how long sessions may take to release their resources when the server stops")
+	logLevel := flag.String("log-level", logging.LevelInfo.String(),
+		"minimal level of logged messages, one of trace, debug, info, warn and error")
+	logFormat := flag.String("log-format", logging.TextFormat, "format of logged messages, text or json")
+	flag.Parse()
 
-	sigs := make(chan os.Signal, 1)
-	done := make(chan bool)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	if *userEntry != "" {
+		usernameAndPassword := strings.SplitN(*userEntry, ":", 2)
+		if len(usernameAndPassword) != 2 {
+			logging.Fatal("user entry has to be in form <username>:<password>")
+		}
+		fmt.Println(components.FormatUserEntry(usernameAndPassword[0], *realm, usernameAndPassword[1],
+			[]string{"*"}, []string{}))
+		return
+	}
 
-	listener, err := net.Listen("tcp", fmt.Sprint(":", port))
+	var config *components.ServerConfig
+	var err error
+	if *configFileName != "" {
+		config, err = components.LoadServerConfig(*configFileName)
+		if err != nil {
+			logging.Fatal("cannot load configuration", logging.ErrorKey, err)
+		}
+	} else {
+		if flag.NArg() < 1 {
+			logging.Fatal("incorrect number of arguments, please provide server port or -config file")
+		}
+		config = components.DefaultServerConfig()
+		config.Listeners = []components.ListenerConfig{{
+			Address:     fmt.Sprint(":", flag.Arg(0)),
+			Certificate: *certificateFileName,
+			Key:         *keyFileName,
+		}}
+		config.Auth = components.AuthConfig{Users: *userFileName, Realm: *realm}
+		config.Congestion.Strategy = *congestionStrategyName
+		config.Congestion.FecGroupSize = *fecGroupSize
+		config.Recording.Directory = *recordDirectory
+		config.Dvr = components.DvrConfig{
+			Directory: *dvrDirectory,
+			Retention: components.Duration(*dvrRetention),
+			Segment:   components.Duration(*dvrSegment),
+		}
+		config.Http.Address = *httpAddress
+		config.Admin.Address = *adminAddress
+		config.Log = components.LogConfig{Level: *logLevel, Format: *logFormat}
+		config.ShutdownTimeout = components.Duration(*shutdownTimeout)
+		if err = config.Validate(); err != nil {
+			logging.Fatal("invalid arguments", logging.ErrorKey, err)
+		}
+	}
+	server := components.NewStreamingServer(config, *configFileName)
+	err = server.Start()
 	if err != nil {
-		log.Println("[ERROR] error while opening connection:", err)
+		logging.Fatal("cannot start server", logging.ErrorKey, err)
 	}
+	serverLogger := logging.With(logging.ComponentKey, "RTSP")
+	serverLogger.Info("server started")
 
-	go runDataDisposer(serverMap, mainChannel, done)
-
-	go func(serverMap *sync.Map, listener net.Listener) {
-		for {
-			clientConnection, err := listener.Accept()
-			if err != nil {
-				log.Println("[ERROR] error while connecting with client:", err)
-			}
-			go func(serverMap *sync.Map, clientConnection net.Conn) {
-				log.Printf("[RTSP] received new connection from %v", clientConnection.RemoteAddr().String())
-				privateChannel := make(chan *rtp.Packet)
-				srv := components.NewServer(clientConnection, mainChannel, privateChannel)
-				serverMap.LoadOrStore(srv, privateChannel)
-				srv.Start()
-			}(serverMap, clientConnection)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigs {
+		if sig != syscall.SIGHUP {
+			break
 		}
-	}(serverMap, listener)
-
-	<-sigs
-	freeResources(serverMap)
-	done <- true
-	log.Println("[RTSP] Server closed")
-}
-
-func runDataDisposer(serverMap *sync.Map, mainChannel chan *rtp.Packet, done chan bool) {
-	for {
-		select {
-		case packet := <-mainChannel:
-			serverMap.Range(
-				func(k, v interface{}) bool {
-					srv := k.(*components.RtspServer)
-					privateChan := v.(chan *rtp.Packet)
-					if srv.State == state.Playing {
-						privateChan <- packet
-					} else if srv.State == state.Detached {
-						srv.CloseConnection()
-						serverMap.Delete(srv)
-					}
-					return true
-				},
-			)
-		case <-done:
-			return
+		// SIGHUP reloads configuration file without dropping sessions
+		if err = server.ReloadConfigFile(); err != nil {
+			serverLogger.Error("configuration can't be reloaded", logging.ErrorKey, err)
 		}
 	}
-}
-
-func freeResources(serverMap *sync.Map) {
-	serverMap.Range(
-		func(k, v interface{}) bool {
-			srv := k.(*components.RtspServer)
-			srv.CloseConnection()
-			return true
-		},
-	)
+	// sessions get RTCP BYE, then recordings are finalized and http streams are closed
+	ctx, cancel := context.WithTimeout(context.Background(), server.ShutdownTimeout())
+	defer cancel()
+	if err = server.Shutdown(ctx); err != nil {
+		serverLogger.Fatal("server wasn't shut down cleanly", logging.ErrorKey, err)
+	}
+	serverLogger.Info("server closed")
 }
@@ -7,11 +7,13 @@ import (
 	"fyne.io/fyne/canvas"
 	"fyne.io/fyne/layout"
 	"fyne.io/fyne/widget"
-	"log"
+	"streming_server/logging"
 	"streming_server/ui/resources"
 	"streming_server/video"
 )
 
+var guiLogger = logging.With(logging.ComponentKey, "GUI")
+
 type View struct {
 	FrameSync        *video.FrameSync
 	Window           fyne.Window
@@ -26,10 +28,13 @@ type View struct {
 	onPause    func()
 	onDescribe func()
 	onTeardown func()
+	onRewind   func()
+	onLive     func()
 }
 
 func NewView(frameSync *video.FrameSync,
-	OnSetup func(), OnRecord func(), OnPlay func(), OnPause func(), OnDescribe func(), OnTeardown func()) *View {
+	OnSetup func(), OnRecord func(), OnPlay func(), OnPause func(), OnDescribe func(), OnTeardown func(),
+	OnRewind func(), OnLive func()) *View {
 	view := &View{
 		FrameSync:  frameSync,
 		onSetup:    OnSetup,
@@ -38,6 +43,8 @@ func NewView(frameSync *video.FrameSync,
 		onPause:    OnPause,
 		onDescribe: OnDescribe,
 		onTeardown: OnTeardown,
+		onRewind:   OnRewind,
+		onLive:     OnLive,
 	}
 	view.InitView()
 	view.onSetup = OnSetup
@@ -74,13 +81,13 @@ func (view *View) StartGUI() {
 }
 
 func (view *View) UpdateImage() {
-	if !view.FrameSync.Empty() {
+	if view.FrameSync.Ready() {
 		view.Image.Resource = fyne.NewStaticResource("livestream", view.FrameSync.NextFrame())
 		canvas.Refresh(view.Image)
 	}
 }
 
-func (view *View) UpdateStatistics(totalBytesReceived int, packageLost int, dataRate float64) {
+func (view *View) UpdateStatistics(totalBytesReceived int, packageLost int, dataRate float64, estimatedBandwidth int) {
 	view.StatisticsBox.Children[0].(*widget.Label).SetText(
 		fmt.Sprint(resources.TotalBytesReceivedText, totalBytesReceived),
 	)
@@ -90,13 +97,26 @@ func (view *View) UpdateStatistics(totalBytesReceived int, packageLost int, data
 	view.StatisticsBox.Children[2].(*widget.Label).SetText(
 		fmt.Sprintf("%v%.2f", resources.DataRateText, dataRate),
 	)
+	view.StatisticsBox.Children[3].(*widget.Label).SetText(
+		fmt.Sprint(resources.EstimatedBandwidthText, estimatedBandwidth),
+	)
+	view.StatisticsBox.Refresh()
+}
+
+// UpdateResolution shows resolution of received frames, source resolution is shown when frames are downscaled
+func (view *View) UpdateResolution(width int, height int, sourceWidth int, sourceHeight int) {
+	text := fmt.Sprintf("%v%vx%v", resources.ResolutionText, width, height)
+	if width != sourceWidth || height != sourceHeight {
+		text += fmt.Sprintf(" (source %vx%v)", sourceWidth, sourceHeight)
+	}
+	view.StatisticsBox.Children[4].(*widget.Label).SetText(text)
 	view.StatisticsBox.Refresh()
 }
 
 func resolveIcon(name string) fyne.Resource {
 	icon, err := fyne.LoadResourceFromPath(fmt.Sprintf("ui/resources/icons/%v-icon.png", name))
 	if err != nil {
-		log.Println("[ERROR] cannot retrieve resource:", err)
+		guiLogger.Error("cannot retrieve resource", logging.ErrorKey, err)
 	}
 	return icon
 }
@@ -115,6 +135,8 @@ func (view *View) prepareButtonsContainer() *fyne.Container {
 		widget.NewButtonWithIcon("Play", resolveIcon("play"), view.onPlay),
 		widget.NewButtonWithIcon("Pause", resolveIcon("pause"), view.onPause),
 		widget.NewButtonWithIcon("Describe", resolveIcon("describe"), view.onDescribe),
+		widget.NewButton(resources.RewindText, view.onRewind),
+		widget.NewButton(resources.LiveText, view.onLive),
 	}
 
 	result := make([]fyne.CanvasObject, 0)
@@ -131,6 +153,8 @@ func prepareStatisticsBox() *widget.Box {
 		widget.NewLabel(fmt.Sprint(resources.TotalBytesReceivedText, 0)),
 		widget.NewLabel(fmt.Sprint(resources.PackageLostText, 0)),
 		widget.NewLabel(fmt.Sprint(resources.DataRateText, 0)),
+		widget.NewLabel(fmt.Sprint(resources.EstimatedBandwidthText, 0)),
+		widget.NewLabel(resources.ResolutionText),
 	)
 	return result
 }
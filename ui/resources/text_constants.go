@@ -4,4 +4,8 @@ const (
 	TotalBytesReceivedText = "Total Bytes Received: "
 	PackageLostText        = "Package Lost: "
 	DataRateText           = "Data Rate (bytes/sec): "
+	EstimatedBandwidthText = "Estimated Bandwidth (bits/sec): "
+	ResolutionText         = "Resolution: "
+	RewindText             = "Rewind 30s"
+	LiveText               = "Live"
 )
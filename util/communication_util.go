@@ -4,24 +4,79 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"strconv"
+	"streming_server/logging"
 	"strings"
+	"time"
 )
 
+var rtspLogger = logging.With(logging.ComponentKey, "RTSP")
+
+const clockRangeLayout = "20060102T150405.999999999Z"
+const maxRequestBodySize = 64 * 1024
+
+// payload types starting from it have no static meaning and are mapped by rtpmap attribute
+const dynamicPayloadTypes = 96
+
 func FormatHeader(sequentialNumber int, sessionId string) string {
 	return fmt.Sprintf(
 		"RTSP/1.0 200 OK\r\nCSeq: %v\r\nSession: %v\r\n", sequentialNumber, sessionId,
 	)
 }
 
-func PrepareDescribeResponse(sequentialNumber int, rtspDestinationPort string, mjpegType int,
-	sessionId string, videoFileName string,
+func FormatErrorHeader(sequentialNumber int, sessionId string, statusCode int, reason string) string {
+	return fmt.Sprintf(
+		"RTSP/1.0 %v %v\r\nCSeq: %v\r\nSession: %v\r\n", statusCode, reason, sequentialNumber, sessionId,
+	)
+}
+
+// FrameSizeAttribute announces resolution of the source in SDP, in form a=framesize:<payload type> <width>-<height>
+const FrameSizeAttribute = "a=framesize:"
+
+// ParseFrameSize reads resolution from value of framesize attribute
+func ParseFrameSize(value string) (int, int, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("invalid framesize attribute %q", value)
+	}
+	var width, height int
+	_, err := fmt.Sscanf(fields[1], "%d-%d", &width, &height)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid framesize attribute %q: %v", value, err)
+	}
+	return width, height, nil
+}
+
+// PrepareDescribeResponse describes MJPEG stream with RTX retransmission stream (RFC 4588) and NACK feedback,
+// ULPFEC stream (RFC 5109) is described when fecType is not 0, source resolution when width is not 0,
+// the stream is announced as SRTP protected when cryptoAttribute with its master key isn't empty,
+// dynamic payload type of the stream gets its own rtpmap
+func PrepareDescribeResponse(sequentialNumber int, rtspDestinationPort string, mjpegType int, rtxType int,
+	fecType int, width int, height int, cryptoAttribute string, sessionId string, videoFileName string,
 ) string {
 
+	profile := "RTP/AVP"
+	if cryptoAttribute != "" {
+		profile = "RTP/SAVP"
+	}
 	control := fmt.Sprintf(
-		"v=0\r\nm=video %v RTP/AVP %v\r\na=control:streamid=%v\r\na=mimetypestring;\"video/MJPEG\"\r\n",
-		rtspDestinationPort, mjpegType, sessionId,
+		"v=0\r\nm=video %v %v %v %v\r\na=control:streamid=%v\r\na=mimetypestring;\"video/MJPEG\"\r\n"+
+			"a=rtpmap:%v rtx/90000\r\na=fmtp:%v apt=%v\r\na=rtcp-fb:%v nack\r\n",
+		rtspDestinationPort, profile, mjpegType, rtxType, sessionId, rtxType, rtxType, mjpegType, mjpegType,
 	)
+	if mjpegType >= dynamicPayloadTypes {
+		control += fmt.Sprintf("a=rtpmap:%v JPEG/90000\r\n", mjpegType)
+	}
+	if cryptoAttribute != "" {
+		control += cryptoAttribute + "\r\n"
+	}
+	if fecType != 0 {
+		control += fmt.Sprintf("a=rtpmap:%v ulpfec/90000\r\n", fecType)
+	}
+	if width != 0 {
+		control += fmt.Sprintf("%v%v %v-%v\r\n", FrameSizeAttribute, mjpegType, width, height)
+	}
 	content := fmt.Sprintf("Content-Base: %v\r\nContent-Type: application/sdp\r\nContent-Length: %v\r\n",
 		videoFileName, len(control),
 	)
@@ -33,27 +88,127 @@ func PrepareSetupResponse(sequentialNumber int, serverPort string, sessionId str
 	return fmt.Sprint(FormatHeader(sequentialNumber, sessionId), content)
 }
 
-func ReadRequestElements(bufferedReader *bufio.Reader) []string {
-	request := ""
-	for i := 0; i < 3; i++ {
+// ReadRequestLines reads request line and headers up to the empty line which ends the request
+func ReadRequestLines(bufferedReader *bufio.Reader) []string {
+	requestLines := make([]string, 0)
+	for {
 		requestLineBytes, _, err := bufferedReader.ReadLine()
 		if err != nil {
-			log.Println("Client disconnected.")
+			rtspLogger.Debug("connection closed while reading request", logging.ErrorKey, err)
 			return make([]string, 0)
 		}
 		requestLine := string(requestLineBytes)
-		request += requestLine + " "
-		log.Println("\t[RTSP message]", requestLine)
+		if requestLine == "" {
+			if len(requestLines) == 0 {
+				// skip empty lines between requests
+				continue
+			}
+			break
+		}
+		requestLines = append(requestLines, requestLine)
+		if strings.HasPrefix(strings.ToLower(requestLine), "authorization:") {
+			// credentials are kept out of logs
+			requestLine = "Authorization: ..."
+		}
+		rtspLogger.Debug("request line received", "line", requestLine)
+	}
+	return requestLines
+}
+
+// ReadRequestElements reads request line and headers up to the empty line which ends the request
+func ReadRequestElements(bufferedReader *bufio.Reader) []string {
+	return RequestElements(ReadRequestLines(bufferedReader))
+}
+
+// RequestElements splits lines of the request into space separated elements
+func RequestElements(requestLines []string) []string {
+	if len(requestLines) == 0 {
+		return make([]string, 0)
+	}
+	return strings.Split(strings.Join(requestLines, " ")+" ", " ")
+}
+
+// ParseHeaderLine returns whole value of the header, which may contain spaces unlike values returned by ParseHeader
+func ParseHeaderLine(requestLines []string, headerName string) (string, error) {
+	for _, line := range requestLines {
+		nameAndValue := strings.SplitN(line, ":", 2)
+		if len(nameAndValue) == 2 && strings.EqualFold(strings.TrimSpace(nameAndValue[0]), headerName) {
+			return strings.TrimSpace(nameAndValue[1]), nil
+		}
+	}
+	return "", errors.New("unable to parse header")
+}
+
+// ParseRequestLine returns method, url and sequence number of the request read by ReadRequestElements
+func ParseRequestLine(requestElements []string) (string, string, int, error) {
+	if len(requestElements) < 3 || requestElements[0] == "" || requestElements[1] == "" {
+		return "", "", 0, errors.New("incomplete request line")
+	}
+	cseq, err := ParseHeader(requestElements, "CSeq")
+	if err != nil {
+		return "", "", 0, errors.New("missing CSeq header")
+	}
+	sequentialNumber, err := strconv.Atoi(cseq)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("invalid CSeq header %q", cseq)
+	}
+	return requestElements[0], requestElements[1], sequentialNumber, nil
+}
+
+// ReadRequestBody reads body of the request which declares Content-Length header
+func ReadRequestBody(bufferedReader *bufio.Reader, requestElements []string) ([]byte, error) {
+	contentLength, err := ParseHeader(requestElements, "Content-Length")
+	if err != nil {
+		return make([]byte, 0), nil
 	}
-	return strings.Split(request, " ")
+	length, err := strconv.Atoi(contentLength)
+	if err != nil || length < 0 || length > maxRequestBodySize {
+		return nil, errors.New("invalid content length")
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(bufferedReader, body)
+	return body, err
+}
+
+func PrepareContentResponse(sequentialNumber int, sessionId string, contentType string, content []byte) []byte {
+	header := fmt.Sprintf("%vContent-Type: %v\r\nContent-Length: %v\r\n\r\n",
+		FormatHeader(sequentialNumber, sessionId), contentType, len(content))
+	return append([]byte(header), content...)
+}
+
+// ParseHeader returns value of the header with given name from the request elements
+func ParseHeader(requestElements []string, headerName string) (string, error) {
+	for index, element := range requestElements {
+		if strings.EqualFold(element, headerName+":") && index+1 < len(requestElements) {
+			return requestElements[index+1], nil
+		}
+	}
+	return "", errors.New("unable to parse header")
+}
+
+// ParseClockRange returns beginning of the absolute time range, e.g. "clock=20201019T101500Z-"
+func ParseClockRange(rangeValue string) (time.Time, error) {
+	if !strings.HasPrefix(rangeValue, "clock=") {
+		return time.Time{}, errors.New("unsupported range unit")
+	}
+	begin := strings.Split(strings.TrimPrefix(rangeValue, "clock="), "-")[0]
+	return time.Parse(clockRangeLayout, begin)
+}
+
+func FormatClockRange(beginTime time.Time) string {
+	return fmt.Sprintf("clock=%v-", beginTime.UTC().Format(clockRangeLayout))
 }
 
+// ParseParameter returns comma separated values of transport parameter, e.g. "client_port=5000,5001"
 func ParseParameter(text string, parameterName string) ([]string, error) {
 	transportOptions := strings.Split(text, ";")
 	for _, option := range transportOptions {
 		if strings.HasPrefix(option, parameterName) {
-			value := strings.Split(option, "=")[1]
-			ports := strings.Split(value, ",")
+			nameAndValue := strings.SplitN(option, "=", 2)
+			if len(nameAndValue) != 2 {
+				return make([]string, 0), errors.New("parameter without value")
+			}
+			ports := strings.Split(nameAndValue[1], ",")
 			return ports, nil
 		}
 	}
@@ -0,0 +1,66 @@
+package util
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func FuzzParseRequest(f *testing.F) {
+	f.Add("SETUP /stream RTSP/1.0\r\nCSeq: 1\r\nTransport: RTP/UDP;client_port=5000,5001\r\n\r\n")
+	f.Add("PLAY /stream RTSP/1.0\r\nCSeq: 3\r\nSession: 1\r\nRange: clock=20201019T101500Z-\r\nScale: 2\r\n\r\n")
+	f.Add("GET_PARAMETER /stream RTSP/1.0\r\nCSeq: 4\r\nContent-Length: 9\r\n\r\nsnapshot\n")
+	f.Add("GET_PARAMETER /stream RTSP/1.0\r\nCSeq: 5\r\nContent-Length: 4\r\n\r\nbodyOPTIONS * RTSP/1.0\r\nCSeq: 6\r\n\r\n")
+
+	f.Fuzz(func(t *testing.T, request string) {
+		// body is read from the same reader as headers, as the server does
+		bufferedReader := bufio.NewReader(strings.NewReader(request))
+		requestElements := ReadRequestElements(bufferedReader)
+		_, _, _, err := ParseRequestLine(requestElements)
+		if err != nil {
+			return
+		}
+		if transport, err := ParseHeader(requestElements, "Transport"); err == nil {
+			_, _ = ParseParameter(transport, "client_port")
+		}
+		if rangeValue, err := ParseHeader(requestElements, "Range"); err == nil {
+			_, _ = ParseClockRange(rangeValue)
+		}
+		body, err := ReadRequestBody(bufferedReader, requestElements)
+		if err != nil {
+			return
+		}
+		if contentLength, err := ParseHeader(requestElements, "Content-Length"); err == nil {
+			if length, _ := strconv.Atoi(contentLength); length != len(body) {
+				t.Fatalf("body has %v bytes, Content-Length is %v", len(body), contentLength)
+			}
+		}
+		if !strings.Contains(request, "\n"+string(body)) {
+			t.Fatalf("body %q isn't part of the request", body)
+		}
+		// next request starts right after the body
+		ReadRequestElements(bufferedReader)
+	})
+}
+
+func FuzzParseParameter(f *testing.F) {
+	f.Add("RTP/UDP;client_port=5000,5001", "client_port")
+	f.Add("server_port=5000", "server_port")
+
+	f.Fuzz(func(t *testing.T, text string, parameterName string) {
+		values, err := ParseParameter(text, parameterName)
+		if err == nil && len(values) == 0 {
+			t.Fatalf("parameter %q of %q has no values", parameterName, text)
+		}
+	})
+}
+
+func FuzzParseFrameSize(f *testing.F) {
+	f.Add("26 640-480")
+	f.Add("26 -1")
+
+	f.Fuzz(func(t *testing.T, value string) {
+		_, _, _ = ParseFrameSize(value)
+	})
+}
@@ -0,0 +1,244 @@
+package video
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image/jpeg"
+	"os"
+)
+
+const (
+	aviHeaderSize    = 224
+	aviFlagHasIndex  = 0x10
+	aviFlagKeyFrame  = 0x10
+	aviFrameChunkId  = "00dc"
+	aviIndexItemSize = 16
+
+	// offsets of the fields which are known only after the last frame was written
+	riffSizeOffset         = 4
+	maxBytesPerSecOffset   = 36
+	totalFramesOffset      = 48
+	avihBufferSizeOffset   = 60
+	streamLengthOffset     = 140
+	strhBufferSizeOffset   = 144
+	moviSizeOffset         = 216
+	moviFourCcOffset       = 220
+	maxRiffFileSize        = 1 << 30
+	defaultFramesPerSecond = 30
+)
+
+type aviIndexEntry struct {
+	offset uint32
+	size   uint32
+}
+
+// AviWriter stores jpeg frames in the AVI container (MJPEG codec) with idx1 index,
+// header is written lazily because frame dimensions are known after the first frame
+type AviWriter struct {
+	file            *os.File
+	index           []aviIndexEntry
+	framesPerSecond int
+	width           int
+	height          int
+	maxFrameSize    int
+	Size            int64
+	headerWritten   bool
+	closed          bool
+}
+
+func NewAviWriter(fileName string, framesPerSecond int) (*AviWriter, error) {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if framesPerSecond <= 0 {
+		framesPerSecond = defaultFramesPerSecond
+	}
+	return &AviWriter{
+		file:            file,
+		index:           make([]aviIndexEntry, 0),
+		framesPerSecond: framesPerSecond,
+	}, nil
+}
+
+func (w *AviWriter) WriteFrame(frame []byte) error {
+	if w.closed {
+		return errors.New("avi writer is closed")
+	}
+	if !w.headerWritten {
+		config, err := jpeg.DecodeConfig(bytes.NewReader(frame))
+		if err != nil {
+			return err
+		}
+		w.width = config.Width
+		w.height = config.Height
+		if _, err := w.file.Write(w.prepareHeader()); err != nil {
+			return err
+		}
+		w.Size = aviHeaderSize
+		w.headerWritten = true
+	}
+
+	chunk := make([]byte, 8, 8+len(frame)+1)
+	copy(chunk[0:4], aviFrameChunkId)
+	binary.LittleEndian.PutUint32(chunk[4:8], uint32(len(frame)))
+	chunk = append(chunk, frame...)
+	// chunks are word aligned
+	if len(frame)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	if _, err := w.file.Write(chunk); err != nil {
+		return err
+	}
+
+	w.index = append(w.index, aviIndexEntry{
+		offset: uint32(w.Size - moviFourCcOffset),
+		size:   uint32(len(frame)),
+	})
+	w.Size += int64(len(chunk))
+	if len(frame) > w.maxFrameSize {
+		w.maxFrameSize = len(frame)
+	}
+	return nil
+}
+
+// FramesNumber returns number of frames written so far
+func (w *AviWriter) FramesNumber() int {
+	return len(w.index)
+}
+
+// Full informs that the file reached the size limit of the RIFF AVI format
+func (w *AviWriter) Full() bool {
+	return w.Size+int64(len(w.index)+1)*aviIndexItemSize >= maxRiffFileSize
+}
+
+// Close appends idx1 index, fills in sizes in the header and closes the file
+func (w *AviWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	if !w.headerWritten {
+		// no frames, nothing to finalize
+		return w.file.Close()
+	}
+
+	moviSize := w.Size - moviFourCcOffset
+	index := make([]byte, 8+len(w.index)*aviIndexItemSize)
+	copy(index[0:4], "idx1")
+	binary.LittleEndian.PutUint32(index[4:8], uint32(len(w.index)*aviIndexItemSize))
+	for i, entry := range w.index {
+		item := index[8+i*aviIndexItemSize:]
+		copy(item[0:4], aviFrameChunkId)
+		binary.LittleEndian.PutUint32(item[4:8], aviFlagKeyFrame)
+		binary.LittleEndian.PutUint32(item[8:12], entry.offset)
+		binary.LittleEndian.PutUint32(item[12:16], entry.size)
+	}
+	if _, err := w.file.Write(index); err != nil {
+		w.file.Close()
+		return err
+	}
+	w.Size += int64(len(index))
+
+	patches := []struct {
+		offset int64
+		value  uint32
+	}{
+		{riffSizeOffset, uint32(w.Size - 8)},
+		{maxBytesPerSecOffset, uint32(w.maxFrameSize * w.framesPerSecond)},
+		{totalFramesOffset, uint32(len(w.index))},
+		{avihBufferSizeOffset, uint32(w.maxFrameSize)},
+		{streamLengthOffset, uint32(len(w.index))},
+		{strhBufferSizeOffset, uint32(w.maxFrameSize)},
+		{moviSizeOffset, uint32(moviSize)},
+	}
+	for _, patch := range patches {
+		value := make([]byte, 4)
+		binary.LittleEndian.PutUint32(value, patch.value)
+		if _, err := w.file.WriteAt(value, patch.offset); err != nil {
+			w.file.Close()
+			return err
+		}
+	}
+	return w.file.Close()
+}
+
+func (w *AviWriter) prepareHeader() []byte {
+	header := new(bytes.Buffer)
+	writeFourCc := func(fourCc string) { header.WriteString(fourCc) }
+	writeUint32 := func(value uint32) { _ = binary.Write(header, binary.LittleEndian, value) }
+	writeUint16 := func(value uint16) { _ = binary.Write(header, binary.LittleEndian, value) }
+
+	// RIFF and movi sizes are patched on close
+	writeFourCc("RIFF")
+	writeUint32(0)
+	writeFourCc("AVI ")
+
+	writeFourCc("LIST")
+	writeUint32(192)
+	writeFourCc("hdrl")
+
+	// main AVI header
+	writeFourCc("avih")
+	writeUint32(56)
+	writeUint32(uint32(1000000 / w.framesPerSecond))
+	writeUint32(0) // max bytes per second
+	writeUint32(0) // padding granularity
+	writeUint32(aviFlagHasIndex)
+	writeUint32(0) // total frames
+	writeUint32(0) // initial frames
+	writeUint32(1) // streams
+	writeUint32(0) // suggested buffer size
+	writeUint32(uint32(w.width))
+	writeUint32(uint32(w.height))
+	for i := 0; i < 4; i++ {
+		writeUint32(0)
+	}
+
+	writeFourCc("LIST")
+	writeUint32(116)
+	writeFourCc("strl")
+
+	// stream header
+	writeFourCc("strh")
+	writeUint32(56)
+	writeFourCc("vids")
+	writeFourCc("MJPG")
+	writeUint32(0) // flags
+	writeUint16(0) // priority
+	writeUint16(0) // language
+	writeUint32(0) // initial frames
+	writeUint32(1) // scale
+	writeUint32(uint32(w.framesPerSecond))
+	writeUint32(0) // start
+	writeUint32(0) // length
+	writeUint32(0) // suggested buffer size
+	writeUint32(0xFFFFFFFF)
+	writeUint32(0) // sample size
+	writeUint16(0)
+	writeUint16(0)
+	writeUint16(uint16(w.width))
+	writeUint16(uint16(w.height))
+
+	// stream format (BITMAPINFOHEADER)
+	writeFourCc("strf")
+	writeUint32(40)
+	writeUint32(40)
+	writeUint32(uint32(w.width))
+	writeUint32(uint32(w.height))
+	writeUint16(1)  // planes
+	writeUint16(24) // bit count
+	writeFourCc("MJPG")
+	writeUint32(uint32(w.width * w.height * 3))
+	for i := 0; i < 4; i++ {
+		writeUint32(0)
+	}
+
+	writeFourCc("LIST")
+	writeUint32(0)
+	writeFourCc("movi")
+
+	return header.Bytes()
+}
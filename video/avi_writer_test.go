@@ -0,0 +1,111 @@
+package video
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func encodeFrame(t *testing.T, width int, height int, comment int) []byte {
+	t.Helper()
+	buffer := new(bytes.Buffer)
+	if err := jpeg.Encode(buffer, image.NewGray(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+	// bytes after end of image change the size of the frame, odd sizes are padded in the file
+	return append(buffer.Bytes(), make([]byte, comment)...)
+}
+
+func TestAviWriterWritesHeaderMoviAndIndex(t *testing.T) {
+	directory, err := ioutil.TempDir("", "avi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	fileName := filepath.Join(directory, "recording.avi")
+	writer, err := NewAviWriter(fileName, 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := [][]byte{encodeFrame(t, 32, 16, 0), encodeFrame(t, 32, 16, 1), encodeFrame(t, 32, 16, 100)}
+	for _, frame := range frames {
+		if err := writer.WriteFrame(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(file)) != writer.Size {
+		t.Fatalf("file has %v bytes, writer counted %v", len(file), writer.Size)
+	}
+	fourCc := func(offset int) string { return string(file[offset : offset+4]) }
+	value := func(offset int) int { return int(binary.LittleEndian.Uint32(file[offset : offset+4])) }
+
+	maxFrameSize := len(frames[2])
+	fields := []struct {
+		name     string
+		offset   int
+		expected int
+	}{
+		{"RIFF size", riffSizeOffset, len(file) - 8},
+		{"hdrl size", 16, 192},
+		{"microseconds per frame", 32, 1000000 / 25},
+		{"max bytes per second", maxBytesPerSecOffset, maxFrameSize * 25},
+		{"total frames", totalFramesOffset, len(frames)},
+		{"avih buffer size", avihBufferSizeOffset, maxFrameSize},
+		{"width", 64, 32},
+		{"height", 68, 16},
+		{"strl size", 92, 116},
+		{"stream length", streamLengthOffset, len(frames)},
+		{"strh buffer size", strhBufferSizeOffset, maxFrameSize},
+	}
+	for _, field := range fields {
+		if actual := value(field.offset); actual != field.expected {
+			t.Errorf("%v is %v, expected %v", field.name, actual, field.expected)
+		}
+	}
+	for offset, expected := range map[int]string{0: "RIFF", 8: "AVI ", 12: "LIST", 20: "hdrl", 24: "avih",
+		88: "LIST", 96: "strl", 100: "strh", 108: "vids", 112: "MJPG", 212: "LIST", moviFourCcOffset: "movi"} {
+		if fourCc(offset) != expected {
+			t.Errorf("%q at offset %v, expected %q", fourCc(offset), offset, expected)
+		}
+	}
+
+	// movi list holds word aligned chunks of the frames and is followed by idx1
+	moviEnd := moviFourCcOffset + value(moviSizeOffset)
+	offset := aviHeaderSize
+	chunkOffsets := make([]int, 0)
+	for index := 0; offset < moviEnd; index++ {
+		size := value(offset + 4)
+		if fourCc(offset) != aviFrameChunkId || !bytes.Equal(file[offset+8:offset+8+size], frames[index]) {
+			t.Fatalf("chunk of frame %v at offset %v doesn't hold the frame", index, offset)
+		}
+		chunkOffsets = append(chunkOffsets, offset)
+		offset += 8 + size + size%2
+	}
+	if offset != moviEnd || len(chunkOffsets) != len(frames) {
+		t.Fatalf("%v chunks end at %v, movi list ends at %v", len(chunkOffsets), offset, moviEnd)
+	}
+	if fourCc(moviEnd) != "idx1" || value(moviEnd+4) != len(frames)*aviIndexItemSize ||
+		moviEnd+8+len(frames)*aviIndexItemSize != len(file) {
+		t.Fatalf("idx1 of %v bytes at offset %v", value(moviEnd+4), moviEnd)
+	}
+	// index offsets are relative to movi fourcc
+	for index, chunkOffset := range chunkOffsets {
+		item := moviEnd + 8 + index*aviIndexItemSize
+		if fourCc(item) != aviFrameChunkId || value(item+4) != aviFlagKeyFrame ||
+			moviFourCcOffset+value(item+8) != chunkOffset || value(item+12) != len(frames[index]) {
+			t.Errorf("index item %x of frame %v at offset %v", file[item:item+aviIndexItemSize], index, chunkOffset)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package video
+
+import (
+	"encoding/binary"
+	"os"
+	"time"
+)
+
+// every frame in the segment file is preceded by: timestamp (unix nanoseconds), sequence number and length
+const segmentRecordHeaderSize = 16
+
+type SegmentFrame struct {
+	Timestamp time.Time
+	SeqNum    int
+	Data      []byte
+}
+
+// SegmentWriter appends timestamped jpeg frames to the segment file
+type SegmentWriter struct {
+	file *os.File
+	Size int64
+}
+
+func NewSegmentWriter(fileName string) (*SegmentWriter, error) {
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &SegmentWriter{
+		file: file,
+	}, nil
+}
+
+func (w *SegmentWriter) WriteFrame(frame *SegmentFrame) error {
+	// record is written at once so readers never observe header without data
+	record := make([]byte, segmentRecordHeaderSize+len(frame.Data))
+	binary.BigEndian.PutUint64(record[0:8], uint64(frame.Timestamp.UnixNano()))
+	binary.BigEndian.PutUint32(record[8:12], uint32(frame.SeqNum))
+	binary.BigEndian.PutUint32(record[12:16], uint32(len(frame.Data)))
+	copy(record[segmentRecordHeaderSize:], frame.Data)
+
+	_, err := w.file.Write(record)
+	if err != nil {
+		return err
+	}
+	w.Size += int64(len(record))
+	return nil
+}
+
+func (w *SegmentWriter) Close() error {
+	return w.file.Close()
+}
+
+// SegmentReader reads frames from the segment file, also while the file is still being written
+type SegmentReader struct {
+	file   *os.File
+	offset int64
+}
+
+func NewSegmentReader(fileName string) (*SegmentReader, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return &SegmentReader{
+		file: file,
+	}, nil
+}
+
+// NextFrame returns io.EOF when there is no complete frame available yet, reading can be retried later
+func (r *SegmentReader) NextFrame() (*SegmentFrame, error) {
+	header := make([]byte, segmentRecordHeaderSize)
+	_, err := r.file.ReadAt(header, r.offset)
+	if err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[12:16])
+	data := make([]byte, length)
+	_, err = r.file.ReadAt(data, r.offset+segmentRecordHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	r.offset += segmentRecordHeaderSize + int64(length)
+
+	return &SegmentFrame{
+		Timestamp: time.Unix(0, int64(binary.BigEndian.Uint64(header[0:8]))),
+		SeqNum:    int(binary.BigEndian.Uint32(header[8:12])),
+		Data:      data,
+	}, nil
+}
+
+func (r *SegmentReader) Close() error {
+	return r.file.Close()
+}
@@ -2,34 +2,122 @@ package video
 
 import (
 	"github.com/kyroy/priority-queue"
+	"sync"
 )
 
+const DefaultFramePeriod = 33
+
 type FrameSync struct {
 	FramesQueue   *pq.PriorityQueue
 	FramePeriod   int
 	CurrentSeqNum int
+	lastSeqNum    int
+	queued        map[int]bool
+	playoutBuffer int
+	mutex         sync.Mutex
 }
 
 func NewFrameSync() *FrameSync {
 	return &FrameSync{
 		FramesQueue:   pq.NewPriorityQueue(),
-		FramePeriod:   33,
+		FramePeriod:   DefaultFramePeriod,
 		CurrentSeqNum: 0,
+		queued:        make(map[int]bool),
 	}
 }
 
-func (fs *FrameSync) AddFrame(image []byte, sequentialNumber int) {
-	if sequentialNumber > fs.CurrentSeqNum {
-		fs.FramesQueue.Insert(image, float64(sequentialNumber))
+// AddFrame queues the frame, it reports false when the frame is dropped because newer one was already released
+// or frame with the same number is queued
+func (fs *FrameSync) AddFrame(image []byte, sequentialNumber int) bool {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	// frames older than already released one are dropped
+	if sequentialNumber <= fs.lastSeqNum || fs.queued[sequentialNumber] {
+		return false
 	}
+	fs.queued[sequentialNumber] = true
+	fs.FramesQueue.Insert(image, float64(sequentialNumber))
+	return true
+}
+
+// AddLayers adds frame encoded in several simulcast layers, the first one is the primary layer,
+// it reports whether the frame was queued as AddFrame does
+func (fs *FrameSync) AddLayers(layers [][]byte, sequentialNumber int) bool {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	if sequentialNumber <= fs.lastSeqNum || fs.queued[sequentialNumber] {
+		return false
+	}
+	fs.queued[sequentialNumber] = true
+	fs.FramesQueue.Insert(layers, float64(sequentialNumber))
+	return true
 }
 
 func (fs *FrameSync) NextFrame() []byte {
+	return fs.NextLayers()[0]
+}
+
+// NextLayers returns all layers of the next frame, frames added with AddFrame have single layer
+func (fs *FrameSync) NextLayers() [][]byte {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
 	fs.CurrentSeqNum++
+	_, sequentialNumber := fs.FramesQueue.Get(0)
+	fs.lastSeqNum = int(sequentialNumber)
+	delete(fs.queued, fs.lastSeqNum)
 	data := fs.FramesQueue.PopLowest()
-	return data.([]byte)
+	if layers, ok := data.([][]byte); ok {
+		return layers
+	}
+	return [][]byte{data.([]byte)}
+}
+
+// LastSeqNum returns sequential number of the last released frame
+func (fs *FrameSync) LastSeqNum() int {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	return fs.lastSeqNum
+}
+
+// SetPlayoutBuffer sets number of frames which may be held back while waiting for a missing frame
+func (fs *FrameSync) SetPlayoutBuffer(frames int) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	fs.playoutBuffer = frames
+}
+
+// Ready reports whether next frame can be released, frame following a gap is held back
+// until the gap is filled or playout buffer is full
+func (fs *FrameSync) Ready() bool {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	if fs.FramesQueue.Len() == 0 {
+		return false
+	}
+	_, sequentialNumber := fs.FramesQueue.Get(0)
+	return fs.lastSeqNum == 0 || int(sequentialNumber) == fs.lastSeqNum+1 ||
+		fs.FramesQueue.Len() > fs.playoutBuffer
+}
+
+// Len returns number of queued frames
+func (fs *FrameSync) Len() int {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	return fs.FramesQueue.Len()
 }
 
 func (fs *FrameSync) Empty() bool {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
 	return fs.FramesQueue.Len() == 0
 }
+
+// Reset drops queued frames and accepts new numbering of incoming frames, used when source of frames changes,
+// numbering of released frames (CurrentSeqNum) is continued
+func (fs *FrameSync) Reset() {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	fs.FramesQueue = pq.NewPriorityQueue()
+	fs.queued = make(map[int]bool)
+	fs.lastSeqNum = 0
+}
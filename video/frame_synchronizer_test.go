@@ -0,0 +1,30 @@
+package video
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFrameSyncDropsRepeatedFrames(t *testing.T) {
+	frameSync := NewFrameSync()
+	for _, step := range []struct {
+		seqNum int
+		queued bool
+	}{{2, true}, {1, true}, {2, false}, {3, true}} {
+		if queued := frameSync.AddFrame([]byte{byte(step.seqNum)}, step.seqNum); queued != step.queued {
+			t.Fatalf("frame %v queued %v, expected %v", step.seqNum, queued, step.queued)
+		}
+	}
+	for seqNum := 1; seqNum <= 2; seqNum++ {
+		if frame := frameSync.NextFrame(); !bytes.Equal(frame, []byte{byte(seqNum)}) {
+			t.Fatalf("frame %v played as %v", seqNum, frame)
+		}
+	}
+	// released frame isn't queued again, following one is played once
+	if frameSync.AddFrame([]byte{2}, 2) || frameSync.AddFrame([]byte{3}, 3) {
+		t.Fatal("repeated frame queued")
+	}
+	if frame := frameSync.NextFrame(); !bytes.Equal(frame, []byte{3}) || !frameSync.Empty() {
+		t.Fatalf("frame 3 played as %v, %v frames left", frame, frameSync.Len())
+	}
+}
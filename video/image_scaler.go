@@ -0,0 +1,116 @@
+package video
+
+import (
+	"image"
+	"image/draw"
+)
+
+// ScaleImage resizes image choosing filter by scale, area averaging is used when the image shrinks
+// at least twice in both dimensions, so no source pixels are skipped, otherwise bilinear interpolation
+func ScaleImage(source image.Image, width int, height int) *image.RGBA {
+	bounds := source.Bounds()
+	if width > 0 && height > 0 && bounds.Dx() >= 2*width && bounds.Dy() >= 2*height {
+		return ScaleAreaAverage(source, width, height)
+	}
+	return ScaleBilinear(source, width, height)
+}
+
+// ScaleBilinear resizes image to given dimensions using bilinear interpolation
+func ScaleBilinear(source image.Image, width int, height int) *image.RGBA {
+	result := image.NewRGBA(image.Rect(0, 0, width, height))
+	if width <= 0 || height <= 0 || source.Bounds().Empty() {
+		return result
+	}
+	sourceRgba := toRGBA(source)
+	sourceWidth, sourceHeight := sourceRgba.Rect.Dx(), sourceRgba.Rect.Dy()
+
+	scaleX := float64(sourceWidth) / float64(width)
+	scaleY := float64(sourceHeight) / float64(height)
+	for y := 0; y < height; y++ {
+		// sampling in the middle of the destination pixel
+		sourceY := (float64(y)+0.5)*scaleY - 0.5
+		y0, y1, weightY := neighbours(sourceY, sourceHeight)
+		row0 := sourceRgba.Pix[y0*sourceRgba.Stride:]
+		row1 := sourceRgba.Pix[y1*sourceRgba.Stride:]
+		destination := result.Pix[y*result.Stride:]
+		for x := 0; x < width; x++ {
+			sourceX := (float64(x)+0.5)*scaleX - 0.5
+			x0, x1, weightX := neighbours(sourceX, sourceWidth)
+			for channel := 0; channel < 4; channel++ {
+				destination[4*x+channel] = interpolate(row0[4*x0+channel], row0[4*x1+channel],
+					row1[4*x0+channel], row1[4*x1+channel], weightX, weightY)
+			}
+		}
+	}
+	return result
+}
+
+// ScaleAreaAverage resizes image to smaller dimensions, every destination pixel is average
+// of source pixels it covers
+func ScaleAreaAverage(source image.Image, width int, height int) *image.RGBA {
+	result := image.NewRGBA(image.Rect(0, 0, width, height))
+	if width <= 0 || height <= 0 || source.Bounds().Empty() {
+		return result
+	}
+	sourceRgba := toRGBA(source)
+	sourceWidth, sourceHeight := sourceRgba.Rect.Dx(), sourceRgba.Rect.Dy()
+
+	var sums [4]int
+	for y := 0; y < height; y++ {
+		top, bottom := y*sourceHeight/height, (y+1)*sourceHeight/height
+		if bottom == top {
+			bottom = top + 1
+		}
+		destination := result.Pix[y*result.Stride:]
+		for x := 0; x < width; x++ {
+			left, right := x*sourceWidth/width, (x+1)*sourceWidth/width
+			if right == left {
+				right = left + 1
+			}
+			sums = [4]int{}
+			for sourceY := top; sourceY < bottom; sourceY++ {
+				row := sourceRgba.Pix[sourceY*sourceRgba.Stride:]
+				for sourceX := left; sourceX < right; sourceX++ {
+					for channel := 0; channel < 4; channel++ {
+						sums[channel] += int(row[4*sourceX+channel])
+					}
+				}
+			}
+			count := (bottom - top) * (right - left)
+			for channel := 0; channel < 4; channel++ {
+				destination[4*x+channel] = uint8((sums[channel] + count/2) / count)
+			}
+		}
+	}
+	return result
+}
+
+// toRGBA converts image to RGBA with origin in (0, 0), images decoded from jpeg are converted without
+// per-pixel color model conversion
+func toRGBA(source image.Image) *image.RGBA {
+	if rgba, ok := source.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) {
+		return rgba
+	}
+	bounds := source.Bounds()
+	result := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(result, result.Rect, source, bounds.Min, draw.Src)
+	return result
+}
+
+// neighbours returns indexes of two closest source pixels and weight of the second one
+func neighbours(position float64, size int) (int, int, float64) {
+	if position < 0 {
+		position = 0
+	}
+	first := int(position)
+	if first >= size-1 {
+		return size - 1, size - 1, 0
+	}
+	return first, first + 1, position - float64(first)
+}
+
+func interpolate(topLeft, topRight, bottomLeft, bottomRight uint8, weightX, weightY float64) uint8 {
+	top := float64(topLeft)*(1-weightX) + float64(topRight)*weightX
+	bottom := float64(bottomLeft)*(1-weightX) + float64(bottomRight)*weightX
+	return uint8(top*(1-weightY) + bottom*weightY + 0.5)
+}
@@ -2,8 +2,8 @@ package video
 
 import (
 	"bytes"
+	"image"
 	"image/jpeg"
-	"log"
 )
 
 type QualityAdjuster struct {
@@ -16,20 +16,72 @@ func NewQualityAdjuster() *QualityAdjuster {
 	}
 }
 
-func (it *QualityAdjuster) Compress(image []byte) []byte {
+// Compress re-encodes image with the compression quality, corrupt image is reported as error
+func (it *QualityAdjuster) Compress(image []byte) ([]byte, error) {
+	return it.CompressWithResolution(image, 0, 0)
+}
+
+// CompressWithWidth re-encodes image scaled down to given width, aspect ratio is preserved,
+// images narrower than requested width are only re-encoded
+func (it *QualityAdjuster) CompressWithWidth(image []byte, width int) ([]byte, error) {
+	return it.CompressWithResolution(image, width, 0)
+}
+
+// CompressWithResolution re-encodes image scaled down to given dimensions, when height is 0 it's calculated
+// from aspect ratio, images smaller than requested dimensions are only re-encoded
+func (it *QualityAdjuster) CompressWithResolution(image []byte, width int, height int) ([]byte, error) {
 	decodedImage, err := jpeg.Decode(bytes.NewBuffer(image))
 	if err != nil {
-		log.Fatalln("[ERRPR] cannot decode frame from jpeg:", err)
+		return nil, err
 	}
 
-	buffer := make([]byte, 0)
-	encodedImage := bytes.NewBuffer(buffer)
+	bounds := decodedImage.Bounds()
+	if width > 0 && width < bounds.Dx() {
+		if height <= 0 {
+			height = bounds.Dy() * width / bounds.Dx()
+		}
+		if height < 1 {
+			height = 1
+		}
+		decodedImage = ScaleImage(decodedImage, width, height)
+	}
 
+	encodedImage := new(bytes.Buffer)
 	err = jpeg.Encode(encodedImage, decodedImage, &jpeg.Options{Quality: it.CompressionQuality})
 	if err != nil {
-		log.Fatalln("[ERRPR] cannot encode frame to jpeg:", err)
+		return nil, err
+	}
+	return encodedImage.Bytes(), nil
+}
+
+// resolution steps relative to the source, as numerator over 8
+var resolutionLadderSteps = []int{8, 6, 4, 3, 2}
+
+// ResolutionLadder returns resolutions used when bandwidth is low, from the source one down to a quarter of it,
+// dimensions are multiples of 8 (single jpeg block, also required by RFC 2435)
+func ResolutionLadder(width int, height int) []image.Point {
+	result := []image.Point{{X: width, Y: height}}
+	for _, step := range resolutionLadderSteps[1:] {
+		scaledWidth := width * step / 8 / 8 * 8
+		scaledHeight := height * step / 8 / 8 * 8
+		last := result[len(result)-1]
+		if scaledWidth < 8 || scaledHeight < 8 {
+			break
+		}
+		if scaledWidth < last.X && scaledHeight < last.Y {
+			result = append(result, image.Point{X: scaledWidth, Y: scaledHeight})
+		}
+	}
+	return result
+}
+
+// FrameResolution reads dimensions from jpeg header without decoding the image
+func FrameResolution(frame []byte) (image.Point, error) {
+	config, err := jpeg.DecodeConfig(bytes.NewReader(frame))
+	if err != nil {
+		return image.Point{}, err
 	}
-	return encodedImage.Bytes()
+	return image.Point{X: config.Width, Y: config.Height}, nil
 }
 
 func (it *QualityAdjuster) ChangeCompressionQuality(newCompressionQuality int) {
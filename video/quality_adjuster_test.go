@@ -0,0 +1,28 @@
+package video
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+func TestCompressReportsCorruptFrame(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	err := jpeg.Encode(buffer, image.NewGray(image.Rect(0, 0, 16, 16)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qualityAdjuster := NewQualityAdjuster()
+	compressed, err := qualityAdjuster.Compress(buffer.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolution, err := FrameResolution(compressed); err != nil || resolution != image.Pt(16, 16) {
+		t.Errorf("compressed frame has resolution %v, %v", resolution, err)
+	}
+
+	if _, err := qualityAdjuster.Compress(buffer.Bytes()[:buffer.Len()/2]); err == nil {
+		t.Error("truncated frame compressed")
+	}
+}
@@ -0,0 +1,155 @@
+package video
+
+import (
+	"bytes"
+	"streming_server/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// number of the most recent frames of a stream whose transcoded versions are kept
+const transcodedFramesNumber = 8
+
+var reencodeDurationMetric = metrics.NewHistogram("streaming_jpeg_reencode_duration_seconds",
+	"Duration of JPEG re-encoding of frames for lower quality or resolution.",
+	[]float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25})
+
+// TranscodeTier describes single quality level of transcoded frames
+type TranscodeTier struct {
+	Quality int
+	// 0 keeps resolution of the source, height 0 keeps aspect ratio
+	Width  int
+	Height int
+}
+
+// TranscodeCache shares transcoded frames between sessions watching the same stream, so every frame
+// is transcoded once per tier no matter how many sessions use the tier
+type TranscodeCache struct {
+	streams map[string]*StreamTranscodeCache
+	mutex   sync.Mutex
+}
+
+func NewTranscodeCache() *TranscodeCache {
+	return &TranscodeCache{
+		streams: make(map[string]*StreamTranscodeCache),
+	}
+}
+
+// Stream returns cache of given stream for new session, which releases it when it ends,
+// nil cache returns nil stream cache which transcodes every frame
+func (c *TranscodeCache) Stream(path string) *StreamTranscodeCache {
+	if c == nil {
+		return nil
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	stream, ok := c.streams[path]
+	if !ok {
+		stream = &StreamTranscodeCache{path: path}
+		c.streams[path] = stream
+	}
+	stream.sessions++
+	return stream
+}
+
+// Release ends use of the stream cache by single session, cache of stream without sessions is dropped
+// with its frames, so paths which are no longer watched don't hold memory
+func (c *TranscodeCache) Release(stream *StreamTranscodeCache) {
+	if c == nil || stream == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	stream.sessions--
+	if stream.sessions == 0 && c.streams[stream.path] == stream {
+		delete(c.streams, stream.path)
+	}
+}
+
+// StreamTranscodeCache keeps transcoded versions of the most recent frames of single stream,
+// frames are identified by their content, as buffer of the frame may be reused for later frames
+type StreamTranscodeCache struct {
+	frames    [transcodedFramesNumber]*transcodedFrame
+	nextFrame int
+	hits      int64
+	misses    int64
+	mutex     sync.Mutex
+	path      string
+	// number of sessions using the cache, guarded by mutex of TranscodeCache
+	sessions int
+}
+
+type transcodedFrame struct {
+	// copy of the source, the frame itself may change once it's reused
+	source []byte
+	tiers  map[TranscodeTier]*transcodeResult
+}
+
+type transcodeResult struct {
+	done  chan struct{}
+	frame []byte
+	err   error
+}
+
+// Transcode returns frame re-encoded according to the tier, concurrent requests of the same frame and tier
+// wait for the single transcoding
+func (c *StreamTranscodeCache) Transcode(frame []byte, tier TranscodeTier) ([]byte, error) {
+	if c == nil || len(frame) == 0 {
+		return transcode(frame, tier)
+	}
+
+	c.mutex.Lock()
+	cachedFrame := c.findFrame(frame)
+	result, found := cachedFrame.tiers[tier]
+	if !found {
+		result = &transcodeResult{done: make(chan struct{})}
+		cachedFrame.tiers[tier] = result
+	}
+	c.mutex.Unlock()
+
+	if found {
+		atomic.AddInt64(&c.hits, 1)
+		<-result.done
+		return result.frame, result.err
+	}
+	atomic.AddInt64(&c.misses, 1)
+	result.frame, result.err = transcode(frame, tier)
+	close(result.done)
+	return result.frame, result.err
+}
+
+// findFrame returns cached frame, new frame replaces the oldest one
+func (c *StreamTranscodeCache) findFrame(frame []byte) *transcodedFrame {
+	for _, cachedFrame := range c.frames {
+		if cachedFrame != nil && bytes.Equal(cachedFrame.source, frame) {
+			return cachedFrame
+		}
+	}
+	cachedFrame := &transcodedFrame{
+		source: append([]byte(nil), frame...),
+		tiers:  make(map[TranscodeTier]*transcodeResult),
+	}
+	c.frames[c.nextFrame] = cachedFrame
+	c.nextFrame = (c.nextFrame + 1) % transcodedFramesNumber
+	return cachedFrame
+}
+
+// Stats returns number of requests served from the cache and number of transcoded frames
+func (c *StreamTranscodeCache) Stats() (int64, int64) {
+	if c == nil {
+		return 0, 0
+	}
+	return atomic.LoadInt64(&c.hits), atomic.LoadInt64(&c.misses)
+}
+
+func transcode(frame []byte, tier TranscodeTier) ([]byte, error) {
+	startedAt := time.Now()
+	qualityAdjuster := NewQualityAdjuster()
+	qualityAdjuster.ChangeCompressionQuality(tier.Quality)
+	result, err := qualityAdjuster.CompressWithResolution(frame, tier.Width, tier.Height)
+	if err == nil {
+		reencodeDurationMetric.Observe(time.Since(startedAt).Seconds())
+	}
+	return result, err
+}
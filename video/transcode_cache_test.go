@@ -0,0 +1,185 @@
+package video
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"sync"
+	"testing"
+	"time"
+)
+
+const simulatedViewersNumber = 50
+
+// uniformFrame returns frame of single gray level, padded by zeros after the end of image up to given length
+func uniformFrame(t *testing.T, gray uint8, length int) []byte {
+	source := image.NewGray(image.Rect(0, 0, 64, 48))
+	for index := range source.Pix {
+		source.Pix[index] = gray
+	}
+	buffer := new(bytes.Buffer)
+	err := jpeg.Encode(buffer, source, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buffer.Len() > length {
+		t.Fatalf("frame has %v bytes, more than %v", buffer.Len(), length)
+	}
+	return append(buffer.Bytes(), make([]byte, length-buffer.Len())...)
+}
+
+// checkTranscoded compares frame returned by the cache with frame transcoded without it
+func checkTranscoded(t *testing.T, cache *StreamTranscodeCache, frame []byte, tier TranscodeTier) {
+	expected, err := transcode(frame, tier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := cache.Transcode(frame, tier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(result, expected) {
+		t.Errorf("tier %+v of the frame differs from the transcoded frame", tier)
+	}
+}
+
+func TestTranscodeCacheSeparatesFramesAndTiers(t *testing.T) {
+	cache := NewTranscodeCache().Stream("/live")
+	dark, bright := uniformFrame(t, 40, 2048), uniformFrame(t, 200, 2048)
+	tiers := []TranscodeTier{{Quality: 50}, {Quality: 50, Width: 32}}
+	for round := 0; round < 2; round++ {
+		for _, frame := range [][]byte{dark, bright} {
+			for _, tier := range tiers {
+				checkTranscoded(t, cache, frame, tier)
+			}
+		}
+	}
+	if hits, misses := cache.Stats(); hits != 4 || misses != 4 {
+		t.Errorf("%v frames were served from the cache and %v transcoded", hits, misses)
+	}
+}
+
+func TestTranscodeCacheDropsStreamWithoutSessions(t *testing.T) {
+	cache := NewTranscodeCache()
+	first := cache.Stream("cam")
+	second := cache.Stream("cam")
+	other := cache.Stream("other")
+	if first != second || first == other {
+		t.Fatal("sessions of the same stream don't share its cache")
+	}
+
+	cache.Release(first)
+	if cache.streams["cam"] != second {
+		t.Fatal("cache of the stream is dropped while it has session")
+	}
+	cache.Release(second)
+	if _, found := cache.streams["cam"]; found || cache.streams["other"] != other {
+		t.Fatalf("caches %v left after the last session of the stream", cache.streams)
+	}
+	if cache.Stream("cam") == first {
+		t.Fatal("new session of the stream got dropped cache")
+	}
+}
+
+func TestTranscodeCacheDetectsReusedBuffer(t *testing.T) {
+	cache := NewTranscodeCache().Stream("/live")
+	tier := TranscodeTier{Quality: 50}
+	buffer := make([]byte, 2048)
+	copy(buffer, uniformFrame(t, 40, len(buffer)))
+	checkTranscoded(t, cache, buffer, tier)
+
+	copy(buffer, uniformFrame(t, 200, len(buffer)))
+	checkTranscoded(t, cache, buffer, tier)
+	if _, misses := cache.Stats(); misses != 2 {
+		t.Errorf("frame was transcoded %v times, new content of the buffer was served from the cache", misses)
+	}
+}
+
+func TestTranscodeCacheTranscodesConcurrentRequestsOnce(t *testing.T) {
+	cache := NewTranscodeCache().Stream("/live")
+	frame := prepareFrame(t)
+	tier := TranscodeTier{Quality: 45, Width: 320}
+	results := make([][]byte, simulatedViewersNumber)
+	var waitGroup sync.WaitGroup
+	for viewer := range results {
+		waitGroup.Add(1)
+		go func(viewer int) {
+			defer waitGroup.Done()
+			var err error
+			results[viewer], err = cache.Transcode(frame, tier)
+			if err != nil {
+				t.Error(err)
+			}
+		}(viewer)
+	}
+	waitGroup.Wait()
+
+	if hits, misses := cache.Stats(); hits != simulatedViewersNumber-1 || misses != 1 {
+		t.Errorf("%v requests were served from the cache and %v transcoded", hits, misses)
+	}
+	for viewer, result := range results {
+		if len(result) == 0 || &result[0] != &results[0][0] {
+			t.Errorf("viewer %v received different frame", viewer)
+		}
+	}
+}
+
+// tiers used by congested viewers, every third viewer uses the same one
+var benchmarkTiers = []TranscodeTier{
+	{Quality: 60},
+	{Quality: 45, Width: 480, Height: 360},
+	{Quality: 45, Width: 320, Height: 240},
+}
+
+func prepareFrame(t testing.TB) []byte {
+	source := image.NewRGBA(image.Rect(0, 0, 640, 480))
+	for y := 0; y < 480; y++ {
+		for x := 0; x < 640; x++ {
+			source.SetRGBA(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: uint8(x ^ y), A: 255})
+		}
+	}
+	buffer := new(bytes.Buffer)
+	err := jpeg.Encode(buffer, source, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+// deliverToViewers sends every frame to all viewers at once, as stream fan-out does, sequence number
+// is appended after the end of image to every frame, so frames differ like in a live stream
+func deliverToViewers(b *testing.B, cache *StreamTranscodeCache) {
+	frame := prepareFrame(b)
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		liveFrame := append(append([]byte(nil), frame...), byte(i), byte(i>>8), byte(i>>16), byte(i>>24))
+		var waitGroup sync.WaitGroup
+		for viewer := 0; viewer < simulatedViewersNumber; viewer++ {
+			waitGroup.Add(1)
+			go func(tier TranscodeTier) {
+				defer waitGroup.Done()
+				_, err := cache.Transcode(liveFrame, tier)
+				if err != nil {
+					b.Error(err)
+				}
+			}(benchmarkTiers[viewer%len(benchmarkTiers)])
+		}
+		waitGroup.Wait()
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "frames/s")
+}
+
+func BenchmarkTranscode50ViewersShared(b *testing.B) {
+	cache := NewTranscodeCache().Stream("/live")
+	deliverToViewers(b, cache)
+	_, misses := cache.Stats()
+	b.ReportMetric(float64(misses)/float64(b.N), "transcodes/frame")
+}
+
+func BenchmarkTranscode50ViewersWithoutCache(b *testing.B) {
+	deliverToViewers(b, nil)
+	b.ReportMetric(simulatedViewersNumber, "transcodes/frame")
+}